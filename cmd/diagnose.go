@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/heartbeat"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// dependencyTimeout bounds each connectivity check, so one hanging dependency doesn't use up the
+// whole command timeout
+const dependencyTimeout = 5 * time.Second
+
+// errorClassLimit is how many of the most common failure reasons are reported
+const errorClassLimit = 3
+
+type severity int
+
+const (
+	severityOk severity = iota
+	severityInfo
+	severityWarning
+	severityCritical
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityCritical:
+		return "CRITICAL"
+	case severityWarning:
+		return "WARNING"
+	case severityInfo:
+		return "INFO"
+	default:
+		return "OK"
+	}
+}
+
+type finding struct {
+	severity severity
+	check    string
+	message  string
+}
+
+type diagnosis struct {
+	findings []finding
+}
+
+func (d *diagnosis) add(severity severity, check, format string, args ...interface{}) {
+	d.findings = append(d.findings, finding{
+		severity: severity,
+		check:    check,
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// diagnose runs the checks usually made by hand when the worker falls behind, printing the findings
+// most severe first. It fails if anything critical was found.
+func diagnose(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("diagnose", flag.ContinueOnError)
+	stuckAfter := flags.Duration("stuck-after", 30*time.Minute, "how long a request may be in processing before it is reported as stuck")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var d diagnosis
+
+	redisClient := newRedisClient(1)
+	defer redisClient.Close()
+
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		d.add(severityCritical, "redis", "Redis at %s is unreachable, queue checks skipped: %v", redisTarget(), err)
+	} else {
+		d.add(severityOk, "redis", "Redis at %s is reachable", redisTarget())
+
+		workerAlive := diagnoseHeartbeat(ctx, &d, redisClient)
+		diagnoseQueues(ctx, &d, redisClient, workerAlive, *stuckAfter)
+		diagnoseFailed(ctx, &d, redisClient)
+	}
+
+	diagnoseDependencies(ctx, &d)
+
+	return printDiagnosis(d)
+}
+
+func diagnoseHeartbeat(ctx context.Context, d *diagnosis, redisClient redis.UniversalClient) bool {
+	lastBeat, alive, err := heartbeat.LastBeat(ctx, redisClient)
+	switch {
+	case err != nil:
+		d.add(severityWarning, "heartbeat", "Failed to read worker heartbeat: %v", err)
+		return false
+	case !alive:
+		d.add(severityCritical, "heartbeat", "No worker heartbeat, no worker is running or none can reach Redis")
+		return false
+	}
+
+	age := time.Since(lastBeat).Truncate(time.Second)
+	if age > 2*heartbeat.HeartbeatInterval {
+		d.add(severityWarning, "heartbeat", "Worker heartbeat is %s old, expected every %s", age, heartbeat.HeartbeatInterval)
+	} else {
+		d.add(severityOk, "heartbeat", "Worker heartbeat is %s old", age)
+	}
+
+	return true
+}
+
+func diagnoseQueues(ctx context.Context, d *diagnosis, redisClient redis.UniversalClient, workerAlive bool, stuckAfter time.Duration) {
+	backend := config.Conf.Queue.Backend
+	if backend == "memory" {
+		d.add(severityInfo, "queue", "The memory backend keeps its queue inside the worker process, depths can't be read")
+		return
+	}
+
+	if backend == "kafka" {
+		d.add(severityInfo, "queue", "The Kafka backend's depth is the consumer lag of group %s on topic %s, read it from Kafka", config.Conf.Kafka.Group, config.Conf.Kafka.Topic)
+		return
+	}
+
+	if backend == "postgres" {
+		d.add(severityInfo, "queue", "The Postgres backend keeps its queue in the gdpr_queue table, read its depth from the database")
+		return
+	}
+
+	requestTypes := []*gdprrelay.RequestType{nil}
+	for name := range config.Conf.Queue.TypeConcurrency {
+		if requestType, ok := gdpr.ParseRequestType(name); ok {
+			requestTypes = append(requestTypes, &requestType)
+		}
+	}
+
+	now := time.Now()
+	for _, requestType := range requestTypes {
+		var (
+			stats gdprrelay.QueueStats
+			err   error
+		)
+		if backend == "stream" {
+			stats, err = gdprrelay.StreamQueueStats(ctx, redisClient, requestType, config.Conf.Queue.StreamGroup, stuckAfter)
+		} else {
+			stats, err = gdprrelay.ListQueueStats(ctx, redisClient, requestType, stuckAfter, now)
+		}
+
+		check := "queue:" + stats.Name
+		if err != nil {
+			d.add(severityWarning, check, "Failed to read queue: %v", err)
+			continue
+		}
+
+		if stats.Pending > 0 && !workerAlive {
+			d.add(severityCritical, check, "%d request(s) waiting with no worker running", stats.Pending)
+		}
+
+		if !stats.OldestPending.IsZero() {
+			if age := now.Sub(stats.OldestPending).Truncate(time.Second); age > config.Conf.Metrics.SLOPendingMaxAge {
+				d.add(severityWarning, check, "Oldest pending request was queued %s ago, over the %s target", age, config.Conf.Metrics.SLOPendingMaxAge)
+			}
+		}
+
+		if stats.Stuck > 0 {
+			recovery := "they are requeued when a worker restarts"
+			if backend == "stream" {
+				recovery = "live consumers should claim them after the claim idle time"
+			}
+			d.add(severityWarning, check, "%d request(s) in processing for over %s, %s", stats.Stuck, stuckAfter, recovery)
+		}
+
+		if stats.Delayed > 0 {
+			d.add(severityInfo, check, "%d request(s) waiting to be retried", stats.Delayed)
+		}
+
+		d.add(severityOk, check, "%d pending, %d processing, %d delayed", stats.Pending, stats.Processing, stats.Delayed)
+	}
+}
+
+var digitsPattern = regexp.MustCompile(`[0-9]+`)
+
+func diagnoseFailed(ctx context.Context, d *diagnosis, redisClient redis.UniversalClient) {
+	entries, err := gdprrelay.ListFailed(ctx, redisClient)
+	if err != nil {
+		d.add(severityWarning, "failed", "Failed to read failed queue: %v", err)
+		return
+	}
+
+	if len(entries) == 0 {
+		d.add(severityOk, "failed", "The failed queue is empty")
+		return
+	}
+
+	// IDs and counts are masked, so failures with the same cause are grouped together
+	classes := make(map[string]int)
+	for _, entry := range entries {
+		class := "undecodable entry"
+		if entry.DecodeErr == nil {
+			class = digitsPattern.ReplaceAllString(orDash(entry.Request.LastError), "N")
+		}
+		classes[class]++
+	}
+
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if classes[names[i]] != classes[names[j]] {
+			return classes[names[i]] > classes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) > errorClassLimit {
+		names = names[:errorClassLimit]
+	}
+
+	top := make([]string, len(names))
+	for i, name := range names {
+		top[i] = fmt.Sprintf("%dx %s", classes[name], name)
+	}
+
+	d.add(severityWarning, "failed", "%d request(s) in the failed queue, see inspect-failed. Most common: %s",
+		len(entries), strings.Join(top, "; "))
+}
+
+func diagnoseDependencies(ctx context.Context, d *diagnosis) {
+	if conf := config.Conf.Database; conf.Host != "" {
+		pingCtx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+		err := database.Ping(pingCtx, conf.Host, conf.Database, conf.Username, conf.Password)
+		cancel()
+
+		if err != nil {
+			d.add(severityCritical, "database", "Database at %s is unreachable: %v", conf.Host, err)
+		} else {
+			d.add(severityOk, "database", "Database at %s is reachable", conf.Host)
+		}
+	}
+
+	if archiverUrl := config.Conf.Archiver.Url; archiverUrl != "" {
+		// Any response means the archiver is up, the root path isn't expected to succeed
+		if err := checkHttp(ctx, archiverUrl); err != nil {
+			d.add(severityCritical, "archiver", "Archiver at %s is unreachable: %v", archiverUrl, err)
+		} else {
+			d.add(severityOk, "archiver", "Archiver at %s is reachable", archiverUrl)
+		}
+	}
+
+	if proxyUrl := config.Conf.Discord.ProxyUrl; proxyUrl != "" {
+		// The worker falls back to calling Discord directly, so an unreachable proxy only risks rate limits
+		if err := checkDial(ctx, proxyUrl); err != nil {
+			d.add(severityWarning, "discord-proxy", "Discord proxy at %s is unreachable, requests are sent directly: %v", proxyUrl, err)
+		} else {
+			d.add(severityOk, "discord-proxy", "Discord proxy at %s is reachable", proxyUrl)
+		}
+	}
+}
+
+func checkHttp(ctx context.Context, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func checkDial(ctx context.Context, target string) error {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	host := parsed.Host
+	if parsed.Port() == "" {
+		port := "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(parsed.Hostname(), port)
+	}
+
+	dialer := net.Dialer{Timeout: dependencyTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func printDiagnosis(d diagnosis) error {
+	sort.SliceStable(d.findings, func(i, j int) bool {
+		return d.findings[i].severity > d.findings[j].severity
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEVERITY\tCHECK\tFINDING")
+
+	counts := make(map[severity]int)
+	for _, f := range d.findings {
+		counts[f.severity]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.severity, f.check, f.message)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d critical, %d warning(s)\n", counts[severityCritical], counts[severityWarning])
+
+	if counts[severityCritical] > 0 {
+		return fmt.Errorf("%d critical finding(s)", counts[severityCritical])
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !dev
+
+package main
+
+import "errors"
+
+// startEmbeddedRedis fails outside of dev builds, as state kept in an in-memory server is lost on
+// every restart
+func startEmbeddedRedis() (string, func(), error) {
+	return "", nil, errors.New("REDIS_ADDR is required, an embedded Redis server is only available in builds with the dev tag")
+}
@@ -0,0 +1,16 @@
+//go:build dev
+
+package main
+
+import "github.com/alicebob/miniredis/v2"
+
+// startEmbeddedRedis starts an in-memory Redis server for local development, returning its address
+// and a function that stops it
+func startEmbeddedRedis() (string, func(), error) {
+	server, err := miniredis.Run()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return server.Addr(), server.Close, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+)
+
+const commandTimeout = 30 * time.Second
+
+const usage = `Usage: gdpr-worker [command]
+
+Runs the worker when no command is given.
+
+Commands:
+  inspect-failed              List requests in the failed queue
+  requeue-failed <request-id> Move a failed request back onto the queue with its retries reset
+  purge-failed -yes           Delete every request in the failed queue
+  diagnose [-stuck-after 30m] Check queues, the worker heartbeat and dependencies, most severe findings first
+  migrate-queue -to <list|stream> [-from <list|stream>] [-from-namespace ns] [-to-namespace ns] [-dry-run] [-force]
+                              Move every queued, in-flight, delayed and failed request to another backend or
+                              key namespace, e.g. before switching QUEUE_BACKEND. Workers must be stopped first.
+  selftest [-compose file] [-keep] [-timeout 15m]
+                              Start throwaway dependencies with docker compose, run a request of every type
+                              through this build and check what each deleted. Needs Docker, not a deployment.
+`
+
+// runCommand runs an operator subcommand in place of the worker, returning the exit code
+func runCommand(name string, args []string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var err error
+	switch name {
+	case "inspect-failed":
+		err = inspectFailed(ctx)
+	case "requeue-failed":
+		err = requeueFailed(ctx, args)
+	case "purge-failed":
+		err = purgeFailed(ctx, args)
+	case "diagnose":
+		err = diagnose(ctx, args)
+	case "migrate-queue":
+		err = migrateQueue(ctx, args)
+	case "selftest":
+		// Starting the dependencies alone takes longer than the usual command timeout
+		err = selftest(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func inspectFailed(ctx context.Context) error {
+	redisClient := newRedisClient(1)
+	defer redisClient.Close()
+
+	entries, err := gdprrelay.ListFailed(ctx, redisClient)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("The failed queue is empty")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST ID\tTYPE\tSCRAMBLED USER ID\tGUILDS\tTICKETS\tRETRIES\tQUEUED AT\tLAST ATTEMPT\tERROR")
+
+	for _, entry := range entries {
+		if entry.DecodeErr != nil {
+			fmt.Fprintf(tw, "-\t-\t-\t-\t-\t-\t-\t-\tinvalid entry: %s\n", entry.DecodeErr)
+			continue
+		}
+
+		request := entry.Request
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			request.RequestID,
+			utils.GetRequestTypeName(int(request.Request.Type)),
+			utils.ScrambleUserId(request.Request.UserId),
+			len(request.Request.GuildIds),
+			len(request.Request.TicketIds),
+			request.RetryCount,
+			formatTime(request.QueuedAt),
+			formatTime(request.LastAttemptAt),
+			orDash(request.LastError),
+		)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d request(s) in the failed queue\n", len(entries))
+	return nil
+}
+
+func requeueFailed(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a request ID, see help")
+	}
+
+	requestId, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid request ID %q", args[0])
+	}
+
+	redisClient := newRedisClient(1)
+	defer redisClient.Close()
+
+	requeued, err := gdprrelay.RequeueFailed(ctx, redisClient, requestId, config.Conf.Queue.Backend == "stream")
+	if err != nil {
+		return err
+	}
+
+	if !requeued {
+		return fmt.Errorf("request %d is not in the failed queue", requestId)
+	}
+
+	fmt.Printf("Requeued request %d\n", requestId)
+	return nil
+}
+
+func purgeFailed(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("purge-failed", flag.ContinueOnError)
+	confirmed := flags.Bool("yes", false, "confirm that every failed request should be deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Purged requests are never processed, so the user's data is left in place
+	if !*confirmed {
+		return fmt.Errorf("purging deletes failed requests without processing them, pass -yes to confirm")
+	}
+
+	redisClient := newRedisClient(1)
+	defer redisClient.Close()
+
+	purged, err := gdprrelay.PurgeFailed(ctx, redisClient)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Purged %d request(s) from the failed queue\n", purged)
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
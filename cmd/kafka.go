@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go.uber.org/zap"
+)
+
+// newKafkaQueue connects to the configured Kafka brokers. Redis is still used for the archive of
+// acknowledged requests.
+func newKafkaQueue(redisClient redis.UniversalClient, logger *zap.Logger) (*gdprrelay.KafkaQueue, error) {
+	conf := config.Conf.Kafka
+
+	if len(conf.Brokers) == 0 {
+		return nil, fmt.Errorf("KAFKA_BROKERS is required with the kafka queue backend")
+	}
+
+	var tlsConfig *tls.Config
+	if conf.TLS {
+		tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	mechanism, err := kafkaSASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Using Kafka queue backend",
+		zap.String("brokers", strings.Join(conf.Brokers, ", ")),
+		zap.String("topic", conf.Topic),
+		zap.String("dead_letter_topic", conf.DeadLetterTopic),
+		zap.String("group", conf.Group),
+	)
+
+	return gdprrelay.NewKafkaQueue(
+		redisClient,
+		conf.Brokers,
+		conf.Topic,
+		conf.DeadLetterTopic,
+		conf.Group,
+		tlsConfig,
+		mechanism,
+		logger,
+	), nil
+}
+
+func kafkaSASLMechanism() (sasl.Mechanism, error) {
+	conf := config.Conf.Kafka
+
+	switch strings.ToLower(conf.SASLMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{
+			Username: conf.SASLUsername,
+			Password: conf.SASLPassword,
+		}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, conf.SASLUsername, conf.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, conf.SASLUsername, conf.SASLPassword)
+	default:
+		return nil, fmt.Errorf("unknown Kafka SASL mechanism %q, must be plain, scram-sha-256 or scram-sha-512", conf.SASLMechanism)
+	}
+}
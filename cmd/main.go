@@ -2,50 +2,144 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/admin"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/archiver"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/autoscale"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/callback"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/control"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/deadletter"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/discordproxy"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/email"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/erasure"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/exportstore"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/guildpurge"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/heartbeat"
-	"github.com/TicketsBot-cloud/gdpr-worker/internal/processor"
-	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
-	"github.com/go-redis/redis/v8"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/latesweep"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/platformerasure"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/quarantine"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/worker"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/certificate"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	v2 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v2"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
+const localePath = "locale"
+
 func main() {
 	config.Parse()
 
+	if err := loadRedisConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if err := validateConfig(config.Conf); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	// Operator subcommands run against the queue and exit, without starting the worker
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	logger := initLogger(config.Conf.JsonLogs, config.Conf.LogLevel)
 	logger.Info("Starting GDPR Worker")
 
+	clk := clock.Real
+	if config.Conf.ClockOffset != 0 {
+		logger.Warn("Running with an offset clock", zap.Duration("offset", config.Conf.ClockOffset))
+		clk = clock.Offset(clk, config.Conf.ClockOffset)
+	}
+
+	localeDir := localePath
+	if config.Conf.Locale.BundleUrl != "" {
+		localeDir = downloadLocales(logger)
+	}
+
 	logger.Info("Initializing i18n")
-	if err := i18n.Init("locale"); err != nil {
+	if err := i18n.Init(localeDir); err != nil {
 		logger.Fatal("Failed to initialize i18n", zap.Error(err))
 		return
 	}
 
+	if config.Conf.Tracing.Endpoint != "" {
+		logger.Info("Initializing tracing")
+		shutdownTracing, err := tracing.Setup(
+			context.Background(),
+			config.Conf.Tracing.Endpoint,
+			config.Conf.Tracing.ServiceName,
+			config.Conf.Tracing.SampleRatio,
+		)
+		if err != nil {
+			logger.Fatal("Failed to initialize tracing", zap.Error(err))
+			return
+		}
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Error("Failed to flush traces", zap.Error(err))
+			}
+		}()
+	}
+
+	queueInRedis := config.Conf.Queue.Backend != "memory" && config.Conf.Queue.Backend != "postgres"
+	if !queueInRedis && config.Conf.Redis.Mode == "standalone" && config.Conf.Redis.Address == "" {
+		// Heartbeats, checkpoints and the like still live in Redis, so in development they go to an
+		// embedded server and the worker can run with no Redis instance at all
+		addr, stopEmbeddedRedis, err := startEmbeddedRedis()
+		if err != nil {
+			logger.Fatal("No Redis address configured", zap.Error(err))
+			return
+		}
+		defer stopEmbeddedRedis()
+
+		logger.Warn("No Redis address configured, using embedded in-memory Redis")
+		config.Conf.Redis.Address = addr
+	}
+
 	logger.Info("Connecting to Redis")
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     config.Conf.Redis.Address,
-		Password: config.Conf.Redis.Password,
-		DB:       0,
-	})
+	redisClient := newRedisClient(config.Conf.Redis.Threads)
 
 	if err := redisClient.Ping(context.Background()).Err(); err != nil {
 		logger.Fatal("Failed to connect to Redis", zap.Error(err))
 		return
 	}
+
+	// The queue listener blocks on BLMOVE/XREADGROUP for as long as the queue is empty, so it gets its
+	// own connection to avoid acknowledgements and heartbeats queueing behind a blocked one.
+	listenerRedisClient := newRedisClient(1)
+
+	if err := listenerRedisClient.Ping(context.Background()).Err(); err != nil {
+		logger.Fatal("Failed to connect to Redis", zap.Error(err))
+		return
+	}
 	logger.Info("Connected to Redis")
 
 	logger.Info("Connecting to database")
@@ -61,6 +155,13 @@ func main() {
 		return
 	}
 
+	if config.Conf.Queue.Backend == "postgres" {
+		if err := database.CreateRequestQueue(context.Background()); err != nil {
+			logger.Fatal("Failed to create request queue", zap.Error(err))
+			return
+		}
+	}
+
 	logger.Info("Initializing archiver client")
 	archiver.Initialize(
 		logger.With(),
@@ -68,109 +169,542 @@ func main() {
 		config.Conf.Archiver.AesKey,
 	)
 
-	proc := processor.New(logger.With())
+	var exportStore processor.ExportStore
+	if config.Conf.Export.Endpoint != "" {
+		store, err := exportstore.NewS3Store(
+			config.Conf.Export.Endpoint,
+			config.Conf.Export.AccessKey,
+			config.Conf.Export.SecretKey,
+			config.Conf.Export.Bucket,
+			config.Conf.Export.Secure,
+			config.Conf.Export.LinkExpiry,
+		)
+		if err != nil {
+			logger.Fatal("Failed to initialize export store", zap.Error(err))
+			return
+		}
 
-	callbackHandler := callback.New(
-		logger.With(),
-		config.Conf.Discord.ProxyUrl,
+		exportStore = store
+	}
+
+	var transcriptStorage processor.GuildObjectLister
+	if config.Conf.TranscriptStorage.Endpoint != "" {
+		storage, err := archiver.NewStorage(
+			config.Conf.TranscriptStorage.Endpoint,
+			config.Conf.TranscriptStorage.AccessKey,
+			config.Conf.TranscriptStorage.SecretKey,
+			config.Conf.TranscriptStorage.Bucket,
+			config.Conf.TranscriptStorage.Secure,
+		)
+		if err != nil {
+			logger.Fatal("Failed to initialize transcript storage client", zap.Error(err))
+			return
+		}
+
+		transcriptStorage = storage
+	}
+
+	var attachmentStore processor.AttachmentDeleter
+	if config.Conf.AttachmentStorage.Endpoint != "" {
+		store, err := archiver.NewAttachmentStore(
+			config.Conf.AttachmentStorage.Endpoint,
+			config.Conf.AttachmentStorage.AccessKey,
+			config.Conf.AttachmentStorage.SecretKey,
+			config.Conf.AttachmentStorage.Bucket,
+			config.Conf.AttachmentStorage.Secure,
+			config.Conf.AttachmentStorage.UrlPrefix,
+		)
+		if err != nil {
+			logger.Fatal("Failed to initialize attachment storage client", zap.Error(err))
+			return
+		}
+
+		attachmentStore = store
+	}
+
+	var issuer *erasure.Issuer
+	if config.Conf.Certificate.SigningKey != "" {
+		key, err := certificate.ParsePrivateKey(config.Conf.Certificate.SigningKey)
+		if err != nil {
+			logger.Fatal("Failed to parse certificate signing key", zap.Error(err))
+			return
+		}
+
+		issuer = erasure.NewIssuer(key, database.Certificates, exportStore)
+	}
+
+	if config.Conf.Discord.ProxyUrl != "" {
+		if err := discordproxy.Install(config.Conf.Discord.ProxyUrl, logger.With()); err != nil {
+			logger.Fatal("Failed to configure Discord proxy", zap.Error(err))
+			return
+		}
+
+		logger.Info("Routing Discord REST calls through proxy")
+	}
+
+	callbackHandler := callback.New(logger.With())
+
+	if config.Conf.Branding.File != "" {
+		branding, err := callback.LoadBranding(config.Conf.Branding.File)
+		if err != nil {
+			logger.Fatal("Failed to load callback branding", zap.Error(err))
+			return
+		}
+
+		callbackHandler.SetBranding(branding)
+		logger.Info("Loaded callback branding", zap.Int("brands", len(branding)))
+	}
+
+	if config.Conf.Email.SmtpAddr != "" {
+		mailer, err := email.NewSMTPSender(
+			config.Conf.Email.SmtpAddr,
+			config.Conf.Email.Username,
+			config.Conf.Email.Password,
+			config.Conf.Email.From,
+			config.Conf.Email.ImplicitTLS,
+		)
+		if err != nil {
+			logger.Fatal("Failed to configure email sender", zap.Error(err))
+			return
+		}
+
+		callbackHandler.SetMailer(mailer)
+	}
+
+	var notifier worker.Notifier = callbackHandler
+	callbackRetryCtx, callbackRetryCancel := context.WithCancel(context.Background())
+	defer callbackRetryCancel()
+	if config.Conf.CallbackRetry.Enabled {
+		callbackRetry := callback.NewRetryQueue(redisClient, callbackHandler, callback.RetryPolicy{
+			MaxAttempts: config.Conf.CallbackRetry.MaxAttempts,
+			BaseDelay:   config.Conf.CallbackRetry.BaseDelay,
+			MaxDelay:    config.Conf.CallbackRetry.MaxDelay,
+		}, logger.With())
+		callbackRetry.SetClock(clk)
+
+		logger.Info("Starting callback retry queue")
+		go callbackRetry.Run(callbackRetryCtx)
+
+		notifier = callbackRetry
+	}
+
+	var webhookDispatcher *callback.WebhookDispatcher
+	if config.Conf.CallbackWebhook.Url != "" {
+		if config.Conf.CallbackWebhook.Secret == "" {
+			logger.Fatal("CALLBACK_WEBHOOK_SECRET must be set when CALLBACK_WEBHOOK_URL is")
+			return
+		}
+
+		webhookDispatcher = callback.NewWebhookDispatcher(
+			config.Conf.CallbackWebhook.Url,
+			config.Conf.CallbackWebhook.Secret,
+			callback.RetryPolicy{
+				MaxAttempts: config.Conf.CallbackWebhook.MaxAttempts,
+				BaseDelay:   config.Conf.CallbackWebhook.BaseDelay,
+				MaxDelay:    config.Conf.CallbackWebhook.MaxDelay,
+			},
+			database.WebhookOutbox,
+			logger.With(),
+		)
+		webhookDispatcher.SetClock(clk)
+
+		logger.Info("Starting compliance webhook dispatcher")
+		go webhookDispatcher.Run(callbackRetryCtx)
+	}
+
+	var batchDeleter processor.BatchDeleter
+	if config.Conf.Archiver.BatchDelete {
+		batchDeleter = archiver.Batch
+	}
+
+	verificationMode := gdpr.VerificationMode(config.Conf.VerificationMode)
+	if verificationMode != gdpr.VerificationModeOwner && verificationMode != gdpr.VerificationModeAdmin {
+		logger.Fatal("Unknown verification mode", zap.String("mode", config.Conf.VerificationMode))
+		return
+	}
+
+	var quarantineStore processor.QuarantineStore
+	if config.Conf.SoftDelete.Enabled {
+		quarantineStore = database.Quarantine
+	}
+
+	// Kept in two variables, as a nil *StateStore in the processor's interface wouldn't be nil
+	var (
+		stateStore      *gdprrelay.StateStore
+		processorStates processor.StateStore
 	)
+	if config.Conf.State.TTL > 0 {
+		stateStore = gdprrelay.NewStateStore(redisClient, config.Conf.State.TTL)
+		processorStates = stateStore
+	}
+
+	processorOptions := processor.Options{
+		Database:     database.Client,
+		Archiver:     archiver.Client,
+		Retriever:    archiver.Metered,
+		Purger:       archiver.Proxy,
+		PurgeTimeout: config.Conf.Archiver.PurgeTimeout,
+		BatchDeleter: batchDeleter,
+		BatchSize:    config.Conf.Archiver.BatchSize,
+		Storage:      transcriptStorage,
+		DiscordToken: config.Conf.Discord.Token,
+		ExportStore:  exportStore,
+		Attachments:  attachmentStore,
+		DryRun:       config.Conf.DryRun,
+		VerifyGuild:  config.Conf.VerifyTicketGuild,
+		Placeholder: &v2.User{
+			Id:       config.Conf.Placeholder.UserId,
+			Username: config.Conf.Placeholder.Username,
+			Avatar:   config.Conf.Placeholder.Avatar,
+		},
+		TranscriptKey:     []byte(config.Conf.Archiver.AesKey),
+		StreamThreshold:   config.Conf.Archiver.StreamThreshold,
+		VerificationMode:  verificationMode,
+		OwnerRecheckAfter: config.Conf.OwnerRecheckAfter,
+		Progress:          callbackHandler,
+		ProgressEvery:     config.Conf.ProgressEvery,
+		ProgressInterval:  config.Conf.ProgressInterval,
+		States:            processorStates,
+		Checkpoints:       gdprrelay.NewCheckpointStore(redisClient, config.Conf.CheckpointTTL),
+		CheckpointEvery:   config.Conf.CheckpointEvery,
+		LegalHolds:        database.LegalHolds,
+		TranscriptLocks:   gdprrelay.NewTranscriptLocks(redisClient),
+		ConflictWait:      config.Conf.ConflictWait,
+		Quarantine:        quarantineStore,
+		QuarantinePeriod:  config.Conf.SoftDelete.GracePeriod,
+	}
+	proc := processor.New(logger.With(), processorOptions)
 
 	logger.Info("Starting heartbeat")
 	heartbeatCtx, heartbeatCancel := context.WithCancel(context.Background())
 	defer heartbeatCancel()
-	go heartbeat.Start(heartbeatCtx, redisClient, logger.With())
+	go heartbeat.Start(heartbeatCtx, redisClient, clk, logger.With())
+
+	listenerCtx, listenerCancel := context.WithCancel(context.Background())
+	defer listenerCancel()
+
+	queue := newQueue(redisClient, listenerRedisClient, nil, clk, logger.With())
+
+	logger.Info("Starting metrics server")
+	metricsCtx, metricsCancel := context.WithCancel(context.Background())
+	defer metricsCancel()
+	go metrics.Serve(metricsCtx, config.Conf.Metrics.Addr, logger.With())
+
+	sloTracker := metrics.NewSLOTracker(
+		redisClient,
+		logger.With(),
+		config.Conf.Metrics.SLOWindow,
+		config.Conf.Metrics.SLOPendingMaxAge,
+		config.Conf.Metrics.SLOTarget,
+	)
+	sloTracker.SetClock(clk)
+	go sloTracker.Run(metricsCtx)
+
+	typeTimeouts := make(map[gdpr.RequestType]time.Duration, len(config.Conf.RequestTypeTimeouts))
+	for name, timeout := range config.Conf.RequestTypeTimeouts {
+		requestType, ok := gdpr.ParseRequestType(name)
+		if !ok {
+			logger.Fatal("Unknown request type in request timeout configuration", zap.String("request_type", name))
+			return
+		}
+
+		typeTimeouts[requestType] = timeout
+	}
+
+	// Replays outlive the listeners, so writes buffered while draining still reach Redis
+	bufferCtx, bufferCancel := context.WithCancel(context.Background())
+	defer bufferCancel()
+
+	// Created ahead of the workers, which schedule the sweeps as message erasures complete
+	var lateSweeps *latesweep.Scheduler
+	lateSweepCtx, lateSweepCancel := context.WithCancel(context.Background())
+	defer lateSweepCancel()
+	if config.Conf.LateSweep.Delay > 0 {
+		lateSweeps = latesweep.NewScheduler(redisClient, proc, config.Conf.LateSweep.Delay, logger.With())
+		lateSweeps.SetClock(clk)
+
+		logger.Info("Starting late sweep scheduler", zap.Duration("delay", config.Conf.LateSweep.Delay))
+		go lateSweeps.Run(lateSweepCtx)
+	}
+
+	var guildNotices *callback.GuildNotifier
+	if config.Conf.GuildNotice.Enabled {
+		if config.Conf.Discord.Token == "" {
+			logger.Fatal("A Discord token must be configured to post guild notices")
+			return
+		}
+
+		guildNotices = callback.NewGuildNotifier(config.Conf.Discord.Token, logger.With())
+	}
+
+	// Only the Redis backends keep failed requests in the failed list
+	deadLetterCtx, deadLetterCancel := context.WithCancel(context.Background())
+	defer deadLetterCancel()
+	if (config.Conf.Queue.Backend == "list" || config.Conf.Queue.Backend == "stream") && config.Conf.DeadLetter.Interval > 0 {
+		alerter, err := newDeadLetterAlerter()
+		if err != nil {
+			logger.Fatal("Failed to configure failed queue alerts", zap.Error(err))
+			return
+		}
+
+		monitor := deadletter.NewMonitor(
+			redisClient,
+			alerter,
+			config.Conf.DeadLetter.TTL,
+			config.Conf.DeadLetter.MaxLen,
+			config.Conf.DeadLetter.AlertThreshold,
+			config.Conf.DeadLetter.AlertCooldown,
+			config.Conf.DeadLetter.Interval,
+			logger.With(),
+		)
+		monitor.SetClock(clk)
+
+		logger.Info("Starting failed queue monitor",
+			zap.Duration("ttl", config.Conf.DeadLetter.TTL),
+			zap.Int64("max_len", config.Conf.DeadLetter.MaxLen),
+			zap.Bool("alerts", alerter != nil),
+		)
+		go monitor.Run(deadLetterCtx)
+	}
+
+	w := worker.New(logger.With(), redisClient, queue, proc, notifier, issuer, sloTracker, config.Conf.MaxConcurrency)
+	w.SetClock(clk)
+	w.SetTimeouts(config.Conf.RequestTimeout, typeTimeouts)
+	w.SetWebhook(webhookDispatcher)
+	w.SetBuffer(newOfflineBuffer("shared", logger))
+	w.SetStates(stateStore)
+	w.SetLateSweeps(lateSweeps)
+	w.SetGuildNotices(guildNotices)
+	go w.RunBufferReplay(bufferCtx, config.Conf.OfflineBuffer.ReplayInterval)
 
 	logger.Info("Starting GDPR queue listener")
 	ch := make(chan gdprrelay.QueuedRequest)
-	go gdprrelay.Listen(redisClient, ch, logger.With())
-
-	semaphore := make(chan struct{}, config.Conf.MaxConcurrency)
-
-	go func() {
-		for request := range ch {
-			semaphore <- struct{}{}
-
-			go func(req gdprrelay.QueuedRequest) {
-				defer func() {
-					<-semaphore
-				}()
-
-				processCtx := context.Background()
-
-				scrambledId := utils.ScrambleUserId(req.Request.UserId)
-				requestTypeName := utils.GetRequestTypeName(int(req.Request.Type))
-
-				logger.Info("Processing GDPR request",
-					zap.String("scrambled_user_id", scrambledId),
-					zap.String("request_type", requestTypeName),
-					zap.Uint64("request_id", uint64(req.RequestID)),
-				)
-
-				result := proc.Process(processCtx, req.Request)
-
-				if result.Error != nil {
-					logger.Error("Failed to process GDPR request",
-						zap.String("scrambled_user_id", scrambledId),
-						zap.String("request_type", requestTypeName),
-						zap.Uint64("request_id", uint64(req.RequestID)),
-						zap.Error(result.Error),
-					)
-
-					if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, "Failed"); updateErr != nil {
-						logger.Error("Failed to update GDPR log",
-							zap.Uint64("request_id", uint64(req.RequestID)),
-							zap.String("scrambled_user_id", scrambledId),
-							zap.Error(updateErr),
-						)
-					}
-
-					if rejectErr := gdprrelay.Reject(processCtx, redisClient, req.Request, logger); rejectErr != nil {
-						logger.Error("Failed to reject GDPR request",
-							zap.Uint64("request_id", uint64(req.RequestID)),
-							zap.String("scrambled_user_id", scrambledId),
-							zap.Error(rejectErr),
-						)
-					}
-				} else {
-					if ackErr := gdprrelay.Acknowledge(processCtx, redisClient, req.Request, logger); ackErr != nil {
-						logger.Error("Failed to acknowledge GDPR request",
-							zap.Uint64("request_id", uint64(req.RequestID)),
-							zap.String("scrambled_user_id", scrambledId),
-							zap.Error(ackErr),
-						)
-					}
-				}
-
-				callbackData := callback.ResultData{
-					TranscriptsDeleted:    result.TranscriptsDeleted,
-					MessagesDeleted: result.MessagesDeleted,
-					Error:           result.Error,
-					RequestType:     req.Request.Type,
-					GuildIds:        req.Request.GuildIds,
-					TicketIds:       req.Request.TicketIds,
-				}
-
-				callbackCtx, callbackCancel := context.WithTimeout(context.Background(), 30*time.Second)
-				defer callbackCancel()
-
-				if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, "Completed"); updateErr != nil {
-					logger.Error("Failed to update GDPR log status to Completed",
-						zap.Uint64("request_id", uint64(req.RequestID)),
-						zap.String("scrambled_user_id", scrambledId),
-						zap.Error(updateErr),
-					)
-				}
-
-				if err := callbackHandler.SendCompletion(callbackCtx, req.Request, callbackData); err != nil {
-					logger.Error("Failed to send completion callback",
-						zap.Uint64("request_id", uint64(req.RequestID)),
-						zap.String("scrambled_user_id", scrambledId),
-						zap.Error(err),
-					)
-				}
-			}(request)
-		}
-	}()
+	go queue.Listen(listenerCtx, w, ch)
+	go w.Run(ch)
+
+	var (
+		laneWorkers []*worker.Worker
+		laneTypes   []gdprrelay.RequestType
+	)
+	for name, concurrency := range config.Conf.Queue.TypeConcurrency {
+		requestType, ok := gdpr.ParseRequestType(name)
+		if !ok {
+			logger.Fatal("Unknown request type in dedicated queue configuration", zap.String("request_type", name))
+			return
+		}
+
+		if concurrency < 1 {
+			logger.Fatal("Dedicated queue concurrency must be at least 1",
+				zap.String("request_type", name),
+				zap.Int("concurrency", concurrency),
+			)
+			return
+		}
+
+		laneLogger := logger.With(zap.String("queue", requestType.String()))
+		laneLogger.Info("Starting dedicated GDPR queue listener", zap.Int("concurrency", concurrency))
+
+		// Every blocking listener needs a connection of its own
+		laneRedisClient := newRedisClient(1)
+		laneQueue := newQueue(redisClient, laneRedisClient, &requestType, clk, laneLogger)
+		if config.Conf.Queue.Backend == "list" {
+			sloTracker.WatchPending(gdpr.KeyPendingFor(requestType))
+			sloTracker.WatchPending(gdpr.KeyPriorityFor(gdpr.KeyPendingFor(requestType)))
+		}
+
+		laneWorker := worker.New(laneLogger, redisClient, laneQueue, proc, notifier, issuer, sloTracker, concurrency)
+		laneWorker.SetClock(clk)
+		laneWorker.SetName(requestType.String())
+		laneWorker.SetTimeouts(config.Conf.RequestTimeout, typeTimeouts)
+		laneWorker.SetWebhook(webhookDispatcher)
+		laneWorker.SetBuffer(newOfflineBuffer(requestType.String(), laneLogger))
+		laneWorker.SetStates(stateStore)
+		laneWorker.SetLateSweeps(lateSweeps)
+		laneWorker.SetGuildNotices(guildNotices)
+		go laneWorker.RunBufferReplay(bufferCtx, config.Conf.OfflineBuffer.ReplayInterval)
+
+		laneCh := make(chan gdprrelay.QueuedRequest)
+		go laneQueue.Listen(listenerCtx, laneWorker, laneCh)
+		go laneWorker.Run(laneCh)
+
+		laneWorkers = append(laneWorkers, laneWorker)
+		laneTypes = append(laneTypes, requestType)
+	}
+
+	workers := worker.NewGroup(w, laneWorkers...)
+
+	if config.Conf.Autoscale.Enabled {
+		if config.Conf.Queue.Backend == "memory" {
+			logger.Fatal("The autoscaling hint needs a Redis queue backend, as replicas can't share the in-memory queue")
+			return
+		}
+
+		if config.Conf.Queue.Backend == "kafka" {
+			logger.Fatal("The autoscaling hint needs a Redis queue backend, Kafka consumer lag should be scaled on instead")
+			return
+		}
+
+		if config.Conf.Queue.Backend == "postgres" {
+			logger.Fatal("The autoscaling hint needs a Redis queue backend, the depth of the gdpr_queue table should be scaled on instead")
+			return
+		}
+
+		publisher := autoscale.NewPublisher(
+			redisClient,
+			admin.NewQueueInspector(
+				redisClient,
+				config.Conf.Queue.Backend == "stream",
+				config.Conf.Queue.StreamGroup,
+				laneTypes,
+				config.Conf.Admin.StuckAfter,
+			),
+			workers,
+			sloTracker,
+			config.Conf.Autoscale.DrainTarget,
+			config.Conf.Autoscale.MinReplicas,
+			config.Conf.Autoscale.MaxReplicas,
+			logger.With(),
+		)
+		publisher.SetClock(clk)
+
+		logger.Info("Publishing autoscaling hint", zap.String("key", autoscale.KeyDesiredReplicas))
+		go publisher.Run(metricsCtx)
+	}
+
+	guildPurgeCtx, guildPurgeCancel := context.WithCancel(context.Background())
+	defer guildPurgeCancel()
+	if config.Conf.GuildPurge.Enabled {
+		if config.Conf.Discord.Token == "" {
+			logger.Fatal("A Discord token must be configured when guild purges are enabled")
+			return
+		}
+
+		// Purges are queued like any other request, so the memory, Kafka and Postgres queues take them
+		// directly
+		var enqueuer guildpurge.Enqueuer
+		if memoryQueue, ok := queue.(*gdprrelay.MemoryQueue); ok {
+			enqueuer = memoryQueue
+		} else if kafkaQueue, ok := queue.(*gdprrelay.KafkaQueue); ok {
+			enqueuer = kafkaQueue
+		} else if postgresQueue, ok := queue.(*gdprrelay.PostgresQueue); ok {
+			enqueuer = postgresQueue
+		} else {
+			producer := gdprrelay.NewProducer(redisClient, config.Conf.Queue.Backend == "stream", laneTypes)
+			producer.SetClock(clk)
+			producer.SetStates(stateStore)
+			enqueuer = producer
+		}
+
+		scheduler := guildpurge.NewScheduler(
+			redisClient,
+			newRedisClient(1),
+			enqueuer,
+			config.Conf.Discord.Token,
+			config.Conf.GuildPurge.GracePeriod,
+			config.Conf.Queue.StreamGroup,
+			consumerName(logger),
+			logger.With(),
+		)
+		scheduler.SetClock(clk)
+
+		logger.Info("Starting guild purge scheduler", zap.Duration("grace_period", config.Conf.GuildPurge.GracePeriod))
+		go scheduler.Run(guildPurgeCtx)
+	}
+
+	quarantineCtx, quarantineCancel := context.WithCancel(context.Background())
+	defer quarantineCancel()
+	if config.Conf.SoftDelete.Enabled {
+		sweeper := quarantine.NewSweeper(database.Quarantine, proc, config.Conf.SoftDelete.SweepInterval, logger.With())
+		sweeper.SetClock(clk)
+
+		logger.Info("Starting quarantine sweeper", zap.Duration("grace_period", config.Conf.SoftDelete.GracePeriod))
+		go sweeper.Run(quarantineCtx)
+	}
+
+	platformErasureCtx, platformErasureCancel := context.WithCancel(context.Background())
+	defer platformErasureCancel()
+	if config.Conf.PlatformErasure.Enabled {
+		// Deletes one transcript at a time rather than purging the guild server-side, so the rate
+		// limit holds, and reports no progress as there is no user to report it to
+		options := processorOptions
+		options.Purger = nil
+		options.Progress = nil
+		options.TicketsPerSecond = config.Conf.PlatformErasure.TicketsPerSecond
+		options.PlatformErasures = true
+
+		runner := platformerasure.NewRunner(
+			database.PlatformErasures,
+			database.AdminActions,
+			processor.New(logger.With(zap.Bool("platform_erasure", true)), options),
+			config.Conf.PlatformErasure.Interval,
+			config.Conf.PlatformErasure.MaxAttempts,
+			config.Conf.PlatformErasure.RetryDelay,
+			logger.With(),
+		)
+		runner.SetClock(clk)
+
+		logger.Info("Starting platform erasure runner", zap.Float64("tickets_per_second", config.Conf.PlatformErasure.TicketsPerSecond))
+		go runner.Run(platformErasureCtx)
+	}
+
+	logger.Info("Starting cancellation listener")
+	cancellationCtx, cancellationCancel := context.WithCancel(context.Background())
+	defer cancellationCancel()
+	go workers.ListenCancellations(cancellationCtx, redisClient, logger.With())
+
+	logger.Info("Starting control channel listener")
+	controlCtx, controlCancel := context.WithCancel(context.Background())
+	defer controlCancel()
+	go control.Listen(controlCtx, redisClient, config.Conf.Control.Secret, localeDir, workers, logger.With())
+
+	adminCtx, adminCancel := context.WithCancel(context.Background())
+	defer adminCancel()
+	if config.Conf.Admin.Addr != "" {
+		if config.Conf.Admin.Token == "" && config.Conf.Admin.TokensFile == "" {
+			logger.Fatal("Admin API token or service tokens must be configured when the admin API is enabled")
+			return
+		}
+
+		var serviceTokens []admin.ServiceToken
+		if config.Conf.Admin.TokensFile != "" {
+			var err error
+			serviceTokens, err = admin.LoadServiceTokens(config.Conf.Admin.TokensFile)
+			if err != nil {
+				logger.Fatal("Failed to load admin service tokens", zap.Error(err))
+				return
+			}
+		}
+
+		adminServer := admin.NewServer(config.Conf.Admin.Token, serviceTokens, logger.With())
+		if memoryQueue, ok := queue.(*gdprrelay.MemoryQueue); ok {
+			adminServer.AcceptRequests(memoryQueue)
+		}
+		if queueInRedis && config.Conf.Queue.Backend != "kafka" {
+			adminServer.AllowActions(admin.NewQueueActions(redisClient, config.Conf.Queue.Backend == "stream", workers, config.Conf.CompletedTTL))
+		} else {
+			adminServer.AllowActions(admin.NewCancelActions(workers))
+		}
+		if config.Conf.SoftDelete.Enabled {
+			adminServer.AllowRestore(database.Quarantine)
+		}
+		if config.Conf.PlatformErasure.Enabled {
+			adminServer.AllowPlatformErasures(database.PlatformErasures)
+		}
+		if queueInRedis && config.Conf.Queue.Backend != "kafka" {
+			adminServer.AllowInspection(admin.NewQueueInspector(
+				redisClient,
+				config.Conf.Queue.Backend == "stream",
+				config.Conf.Queue.StreamGroup,
+				laneTypes,
+				config.Conf.Admin.StuckAfter,
+			), workers)
+		}
+
+		logger.Info("Starting admin API")
+		go adminServer.Serve(adminCtx, config.Conf.Admin.Addr)
+	} else if config.Conf.Queue.Backend == "memory" {
+		logger.Warn("Admin API is disabled, so nothing can be submitted to the in-memory queue")
+	}
 
 	logger.Info("GDPR Worker is now running.")
 
@@ -180,9 +714,206 @@ func main() {
 
 	logger.Info("Received shutdown signal, cleaning up...")
 
+	listenerCancel()
+	cancellationCancel()
+	guildPurgeCancel()
+	quarantineCancel()
+	lateSweepCancel()
+	deadLetterCancel()
+	platformErasureCancel()
+	if !workers.Shutdown(config.Conf.ShutdownTimeout) {
+		logger.Warn("Some GDPR requests did not finish before shutdown, they will be picked up again once their leases or claims expire")
+	}
+
+	// Closed once the workers are done with it, as they finish their requests through the queue
+	if closer, ok := queue.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("Failed to close GDPR queue", zap.Error(err))
+		}
+	}
+
 	logger.Info("GDPR Worker shutdown complete")
 }
 
+// newQueue creates the configured queue backend, consuming the shared queue if requestType is nil
+// or the type's dedicated queue otherwise
+func newQueue(redisClient, listenerRedisClient redis.UniversalClient, requestType *gdprrelay.RequestType, clk clock.Clock, logger *zap.Logger) gdprrelay.Queue {
+	switch config.Conf.Queue.Backend {
+	case "stream":
+		consumer := consumerName(logger)
+
+		logger.Info("Using Redis stream queue backend",
+			zap.String("group", config.Conf.Queue.StreamGroup),
+			zap.String("consumer", consumer),
+		)
+
+		var queue *gdprrelay.StreamQueue
+		if requestType != nil {
+			queue = gdprrelay.NewStreamQueueForType(
+				redisClient,
+				listenerRedisClient,
+				*requestType,
+				config.Conf.Queue.StreamGroup,
+				consumer,
+				config.Conf.Queue.StreamClaimMinIdle,
+				logger,
+			)
+		} else {
+			queue = gdprrelay.NewStreamQueue(
+				redisClient,
+				listenerRedisClient,
+				config.Conf.Queue.StreamGroup,
+				consumer,
+				config.Conf.Queue.StreamClaimMinIdle,
+				logger,
+			)
+		}
+
+		queue.SetClock(clk)
+		return queue
+	case "kafka":
+		if requestType != nil {
+			logger.Fatal("Dedicated queues are not supported by the Kafka queue backend")
+		}
+
+		queue, err := newKafkaQueue(redisClient, logger)
+		if err != nil {
+			logger.Fatal("Failed to configure Kafka queue backend", zap.Error(err))
+		}
+
+		queue.SetClock(clk)
+		return queue
+	case "postgres":
+		if requestType != nil {
+			logger.Fatal("Dedicated queues are not supported by the Postgres queue backend")
+		}
+
+		owner := consumerName(logger)
+
+		logger.Info("Using Postgres queue backend",
+			zap.String("owner", owner),
+			zap.Duration("lease_ttl", config.Conf.Queue.ListLeaseTTL),
+		)
+
+		queue := gdprrelay.NewPostgresQueue(database.RequestQueue, redisClient, owner, config.Conf.Queue.ListLeaseTTL, logger)
+		queue.SetClock(clk)
+		return queue
+	case "memory":
+		if requestType != nil {
+			logger.Fatal("Dedicated queues are not supported by the memory queue backend")
+		}
+
+		logger.Warn("Using in-memory queue backend, requests are lost on restart")
+		queue := gdprrelay.NewMemoryQueue(config.Conf.Queue.MemorySize, logger)
+		queue.SetClock(clk)
+		return queue
+	case "list":
+		owner := consumerName(logger)
+
+		logger.Info("Using Redis list queue backend",
+			zap.String("owner", owner),
+			zap.Duration("lease_ttl", config.Conf.Queue.ListLeaseTTL),
+		)
+
+		var queue *gdprrelay.ListQueue
+		if requestType != nil {
+			queue = gdprrelay.NewListQueueForType(
+				redisClient,
+				listenerRedisClient,
+				*requestType,
+				owner,
+				config.Conf.Queue.ListLeaseTTL,
+				logger,
+			)
+		} else {
+			queue = gdprrelay.NewListQueue(
+				redisClient,
+				listenerRedisClient,
+				owner,
+				config.Conf.Queue.ListLeaseTTL,
+				logger,
+			)
+		}
+
+		// A request taken back while still within its timeout may be processed twice
+		if timeout := config.Conf.Queue.VisibilityTimeout; timeout > 0 && timeout <= config.Conf.RequestTimeout {
+			logger.Warn("Queue visibility timeout is no longer than the request timeout, requests may be taken back while still being processed",
+				zap.Duration("visibility_timeout", timeout),
+				zap.Duration("request_timeout", config.Conf.RequestTimeout),
+			)
+		}
+
+		queue.SetVisibilityTimeout(config.Conf.Queue.VisibilityTimeout)
+		queue.SetClock(clk)
+		return queue
+	default:
+		logger.Fatal("Unknown queue backend", zap.String("backend", config.Conf.Queue.Backend))
+		return nil
+	}
+}
+
+// consumerName returns the name this worker reads Redis streams and holds list leases as, its
+// hostname unless QUEUE_STREAM_CONSUMER is set
+func consumerName(logger *zap.Logger) string {
+	if config.Conf.Queue.StreamConsumer != "" {
+		return config.Conf.Queue.StreamConsumer
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Fatal("Failed to determine queue consumer name", zap.Error(err))
+	}
+
+	return hostname
+}
+
+// newOfflineBuffer opens the offline buffer of the worker consuming the named queue, or returns nil
+// if buffering is disabled
+func newOfflineBuffer(name string, logger *zap.Logger) *gdprrelay.OfflineBuffer {
+	if config.Conf.OfflineBuffer.Dir == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(config.Conf.OfflineBuffer.Key)
+	if err != nil || len(key) != 32 {
+		logger.Fatal("OFFLINE_BUFFER_KEY must be a base64 encoded 32 byte key")
+		return nil
+	}
+
+	buffer, err := gdprrelay.NewOfflineBuffer(filepath.Join(config.Conf.OfflineBuffer.Dir, name), key, config.Conf.OfflineBuffer.MaxEntries)
+	if err != nil {
+		logger.Fatal("Failed to open offline buffer", zap.Error(err))
+		return nil
+	}
+
+	if pending := buffer.Len(); pending > 0 {
+		logger.Info("Loaded buffered GDPR request writes to replay", zap.Int("count", pending))
+	}
+
+	return buffer
+}
+
+// downloadLocales fetches the latest locale bundle, returning the directory to load locales from. The locales
+// bundled with the image are used if the download fails.
+func downloadLocales(logger *zap.Logger) string {
+	logger.Info("Downloading locale bundle", zap.String("url", config.Conf.Locale.BundleUrl))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Conf.Locale.DownloadTimeout)
+	defer cancel()
+
+	source := i18n.BundleSource{
+		Url:    config.Conf.Locale.BundleUrl,
+		Sha256: config.Conf.Locale.BundleSha256,
+	}
+
+	if err := i18n.Download(ctx, http.DefaultClient, source, config.Conf.Locale.Dir); err != nil {
+		logger.Error("Failed to download locale bundle, using bundled locales", zap.Error(err))
+		return localePath
+	}
+
+	return config.Conf.Locale.Dir
+}
+
 func initLogger(jsonLogs bool, level zapcore.Level) *zap.Logger {
 	var config zap.Config
 
@@ -203,3 +934,23 @@ func initLogger(jsonLogs bool, level zapcore.Level) *zap.Logger {
 
 	return logger
 }
+
+// newDeadLetterAlerter creates the configured alerter for the failed queue, nil if alerts are
+// disabled. A webhook is preferred over posting to a channel as the bot.
+func newDeadLetterAlerter() (deadletter.Alerter, error) {
+	conf := config.Conf.DeadLetter
+
+	if conf.AlertWebhook != "" {
+		return deadletter.NewWebhookAlerter(conf.AlertWebhook)
+	}
+
+	if conf.AlertChannelId != 0 {
+		if config.Conf.Discord.Token == "" {
+			return nil, fmt.Errorf("a Discord token must be configured to post alerts to a channel")
+		}
+
+		return deadletter.NewChannelAlerter(config.Conf.Discord.Token, conf.AlertChannelId), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/heartbeat"
+)
+
+// migrateQueue moves the queue to another backend or namespace, so the queue architecture can be
+// changed without dropping requests. In-flight requests are moved back to waiting, so it refuses
+// to run while a worker is alive unless forced.
+func migrateQueue(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("migrate-queue", flag.ContinueOnError)
+	fromBackend := flags.String("from", config.Conf.Queue.Backend, "backend to move requests out of, list or stream")
+	toBackend := flags.String("to", "", "backend to move requests into, list or stream")
+	fromNamespace := flags.String("from-namespace", "", "key prefix of the source queue, tickets:gdpr if empty")
+	toNamespace := flags.String("to-namespace", "", "key prefix of the destination queue, tickets:gdpr if empty")
+	dryRun := flags.Bool("dry-run", false, "only count the requests that would be moved")
+	force := flags.Bool("force", false, "migrate even though a worker heartbeat was found")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := migrationLayout(*fromBackend, *fromNamespace)
+	if err != nil {
+		return err
+	}
+
+	to, err := migrationLayout(*toBackend, *toNamespace)
+	if err != nil {
+		return err
+	}
+
+	redisClient := newRedisClient(1)
+	defer redisClient.Close()
+
+	if !*dryRun && !*force {
+		alive, err := heartbeat.Check(ctx, redisClient)
+		if err != nil {
+			return fmt.Errorf("failed to check for running workers: %w", err)
+		}
+
+		// A worker would keep processing the requests moved back out of its processing queue
+		if alive {
+			return fmt.Errorf("a worker is still running, stop every worker before migrating or pass -force")
+		}
+	}
+
+	results, err := gdprrelay.MigrateQueue(ctx, redisClient, from, to, *dryRun)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDESTINATION\tFOUND\tMOVED")
+
+	var found, moved int
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", result.Source, result.Destination, result.Found, result.Moved)
+		found += result.Found
+		moved += result.Moved
+	}
+
+	if flushErr := tw.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *dryRun:
+		fmt.Printf("\n%d request(s) would be moved, nothing was changed\n", found)
+	case moved != found:
+		return fmt.Errorf("moved %d of %d request(s), stream entries without a request were left in place", moved, found)
+	default:
+		fmt.Printf("\nMoved %d request(s)\n", moved)
+	}
+
+	return nil
+}
+
+func migrationLayout(backend, namespace string) (gdprrelay.MigrationLayout, error) {
+	switch backend {
+	case "list", "stream":
+		return gdprrelay.MigrationLayout{
+			Stream:    backend == "stream",
+			Group:     config.Conf.Queue.StreamGroup,
+			Namespace: namespace,
+		}, nil
+	default:
+		return gdprrelay.MigrationLayout{}, fmt.Errorf("unsupported backend %q, expected list or stream", backend)
+	}
+}
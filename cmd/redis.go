@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTLS is the TLS configuration Redis connections are made with, nil if TLS is disabled. Set by
+// loadRedisConfig.
+var redisTLS *tls.Config
+
+// loadRedisConfig checks the Redis settings of the configured mode and loads the TLS certificates,
+// so a misconfiguration is reported once at startup rather than by each client
+func loadRedisConfig() error {
+	conf := config.Conf.Redis
+
+	switch conf.Mode {
+	case "standalone":
+	case "sentinel":
+		if conf.SentinelMasterName == "" || len(conf.SentinelAddrs) == 0 {
+			return fmt.Errorf("REDIS_SENTINEL_MASTER_NAME and REDIS_SENTINEL_ADDRS are required in sentinel mode")
+		}
+	case "cluster":
+		if len(conf.ClusterAddrs) == 0 {
+			return fmt.Errorf("REDIS_CLUSTER_ADDRS is required in cluster mode")
+		}
+	default:
+		return fmt.Errorf("unknown Redis mode %q, must be standalone, sentinel or cluster", conf.Mode)
+	}
+
+	if !conf.TLS {
+		redisTLS = nil
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         conf.TLSServerName,
+		InsecureSkipVerify: conf.TLSSkipVerify,
+	}
+
+	if conf.TLSCAFile != "" {
+		pem, err := os.ReadFile(conf.TLSCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read Redis CA certificate: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in Redis CA file %s", conf.TLSCAFile)
+		}
+	}
+
+	if conf.TLSCertFile != "" || conf.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load Redis client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	redisTLS = tlsConfig
+	return nil
+}
+
+// newRedisClient connects to Redis in the configured mode, with poolSize connections to each node
+func newRedisClient(poolSize int) redis.UniversalClient {
+	conf := config.Conf.Redis
+
+	switch conf.Mode {
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       conf.SentinelMasterName,
+			SentinelAddrs:    conf.SentinelAddrs,
+			SentinelPassword: conf.SentinelPassword,
+			Username:         conf.Username,
+			Password:         conf.Password,
+			PoolSize:         poolSize,
+			Protocol:         3,
+			TLSConfig:        redisTLS,
+
+			ContextTimeoutEnabled: true,
+		})
+	case "cluster":
+		// Every key is renamed into a single slot, so commands spanning several keys keep working
+		client := redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     conf.ClusterAddrs,
+			Username:  conf.Username,
+			Password:  conf.Password,
+			PoolSize:  poolSize,
+			Protocol:  3,
+			TLSConfig: redisTLS,
+			NewClient: func(opt *redis.Options) *redis.Client {
+				node := redis.NewClient(opt)
+				node.AddHook(gdprrelay.ClusterKeys{})
+				return node
+			},
+
+			ContextTimeoutEnabled: true,
+		})
+		client.AddHook(gdprrelay.ClusterKeys{})
+		return client
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      conf.Address,
+			Username:  conf.Username,
+			Password:  conf.Password,
+			DB:        0,
+			PoolSize:  poolSize,
+			Protocol:  3,
+			TLSConfig: redisTLS,
+
+			// Commands give up at their context's deadline, rather than only the connection timeouts
+			ContextTimeoutEnabled: true,
+		})
+	}
+}
+
+// redisTarget describes the Redis deployment connected to, for messages
+func redisTarget() string {
+	conf := config.Conf.Redis
+
+	switch conf.Mode {
+	case "sentinel":
+		return fmt.Sprintf("master %s via sentinels %s", conf.SentinelMasterName, strings.Join(conf.SentinelAddrs, ", "))
+	case "cluster":
+		return fmt.Sprintf("cluster %s", strings.Join(conf.ClusterAddrs, ", "))
+	default:
+		return conf.Address
+	}
+}
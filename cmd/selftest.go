@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TicketsBot-cloud/archiverclient"
+	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
+	"github.com/TicketsBot-cloud/gdl/objects/user"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/archiver"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/callback"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/discordproxy"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/exportstore"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/worker"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Credentials and addresses of the stack in selftest/docker-compose.yml
+const (
+	selftestRedisAddr    = "localhost:16379"
+	selftestDatabaseHost = "localhost:15432"
+	selftestDatabaseName = "tickets"
+	selftestDatabaseUser = "selftest"
+	selftestArchiverUrl  = "http://localhost:14000"
+	selftestS3Endpoint   = "localhost:19000"
+	selftestS3AccessKey  = "selftest"
+	selftestS3SecretKey  = "selftest-secret"
+	selftestAesKey       = "selftest-transcript-key-32-bytes"
+	selftestDiscordToken = "selftest"
+
+	transcriptBucket = "transcripts" // Must match the bucket registered in selftest/archiver.sql
+	exportBucket     = "exports"
+)
+
+// selftestCaseTimeout bounds how long each request may take to be picked up and processed
+const selftestCaseTimeout = 2 * time.Minute
+
+// selftestFixtures are the servers, users and tickets seeded for a run. IDs are derived from the
+// start time, so runs against a stack kept with -keep don't see each other's data.
+type selftestFixtures struct {
+	userId  uint64 // The user making every request
+	otherId uint64 // Another member, whose data must be left alone
+
+	ownedGuild  uint64 // Owned by the user, erased as a whole
+	otherGuild  uint64 // Owned by the other user
+	memberGuild uint64 // Owned by the other user, who added the user to a ticket
+
+	owned  []int // Tickets in ownedGuild, opened by the user and the other user
+	opened int   // Ticket in otherGuild opened by the user, erased as its opener
+	mixed  int   // Ticket in otherGuild opened by the user, with messages from both users
+	joined int   // Ticket in memberGuild opened by the other user, with messages from both users
+}
+
+type selftestCase struct {
+	name    string
+	request gdpr.Request
+	check   func(ctx context.Context, result callback.ResultData) error
+}
+
+type selftestOutcome struct {
+	name string
+	err  error
+}
+
+// selftest starts the dependencies in selftest/docker-compose.yml, runs a request of every type
+// through the queue, processor and worker built into this binary, and checks what each left behind.
+// Discord is replaced by a stub answering guild lookups, and completions are recorded instead of
+// being sent, so no bot is needed. It fails if any check does.
+func selftest(args []string) error {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	composeFile := flags.String("compose", "selftest/docker-compose.yml", "compose file defining the dependencies")
+	keep := flags.Bool("keep", false, "leave the dependencies running afterwards, for inspection")
+	timeout := flags.Duration("timeout", 15*time.Minute, "how long the whole self test may take, including starting the dependencies")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// The commands share one timeout, which building the archiver image alone can exceed
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	fmt.Println("Starting dependencies")
+	if err := compose(ctx, *composeFile, "up", "--detach", "--wait", "--build"); err != nil {
+		return fmt.Errorf("failed to start dependencies: %w", err)
+	}
+
+	if *keep {
+		defer fmt.Printf("Dependencies left running, stop them with: docker compose -f %s down -v\n", *composeFile)
+	} else {
+		defer func() {
+			fmt.Println("Stopping dependencies")
+			if err := compose(context.Background(), *composeFile, "down", "--volumes"); err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to stop dependencies:", err)
+			}
+		}()
+	}
+
+	useSelftestStack()
+	logger := initLogger(config.Conf.JsonLogs, zapcore.WarnLevel)
+
+	if err := waitForDependencies(ctx); err != nil {
+		return err
+	}
+
+	proc, err := newSelftestProcessor(ctx, logger)
+	if err != nil {
+		return err
+	}
+
+	fixtures, err := seedFixtures(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to seed fixtures: %w", err)
+	}
+
+	discord := httptest.NewServer(fakeDiscord(map[uint64]uint64{
+		fixtures.ownedGuild:  fixtures.userId,
+		fixtures.otherGuild:  fixtures.otherId,
+		fixtures.memberGuild: fixtures.otherId,
+	}))
+	defer discord.Close()
+
+	if err := discordproxy.Install(discord.URL, logger.With()); err != nil {
+		return fmt.Errorf("failed to route Discord calls to stub: %w", err)
+	}
+
+	redisClient := newRedisClient(4)
+	defer redisClient.Close()
+
+	listenerRedisClient := newRedisClient(1)
+	defer listenerRedisClient.Close()
+
+	listenerCtx, listenerCancel := context.WithCancel(ctx)
+	defer listenerCancel()
+
+	queue := newQueue(redisClient, listenerRedisClient, nil, clock.Real, logger.With())
+	notifier := newRecordingNotifier()
+	sloTracker := metrics.NewSLOTracker(redisClient, logger.With(), config.Conf.Metrics.SLOWindow,
+		config.Conf.Metrics.SLOPendingMaxAge, config.Conf.Metrics.SLOTarget)
+
+	w := worker.New(logger.With(), redisClient, queue, proc, notifier, nil, sloTracker, 1)
+	ch := make(chan gdprrelay.QueuedRequest)
+	go queue.Listen(listenerCtx, w, ch)
+	go w.Run(ch)
+	defer w.Shutdown(5 * time.Second)
+
+	producer := gdprrelay.NewProducer(redisClient, config.Conf.Queue.Backend == "stream", nil)
+
+	// Requests run one at a time in this order, so each sees what the previous ones left behind
+	baseId := int(time.Now().Unix()%1_000_000) * 100
+	outcomes := make([]selftestOutcome, 0)
+	for i, c := range selftestCases(fixtures) {
+		requestId := baseId + i
+		results := notifier.expect(requestId)
+
+		fmt.Printf("Running %s\n", c.name)
+		err := runSelftestCase(ctx, producer, c, requestId, results)
+		outcomes = append(outcomes, selftestOutcome{name: c.name, err: err})
+	}
+
+	return printSelftest(outcomes)
+}
+
+func runSelftestCase(ctx context.Context, producer *gdprrelay.Producer, c selftestCase, requestId int, results <-chan callback.ResultData) error {
+	ctx, cancel := context.WithTimeout(ctx, selftestCaseTimeout)
+	defer cancel()
+
+	if _, err := producer.Enqueue(ctx, gdpr.NewQueuedRequest(c.request, requestId)); err != nil {
+		return fmt.Errorf("failed to enqueue request: %w", err)
+	}
+
+	var result callback.ResultData
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("request %d did not complete within %s", requestId, selftestCaseTimeout)
+	case result = <-results:
+	}
+
+	if result.Error != nil {
+		return fmt.Errorf("request failed: %w", result.Error)
+	}
+
+	return c.check(ctx, result)
+}
+
+func selftestCases(f selftestFixtures) []selftestCase {
+	return []selftestCase{
+		{
+			name:    "DataExport",
+			request: gdpr.Request{Type: gdpr.RequestTypeDataExport, UserId: f.userId},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				// Every ticket the user opened or was added to has a transcript at this point
+				if result.TranscriptsExported != 4 {
+					return fmt.Errorf("expected 4 transcripts exported, got %d", result.TranscriptsExported)
+				}
+
+				return checkDownload(ctx, result.ExportUrl)
+			},
+		},
+		{
+			name:    "Feedback",
+			request: gdpr.Request{Type: gdpr.RequestTypeFeedback, UserId: f.userId},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				if result.FeedbackDeleted != 2 {
+					return fmt.Errorf("expected 2 ratings deleted, got %d", result.FeedbackDeleted)
+				}
+
+				var remaining int
+				err := database.Client.Tickets.QueryRow(ctx,
+					`SELECT COUNT(*) FROM service_ratings WHERE guild_id = ANY($1)`,
+					[]uint64{f.ownedGuild, f.otherGuild},
+				).Scan(&remaining)
+				if err != nil {
+					return fmt.Errorf("failed to count ratings: %w", err)
+				} else if remaining != 0 {
+					return fmt.Errorf("%d rating(s) left after deletion", remaining)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "SpecificMessages",
+			request: gdpr.Request{
+				Type:      gdpr.RequestTypeSpecificMessages,
+				UserId:    f.userId,
+				GuildIds:  []uint64{f.otherGuild},
+				TicketIds: []int{f.mixed},
+			},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				if result.MessagesDeleted != 2 {
+					return fmt.Errorf("expected 2 messages deleted, got %d", result.MessagesDeleted)
+				}
+
+				return checkMessagesRemoved(ctx, f.otherGuild, f.mixed, f.userId, f.otherId)
+			},
+		},
+		{
+			name: "AllMessages",
+			request: gdpr.Request{
+				Type:     gdpr.RequestTypeAllMessages,
+				UserId:   f.userId,
+				GuildIds: []uint64{f.memberGuild},
+			},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				if result.MessagesDeleted != 1 {
+					return fmt.Errorf("expected 1 message deleted, got %d", result.MessagesDeleted)
+				}
+
+				return checkMessagesRemoved(ctx, f.memberGuild, f.joined, f.userId, f.otherId)
+			},
+		},
+		{
+			name: "SpecificTranscripts",
+			request: gdpr.Request{
+				Type:             gdpr.RequestTypeSpecificTranscripts,
+				UserId:           f.userId,
+				GuildIds:         []uint64{f.otherGuild},
+				TicketIds:        []int{f.opened},
+				VerificationMode: gdpr.VerificationModeOpener,
+			},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				if result.TranscriptsDeleted != 1 {
+					return fmt.Errorf("expected 1 transcript deleted, got %d", result.TranscriptsDeleted)
+				}
+
+				if err := checkTranscriptDeleted(ctx, f.otherGuild, f.opened); err != nil {
+					return err
+				}
+
+				// Tickets not named in the request stay
+				return checkTranscriptExists(ctx, f.otherGuild, f.mixed)
+			},
+		},
+		{
+			name: "AllTranscripts",
+			request: gdpr.Request{
+				Type:     gdpr.RequestTypeAllTranscripts,
+				UserId:   f.userId,
+				GuildIds: []uint64{f.ownedGuild},
+			},
+			check: func(ctx context.Context, result callback.ResultData) error {
+				if result.TranscriptsDeleted != len(f.owned) {
+					return fmt.Errorf("expected %d transcripts deleted, got %d", len(f.owned), result.TranscriptsDeleted)
+				}
+
+				for _, ticketId := range f.owned {
+					if err := checkTranscriptDeleted(ctx, f.ownedGuild, ticketId); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+}
+
+// useSelftestStack points the configuration at the compose stack, whatever the environment says,
+// so the self test can never write fixtures to or erase data from a real deployment
+func useSelftestStack() {
+	config.Conf.Redis.Mode = "standalone"
+	config.Conf.Redis.Address = selftestRedisAddr
+	config.Conf.Redis.Username = ""
+	config.Conf.Redis.Password = ""
+	config.Conf.Redis.TLS = false
+	redisTLS = nil
+	config.Conf.Database.Host = selftestDatabaseHost
+	config.Conf.Database.Database = selftestDatabaseName
+	config.Conf.Database.Username = selftestDatabaseUser
+	config.Conf.Database.Password = selftestDatabaseUser
+	config.Conf.Archiver.Url = selftestArchiverUrl
+	config.Conf.Archiver.AesKey = selftestAesKey
+	config.Conf.Queue.Backend = "list"
+	config.Conf.Discord.Token = selftestDiscordToken
+	config.Conf.DryRun = false
+}
+
+func compose(ctx context.Context, file string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"compose", "-f", file}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// waitForDependencies waits for the archiver to accept requests, which compose can't tell as it
+// has no health check
+func waitForDependencies(ctx context.Context) error {
+	for {
+		err := checkHttp(ctx, selftestArchiverUrl)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("archiver did not become reachable: %w", err)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func newSelftestProcessor(ctx context.Context, logger *zap.Logger) (*processor.Processor, error) {
+	if err := database.Connect(logger.With(), selftestDatabaseHost, selftestDatabaseName, selftestDatabaseUser, selftestDatabaseUser, 4); err != nil {
+		return nil, err
+	}
+
+	// The bot owns the ticket tables, so nothing else creates them in a fresh database
+	database.Client.CreateTables(ctx, database.Jobs.Pool)
+
+	if err := createBuckets(ctx); err != nil {
+		return nil, err
+	}
+
+	archiver.Initialize(logger.With(), selftestArchiverUrl, selftestAesKey)
+
+	exportStore, err := exportstore.NewS3Store(selftestS3Endpoint, selftestS3AccessKey, selftestS3SecretKey, exportBucket, false, time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize export store: %w", err)
+	}
+
+	var batchDeleter processor.BatchDeleter
+	if config.Conf.Archiver.BatchDelete {
+		batchDeleter = archiver.Batch
+	}
+
+	return processor.New(logger.With(), processor.Options{
+		Database:         database.Client,
+		Archiver:         archiver.Client,
+		Retriever:        archiver.Metered,
+		Purger:           archiver.Proxy,
+		PurgeTimeout:     config.Conf.Archiver.PurgeTimeout,
+		BatchDeleter:     batchDeleter,
+		BatchSize:        config.Conf.Archiver.BatchSize,
+		DiscordToken:     selftestDiscordToken,
+		ExportStore:      exportStore,
+		TranscriptKey:    []byte(selftestAesKey),
+		StreamThreshold:  config.Conf.Archiver.StreamThreshold,
+		VerificationMode: gdpr.VerificationModeOwner,
+		LegalHolds:       database.LegalHolds,
+	}), nil
+}
+
+func createBuckets(ctx context.Context) error {
+	client, err := minio.New(selftestS3Endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(selftestS3AccessKey, selftestS3SecretKey, ""),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	for _, bucket := range []string{transcriptBucket, exportBucket} {
+		exists, err := client.BucketExists(ctx, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to check bucket %s: %w", bucket, err)
+		}
+
+		if !exists {
+			if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func seedFixtures(ctx context.Context) (selftestFixtures, error) {
+	base := uint64(time.Now().Unix()) * 100
+	f := selftestFixtures{
+		userId:      base + 1,
+		otherId:     base + 2,
+		ownedGuild:  base + 11,
+		otherGuild:  base + 12,
+		memberGuild: base + 13,
+	}
+
+	for _, openerId := range []uint64{f.userId, f.otherId} {
+		ticketId, err := createTicket(ctx, f.ownedGuild, openerId, openerId)
+		if err != nil {
+			return f, err
+		}
+		f.owned = append(f.owned, ticketId)
+	}
+
+	var err error
+	if f.opened, err = createTicket(ctx, f.otherGuild, f.userId, f.userId, f.otherId); err != nil {
+		return f, err
+	}
+
+	if f.mixed, err = createTicket(ctx, f.otherGuild, f.userId, f.userId, f.otherId, f.userId); err != nil {
+		return f, err
+	}
+
+	if f.joined, err = createTicket(ctx, f.memberGuild, f.otherId, f.otherId, f.userId); err != nil {
+		return f, err
+	}
+
+	if err := database.Client.TicketMembers.Add(ctx, f.memberGuild, f.joined, f.userId); err != nil {
+		return f, fmt.Errorf("failed to add ticket member: %w", err)
+	}
+
+	for _, ticket := range []struct {
+		guildId  uint64
+		ticketId int
+	}{{f.ownedGuild, f.owned[0]}, {f.otherGuild, f.mixed}} {
+		if err := database.Client.ServiceRatings.Set(ctx, ticket.guildId, ticket.ticketId, 5); err != nil {
+			return f, fmt.Errorf("failed to rate ticket: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// createTicket creates a closed ticket with a transcript holding a message from each author in turn
+func createTicket(ctx context.Context, guildId, openerId uint64, authors ...uint64) (int, error) {
+	ticketId, err := database.Client.Tickets.Create(ctx, guildId, openerId, false, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ticket: %w", err)
+	}
+
+	if _, err := database.Client.Tickets.Exec(ctx,
+		`UPDATE tickets SET open = false, has_transcript = true WHERE guild_id = $1 AND id = $2`,
+		guildId, ticketId,
+	); err != nil {
+		return 0, fmt.Errorf("failed to close ticket: %w", err)
+	}
+
+	messages := make([]message.Message, len(authors))
+	for i, authorId := range authors {
+		messages[i] = message.Message{
+			Id:        uint64(ticketId)*100 + uint64(i) + 1,
+			ChannelId: guildId,
+			GuildId:   guildId,
+			Author:    user.User{Id: authorId, Username: fmt.Sprintf("selftest-%d", authorId)},
+			Content:   fmt.Sprintf("Self test message %d", i+1),
+			Timestamp: time.Now(),
+		}
+	}
+
+	if err := archiver.Client.Store(ctx, guildId, ticketId, messages); err != nil {
+		return 0, fmt.Errorf("failed to store transcript: %w", err)
+	}
+
+	return ticketId, nil
+}
+
+// fakeDiscord answers guild lookups with the given owners, and every other call with Unknown Guild
+func fakeDiscord(owners map[uint64]uint64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		_, id, found := strings.Cut(r.URL.Path, "/guilds/")
+		guildId, err := strconv.ParseUint(id, 10, 64)
+		ownerId, known := owners[guildId]
+		if !found || err != nil || !known || r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Unknown Guild", "code": 10004}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"id":       strconv.FormatUint(guildId, 10),
+			"name":     "Self test",
+			"owner_id": strconv.FormatUint(ownerId, 10),
+		})
+	})
+}
+
+func checkDownload(ctx context.Context, url string) error {
+	if url == "" {
+		return fmt.Errorf("no export download link")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download export: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("export download returned %s", res.Status)
+	}
+
+	return nil
+}
+
+// checkMessagesRemoved checks no message by the user is left in the transcript, and that the other
+// user's messages were kept
+func checkMessagesRemoved(ctx context.Context, guildId uint64, ticketId int, userId, otherId uint64) error {
+	transcript, err := archiver.Client.Get(ctx, guildId, ticketId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch transcript of ticket %d: %w", ticketId, err)
+	}
+
+	var kept int
+	for _, msg := range transcript.Messages {
+		switch msg.AuthorId {
+		case userId:
+			return fmt.Errorf("message %d by the user is still in ticket %d", msg.Id, ticketId)
+		case otherId:
+			kept++
+		}
+	}
+
+	if kept == 0 {
+		return fmt.Errorf("messages by other users were removed from ticket %d", ticketId)
+	}
+
+	return nil
+}
+
+func checkTranscriptDeleted(ctx context.Context, guildId uint64, ticketId int) error {
+	if _, err := archiver.Client.Get(ctx, guildId, ticketId); err == nil {
+		return fmt.Errorf("transcript of ticket %d is still stored", ticketId)
+	} else if !errors.Is(err, archiverclient.ErrNotFound) {
+		return fmt.Errorf("failed to fetch transcript of ticket %d: %w", ticketId, err)
+	}
+
+	var hasTranscript bool
+	if err := database.Client.Tickets.QueryRow(ctx,
+		`SELECT has_transcript FROM tickets WHERE guild_id = $1 AND id = $2`,
+		guildId, ticketId,
+	).Scan(&hasTranscript); err != nil {
+		return fmt.Errorf("failed to read ticket %d: %w", ticketId, err)
+	}
+
+	if hasTranscript {
+		return fmt.Errorf("ticket %d is still marked as having a transcript", ticketId)
+	}
+
+	return nil
+}
+
+func checkTranscriptExists(ctx context.Context, guildId uint64, ticketId int) error {
+	if _, err := archiver.Client.Get(ctx, guildId, ticketId); err != nil {
+		return fmt.Errorf("transcript of ticket %d should have been kept: %w", ticketId, err)
+	}
+
+	return nil
+}
+
+func printSelftest(outcomes []selftestOutcome) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nREQUEST TYPE\tRESULT\tDETAIL")
+
+	failed := 0
+	for _, outcome := range outcomes {
+		if outcome.err != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\tFAIL\t%s\n", outcome.name, outcome.err)
+		} else {
+			fmt.Fprintf(tw, "%s\tPASS\t-\n", outcome.name)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d check(s) failed", failed, len(outcomes))
+	}
+
+	fmt.Printf("\nAll %d checks passed\n", len(outcomes))
+	return nil
+}
+
+// recordingNotifier takes the place of the callback, handing each completion to whoever is waiting
+// for the request
+type recordingNotifier struct {
+	mu      sync.Mutex
+	waiting map[int]chan callback.ResultData
+}
+
+var _ worker.Notifier = (*recordingNotifier)(nil)
+
+func newRecordingNotifier() *recordingNotifier {
+	return &recordingNotifier{
+		waiting: make(map[int]chan callback.ResultData),
+	}
+}
+
+func (n *recordingNotifier) expect(requestId int) <-chan callback.ResultData {
+	ch := make(chan callback.ResultData, 1)
+
+	n.mu.Lock()
+	n.waiting[requestId] = ch
+	n.mu.Unlock()
+
+	return ch
+}
+
+func (n *recordingNotifier) SendCompletion(_ context.Context, queued gdprrelay.QueuedRequest, result callback.ResultData) error {
+	n.mu.Lock()
+	ch, ok := n.waiting[queued.RequestID]
+	delete(n.waiting, queued.RequestID)
+	n.mu.Unlock()
+
+	if ok {
+		ch <- result
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+)
+
+// minLeaseTTL keeps the lease renewal interval, a third of the TTL, well clear of zero
+const minLeaseTTL = time.Second
+
+// validateConfig checks settings that would otherwise only fail once the worker is running
+func validateConfig(conf config.Config) error {
+	switch conf.Queue.Backend {
+	case "list", "postgres":
+		if conf.Queue.ListLeaseTTL < minLeaseTTL {
+			return fmt.Errorf("QUEUE_LIST_LEASE_TTL must be at least %s, got %s", minLeaseTTL, conf.Queue.ListLeaseTTL)
+		}
+	}
+
+	// A checksum fetched from alongside the bundle would pass for any bundle an attacker swapped in
+	if conf.Locale.BundleUrl != "" && conf.Locale.BundleSha256 == "" {
+		return fmt.Errorf("LOCALE_BUNDLE_SHA256 is required when LOCALE_BUNDLE_URL is set")
+	}
+
+	// Each of these drives a ticker, which can't run at a non-positive interval
+	intervals := []struct {
+		name    string
+		enabled bool
+		value   time.Duration
+	}{
+		{"OFFLINE_BUFFER_REPLAY_INTERVAL", conf.OfflineBuffer.Dir != "", conf.OfflineBuffer.ReplayInterval},
+		{"SOFT_DELETE_SWEEP_INTERVAL", conf.SoftDelete.Enabled, conf.SoftDelete.SweepInterval},
+		{"PLATFORM_ERASURE_INTERVAL", conf.PlatformErasure.Enabled, conf.PlatformErasure.Interval},
+	}
+
+	for _, interval := range intervals {
+		if interval.enabled && interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", interval.name, interval.value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+)
+
+func TestValidateConfigRejectsShortLeaseTTL(t *testing.T) {
+	for _, backend := range []string{"list", "postgres"} {
+		for _, ttl := range []time.Duration{0, -time.Minute, 2 * time.Nanosecond} {
+			var conf config.Config
+			conf.Queue.Backend = backend
+			conf.Queue.ListLeaseTTL = ttl
+
+			if err := validateConfig(conf); err == nil {
+				t.Errorf("expected a lease TTL of %s to be rejected with the %s backend", ttl, backend)
+			}
+		}
+	}
+}
+
+func TestValidateConfigRejectsNonPositiveIntervals(t *testing.T) {
+	cases := map[string]func(conf *config.Config){
+		"replay": func(conf *config.Config) {
+			conf.OfflineBuffer.Dir = "/tmp/buffer"
+			conf.OfflineBuffer.ReplayInterval = 0
+		},
+		"quarantine sweep": func(conf *config.Config) {
+			conf.SoftDelete.Enabled = true
+			conf.SoftDelete.SweepInterval = -time.Second
+		},
+		"platform erasure": func(conf *config.Config) {
+			conf.PlatformErasure.Enabled = true
+			conf.PlatformErasure.Interval = 0
+		},
+	}
+
+	for name, configure := range cases {
+		conf := config.Conf
+		configure(&conf)
+
+		if err := validateConfig(conf); err == nil {
+			t.Errorf("expected a non-positive %s interval to be rejected", name)
+		}
+	}
+}
+
+func TestValidateConfigIgnoresIntervalsOfDisabledFeatures(t *testing.T) {
+	conf := config.Conf
+	conf.OfflineBuffer.Dir = ""
+	conf.OfflineBuffer.ReplayInterval = 0
+	conf.SoftDelete.Enabled = false
+	conf.SoftDelete.SweepInterval = 0
+	conf.PlatformErasure.Enabled = false
+	conf.PlatformErasure.Interval = 0
+
+	if err := validateConfig(conf); err != nil {
+		t.Fatalf("expected intervals of disabled features to be ignored: %v", err)
+	}
+}
+
+func TestValidateConfigRequiresPinnedLocaleChecksum(t *testing.T) {
+	conf := config.Conf
+	conf.Locale.BundleUrl = "https://example.com/locales.tar.gz"
+	conf.Locale.BundleSha256 = ""
+
+	if err := validateConfig(conf); err == nil {
+		t.Fatal("expected a locale bundle URL without a pinned checksum to be rejected")
+	}
+}
+
+func TestValidateConfigAcceptsDefaults(t *testing.T) {
+	if err := validateConfig(config.Conf); err != nil {
+		t.Fatalf("expected the default configuration to be valid: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package i18n
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const maxBundleSize = 64 * 1024 * 1024
+
+// BundleSource describes where a locale bundle is downloaded from. The bundle is a gzipped tarball of locale
+// files named by their ISO code, e.g. en-GB.json, at any depth.
+type BundleSource struct {
+	Url    string
+	Sha256 string // Expected hex SHA-256 of the bundle, pinned in config as a checksum served next to the bundle proves nothing
+}
+
+// Download fetches the locale bundle, verifies its checksum and extracts it into dir, replacing the files of any
+// previous download. The bundle must contain a loadable English locale, otherwise dir is left untouched.
+func Download(ctx context.Context, client *http.Client, source BundleSource, dir string) error {
+	expected := strings.ToLower(strings.TrimSpace(source.Sha256))
+	if expected == "" {
+		return errors.New("locale bundle checksum is not pinned")
+	}
+
+	if _, err := hex.DecodeString(expected); err != nil || len(expected) != sha256.Size*2 {
+		return fmt.Errorf("invalid locale bundle checksum %q", expected)
+	}
+
+	bundle, err := fetch(ctx, client, source.Url, maxBundleSize)
+	if err != nil {
+		return fmt.Errorf("failed to fetch locale bundle: %w", err)
+	}
+
+	sum := sha256.Sum256(bundle)
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(expected)) != 1 {
+		return fmt.Errorf("locale bundle checksum mismatch: expected %s, got %x", expected, sum)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filepath.Clean(dir)), 0o755); err != nil {
+		return fmt.Errorf("failed to create locale directory: %w", err)
+	}
+
+	staging, err := os.MkdirTemp(filepath.Dir(filepath.Clean(dir)), ".locale-*")
+	if err != nil {
+		return fmt.Errorf("failed to create locale staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	if err := extractBundle(bundle, staging); err != nil {
+		return err
+	}
+
+	// Make sure the bundle actually loads before replacing a previous download with it
+	if _, _, err := loadLocales(staging); err != nil {
+		return fmt.Errorf("downloaded locale bundle is invalid: %w", err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove previous locale download: %w", err)
+	}
+
+	if err := os.Rename(staging, dir); err != nil {
+		return fmt.Errorf("failed to move locale bundle into place: %w", err)
+	}
+
+	return nil
+}
+
+func fetch(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+
+	return data, nil
+}
+
+// extractBundle writes every locale file in the bundle into dir, flattening any directories, as release
+// tarballs usually wrap their contents in a top level directory
+func extractBundle(bundle []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return fmt.Errorf("failed to decompress locale bundle: %w", err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read locale bundle: %w", err)
+		}
+
+		name := path.Base(header.Name)
+		if header.Typeflag != tar.TypeReg || path.Ext(name) != ".json" || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s from locale bundle: %w", header.Name, err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write locale file %s: %w", name, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package i18n
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildBundle(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{
+			Name:     "locales/" + name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := archive.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func serveBundle(t *testing.T, bundle []byte) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/locales.tar.gz"
+}
+
+func checksumOf(bundle []byte) string {
+	sum := sha256.Sum256(bundle)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadRejectsTamperedBundle(t *testing.T) {
+	genuine := buildBundle(t, map[string]string{"en-GB.json": `{"greeting": "Hello"}`})
+	tampered := buildBundle(t, map[string]string{"en-GB.json": `{"greeting": "Send your token to..."}`})
+
+	dir := filepath.Join(t.TempDir(), "locale")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := filepath.Join(dir, "en-GB.json")
+	if err := os.WriteFile(previous, []byte(`{"greeting": "Hi"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := BundleSource{
+		Url:    serveBundle(t, tampered),
+		Sha256: checksumOf(genuine),
+	}
+
+	if err := Download(context.Background(), http.DefaultClient, source, dir); err == nil {
+		t.Fatal("expected a bundle not matching the pinned checksum to be rejected")
+	}
+
+	data, err := os.ReadFile(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"greeting": "Hi"}` {
+		t.Fatalf("expected the previous download to be left untouched, got %s", data)
+	}
+}
+
+func TestDownloadRequiresPinnedChecksum(t *testing.T) {
+	bundle := buildBundle(t, map[string]string{"en-GB.json": `{"greeting": "Hello"}`})
+
+	source := BundleSource{Url: serveBundle(t, bundle)}
+	if err := Download(context.Background(), http.DefaultClient, source, filepath.Join(t.TempDir(), "locale")); err == nil {
+		t.Fatal("expected a download without a pinned checksum to be rejected")
+	}
+}
+
+func TestDownloadExtractsBundleMatchingChecksum(t *testing.T) {
+	bundle := buildBundle(t, map[string]string{"en-GB.json": `{"greeting": "Hello"}`})
+
+	dir := filepath.Join(t.TempDir(), "locale")
+	source := BundleSource{
+		Url:    serveBundle(t, bundle),
+		Sha256: checksumOf(bundle),
+	}
+
+	if err := Download(context.Background(), http.DefaultClient, source, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "en-GB.json")); err != nil {
+		t.Fatalf("expected the English locale to be extracted: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// printers caches a printer for each locale numbers have been formatted in, by ISO long code
+var printers sync.Map
+
+// number is a count formatted with the digit grouping of a locale
+type number struct {
+	locale *Locale
+	value  int
+}
+
+// Number formats a count with the digit grouping of locale, e.g. 12,345 in English and 12.345 in
+// German. It formats itself for %d, so it can be passed to a message in place of an integer. IDs
+// and Unix times must be passed as they are.
+func Number(locale *Locale, value int) fmt.Formatter {
+	if locale == nil {
+		locale = LocaleEnglish
+	}
+
+	return number{
+		locale: locale,
+		value:  value,
+	}
+}
+
+func (n number) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'd', 's', 'v':
+		// Formatted as a string, so widths still pad it
+		fmt.Fprintf(f, fmt.FormatString(f, 's'), printerFor(n.locale).Sprint(n.value))
+	default:
+		fmt.Fprintf(f, fmt.FormatString(f, verb), n.value)
+	}
+}
+
+func printerFor(locale *Locale) *message.Printer {
+	if printer, ok := printers.Load(locale.IsoLongCode); ok {
+		return printer.(*message.Printer)
+	}
+
+	printer, _ := printers.LoadOrStore(locale.IsoLongCode, message.NewPrinter(language.Make(locale.IsoLongCode)))
+	return printer.(*message.Printer)
+}
+
+// TimestampStyle is how Discord renders a timestamp, which it does in the reader's own language and
+// timezone
+type TimestampStyle rune
+
+const (
+	TimestampShortDateTime TimestampStyle = 'f' // e.g. 20 April 2021 16:20
+	TimestampLongDateTime  TimestampStyle = 'F' // e.g. Tuesday, 20 April 2021 16:20
+	TimestampRelative      TimestampStyle = 'R' // e.g. 2 months ago
+)
+
+// Timestamp writes Discord's markup for a time, e.g. <t:1618953630:R>
+func Timestamp(t time.Time, style TimestampStyle) string {
+	return fmt.Sprintf("<t:%d:%c>", t.Unix(), style)
+}
@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
 )
 
 type Locale struct {
@@ -19,20 +23,49 @@ var LocaleEnglish = &Locale{
 	IsoLongCode:  "en-GB",
 }
 
-var locales = make(map[string]*Locale)
+var (
+	locales   = make(map[string]*Locale)
+	localesMu sync.RWMutex
+)
 
 func Init(localePath string) error {
+	english, loaded, err := loadLocales(localePath)
+	if err != nil {
+		return err
+	}
+
+	localesMu.Lock()
+	defer localesMu.Unlock()
+
+	LocaleEnglish.Messages = english
+	locales = loaded
+
+	return nil
+}
+
+// Reload re-reads all locale files from disk, keeping the current translations if loading fails
+func Reload(localePath string) error {
+	return Init(localePath)
+}
+
+func loadLocales(localePath string) (map[MessageId]string, map[string]*Locale, error) {
+	loaded := make(map[string]*Locale)
+
 	// Load English
-	if err := loadLocale(localePath, LocaleEnglish); err != nil {
-		return fmt.Errorf("failed to load English locale: %w", err)
+	english := &Locale{
+		IsoShortCode: LocaleEnglish.IsoShortCode,
+		IsoLongCode:  LocaleEnglish.IsoLongCode,
+	}
+	if err := loadLocale(localePath, english); err != nil {
+		return nil, nil, fmt.Errorf("failed to load English locale: %w", err)
 	}
-	locales["en"] = LocaleEnglish
-	locales["en-GB"] = LocaleEnglish
+	loaded["en"] = LocaleEnglish
+	loaded["en-GB"] = LocaleEnglish
 
 	// Load all other locale files
 	files, err := os.ReadDir(localePath)
 	if err != nil {
-		return fmt.Errorf("failed to read locale directory: %w", err)
+		return nil, nil, fmt.Errorf("failed to read locale directory: %w", err)
 	}
 
 	for _, file := range files {
@@ -59,17 +92,17 @@ func Init(localePath string) error {
 			continue
 		}
 
-		locales[isoShortCode] = locale
-		locales[isoLongCode] = locale
+		loaded[isoShortCode] = locale
+		loaded[isoLongCode] = locale
 	}
 
 	// Set parent language relationships for sub-languages
-	setParentLanguages()
+	setParentLanguages(loaded)
 
-	return nil
+	return english.Messages, loaded, nil
 }
 
-func setParentLanguages() {
+func setParentLanguages(locales map[string]*Locale) {
 	// German (Switzerland) -> German
 	if locale, ok := locales["de-CH"]; ok {
 		parent := "de"
@@ -154,6 +187,9 @@ func GetLocale(isoCode string) *Locale {
 		return LocaleEnglish
 	}
 
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+
 	locale, ok := locales[isoCode]
 	if !ok {
 		return LocaleEnglish
@@ -162,18 +198,79 @@ func GetLocale(isoCode string) *Locale {
 	return locale
 }
 
+// ResolveLocale returns the first of the given ISO codes that a locale is loaded for, falling back
+// to English if none are. Each code is tried as is, case-insensitively, and then by its language
+// alone, so Discord locales such as "es-419" or "pt-br" resolve to the closest translation.
+func ResolveLocale(isoCodes ...string) *Locale {
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+
+	for _, isoCode := range isoCodes {
+		if locale := lookupLocale(isoCode); locale != nil {
+			return locale
+		}
+	}
+
+	return LocaleEnglish
+}
+
+func lookupLocale(isoCode string) *Locale {
+	if isoCode == "" {
+		return nil
+	}
+
+	if locale, ok := locales[isoCode]; ok {
+		return locale
+	}
+
+	for code, locale := range locales {
+		if strings.EqualFold(code, isoCode) {
+			return locale
+		}
+	}
+
+	if language, _, ok := strings.Cut(isoCode, "-"); ok {
+		return lookupLocale(language)
+	}
+
+	return nil
+}
+
+// Where a message was found, recorded so untranslated messages in use can be prioritised
+const (
+	resolutionTranslated = "translated"       // In the requested locale
+	resolutionParent     = "parent"           // In the parent language of the requested locale
+	resolutionFallback   = "english_fallback" // Untranslated, so shown in English
+	resolutionMissing    = "missing"          // Not even in English, so an error is shown
+)
+
+// GetMessage returns a message in locale, filled in with format. Arguments should be passed with
+// Named, for the placeholders of the message.
 func GetMessage(locale *Locale, id MessageId, format ...interface{}) string {
 	if locale == nil {
 		locale = LocaleEnglish
 	}
 
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+
+	message, resolution := getMessage(locale, id, format...)
+	metrics.LocaleMessages.WithLabelValues(locale.IsoLongCode, string(id), resolution).Inc()
+
+	return message
+}
+
+func getMessage(locale *Locale, id MessageId, format ...interface{}) (string, string) {
+	if locale == nil {
+		locale = LocaleEnglish
+	}
+
 	if locale.Messages == nil {
 		if locale == LocaleEnglish {
-			return fmt.Sprintf("Error: translations for language `%s` is missing", locale.IsoShortCode)
+			return fmt.Sprintf("Error: translations for language `%s` is missing", locale.IsoShortCode), resolutionMissing
 		}
 
-		locale = LocaleEnglish
-		return GetMessage(locale, id, format...)
+		return fallBack(getMessage(LocaleEnglish, id, format...))
 	}
 
 	value, ok := locale.Messages[id]
@@ -185,21 +282,35 @@ func GetMessage(locale *Locale, id MessageId, format ...interface{}) string {
 	if !ok || value == "" || (englishExists && value == englishValue) {
 		if locale == LocaleEnglish {
 			if !ok || value == "" {
-				return fmt.Sprintf("error: translation for `%s` is missing", id)
+				return fmt.Sprintf("error: translation for `%s` is missing", id), resolutionMissing
 			}
-			return fmt.Sprintf(value, format...)
+			return formatMessage(value, format), resolutionTranslated
 		}
 
 		// Check if locale has a parent language
 		if locale.ParentIsoShortCode != nil {
 			parentLocale := locales[*locale.ParentIsoShortCode]
 			if parentLocale != nil {
-				return GetMessage(parentLocale, id, format...) // try parent language first
+				// try parent language first
+				message, resolution := getMessage(parentLocale, id, format...)
+				if resolution == resolutionTranslated {
+					resolution = resolutionParent
+				}
+				return message, resolution
 			}
 		}
 
-		return GetMessage(LocaleEnglish, id, format...)
+		return fallBack(getMessage(LocaleEnglish, id, format...))
+	}
+
+	return formatMessage(value, format), resolutionTranslated
+}
+
+// fallBack marks a message found in English as a fallback, passing a missing one through
+func fallBack(message, resolution string) (string, string) {
+	if resolution == resolutionTranslated {
+		resolution = resolutionFallback
 	}
 
-	return fmt.Sprintf(value, format...)
+	return message, resolution
 }
@@ -2,16 +2,69 @@ package i18n
 
 type MessageId string
 
+// Messages are filled in through named placeholders, noted beside those that have any, which
+// translations may place wherever their grammar needs. Unix times are passed as integers, for use
+// in Discord's timestamp markup, e.g. <t:{until}:R>.
 var (
-	GdprCompletedTitle               MessageId = "gdpr.completed.title"
-	GdprCompletedAllTranscripts      MessageId = "gdpr.completed.all_transcripts"
-	GdprCompletedAllTranscriptsMulti MessageId = "gdpr.completed.all_transcripts_multi"
-	GdprCompletedSpecificTranscripts MessageId = "gdpr.completed.specific_transcripts"
-	GdprCompletedAllMessages         MessageId = "gdpr.completed.all_messages"
-	GdprCompletedAllMessagesMulti    MessageId = "gdpr.completed.all_messages_multi"
-	GdprCompletedSpecificMessages    MessageId = "gdpr.completed.specific_messages"
-	GdprCompletedError               MessageId = "gdpr.completed.error"
-	GdprFollowupError                MessageId = "gdpr.followup.error"
-	GdprFollowupNoData               MessageId = "gdpr.followup.no_data"
-	GdprFollowupSuccess              MessageId = "gdpr.followup.success"
+	GdprCompletedTitle                MessageId = "gdpr.completed.title"
+	GdprCompletedDryRunTitle          MessageId = "gdpr.completed.dry_run_title"
+	GdprCompletedDryRunNotice         MessageId = "gdpr.completed.dry_run_notice"
+	GdprCompletedAllTranscripts       MessageId = "gdpr.completed.all_transcripts"       // {guild}, {count}
+	GdprCompletedAllTranscriptsMulti  MessageId = "gdpr.completed.all_transcripts_multi" // {guilds}, {count}
+	GdprCompletedSpecificTranscripts  MessageId = "gdpr.completed.specific_transcripts"  // {guild}, {count}
+	GdprCompletedAllMessages          MessageId = "gdpr.completed.all_messages"          // {guild}, {count}
+	GdprCompletedAllMessagesMulti     MessageId = "gdpr.completed.all_messages_multi"    // {guilds}, {count}
+	GdprCompletedSpecificMessages     MessageId = "gdpr.completed.specific_messages"     // {guild}, {count}
+	GdprCompletedDataExport           MessageId = "gdpr.completed.data_export"           // {count}, {url}, {expires}
+	GdprCompletedUnmatchedTickets     MessageId = "gdpr.completed.unmatched_tickets"     // {tickets}
+	GdprCompletedCertificate          MessageId = "gdpr.completed.certificate"           // {url}, {expires}
+	GdprCompletedCertificateReference MessageId = "gdpr.completed.certificate_reference"
+	GdprCompletedExportPassword       MessageId = "gdpr.completed.export_password"
+	GdprCompletedFeedback             MessageId = "gdpr.completed.feedback"        // {count}
+	GdprCompletedLegalHold            MessageId = "gdpr.completed.legal_hold"      // {count}
+	GdprCompletedNoTranscript         MessageId = "gdpr.completed.no_transcript"   // {count}
+	GdprCompletedUnattributed         MessageId = "gdpr.completed.unattributed"    // {count}
+	GdprCompletedAlreadyDeleted       MessageId = "gdpr.completed.already_deleted" // {tickets}
+	GdprCompletedSoftDelete           MessageId = "gdpr.completed.soft_delete"     // {purge_at}
+	GdprCompletedAt                   MessageId = "gdpr.completed.completed_at"    // {completed_at}, already in timestamp markup
+	GdprCompletedPrivate              MessageId = "gdpr.completed.private"
+	GdprCompletedGuildResult          MessageId = "gdpr.completed.guild_result"         // {deleted}, {skipped}
+	GdprCompletedGuildTicketsFailed   MessageId = "gdpr.completed.guild_tickets_failed" // {count}
+	GdprCompletedGuildFailed          MessageId = "gdpr.completed.guild_failed"
+	GdprCompletedGuildRejected        MessageId = "gdpr.completed.guild_rejected"
+	GdprCompletedGuildWithheld        MessageId = "gdpr.completed.guild_withheld"        // {count}
+	GdprCompletedGuildAlreadyDeleted  MessageId = "gdpr.completed.guild_already_deleted" // {count}
+	GdprCompletedGuildNoTranscript    MessageId = "gdpr.completed.guild_no_transcript"   // {count}
+	GdprCompletedGuildsMore           MessageId = "gdpr.completed.guilds_more"           // {count}
+	GdprCompletedError                MessageId = "gdpr.completed.error"                 // {error}
+	GdprCompletedPermanentFailure     MessageId = "gdpr.completed.permanent_failure"     // {error}
+	GdprCompletedCancelledTitle       MessageId = "gdpr.completed.cancelled_title"
+	GdprCompletedCancelled            MessageId = "gdpr.completed.cancelled"
+	GdprCompletedCancelledPartial     MessageId = "gdpr.completed.cancelled_partial" // {transcripts}, {messages}, {feedback}
+	GdprCompletedRateLimitedTitle     MessageId = "gdpr.completed.rate_limited_title"
+	GdprCompletedRateLimited          MessageId = "gdpr.completed.rate_limited" // {until}
+	GdprCompletedCoalescedTitle       MessageId = "gdpr.completed.coalesced_title"
+	GdprCompletedCoalesced            MessageId = "gdpr.completed.coalesced" // {request_id}
+	GdprProgressTitle                 MessageId = "gdpr.progress.title"
+	GdprProgress                      MessageId = "gdpr.progress.body"   // {bar}, {processed}, {total}
+	GdprProgressCounts                MessageId = "gdpr.progress.counts" // {transcripts}, {messages}
+	GdprFollowupError                 MessageId = "gdpr.followup.error"  // {error}
+	GdprFollowupPermanentFailure      MessageId = "gdpr.followup.permanent_failure"
+	GdprFollowupDryRun                MessageId = "gdpr.followup.dry_run"
+	GdprFollowupNoMatchingTickets     MessageId = "gdpr.followup.no_matching_tickets"
+	GdprFollowupNoData                MessageId = "gdpr.followup.no_data"
+	GdprFollowupNoTranscripts         MessageId = "gdpr.followup.no_transcripts" // {count}
+	GdprFollowupSuccess               MessageId = "gdpr.followup.success"
+	GdprFollowupCancelled             MessageId = "gdpr.followup.cancelled"
+	GdprFollowupRateLimited           MessageId = "gdpr.followup.rate_limited" // {until}
+	GdprFollowupCoalesced             MessageId = "gdpr.followup.coalesced"    // {request_id}
+	GdprEmailFooter                   MessageId = "gdpr.email.footer"
+	GdprExportPasswordTitle           MessageId = "gdpr.export_password.title"
+	GdprExportPassword                MessageId = "gdpr.export_password.body" // {password}, {expires}
+	GdprGuildNoticeTitle              MessageId = "gdpr.guild_notice.title"
+	GdprGuildNoticeTranscripts        MessageId = "gdpr.guild_notice.transcripts" // {count}
+	GdprGuildNoticeMessages           MessageId = "gdpr.guild_notice.messages"    // {count}
+	GdprGuildNoticeFooter             MessageId = "gdpr.guild_notice.footer"
+	GdprErrorInternal                 MessageId = "gdpr.error.internal"
+	GdprErrorReference                MessageId = "gdpr.error.reference" // {request_id}
 )
@@ -0,0 +1,58 @@
+package i18n
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Arg is a value substituted for a {name} placeholder of a message
+type Arg struct {
+	Name  string
+	Value interface{}
+}
+
+// Named passes value to a message for its {name} placeholder, which translations may put wherever
+// their grammar needs it
+func Named(name string, value interface{}) Arg {
+	return Arg{
+		Name:  name,
+		Value: value,
+	}
+}
+
+// placeholder matches a named placeholder, e.g. {guild}
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// formatMessage fills in a message. Messages with named placeholders have each replaced by the
+// argument of that name, and placeholders without one are left as they are. Messages still using
+// positional verbs such as %s are formatted with the arguments in the order they were passed, so
+// translations that haven't been converted keep working.
+func formatMessage(message string, args []interface{}) string {
+	named := make(map[string]interface{}, len(args))
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if namedArg, ok := arg.(Arg); ok {
+			named[namedArg.Name] = namedArg.Value
+			values[i] = namedArg.Value
+		} else {
+			values[i] = arg
+		}
+	}
+
+	substituted := false
+	formatted := placeholder.ReplaceAllStringFunc(message, func(match string) string {
+		value, ok := named[match[1:len(match)-1]]
+		if !ok {
+			return match
+		}
+
+		substituted = true
+		return fmt.Sprint(value)
+	})
+
+	if substituted {
+		return formatted
+	}
+
+	return fmt.Sprintf(message, values...)
+}
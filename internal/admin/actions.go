@@ -0,0 +1,241 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Actions carries out the changes to requests that can be made through the admin API
+type Actions interface {
+	// Requeue moves a failed request back onto the queue, returning false if it isn't in the failed queue
+	Requeue(ctx context.Context, requestId int) (bool, error)
+	// Cancel stops a request being processed, returning false if it isn't being processed
+	Cancel(requestId int) bool
+	// ForceComplete marks a request completed, so it's not processed any further
+	ForceComplete(ctx context.Context, requestId int) (string, error)
+}
+
+// Restorer reverses the soft deletion of the transcripts a request deleted, implemented by the
+// quarantine table
+type Restorer interface {
+	Restore(ctx context.Context, requestId int, restoredBy string, restoredAt time.Time) (int, error)
+}
+
+// Canceller stops requests being processed, implemented by the worker group
+type Canceller interface {
+	Cancel(requestId int) bool
+}
+
+// QueueActions carries out admin actions against the Redis queues and the running workers
+type QueueActions struct {
+	redisClient  redis.UniversalClient
+	useStream    bool
+	canceller    Canceller
+	completedTTL time.Duration
+}
+
+var _ Actions = (*QueueActions)(nil)
+
+func NewQueueActions(redisClient redis.UniversalClient, useStream bool, canceller Canceller, completedTTL time.Duration) *QueueActions {
+	return &QueueActions{
+		redisClient:  redisClient,
+		useStream:    useStream,
+		canceller:    canceller,
+		completedTTL: completedTTL,
+	}
+}
+
+func (a *QueueActions) Requeue(ctx context.Context, requestId int) (bool, error) {
+	return gdprrelay.RequeueFailed(ctx, a.redisClient, requestId, a.useStream)
+}
+
+func (a *QueueActions) Cancel(requestId int) bool {
+	return a.canceller.Cancel(requestId)
+}
+
+// ForceComplete stops the request if it's being processed, removes it from the failed queue and
+// records it as completed, so any delivery still queued is dropped as a duplicate
+func (a *QueueActions) ForceComplete(ctx context.Context, requestId int) (string, error) {
+	cancelled := a.canceller.Cancel(requestId)
+
+	removed, err := gdprrelay.RemoveFailed(ctx, a.redisClient, requestId)
+	if err != nil {
+		return "", err
+	}
+
+	if err := gdprrelay.MarkCompleted(ctx, a.redisClient, requestId, a.completedTTL); err != nil {
+		return "", fmt.Errorf("failed to record request completion: %w", err)
+	}
+
+	if database.Client != nil {
+		if err := database.Client.GdprLogs.UpdateLogStatus(requestId, gdpr.FormatLogStatus(gdpr.ResultCompleted, "Completed")); err != nil {
+			return "", fmt.Errorf("failed to update GDPR log status: %w", err)
+		}
+	}
+
+	return fmt.Sprintf("cancelled=%t removed_from_failed=%t", cancelled, removed), nil
+}
+
+// CancelActions carries out admin actions for queue backends that don't keep failed requests in
+// Redis. Requests can only be cancelled, as requeueing and force-completing work on the Redis failed
+// queue and completion keys.
+type CancelActions struct {
+	canceller Canceller
+}
+
+var _ Actions = (*CancelActions)(nil)
+
+func NewCancelActions(canceller Canceller) *CancelActions {
+	return &CancelActions{
+		canceller: canceller,
+	}
+}
+
+func (a *CancelActions) Requeue(ctx context.Context, requestId int) (bool, error) {
+	return false, unsupportedError("requeueing is not supported by this queue backend")
+}
+
+func (a *CancelActions) Cancel(requestId int) bool {
+	return a.canceller.Cancel(requestId)
+}
+
+func (a *CancelActions) ForceComplete(ctx context.Context, requestId int) (string, error) {
+	return "", unsupportedError("force-completing is not supported by this queue backend")
+}
+
+// notFoundError is returned when the request isn't in a state the action applies to
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e)
+}
+
+// unsupportedError is returned when the action can't be carried out with the configured queue backend
+type unsupportedError string
+
+func (e unsupportedError) Error() string {
+	return string(e)
+}
+
+func (s *Server) requeueRequest(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, "requeue", func(ctx context.Context, requestId int) (string, error) {
+		requeued, err := s.actions.Requeue(ctx, requestId)
+		if err != nil {
+			return "", err
+		}
+		if !requeued {
+			return "", notFoundError("request is not in the failed queue")
+		}
+		return "requeued", nil
+	})
+}
+
+func (s *Server) cancelRequest(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, "cancel", func(_ context.Context, requestId int) (string, error) {
+		if !s.actions.Cancel(requestId) {
+			return "", notFoundError("request is not being processed")
+		}
+		return "cancelled", nil
+	})
+}
+
+func (s *Server) restoreRequest(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, "restore", func(ctx context.Context, requestId int) (string, error) {
+		restored, err := s.restorer.Restore(ctx, requestId, caller(ctx).Name, time.Now())
+		if err != nil {
+			return "", err
+		}
+		if restored == 0 {
+			return "", notFoundError("request has no transcripts awaiting permanent deletion")
+		}
+		return fmt.Sprintf("restored %d transcript(s)", restored), nil
+	})
+}
+
+func (s *Server) forceCompleteRequest(w http.ResponseWriter, r *http.Request) {
+	s.runAction(w, r, "force-complete", s.actions.ForceComplete)
+}
+
+// runAction performs an action on the request in the path and records it in the audit log,
+// whether or not it succeeded
+func (s *Server) runAction(w http.ResponseWriter, r *http.Request, action string, fn func(ctx context.Context, requestId int) (string, error)) {
+	requestId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request ID")
+		return
+	}
+
+	detail, err := fn(r.Context(), requestId)
+	if err != nil {
+		detail = err.Error()
+	}
+
+	s.audit(r.Context(), action, requestId, err == nil, detail)
+
+	var notFound notFoundError
+	var unsupported unsupportedError
+	switch {
+	case errors.As(err, &notFound):
+		writeError(w, http.StatusNotFound, notFound.Error())
+	case errors.As(err, &unsupported):
+		writeError(w, http.StatusNotImplemented, unsupported.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to %s request", action))
+	default:
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"request_id": requestId,
+			"result":     detail,
+		})
+	}
+}
+
+// audit logs an admin action and stores it in the audit table. A failure to store it is logged
+// rather than failing the action, which has already been carried out.
+func (s *Server) audit(ctx context.Context, action string, requestId int, success bool, detail string) {
+	s.auditCategory(ctx, database.AuditCategoryAdmin, action, requestId, success, detail)
+}
+
+// auditCategory is audit for actions kept apart from changes to user requests in the audit table
+func (s *Server) auditCategory(ctx context.Context, category, action string, requestId int, success bool, detail string) {
+	actor := caller(ctx).Name
+
+	s.logger.Info("Admin action",
+		zap.String("category", category),
+		zap.String("actor", actor),
+		zap.String("action", action),
+		zap.Int("request_id", requestId),
+		zap.Bool("success", success),
+		zap.String("detail", detail),
+	)
+
+	if database.AdminActions == nil {
+		return
+	}
+
+	if err := database.AdminActions.Insert(context.WithoutCancel(ctx), database.AdminAction{
+		Category:    category,
+		Actor:       actor,
+		Action:      action,
+		RequestId:   requestId,
+		Success:     success,
+		Detail:      detail,
+		PerformedAt: time.Now(),
+	}); err != nil {
+		s.logger.Error("Failed to record admin action",
+			zap.String("actor", actor),
+			zap.String("action", action),
+			zap.Int("request_id", requestId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,330 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// Server exposes GDPR job history to the staff dashboard over HTTP. Every request must carry a
+// service token as a bearer token, granted the scope the endpoint requires.
+type Server struct {
+	tokens   []ServiceToken
+	logger   *zap.Logger
+	enqueuer Enqueuer // Nil unless requests can be submitted through the API
+	actions  Actions  // Nil unless requests can be changed through the API
+
+	inspector Inspector      // Nil unless the queues can be listed through the API
+	status    StatusReporter // Nil if worker status isn't reported
+
+	restorer Restorer // Nil unless transcripts are deleted softly
+
+	platformErasures PlatformErasures // Nil unless platform erasures are enabled
+}
+
+// Enqueuer accepts requests submitted through the API, in place of a producer pushing to Redis
+type Enqueuer interface {
+	Enqueue(ctx context.Context, request gdpr.QueuedRequest) (gdpr.QueuedRequest, error)
+}
+
+// NewServer creates a server accepting token with every scope, and the given service tokens with
+// theirs. token may be empty when only service tokens are used.
+func NewServer(token string, serviceTokens []ServiceToken, logger *zap.Logger) *Server {
+	tokens := serviceTokens
+	if token != "" {
+		tokens = append([]ServiceToken{newFullAccessToken(token)}, serviceTokens...)
+	}
+
+	return &Server{
+		tokens: tokens,
+		logger: logger,
+	}
+}
+
+// AcceptRequests enables POST /requests, which hands requests straight to enqueuer. Used with the
+// in-memory queue, which producers can't reach.
+func (s *Server) AcceptRequests(enqueuer Enqueuer) {
+	s.enqueuer = enqueuer
+}
+
+// AllowActions enables the endpoints that requeue, cancel and force-complete requests
+func (s *Server) AllowActions(actions Actions) {
+	s.actions = actions
+}
+
+// AllowInspection enables listing queued requests and queue stats, reporting worker status
+// alongside the stats if status is not nil
+func (s *Server) AllowInspection(inspector Inspector, status StatusReporter) {
+	s.inspector = inspector
+	s.status = status
+}
+
+// AllowRestore enables reversing requests whose transcripts are still in their grace period
+func (s *Server) AllowRestore(restorer Restorer) {
+	s.restorer = restorer
+}
+
+// AllowPlatformErasures enables ordering and listing erasures on behalf of the bot operator
+func (s *Server) AllowPlatformErasures(platformErasures PlatformErasures) {
+	s.platformErasures = platformErasures
+}
+
+// Handler serves the API both at the root and under /api
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.handle(mux, "GET /jobs", ScopeRead, s.listJobs)
+	s.handle(mux, "GET /jobs/{id}", ScopeRead, s.getJob)
+	s.handle(mux, "GET /legal-holds", ScopeRead, s.listLegalHolds)
+	s.handle(mux, "POST /legal-holds", ScopeLegalHold, s.placeLegalHold)
+	s.handle(mux, "DELETE /legal-holds/{id}", ScopeLegalHold, s.releaseLegalHold)
+
+	if s.enqueuer != nil {
+		s.handle(mux, "POST /requests", ScopeEnqueue, s.enqueueRequest)
+	}
+
+	if s.actions != nil {
+		s.handle(mux, "POST /requests/{id}/requeue", ScopeRequeue, s.requeueRequest)
+		s.handle(mux, "POST /requests/{id}/cancel", ScopeCancel, s.cancelRequest)
+		s.handle(mux, "POST /requests/{id}/force-complete", ScopeForceComplete, s.forceCompleteRequest)
+	}
+
+	if s.restorer != nil {
+		s.handle(mux, "POST /requests/{id}/restore", ScopeRestore, s.restoreRequest)
+	}
+
+	if s.platformErasures != nil {
+		s.handle(mux, "GET /platform-erasures", ScopeRead, s.listPlatformErasures)
+		s.handle(mux, "POST /platform-erasures", ScopePlatformErasure, s.orderPlatformErasure)
+	}
+
+	if s.inspector != nil {
+		s.handle(mux, "GET /requests", ScopeRead, s.listRequests)
+		s.handle(mux, "GET /requests/archived", ScopeRead, s.listArchived)
+		s.handle(mux, "GET /queues/stats", ScopeRead, s.queueStats)
+	}
+
+	root := http.NewServeMux()
+	root.Handle("/", mux)
+	root.Handle("/api/", http.StripPrefix("/api", mux))
+
+	return s.authenticate(root)
+}
+
+// handle registers a handler that may only be called with a token granted scope
+func (s *Server) handle(mux *http.ServeMux, pattern string, scope Scope, handler http.HandlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !caller(r.Context()).allows(scope) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("token lacks the %s scope", scope))
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// Serve runs the admin API on addr until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, addr string) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: s.Handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	s.logger.Info("Serving admin API", zap.String("addr", addr))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Error("Admin API server failed", zap.Error(err))
+	}
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || presented == "" {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		token, ok := match(s.tokens, presented)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withCaller(r.Context(), token)))
+	})
+}
+
+// jobResponse encodes snowflakes as strings, as they exceed the integer precision of JavaScript clients
+type jobResponse struct {
+	database.Job
+	GuildIds      []string                `json:"guild_ids"`
+	Notifications []database.Notification `json:"notifications,omitempty"` // Only included when fetching a single job
+}
+
+func newJobResponse(job database.Job) jobResponse {
+	guildIds := make([]string, len(job.GuildIds))
+	for i, guildId := range job.GuildIds {
+		guildIds[i] = strconv.FormatUint(guildId, 10)
+	}
+
+	return jobResponse{
+		Job:      job,
+		GuildIds: guildIds,
+	}
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jobs, err := database.Jobs.List(r.Context(), filter)
+	if err != nil {
+		s.logger.Error("Failed to list GDPR jobs", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list jobs")
+		return
+	}
+
+	response := make([]jobResponse, len(jobs))
+	for i, job := range jobs {
+		response[i] = newJobResponse(job)
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"jobs":   response,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	requestId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request ID")
+		return
+	}
+
+	job, ok, err := database.Jobs.Get(r.Context(), requestId)
+	if err != nil {
+		s.logger.Error("Failed to fetch GDPR job", zap.Int("request_id", requestId), zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to fetch job")
+		return
+	}
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	response := newJobResponse(job)
+
+	// Job history is still useful without the delivery attempts, so a failure here isn't fatal
+	if response.Notifications, err = database.Notifications.ListForRequest(r.Context(), requestId); err != nil {
+		s.logger.Error("Failed to fetch GDPR notification outcomes", zap.Int("request_id", requestId), zap.Error(err))
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (s *Server) enqueueRequest(w http.ResponseWriter, r *http.Request) {
+	var queued gdpr.QueuedRequest
+	if err := json.NewDecoder(r.Body).Decode(&queued); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	queued, err := s.enqueuer.Enqueue(r.Context(), queued)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.audit(r.Context(), "enqueue", queued.RequestID, true, queued.Request.Type.String())
+
+	writeJson(w, http.StatusAccepted, map[string]interface{}{
+		"request_id": queued.RequestID,
+	})
+}
+
+func parseJobFilter(r *http.Request) (database.JobFilter, error) {
+	query := r.URL.Query()
+
+	filter := database.JobFilter{
+		RequestType: query.Get("type"),
+		Status:      query.Get("status"),
+		Limit:       defaultListLimit,
+	}
+
+	var err error
+	if value := query.Get("user_id"); value != "" {
+		if filter.UserId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return filter, errInvalidParam("user_id")
+		}
+	}
+
+	if value := query.Get("guild_id"); value != "" {
+		if filter.GuildId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return filter, errInvalidParam("guild_id")
+		}
+	}
+
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errInvalidParam("since")
+		}
+	}
+
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errInvalidParam("until")
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 {
+			return filter, errInvalidParam("limit")
+		}
+		filter.Limit = min(filter.Limit, maxListLimit)
+	}
+
+	if value := query.Get("offset"); value != "" {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return filter, errInvalidParam("offset")
+		}
+	}
+
+	return filter, nil
+}
+
+func errInvalidParam(name string) error {
+	return fmt.Errorf("invalid %s parameter", name)
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
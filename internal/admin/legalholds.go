@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"go.uber.org/zap"
+)
+
+// placeLegalHoldBody is the body of POST /legal-holds. Snowflakes are accepted as strings, as they
+// exceed the integer precision of JavaScript clients.
+type placeLegalHoldBody struct {
+	GuildId   uint64     `json:"guild_id,string"`
+	TicketId  *int       `json:"ticket_id"` // Nil to hold the whole guild
+	Reason    string     `json:"reason"`
+	Reference string     `json:"reference"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (s *Server) listLegalHolds(w http.ResponseWriter, r *http.Request) {
+	var guildId uint64
+	if value := r.URL.Query().Get("guild_id"); value != "" {
+		var err error
+		if guildId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidParam("guild_id").Error())
+			return
+		}
+	}
+
+	holds, err := database.LegalHolds.ListActive(r.Context(), guildId)
+	if err != nil {
+		s.logger.Error("Failed to list legal holds", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list legal holds")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"legal_holds": holds,
+	})
+}
+
+func (s *Server) placeLegalHold(w http.ResponseWriter, r *http.Request) {
+	var body placeLegalHoldBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case body.GuildId == 0:
+		writeError(w, http.StatusBadRequest, "guild_id is required")
+		return
+	case body.TicketId != nil && *body.TicketId <= 0:
+		writeError(w, http.StatusBadRequest, "invalid ticket_id")
+		return
+	case body.Reason == "":
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	case body.ExpiresAt != nil && !body.ExpiresAt.After(now):
+		writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	hold, err := database.LegalHolds.Place(r.Context(), database.LegalHold{
+		GuildId:   body.GuildId,
+		TicketId:  body.TicketId,
+		Reason:    body.Reason,
+		Reference: body.Reference,
+		PlacedBy:  caller(r.Context()).Name,
+		PlacedAt:  now,
+		ExpiresAt: body.ExpiresAt,
+	})
+	if err != nil {
+		s.logger.Error("Failed to place legal hold", zap.Uint64("guild_id", body.GuildId), zap.Error(err))
+		s.audit(r.Context(), "legal-hold-place", 0, false, err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to place legal hold")
+		return
+	}
+
+	s.audit(r.Context(), "legal-hold-place", 0, true, describeLegalHold(hold))
+	writeJson(w, http.StatusCreated, hold)
+}
+
+func (s *Server) releaseLegalHold(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid legal hold ID")
+		return
+	}
+
+	released, err := database.LegalHolds.Release(r.Context(), id, caller(r.Context()).Name, time.Now())
+	if err != nil {
+		s.logger.Error("Failed to release legal hold", zap.Int64("legal_hold_id", id), zap.Error(err))
+		s.audit(r.Context(), "legal-hold-release", 0, false, fmt.Sprintf("hold %d: %s", id, err))
+		writeError(w, http.StatusInternalServerError, "failed to release legal hold")
+		return
+	}
+
+	if !released {
+		s.audit(r.Context(), "legal-hold-release", 0, false, fmt.Sprintf("hold %d: not found or no longer active", id))
+		writeError(w, http.StatusNotFound, "legal hold not found or no longer active")
+		return
+	}
+
+	s.audit(r.Context(), "legal-hold-release", 0, true, fmt.Sprintf("hold %d", id))
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"result": "released",
+	})
+}
+
+// describeLegalHold summarises a hold for the audit log, which has no columns of its own for it
+func describeLegalHold(hold database.LegalHold) string {
+	target := fmt.Sprintf("guild %d", hold.GuildId)
+	if hold.TicketId != nil {
+		target += fmt.Sprintf(" ticket %d", *hold.TicketId)
+	}
+
+	detail := fmt.Sprintf("hold %d on %s: %s", hold.Id, target, hold.Reason)
+	if hold.Reference != "" {
+		detail += fmt.Sprintf(" (ref %s)", hold.Reference)
+	}
+
+	return detail
+}
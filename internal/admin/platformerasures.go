@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/platformerasure"
+	"go.uber.org/zap"
+)
+
+// PlatformErasures records erasures ordered on behalf of the bot operator, implemented by the
+// platform erasures table. Ordered erasures are carried out by a platformerasure.Runner.
+type PlatformErasures interface {
+	Order(ctx context.Context, erasure platformerasure.Erasure) (platformerasure.Erasure, error)
+	List(ctx context.Context, guildId uint64, limit int) ([]platformerasure.Erasure, error)
+}
+
+// orderPlatformErasureBody is the body of POST /platform-erasures. Snowflakes are accepted as
+// strings, as they exceed the integer precision of JavaScript clients.
+type orderPlatformErasureBody struct {
+	GuildId   uint64 `json:"guild_id,string"`
+	Reason    string `json:"reason"`
+	Reference string `json:"reference"`
+}
+
+func (s *Server) listPlatformErasures(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var guildId uint64
+	if value := query.Get("guild_id"); value != "" {
+		var err error
+		if guildId, err = strconv.ParseUint(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, errInvalidParam("guild_id").Error())
+			return
+		}
+	}
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, errInvalidParam("limit").Error())
+			return
+		}
+		limit = min(parsed, maxListLimit)
+	}
+
+	erasures, err := s.platformErasures.List(r.Context(), guildId, limit)
+	if err != nil {
+		s.logger.Error("Failed to list platform erasures", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list platform erasures")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"platform_erasures": erasures,
+	})
+}
+
+func (s *Server) orderPlatformErasure(w http.ResponseWriter, r *http.Request) {
+	var body orderPlatformErasureBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	switch {
+	case body.GuildId == 0:
+		writeError(w, http.StatusBadRequest, "guild_id is required")
+		return
+	case body.Reason == "":
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+
+	erasure, err := s.platformErasures.Order(r.Context(), platformerasure.Erasure{
+		GuildId:   body.GuildId,
+		Reason:    body.Reason,
+		Reference: body.Reference,
+		OrderedBy: caller(r.Context()).Name,
+		OrderedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.Error("Failed to order platform erasure", zap.Uint64("guild_id", body.GuildId), zap.Error(err))
+		s.auditCategory(r.Context(), database.AuditCategoryPlatformErasure, platformerasure.ActionOrder, 0, false,
+			fmt.Sprintf("guild %d: %s", body.GuildId, err))
+		writeError(w, http.StatusInternalServerError, "failed to order platform erasure")
+		return
+	}
+
+	s.auditCategory(r.Context(), database.AuditCategoryPlatformErasure, platformerasure.ActionOrder, erasure.RequestId(), true,
+		describePlatformErasure(erasure))
+	writeJson(w, http.StatusAccepted, erasure)
+}
+
+// describePlatformErasure summarises an erasure for the audit log, which has no columns of its own for it
+func describePlatformErasure(erasure platformerasure.Erasure) string {
+	detail := fmt.Sprintf("erasure %d of guild %d: %s", erasure.Id, erasure.GuildId, erasure.Reason)
+	if erasure.Reference != "" {
+		detail += fmt.Sprintf(" (ref %s)", erasure.Reference)
+	}
+
+	return detail
+}
@@ -0,0 +1,307 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/worker"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Inspector reads what is waiting in and moving through the queues
+type Inspector interface {
+	// Entries returns the requests in every queue, at most limit per queue and state
+	Entries(ctx context.Context, limit int64) ([]gdprrelay.Entry, error)
+	// Stats returns the depth of every queue
+	Stats(ctx context.Context) ([]gdprrelay.QueueStats, error)
+	// Archived returns up to limit of the most recently acknowledged requests, newest first
+	Archived(ctx context.Context, limit int64) ([]gdprrelay.ArchivedEntry, error)
+}
+
+// StatusReporter reports what the workers are doing, implemented by the worker group
+type StatusReporter interface {
+	Status() []worker.Status
+}
+
+// QueueInspector reads the Redis queues the worker consumes, the shared queue followed by the
+// dedicated queue of each request type
+type QueueInspector struct {
+	redisClient  redis.UniversalClient
+	useStream    bool
+	group        string
+	requestTypes []gdprrelay.RequestType
+	stuckAfter   time.Duration
+}
+
+var _ Inspector = (*QueueInspector)(nil)
+
+func NewQueueInspector(redisClient redis.UniversalClient, useStream bool, group string, requestTypes []gdprrelay.RequestType, stuckAfter time.Duration) *QueueInspector {
+	return &QueueInspector{
+		redisClient:  redisClient,
+		useStream:    useStream,
+		group:        group,
+		requestTypes: requestTypes,
+		stuckAfter:   stuckAfter,
+	}
+}
+
+func (i *QueueInspector) queues() []*gdprrelay.RequestType {
+	queues := []*gdprrelay.RequestType{nil}
+	for _, requestType := range i.requestTypes {
+		queues = append(queues, &requestType)
+	}
+
+	return queues
+}
+
+func (i *QueueInspector) Entries(ctx context.Context, limit int64) ([]gdprrelay.Entry, error) {
+	var entries []gdprrelay.Entry
+	for _, requestType := range i.queues() {
+		var (
+			queueEntries []gdprrelay.Entry
+			err          error
+		)
+		if i.useStream {
+			queueEntries, err = gdprrelay.StreamQueueEntries(ctx, i.redisClient, requestType, i.group, limit)
+		} else {
+			queueEntries, err = gdprrelay.ListQueueEntries(ctx, i.redisClient, requestType, limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, queueEntries...)
+	}
+
+	// Every queue shares the one failed queue
+	failed, err := gdprrelay.ListFailed(ctx, i.redisClient)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range failed[:min(int64(len(failed)), limit)] {
+		entries = append(entries, gdprrelay.Entry{
+			Queue:     "shared",
+			State:     gdprrelay.EntryFailed,
+			Request:   entry.Request,
+			DecodeErr: entry.DecodeErr,
+		})
+	}
+
+	return entries, nil
+}
+
+func (i *QueueInspector) Stats(ctx context.Context) ([]gdprrelay.QueueStats, error) {
+	now := time.Now()
+
+	var stats []gdprrelay.QueueStats
+	for _, requestType := range i.queues() {
+		var (
+			queueStats gdprrelay.QueueStats
+			err        error
+		)
+		if i.useStream {
+			queueStats, err = gdprrelay.StreamQueueStats(ctx, i.redisClient, requestType, i.group, i.stuckAfter)
+		} else {
+			queueStats, err = gdprrelay.ListQueueStats(ctx, i.redisClient, requestType, i.stuckAfter, now)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, queueStats)
+	}
+
+	return stats, nil
+}
+
+func (i *QueueInspector) Archived(ctx context.Context, limit int64) ([]gdprrelay.ArchivedEntry, error) {
+	return gdprrelay.ListArchived(ctx, i.redisClient, limit)
+}
+
+// entryResponse describes a queued request without the interaction token it carries, encoding
+// snowflakes as strings
+type entryResponse struct {
+	RequestId     int        `json:"request_id"`
+	Queue         string     `json:"queue"`
+	State         string     `json:"state"`
+	RequestType   string     `json:"request_type,omitempty"` // Empty if the entry couldn't be decoded
+	UserId        uint64     `json:"user_id,string"`
+	GuildIds      []string   `json:"guild_ids"`
+	TicketCount   int        `json:"ticket_count"`
+	DryRun        bool       `json:"dry_run"`
+	QueuedAt      time.Time  `json:"queued_at"`
+	RetryCount    int        `json:"retry_count"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	DecodeError   string     `json:"decode_error,omitempty"`
+}
+
+func newEntryResponse(entry gdprrelay.Entry) entryResponse {
+	request := entry.Request.Request
+
+	guildIds := make([]string, len(request.GuildIds))
+	for i, guildId := range request.GuildIds {
+		guildIds[i] = strconv.FormatUint(guildId, 10)
+	}
+
+	response := entryResponse{
+		RequestId:   entry.Request.RequestID,
+		Queue:       entry.Queue,
+		State:       string(entry.State),
+		UserId:      request.UserId,
+		GuildIds:    guildIds,
+		TicketCount: len(request.TicketIds),
+		DryRun:      request.DryRun,
+		QueuedAt:    entry.Request.QueuedAt,
+		RetryCount:  entry.Request.RetryCount,
+		LastError:   entry.Request.LastError,
+	}
+
+	if !entry.Request.LastAttemptAt.IsZero() {
+		response.LastAttemptAt = &entry.Request.LastAttemptAt
+	}
+
+	if !entry.RetryAt.IsZero() {
+		response.RetryAt = &entry.RetryAt
+	}
+
+	if entry.DecodeErr != nil {
+		response.DecodeError = entry.DecodeErr.Error()
+	} else {
+		response.RequestType = request.Type.String()
+	}
+
+	return response
+}
+
+func (s *Server) listRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := int64(defaultListLimit)
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, errInvalidParam("limit").Error())
+			return
+		}
+		limit = int64(min(parsed, maxListLimit))
+	}
+
+	state := gdprrelay.EntryState(query.Get("state"))
+	switch state {
+	case "", gdprrelay.EntryPending, gdprrelay.EntryProcessing, gdprrelay.EntryDelayed, gdprrelay.EntryFailed:
+	default:
+		writeError(w, http.StatusBadRequest, errInvalidParam("state").Error())
+		return
+	}
+
+	var requestType *gdpr.RequestType
+	if value := query.Get("type"); value != "" {
+		parsed, ok := gdpr.ParseRequestType(value)
+		if !ok {
+			writeError(w, http.StatusBadRequest, errInvalidParam("type").Error())
+			return
+		}
+		requestType = &parsed
+	}
+
+	entries, err := s.inspector.Entries(r.Context(), limit)
+	if err != nil {
+		s.logger.Error("Failed to list queued GDPR requests", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list requests")
+		return
+	}
+
+	response := make([]entryResponse, 0, len(entries))
+	for _, entry := range entries {
+		if state != "" && entry.State != state {
+			continue
+		}
+
+		if requestType != nil && (entry.DecodeErr != nil || entry.Request.Request.Type != *requestType) {
+			continue
+		}
+
+		response = append(response, newEntryResponse(entry))
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"requests": response,
+		"limit":    limit,
+	})
+}
+
+// queueStatsResponse encodes the snapshot of a queue with JSON field names
+type queueStatsResponse struct {
+	Queue         string     `json:"queue"`
+	Pending       int64      `json:"pending"`
+	Processing    int64      `json:"processing"`
+	Delayed       int64      `json:"delayed"`
+	Stuck         int64      `json:"stuck"`
+	OldestPending *time.Time `json:"oldest_pending,omitempty"`
+}
+
+func (s *Server) queueStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.inspector.Stats(r.Context())
+	if err != nil {
+		s.logger.Error("Failed to read GDPR queue stats", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to read queue stats")
+		return
+	}
+
+	queues := make([]queueStatsResponse, len(stats))
+	for i, queue := range stats {
+		queues[i] = queueStatsResponse{
+			Queue:      queue.Name,
+			Pending:    queue.Pending,
+			Processing: queue.Processing,
+			Delayed:    queue.Delayed,
+			Stuck:      queue.Stuck,
+		}
+
+		if !queue.OldestPending.IsZero() {
+			queues[i].OldestPending = &stats[i].OldestPending
+		}
+	}
+
+	var workers []worker.Status
+	if s.status != nil {
+		workers = s.status.Status()
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"queues":  queues,
+		"workers": workers,
+	})
+}
+
+func (s *Server) listArchived(w http.ResponseWriter, r *http.Request) {
+	limit := int64(defaultListLimit)
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, http.StatusBadRequest, errInvalidParam("limit").Error())
+			return
+		}
+		limit = int64(min(parsed, maxListLimit))
+	}
+
+	entries, err := s.inspector.Archived(r.Context(), limit)
+	if err != nil {
+		s.logger.Error("Failed to list archived GDPR requests", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to list archived requests")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"requests": entries,
+		"limit":    limit,
+	})
+}
@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Scope is a permission granted to a service token
+type Scope string
+
+const (
+	ScopeRead          Scope = "read"           // View job history
+	ScopeEnqueue       Scope = "enqueue"        // Submit requests, when the API accepts them
+	ScopeRequeue       Scope = "requeue"        // Move failed requests back onto the queue
+	ScopeCancel        Scope = "cancel"         // Cancel requests being processed
+	ScopeForceComplete Scope = "force-complete" // Mark requests completed without processing them
+	ScopeLegalHold     Scope = "legal-hold"     // Place and release legal holds
+	ScopeRestore       Scope = "restore"        // Reverse requests whose transcripts are still in their grace period
+
+	ScopePlatformErasure Scope = "platform-erasure" // Order a server's data erased on behalf of the bot operator
+)
+
+var allScopes = []Scope{ScopeRead, ScopeEnqueue, ScopeRequeue, ScopeCancel, ScopeForceComplete, ScopeLegalHold, ScopeRestore, ScopePlatformErasure}
+
+// ServiceToken is a credential for the admin API, limited to the scopes it's granted. Only the
+// token's hash is kept, so the tokens file doesn't hold usable credentials.
+type ServiceToken struct {
+	Name        string  `json:"name"` // Recorded as the actor of admin actions
+	TokenSha256 string  `json:"token_sha256"`
+	Scopes      []Scope `json:"scopes"`
+
+	hash []byte
+}
+
+func (t ServiceToken) allows(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// LoadServiceTokens reads a JSON array of service tokens, rejecting unknown scopes and duplicate names
+func LoadServiceTokens(path string) ([]ServiceToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service tokens: %w", err)
+	}
+
+	var tokens []ServiceToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse service tokens: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range tokens {
+		token := &tokens[i]
+		if token.Name == "" {
+			return nil, fmt.Errorf("service token %d has no name", i)
+		}
+
+		if names[token.Name] {
+			return nil, fmt.Errorf("duplicate service token name %q", token.Name)
+		}
+		names[token.Name] = true
+
+		if token.hash, err = hex.DecodeString(token.TokenSha256); err != nil || len(token.hash) != sha256.Size {
+			return nil, fmt.Errorf("service token %q has an invalid sha256 hash", token.Name)
+		}
+
+		for _, scope := range token.Scopes {
+			if !slices.Contains(allScopes, scope) {
+				return nil, fmt.Errorf("service token %q has unknown scope %q", token.Name, scope)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// newFullAccessToken wraps the single configured admin token, which is granted every scope
+func newFullAccessToken(token string) ServiceToken {
+	hash := sha256.Sum256([]byte(token))
+	return ServiceToken{
+		Name:   "admin",
+		Scopes: allScopes,
+		hash:   hash[:],
+	}
+}
+
+// match returns the service token presented, comparing hashes in constant time
+func match(tokens []ServiceToken, presented string) (ServiceToken, bool) {
+	hash := sha256.Sum256([]byte(presented))
+
+	var matched ServiceToken
+	found := false
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(hash[:], token.hash) == 1 {
+			matched, found = token, true
+		}
+	}
+
+	return matched, found
+}
+
+type callerKey struct{}
+
+func withCaller(ctx context.Context, token ServiceToken) context.Context {
+	return context.WithValue(ctx, callerKey{}, token)
+}
+
+// caller returns the service token the request was authenticated with
+func caller(ctx context.Context) ServiceToken {
+	token, _ := ctx.Value(callerKey{}).(ServiceToken)
+	return token
+}
@@ -2,18 +2,23 @@ package archiver
 
 import (
 	"github.com/TicketsBot-cloud/archiverclient"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
 	"go.uber.org/zap"
 )
 
 var (
-	Client *archiverclient.ArchiverClient
-	Proxy  *archiverclient.ProxyRetriever
+	Client  *archiverclient.ArchiverClient
+	Proxy   *archiverclient.ProxyRetriever
+	Metered *processor.MeteredRetriever // Proxy, counting the transcript data fetched and stored through it
+	Batch   *BatchClient
 )
 
 func Initialize(logger *zap.Logger, url, aesKey string) {
 	Proxy = archiverclient.NewProxyRetriever(url)
+	Metered = processor.NewMeteredRetriever(Proxy)
+	Batch = NewBatchClient(url)
 	Client = archiverclient.NewArchiverClient(
-		Proxy,
+		Metered,
 		[]byte(aesKey),
 	)
 
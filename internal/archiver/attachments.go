@@ -0,0 +1,85 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// AttachmentStore removes attachment files that were re-hosted in object storage when their
+// ticket was archived. Such attachments are referenced in transcripts by a URL under urlPrefix.
+type AttachmentStore struct {
+	client    *minio.Client
+	bucket    string
+	urlPrefix string
+}
+
+var _ processor.AttachmentDeleter = (*AttachmentStore)(nil)
+
+func NewAttachmentStore(endpoint, accessKey, secretKey, bucket string, secure bool, urlPrefix string) (*AttachmentStore, error) {
+	if urlPrefix == "" {
+		return nil, fmt.Errorf("attachment URL prefix must be set")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &AttachmentStore{
+		client:    client,
+		bucket:    bucket,
+		urlPrefix: strings.TrimSuffix(urlPrefix, "/") + "/",
+	}, nil
+}
+
+// DeleteAttachments removes the stored file of each attachment served from the store. Removing an
+// object that no longer exists succeeds, so retries are safe.
+func (s *AttachmentStore) DeleteAttachments(ctx context.Context, attachments []channel.Attachment) (int, error) {
+	deleted := 0
+	for _, attachment := range attachments {
+		key, ok := s.objectKey(attachment)
+		if !ok {
+			continue
+		}
+
+		if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return deleted, fmt.Errorf("failed to remove attachment %d: %w", attachment.Id, err)
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// objectKey returns the key an attachment is stored under, if either of its URLs points at the store
+func (s *AttachmentStore) objectKey(attachment channel.Attachment) (string, bool) {
+	for _, raw := range []string{attachment.Url, attachment.ProxyUrl} {
+		rest, ok := strings.CutPrefix(raw, s.urlPrefix)
+		if !ok || rest == "" {
+			continue
+		}
+
+		// Signed or cache-busting query parameters aren't part of the key
+		rest, _, _ = strings.Cut(rest, "?")
+
+		key, err := url.PathUnescape(rest)
+		if err != nil {
+			continue
+		}
+
+		return key, true
+	}
+
+	return "", false
+}
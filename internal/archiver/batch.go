@@ -0,0 +1,91 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+)
+
+// batchTimeout bounds a single batch call, which covers far more objects than the per-ticket calls
+// the archiver client makes with its 3 second timeout
+const batchTimeout = 60 * time.Second
+
+// BatchClient calls the archiver's batch deletion endpoint, which the archiver client doesn't cover
+type BatchClient struct {
+	client   *http.Client
+	endpoint string
+}
+
+var _ processor.BatchDeleter = (*BatchClient)(nil)
+
+func NewBatchClient(endpoint string) *BatchClient {
+	return &BatchClient{
+		client: &http.Client{
+			Timeout: batchTimeout,
+		},
+		endpoint: endpoint,
+	}
+}
+
+type batchDeleteRequest struct {
+	TicketIds []int `json:"ticket_ids"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// DeleteTickets removes the transcripts of the given tickets, returning processor.ErrBatchUnsupported
+// if the archiver is a version without the batch endpoint
+func (c *BatchClient) DeleteTickets(ctx context.Context, guildId uint64, ticketIds []int) (processor.BatchDeleteResult, error) {
+	uri, err := url.Parse(c.endpoint)
+	if err != nil {
+		return processor.BatchDeleteResult{}, err
+	}
+
+	uri.Path = fmt.Sprintf("/guild/%d/tickets/delete", guildId)
+
+	body, err := json.Marshal(batchDeleteRequest{TicketIds: ticketIds})
+	if err != nil {
+		return processor.BatchDeleteResult{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri.String(), bytes.NewReader(body))
+	if err != nil {
+		return processor.BatchDeleteResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return processor.BatchDeleteResult{}, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var result processor.BatchDeleteResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return processor.BatchDeleteResult{}, fmt.Errorf("failed to decode batch deletion response: %w", err)
+		}
+
+		return result, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return processor.BatchDeleteResult{}, processor.ErrBatchUnsupported
+	default:
+		var decoded errorResponse
+		if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+			return processor.BatchDeleteResult{}, fmt.Errorf("batch deletion failed with status %d", res.StatusCode)
+		}
+
+		return processor.BatchDeleteResult{}, errors.New(decoded.Message)
+	}
+}
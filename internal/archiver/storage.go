@@ -0,0 +1,23 @@
+package archiver
+
+import (
+	"fmt"
+
+	"github.com/TicketsBot-cloud/logarchiver/pkg/s3client"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// NewStorage connects directly to the bucket transcripts are stored in, for verifying purges
+// rather than reading or writing transcripts, which goes through the archiver
+func NewStorage(endpoint, accessKey, secretKey, bucket string, secure bool) (*s3client.S3Client, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return s3client.NewS3Client(client, bucket), nil
+}
@@ -0,0 +1,141 @@
+// Package autoscale publishes how many worker replicas the backlog calls for, so an external scaler
+// such as KEDA or a Kubernetes HPA can scale workers out during backlog spikes and back in after.
+// The hint is refreshed on every heartbeat, written to Redis and exported as a gauge.
+package autoscale
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/heartbeat"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/worker"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	KeyDesiredReplicas = "tickets:gdpr:worker:desired_replicas" // Redis key holding the latest desired replica count
+	hintTTL            = 3 * heartbeat.HeartbeatInterval        // Lets the hint lapse once no worker is left to refresh it
+)
+
+// Queues reads the depth of every queue the workers consume, implemented by admin.QueueInspector
+type Queues interface {
+	Stats(ctx context.Context) ([]gdprrelay.QueueStats, error)
+}
+
+// Workers reports the slots of this replica, implemented by the worker group
+type Workers interface {
+	Status() []worker.Status
+}
+
+// Throughput reports how many requests per second this replica processes, implemented by
+// metrics.SLOTracker
+type Throughput interface {
+	Throughput() float64
+}
+
+// Publisher works out and publishes the desired replica count. Every replica may run one, as they
+// all see the same backlog and process requests at much the same rate.
+type Publisher struct {
+	redisClient redis.UniversalClient
+	queues      Queues
+	workers     Workers
+	throughput  Throughput
+	drainTarget time.Duration // How soon the backlog should be worked through
+	minReplicas int
+	maxReplicas int
+	logger      *zap.Logger
+	clock       clock.Clock
+}
+
+func NewPublisher(redisClient redis.UniversalClient, queues Queues, workers Workers, throughput Throughput, drainTarget time.Duration, minReplicas, maxReplicas int, logger *zap.Logger) *Publisher {
+	return &Publisher{
+		redisClient: redisClient,
+		queues:      queues,
+		workers:     workers,
+		throughput:  throughput,
+		drainTarget: drainTarget,
+		minReplicas: minReplicas,
+		maxReplicas: maxReplicas,
+		logger:      logger,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock the hint is refreshed by. Must be called before Run.
+func (p *Publisher) SetClock(clk clock.Clock) {
+	p.clock = clk
+}
+
+// Run refreshes the hint every heartbeat until ctx is cancelled
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := p.clock.NewTicker(heartbeat.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		p.publish(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (p *Publisher) publish(ctx context.Context) {
+	stats, err := p.queues.Stats(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Warn("Failed to read queue depths for scaling hint", zap.Error(err))
+		}
+		return
+	}
+
+	// Delayed retries aren't counted, as more replicas wouldn't get through them any sooner
+	var backlog int64
+	for _, queue := range stats {
+		backlog += queue.Pending + queue.Processing
+	}
+
+	slots := 0
+	for _, status := range p.workers.Status() {
+		slots += status.Concurrency
+	}
+
+	throughput := p.throughput.Throughput()
+	desired := p.desiredReplicas(backlog, slots, throughput)
+
+	metrics.Backlog.Set(float64(backlog))
+	metrics.ThroughputPerReplica.Set(throughput)
+	metrics.DesiredReplicas.Set(float64(desired))
+
+	if err := p.redisClient.Set(ctx, KeyDesiredReplicas, desired, hintTTL).Err(); err != nil && ctx.Err() == nil {
+		p.logger.Warn("Failed to publish scaling hint", zap.Error(err))
+		return
+	}
+
+	p.logger.Debug("Published scaling hint",
+		zap.Int("desired_replicas", desired),
+		zap.Int64("backlog", backlog),
+		zap.Float64("throughput_per_replica", throughput),
+	)
+}
+
+// desiredReplicas is the number of replicas that would work through the backlog within the drain
+// target. A replica is assumed to get through at least one request per slot in that time, as
+// throughput is only known once it has processed requests.
+func (p *Publisher) desiredReplicas(backlog int64, slots int, throughput float64) int {
+	perReplica := math.Max(float64(slots), throughput*p.drainTarget.Seconds())
+
+	desired := p.minReplicas
+	if perReplica > 0 {
+		desired = int(math.Ceil(float64(backlog) / perReplica))
+	}
+
+	return max(p.minReplicas, min(desired, p.maxReplicas))
+}
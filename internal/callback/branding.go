@@ -0,0 +1,98 @@
+package callback
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+)
+
+// Brand is how callbacks are styled for a whitelabel bot. Colours are hex strings such as
+// "#5865F2", anything left empty keeps the default.
+type Brand struct {
+	ApplicationId uint64 `json:"application_id,string"`
+	Name          string `json:"name"`           // Shown before every title, e.g. "Acme Support"
+	SuccessColour string `json:"success_colour"` // Completed requests
+	WarningColour string `json:"warning_colour"` // Requests in progress, cancelled or rate limited
+	ErrorColour   string `json:"error_colour"`   // Failed requests
+
+	colours map[utils.Colour]int
+}
+
+// LoadBranding reads a JSON array of brands, rejecting invalid colours and duplicate application IDs
+func LoadBranding(path string) (map[uint64]Brand, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read branding: %w", err)
+	}
+
+	var brands []Brand
+	if err := json.Unmarshal(data, &brands); err != nil {
+		return nil, fmt.Errorf("failed to parse branding: %w", err)
+	}
+
+	branding := make(map[uint64]Brand, len(brands))
+	for i, brand := range brands {
+		if brand.ApplicationId == 0 {
+			return nil, fmt.Errorf("brand %d has no application ID", i)
+		}
+
+		if _, ok := branding[brand.ApplicationId]; ok {
+			return nil, fmt.Errorf("duplicate brand for application %d", brand.ApplicationId)
+		}
+
+		brand.colours = make(map[utils.Colour]int)
+		for colour, value := range map[utils.Colour]string{
+			utils.Green:  brand.SuccessColour,
+			utils.Orange: brand.WarningColour,
+			utils.Red:    brand.ErrorColour,
+		} {
+			if value == "" {
+				continue
+			}
+
+			rgb, err := parseHexColour(value)
+			if err != nil {
+				return nil, fmt.Errorf("brand for application %d has an invalid colour %q", brand.ApplicationId, value)
+			}
+			brand.colours[colour] = rgb
+		}
+
+		branding[brand.ApplicationId] = brand
+	}
+
+	return branding, nil
+}
+
+func parseHexColour(value string) (int, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 {
+		return 0, fmt.Errorf("expected 6 hex digits")
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	return int(rgb), err
+}
+
+// title prefixes a title with the brand name, if there is one
+func (b Brand) title(title string) string {
+	if b.Name == "" {
+		return title
+	}
+
+	return b.Name + " · " + title
+}
+
+// container builds a container in the brand's colours, under its title
+func (b Brand) container(colour utils.Colour, title string, innerComponents []component.Component) component.Component {
+	rgb, ok := b.colours[colour]
+	if !ok {
+		rgb = colour.ToRGB()
+	}
+
+	return utils.BuildContainerWithAccent(rgb, b.title(title), innerComponents)
+}
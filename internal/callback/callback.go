@@ -2,64 +2,173 @@ package callback
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
 	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
 	"github.com/TicketsBot-cloud/gdl/rest"
 	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdl/rest/request"
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/email"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
-	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
 // ResultData contains the result of a GDPR request to be sent back to the user
 type ResultData struct {
-	TranscriptsDeleted int                   // Number of transcript archives deleted
-	MessagesDeleted    int                   // Number of ticket messages deleted
-	Error              error                 // Error if the processing failed
-	RequestType        gdprrelay.RequestType // Type of GDPR request that was processed
-	GuildIds           []uint64              // Guild IDs affected by this request
-	TicketIds          []int                 // Ticket IDs affected by this request
+	TranscriptsDeleted   int                   // Number of transcript archives deleted
+	MessagesDeleted      int                   // Number of ticket messages deleted
+	FeedbackDeleted      int                   // Number of ratings, survey responses and close reasons deleted
+	TranscriptsExported  int                   // Number of transcripts included in a data export
+	ExportUrl            string                // Time-limited download link for a data export
+	ExportExpiresAt      time.Time             // When the export download link stops working
+	ExportPassword       string                // Password the data export is encrypted under, sent apart from the link
+	Error                error                 // Error if the processing failed
+	PermanentlyFailed    bool                  // Whether the request exhausted its retries and won't be attempted again
+	Cancelled            bool                  // Whether the user cancelled the request, counts are what was deleted before it stopped
+	RateLimitedUntil     time.Time             // When the user may make another request, if this one was rejected for exceeding their quota
+	CoalescedInto        int                   // ID of the identical request already being processed, if this one was folded into it
+	DryRun               bool                  // Whether counts are a preview of what would be deleted
+	UnmatchedTicketIds   []int                 // Requested ticket IDs that don't exist in the requested guild
+	CertificateIssued    bool                  // Whether a certificate of erasure was issued
+	CertificateUrl       string                // Time-limited download link for the certificate, empty if not uploaded
+	CertificateExpiresAt time.Time             // When the certificate download link stops working
+	RequestType          gdprrelay.RequestType // Type of GDPR request that was processed
+	ResultCode           gdpr.ResultCode       // Classification of the outcome, recorded with each delivery attempt
+	GuildIds             []uint64              // Guild IDs affected by this request
+	TicketIds            []int                 // Ticket IDs affected by this request
+	TicketsWithheld      int                   // Number of tickets left untouched as they are under a legal hold
+	TicketsNoTranscript  int                   // Number of tickets in scope that had no stored transcript
+	PurgeAt              time.Time             // When deleted transcripts are permanently removed, zero if they were removed immediately
+
+	GuildResults map[uint64]processor.GuildResult // Outcome within each guild, shown as a per-server breakdown
+	CompletedAt  time.Time                        // When processing finished, zero if the request wasn't processed
 }
 
+const (
+	progressBarWidth = 20
+	emailSendTimeout = 30 * time.Second
+
+	// maxResultLength is the most characters of results shown in a message. Discord allows 4000
+	// across all of a message's text, which leaves room for the title.
+	maxResultLength = 3800
+)
+
 type Callback struct {
 	logger      *zap.Logger
 	rateLimiter *ratelimit.Ratelimiter
+	botLimiters *botLimiters     // Rate limiters of whitelabel bots, whose own tokens DMs are sent with
+	deliveries  *deliveryLog     // Delivery attempts of the completion being sent, nil outside of SendCompletion
+	requestId   int              // Request the completion being sent belongs to, given as a reference on errors
+	mailer      email.Sender     // Emails results to users who can't be reached on Discord, nil if disabled
+	branding    map[uint64]Brand // Styling of whitelabel bots' callbacks, by application ID
+	brand       Brand            // Styling of the completion being sent, only set within SendCompletion
 }
 
-func New(logger *zap.Logger, proxyUrl string) *Callback {
+func New(logger *zap.Logger) *Callback {
 	store := ratelimit.NewMemoryStore()
 
 	return &Callback{
 		logger:      logger,
 		rateLimiter: ratelimit.NewRateLimiter(store, 0),
+		botLimiters: newBotLimiters(),
 	}
 }
 
-func (c *Callback) SendCompletion(ctx context.Context, request gdprrelay.GDPRRequest, result ResultData) error {
+// SetBranding styles the callbacks of whitelabel bots, by application ID. Must be called before any
+// completion is sent.
+func (c *Callback) SetBranding(branding map[uint64]Brand) {
+	c.branding = branding
+}
+
+// SetMailer emails results to users who can't be reached on Discord and gave a verified address.
+// Must be called before any completion is sent.
+func (c *Callback) SetMailer(mailer email.Sender) {
+	c.mailer = mailer
+}
+
+func (c *Callback) SendCompletion(ctx context.Context, queued gdprrelay.QueuedRequest, result ResultData) (err error) {
+	ctx, span := tracing.Start(tracing.WithRequestId(ctx, queued.RequestID), "gdpr.callback",
+		attribute.Bool("gdpr.permanently_failed", result.PermanentlyFailed),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Scope log entries to this request so they can be correlated with the queue and processor logs
+	c = c.withLogger(c.logger.With(
+		zap.Int("request_id", queued.RequestID),
+		zap.Int("retry_count", queued.RetryCount),
+	))
+	c.deliveries = newDeliveryLog(queued.RequestID, result.ResultCode)
+	c.requestId = queued.RequestID
+	c.brand = c.branding[queued.Request.ApplicationId]
+	defer c.deliveries.persist(ctx, c.logger)
+
+	request := queued.Request
+	locale := Locale(request)
+
+	if err := c.deliverCompletion(ctx, request, locale, result); err != nil {
+		return err
+	}
+
+	if result.ExportPassword != "" && result.Error == nil && request.NotifyVia != gdpr.NotifyViaSilent {
+		return c.sendExportPassword(ctx, request, locale, result)
+	}
+
+	return nil
+}
+
+// deliverCompletion sends the results through whichever channels the request allows
+func (c *Callback) deliverCompletion(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+
+	switch request.NotifyVia {
+	case gdpr.NotifyViaSilent:
+		c.logger.Debug("Producer notifies the user itself, skipping callback")
+		return nil
+	case gdpr.NotifyViaDM:
+		if request.UserId == 0 {
+			return nil
+		}
+
+		return c.sendCompletionOutOfBand(ctx, request, locale, result)
+	}
+
 	if request.InteractionToken == "" {
+		// Permanent failures would otherwise be completely silent to the user. Purges the worker
+		// scheduled itself have no user to tell.
+		if request.UserId != 0 && (result.PermanentlyFailed || request.Visibility == gdpr.VisibilityDM) {
+			return c.sendCompletionOutOfBand(ctx, request, locale, result)
+		}
+
 		c.logger.Debug("No interaction token, skipping callback")
 		return nil
 	}
 
-	scrambledUserId := utils.ScrambleUserId(request.UserId)
-	locale := i18n.GetLocale(request.Language)
-	components := c.buildResultComponents(locale, result, request.GuildNames)
+	// The original response may be public, so private completions replace it with a notice that
+	// doesn't reveal any counts
+	if request.Visibility == gdpr.VisibilityEphemeral || request.Visibility == gdpr.VisibilityDM {
+		return c.sendPrivateCompletion(ctx, request, locale, result)
+	}
+
+	// Servers left out are listed in the results file attached to the follow-up
+	components, _ := c.buildResultComponents(locale, result, request.GuildNames)
 
 	if err := c.editOriginalMessage(ctx, request, components); err != nil {
 		if c.isTokenExpired(err) {
-			if dmErr := c.sendCompletionViaDM(ctx, request, locale, result); dmErr != nil {
-				c.logger.Error("Failed to send completion via DM",
-					zap.Error(dmErr),
-					zap.String("scrambled_user_id", scrambledUserId),
-				)
-				return dmErr
-			}
-			return nil
+			return c.sendCompletionOutOfBand(ctx, request, locale, result)
 		}
 
 		c.logger.Error("Failed to edit original message",
@@ -69,6 +178,10 @@ func (c *Callback) SendCompletion(ctx context.Context, request gdprrelay.GDPRReq
 		return err
 	}
 
+	if !config.Conf.Callback.FollowupEnabled {
+		return nil
+	}
+
 	if err := c.sendEphemeralFollowup(ctx, request, locale, result); err != nil {
 		if c.isTokenExpired(err) {
 			return nil
@@ -78,91 +191,430 @@ func (c *Callback) SendCompletion(ctx context.Context, request gdprrelay.GDPRReq
 			zap.Error(err),
 			zap.String("scrambled_user_id", scrambledUserId),
 		)
+		return fmt.Errorf("failed to send ephemeral follow-up: %w", err)
+	}
+
+	return nil
+}
+
+// SendProgress edits the original interaction response with a progress bar while a long-running
+// request is processed. Counts are left out unless the results are shown in the original response.
+func (c *Callback) SendProgress(ctx context.Context, queued gdprrelay.QueuedRequest, progress processor.Progress) error {
+	request := queued.Request
+	if request.InteractionToken == "" || request.NotifyVia == gdpr.NotifyViaSilent || request.NotifyVia == gdpr.NotifyViaDM {
+		return nil
+	}
+
+	locale := Locale(request)
+
+	content := i18n.GetMessage(locale, i18n.GdprProgress,
+		i18n.Named("bar", utils.ProgressBar(progress.TicketsProcessed, progress.TicketsTotal, progressBarWidth)),
+		i18n.Named("processed", i18n.Number(locale, progress.TicketsProcessed)),
+		i18n.Named("total", i18n.Number(locale, progress.TicketsTotal)),
+	)
+
+	if request.Visibility == "" || request.Visibility == gdpr.VisibilityOriginal {
+		content += "\n" + i18n.GetMessage(locale, i18n.GdprProgressCounts,
+			i18n.Named("transcripts", i18n.Number(locale, progress.TranscriptsDeleted)),
+			i18n.Named("messages", i18n.Number(locale, progress.MessagesDeleted)),
+		)
+	}
+
+	components := []component.Component{
+		c.branding[request.ApplicationId].container(utils.Orange, i18n.GetMessage(locale, i18n.GdprProgressTitle), []component.Component{
+			component.BuildTextDisplay(component.TextDisplay{
+				Content: content,
+			}),
+		}),
+	}
+
+	if err := c.editOriginalMessage(ctx, request, components); err != nil && !c.isTokenExpired(err) {
+		return err
 	}
 
 	return nil
 }
 
+func (c *Callback) sendPrivateCompletion(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+
+	title := resultTitle(locale, result)
+
+	notice := []component.Component{
+		c.brand.container(resultColour(result), title, []component.Component{
+			component.BuildTextDisplay(component.TextDisplay{
+				Content: i18n.GetMessage(locale, i18n.GdprCompletedPrivate),
+			}),
+		}),
+	}
+
+	if err := c.editOriginalMessage(ctx, request, notice); err != nil {
+		if c.isTokenExpired(err) {
+			return c.sendCompletionOutOfBand(ctx, request, locale, result)
+		}
+
+		c.logger.Error("Failed to edit original message",
+			zap.Error(err),
+			zap.String("scrambled_user_id", scrambledUserId),
+		)
+		return err
+	}
+
+	if request.Visibility == gdpr.VisibilityDM && canDM(request) {
+		dmErr := c.sendCompletionViaDM(ctx, request, locale, result)
+		if dmErr == nil {
+			return nil
+		}
+
+		// Users with DMs disabled would otherwise never see their results
+		c.logger.Warn("Failed to send completion via DM, falling back to ephemeral follow-up",
+			zap.Error(dmErr),
+			zap.String("scrambled_user_id", scrambledUserId),
+		)
+	}
+
+	// Servers left out are listed in the attached results file
+	components, _ := c.buildResultComponents(locale, result, request.GuildNames)
+
+	data := rest.WebhookBody{
+		Components: components,
+		Flags:      uint(message.FlagEphemeral | message.FlagComponentsV2),
+	}
+
+	if report := c.guildReport(locale, result, request.GuildNames); report != nil {
+		data.Components = append(data.Components, reportComponent())
+		data.Attachments = reportAttachments(report)
+	}
+
+	err := c.createFollowup(ctx, request, data)
+	if err != nil {
+		if c.isTokenExpired(err) {
+			return nil
+		}
+
+		c.logger.Error("Failed to send ephemeral completion",
+			zap.Error(err),
+			zap.String("scrambled_user_id", scrambledUserId),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// Locale returns the locale results are sent in: the locale of the interaction that made the
+// request if it has a translation, otherwise the configured language, otherwise English
+func Locale(request gdprrelay.GDPRRequest) *i18n.Locale {
+	return i18n.ResolveLocale(request.Locale, request.Language)
+}
+
+func (c *Callback) withLogger(logger *zap.Logger) *Callback {
+	scoped := *c
+	scoped.logger = logger
+	return &scoped
+}
+
+// Discord error codes returned once an interaction's token can no longer be used to respond to it
+const (
+	discordUnknownWebhook      = 10015
+	discordUnknownInteraction  = 10062
+	discordAlreadyAcknowledged = 40060
+	discordInvalidWebhookToken = 50027
+)
+
+// isTokenExpired reports whether Discord rejected a call as the interaction's token can no longer be
+// used, so the user must be reached another way. Timeouts aren't, they're returned to be retried.
 func (c *Callback) isTokenExpired(err error) bool {
-	if err == nil {
+	var restErr request.RestError
+	if !errors.As(err, &restErr) {
 		return false
 	}
 
-	errStr := strings.ToLower(err.Error())
-	return strings.Contains(errStr, "unknown webhook") ||
-		strings.Contains(errStr, "unknown interaction") ||
-		strings.Contains(errStr, "invalid webhook token") ||
-		strings.Contains(errStr, "interaction has already been acknowledged") ||
-		strings.Contains(errStr, "context deadline exceeded")
+	switch restErr.ApiError.Code {
+	case discordUnknownWebhook, discordUnknownInteraction, discordAlreadyAcknowledged, discordInvalidWebhookToken:
+		return true
+	default:
+		return false
+	}
 }
 
-func (c *Callback) buildResultMessage(locale *i18n.Locale, result ResultData, guildNames map[uint64]string) string {
+// buildResultMessage writes the results shown to the user. Servers that don't fit within maxLength
+// characters are left out of the per-server breakdown and returned, 0 means no limit.
+func (c *Callback) buildResultMessage(locale *i18n.Locale, result ResultData, guildNames map[uint64]string, maxLength int) (string, []string) {
+	if result.CoalescedInto != 0 {
+		return i18n.GetMessage(locale, i18n.GdprCompletedCoalesced, i18n.Named("request_id", result.CoalescedInto)), nil
+	}
+
+	if !result.RateLimitedUntil.IsZero() {
+		return i18n.GetMessage(locale, i18n.GdprCompletedRateLimited, i18n.Named("until", result.RateLimitedUntil.Unix())), nil
+	}
+
+	if result.Cancelled {
+		content := i18n.GetMessage(locale, i18n.GdprCompletedCancelled)
+		if result.TranscriptsDeleted > 0 || result.MessagesDeleted > 0 || result.FeedbackDeleted > 0 {
+			content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedCancelledPartial,
+				i18n.Named("transcripts", i18n.Number(locale, result.TranscriptsDeleted)),
+				i18n.Named("messages", i18n.Number(locale, result.MessagesDeleted)),
+				i18n.Named("feedback", i18n.Number(locale, result.FeedbackDeleted)),
+			)
+		}
+
+		return content, nil
+	}
+
+	notes := resultNotes(locale, result)
+
+	// The per-server breakdown gets whatever room the rest of the results leave
+	reserved := utf8.RuneCountInString(notes)
+	if result.DryRun {
+		reserved += utf8.RuneCountInString(i18n.GetMessage(locale, i18n.GdprCompletedDryRunNotice)) + 2
+	}
+
 	var content string
+	var omitted []string
 
 	switch result.RequestType {
 	case gdprrelay.RequestTypeAllTranscripts:
 		if len(result.GuildIds) == 1 {
 			guildDisplay := utils.FormatGuildDisplay(result.GuildIds[0], guildNames)
-			content = i18n.GetMessage(locale, i18n.GdprCompletedAllTranscripts, guildDisplay, result.TranscriptsDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedAllTranscripts, i18n.Named("guild", guildDisplay), i18n.Named("count", i18n.Number(locale, result.TranscriptsDeleted)))
 		} else {
-			guildDisplays := make([]string, len(result.GuildIds))
-			for i, guildId := range result.GuildIds {
-				guildDisplays[i] = utils.FormatGuildDisplay(guildId, guildNames)
-			}
-			content = i18n.GetMessage(locale, i18n.GdprCompletedAllTranscriptsMulti, strings.Join(guildDisplays, "\n* "), result.TranscriptsDeleted)
+			content, omitted = c.guildListMessage(locale, i18n.GdprCompletedAllTranscriptsMulti, result, guildNames, result.TranscriptsDeleted, maxLength, reserved)
 		}
 
 	case gdprrelay.RequestTypeSpecificTranscripts:
 		if len(result.GuildIds) > 0 {
 			guildDisplay := utils.FormatGuildDisplay(result.GuildIds[0], guildNames)
-			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificTranscripts, guildDisplay, result.TranscriptsDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificTranscripts, i18n.Named("guild", guildDisplay), i18n.Named("count", i18n.Number(locale, result.TranscriptsDeleted)))
 		} else {
-			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificTranscripts, "Unknown", result.TranscriptsDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificTranscripts, i18n.Named("guild", "Unknown"), i18n.Named("count", i18n.Number(locale, result.TranscriptsDeleted)))
 		}
 
 	case gdprrelay.RequestTypeAllMessages:
 		if len(result.GuildIds) == 1 {
 			guildDisplay := utils.FormatGuildDisplay(result.GuildIds[0], guildNames)
-			content = i18n.GetMessage(locale, i18n.GdprCompletedAllMessages, guildDisplay, result.MessagesDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedAllMessages, i18n.Named("guild", guildDisplay), i18n.Named("count", i18n.Number(locale, result.MessagesDeleted)))
 		} else {
-			guildDisplays := make([]string, len(result.GuildIds))
-			for i, guildId := range result.GuildIds {
-				guildDisplays[i] = utils.FormatGuildDisplay(guildId, guildNames)
-			}
-			content = i18n.GetMessage(locale, i18n.GdprCompletedAllMessagesMulti, strings.Join(guildDisplays, "\n* "), result.MessagesDeleted)
+			content, omitted = c.guildListMessage(locale, i18n.GdprCompletedAllMessagesMulti, result, guildNames, result.MessagesDeleted, maxLength, reserved)
 		}
 
 	case gdprrelay.RequestTypeSpecificMessages:
 		if len(result.GuildIds) > 0 {
 			guildDisplay := utils.FormatGuildDisplay(result.GuildIds[0], guildNames)
-			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificMessages, guildDisplay, result.MessagesDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificMessages, i18n.Named("guild", guildDisplay), i18n.Named("count", i18n.Number(locale, result.MessagesDeleted)))
 		} else {
-			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificMessages, "Unknown", result.MessagesDeleted)
+			content = i18n.GetMessage(locale, i18n.GdprCompletedSpecificMessages, i18n.Named("guild", "Unknown"), i18n.Named("count", i18n.Number(locale, result.MessagesDeleted)))
 		}
+
+	case gdprrelay.RequestTypeDataExport:
+		content = i18n.GetMessage(locale, i18n.GdprCompletedDataExport,
+			i18n.Named("count", i18n.Number(locale, result.TranscriptsExported)),
+			i18n.Named("url", result.ExportUrl),
+			i18n.Named("expires", result.ExportExpiresAt.Unix()),
+		)
+
+	case gdprrelay.RequestTypeFeedback:
+		content = i18n.GetMessage(locale, i18n.GdprCompletedFeedback, i18n.Named("count", i18n.Number(locale, result.FeedbackDeleted)))
+	}
+
+	content += notes
+
+	if result.PermanentlyFailed {
+		content = i18n.GetMessage(locale, i18n.GdprCompletedPermanentFailure, i18n.Named("error", c.userFacingError(locale, result.Error)))
+	} else if result.Error != nil {
+		content = i18n.GetMessage(locale, i18n.GdprCompletedError, i18n.Named("error", c.userFacingError(locale, result.Error)))
+	} else if result.DryRun {
+		content = i18n.GetMessage(locale, i18n.GdprCompletedDryRunNotice) + "\n\n" + content
 	}
 
 	if result.Error != nil {
-		content = i18n.GetMessage(locale, i18n.GdprCompletedError, result.Error.Error())
+		omitted = nil
+	}
+
+	// Other lists, such as of unmatched tickets, can still be too long on their own
+	if maxLength > 0 {
+		content = limitText(content, maxLength)
+	}
+
+	return content, omitted
+}
+
+// resultNotes writes the notes shown after the counts of a successful request
+func resultNotes(locale *i18n.Locale, result ResultData) string {
+	var content string
+
+	// The password is never shown beside the link, so the link alone doesn't open the export
+	if result.Error == nil && result.ExportPassword != "" {
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedExportPassword)
+	}
+
+	if result.Error == nil && len(result.UnmatchedTicketIds) > 0 {
+		ticketIds := make([]string, len(result.UnmatchedTicketIds))
+		for i, ticketId := range result.UnmatchedTicketIds {
+			ticketIds[i] = fmt.Sprintf("#%d", ticketId)
+		}
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedUnmatchedTickets, i18n.Named("tickets", strings.Join(ticketIds, ", ")))
+	}
+
+	if result.Error == nil {
+		if ticketIds := alreadyDeletedTicketIds(result); len(ticketIds) > 0 {
+			content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedAlreadyDeleted, i18n.Named("tickets", strings.Join(ticketIds, ", ")))
+		}
+	}
+
+	if result.Error == nil && result.TicketsWithheld > 0 {
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedLegalHold, i18n.Named("count", i18n.Number(locale, result.TicketsWithheld)))
+	}
+
+	if result.Error == nil {
+		unattributed := 0
+		for _, guildResult := range result.GuildResults {
+			unattributed += guildResult.Unattributed
+		}
+
+		if unattributed > 0 {
+			content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedUnattributed, i18n.Named("count", i18n.Number(locale, unattributed)))
+		}
+	}
+
+	// Distinguishes tickets that had nothing stored from ones that failed or were deleted
+	if result.Error == nil && result.TicketsNoTranscript > 0 {
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedNoTranscript, i18n.Named("count", i18n.Number(locale, result.TicketsNoTranscript)))
+	}
+
+	if result.Error == nil && !result.PurgeAt.IsZero() {
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedSoftDelete, i18n.Named("purge_at", result.PurgeAt.Unix()))
+	}
+
+	if result.Error == nil && !result.CompletedAt.IsZero() {
+		content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedAt, i18n.Named("completed_at", i18n.Timestamp(result.CompletedAt, i18n.TimestampRelative)))
+	}
+
+	if result.Error == nil && result.CertificateIssued {
+		if result.CertificateUrl != "" {
+			content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedCertificate, i18n.Named("url", result.CertificateUrl), i18n.Named("expires", result.CertificateExpiresAt.Unix()))
+		} else {
+			content += "\n\n" + i18n.GetMessage(locale, i18n.GdprCompletedCertificateReference)
+		}
 	}
 
 	return content
 }
 
-func (c *Callback) buildResultComponents(locale *i18n.Locale, result ResultData, guildNames map[uint64]string) []component.Component {
-	colour := utils.Green
-	if result.Error != nil {
-		colour = utils.Red
+// guildListMessage writes a message listing the outcome in each server, leaving out the servers
+// that don't fit within maxLength characters along with reserved characters for the rest of the
+// results. Returns the servers left out.
+func (c *Callback) guildListMessage(locale *i18n.Locale, id i18n.MessageId, result ResultData, guildNames map[uint64]string, count, maxLength, reserved int) (string, []string) {
+	lines := c.buildGuildBreakdown(locale, result, guildNames)
+	if maxLength <= 0 {
+		return i18n.GetMessage(locale, id, i18n.Named("guilds", strings.Join(lines, guildListSeparator)), i18n.Named("count", i18n.Number(locale, count))), nil
+	}
+
+	budget := maxLength - reserved - utf8.RuneCountInString(i18n.GetMessage(locale, id, i18n.Named("guilds", ""), i18n.Named("count", i18n.Number(locale, count))))
+	list, omitted := fitGuildList(locale, lines, budget)
+	return i18n.GetMessage(locale, id, i18n.Named("guilds", list), i18n.Named("count", i18n.Number(locale, count))), omitted
+}
+
+// buildGuildBreakdown lists each requested server along with what happened in it, falling back to
+// just the server names if no per-guild results are available
+func (c *Callback) buildGuildBreakdown(locale *i18n.Locale, result ResultData, guildNames map[uint64]string) []string {
+	lines := make([]string, len(result.GuildIds))
+	for i, guildId := range result.GuildIds {
+		lines[i] = utils.FormatGuildDisplay(guildId, guildNames)
+
+		guildResult, ok := result.GuildResults[guildId]
+		if !ok {
+			continue
+		}
+
+		if guildResult.Rejected {
+			lines[i] += ": " + i18n.GetMessage(locale, i18n.GdprCompletedGuildRejected)
+			continue
+		}
+
+		if guildResult.Error != nil && guildResult.Failed == 0 {
+			lines[i] += ": " + i18n.GetMessage(locale, i18n.GdprCompletedGuildFailed)
+			continue
+		}
+
+		deleted := guildResult.TranscriptsDeleted
+		if result.RequestType == gdprrelay.RequestTypeAllMessages {
+			deleted = guildResult.MessagesDeleted
+		}
+
+		lines[i] += ": " + i18n.GetMessage(locale, i18n.GdprCompletedGuildResult, i18n.Named("deleted", i18n.Number(locale, deleted)), i18n.Named("skipped", i18n.Number(locale, guildResult.Skipped)))
+		if guildResult.Failed > 0 {
+			lines[i] += ", " + i18n.GetMessage(locale, i18n.GdprCompletedGuildTicketsFailed, i18n.Named("count", i18n.Number(locale, guildResult.Failed)))
+		}
+		if guildResult.Withheld > 0 {
+			lines[i] += ", " + i18n.GetMessage(locale, i18n.GdprCompletedGuildWithheld, i18n.Named("count", i18n.Number(locale, guildResult.Withheld)))
+		}
+		if len(guildResult.AlreadyDeleted) > 0 {
+			lines[i] += ", " + i18n.GetMessage(locale, i18n.GdprCompletedGuildAlreadyDeleted, i18n.Named("count", i18n.Number(locale, len(guildResult.AlreadyDeleted))))
+		}
+		if guildResult.NoTranscript > 0 {
+			lines[i] += ", " + i18n.GetMessage(locale, i18n.GdprCompletedGuildNoTranscript, i18n.Named("count", i18n.Number(locale, guildResult.NoTranscript)))
+		}
+	}
+
+	return lines
+}
+
+// alreadyDeletedTicketIds lists the tickets whose transcript another request deleted before the
+// user's messages could be cleaned from it, in the order of the requested servers
+func alreadyDeletedTicketIds(result ResultData) []string {
+	var ticketIds []string
+	for _, guildId := range result.GuildIds {
+		for _, ticketId := range result.GuildResults[guildId].AlreadyDeleted {
+			ticketIds = append(ticketIds, fmt.Sprintf("#%d", ticketId))
+		}
 	}
 
+	return ticketIds
+}
+
+// buildResultComponents shows the results in a container. Servers that don't fit in the message
+// are left out of the per-server breakdown and returned.
+func (c *Callback) buildResultComponents(locale *i18n.Locale, result ResultData, guildNames map[uint64]string) ([]component.Component, []string) {
+	colour := resultColour(result)
+	content, omitted := c.buildResultMessage(locale, result, guildNames, maxResultLength)
+
 	innerComponents := []component.Component{
 		component.BuildTextDisplay(component.TextDisplay{
-			Content: c.buildResultMessage(locale, result, guildNames),
+			Content: content,
 		}),
 	}
 
-	title := i18n.GetMessage(locale, i18n.GdprCompletedTitle)
-	container := utils.BuildContainerWithComponents(colour, title, innerComponents)
-	return []component.Component{container}
+	title := resultTitle(locale, result)
+	container := c.brand.container(colour, title, innerComponents)
+	return []component.Component{container}, omitted
+}
+
+// resultTitle is the heading results are shown under
+func resultTitle(locale *i18n.Locale, result ResultData) string {
+	if result.CoalescedInto != 0 {
+		return i18n.GetMessage(locale, i18n.GdprCompletedCoalescedTitle)
+	} else if !result.RateLimitedUntil.IsZero() {
+		return i18n.GetMessage(locale, i18n.GdprCompletedRateLimitedTitle)
+	} else if result.Cancelled {
+		return i18n.GetMessage(locale, i18n.GdprCompletedCancelledTitle)
+	} else if result.DryRun {
+		return i18n.GetMessage(locale, i18n.GdprCompletedDryRunTitle)
+	}
+
+	return i18n.GetMessage(locale, i18n.GdprCompletedTitle)
+}
+
+// resultColour is the accent colour results are shown with: red for errors, orange for cancelled,
+// rate limited and coalesced requests and green otherwise
+func resultColour(result ResultData) utils.Colour {
+	if result.Error != nil {
+		return utils.Red
+	} else if result.Cancelled || !result.RateLimitedUntil.IsZero() || result.CoalescedInto != 0 {
+		return utils.Orange
+	}
+
+	return utils.Green
 }
 
 func (c *Callback) editOriginalMessage(ctx context.Context, request gdprrelay.GDPRRequest, components []component.Component) error {
@@ -172,16 +624,34 @@ func (c *Callback) editOriginalMessage(ctx context.Context, request gdprrelay.GD
 	}
 
 	_, err := rest.EditOriginalInteractionResponse(ctx, request.InteractionToken, c.rateLimiter, request.ApplicationId, data)
+	c.deliveries.record(ChannelInteractionEdit, err)
 	return err
 }
 
 func (c *Callback) sendEphemeralFollowup(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
 	var content string
 
-	if result.Error != nil {
-		content = i18n.GetMessage(locale, i18n.GdprFollowupError, result.Error.Error())
-	} else if result.TranscriptsDeleted == 0 && result.MessagesDeleted == 0 {
-		content = i18n.GetMessage(locale, i18n.GdprFollowupNoData)
+	if result.CoalescedInto != 0 {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupCoalesced, i18n.Named("request_id", result.CoalescedInto))
+	} else if !result.RateLimitedUntil.IsZero() {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupRateLimited, i18n.Named("until", result.RateLimitedUntil.Unix()))
+	} else if result.Cancelled {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupCancelled)
+	} else if result.PermanentlyFailed {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupPermanentFailure)
+	} else if result.Error != nil {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupError, i18n.Named("error", c.userFacingError(locale, result.Error)))
+	} else if result.DryRun {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupDryRun)
+	} else if result.TranscriptsDeleted == 0 && len(result.TicketIds) > 0 && len(result.UnmatchedTicketIds) == len(result.TicketIds) {
+		content = i18n.GetMessage(locale, i18n.GdprFollowupNoMatchingTickets)
+	} else if result.RequestType != gdprrelay.RequestTypeDataExport && result.TranscriptsDeleted == 0 && result.MessagesDeleted == 0 && result.FeedbackDeleted == 0 && result.TicketsWithheld == 0 {
+		// Nothing was deleted, either as nothing was stored for the tickets in scope or as there were none
+		if result.TicketsNoTranscript > 0 {
+			content = i18n.GetMessage(locale, i18n.GdprFollowupNoTranscripts, i18n.Named("count", i18n.Number(locale, result.TicketsNoTranscript)))
+		} else {
+			content = i18n.GetMessage(locale, i18n.GdprFollowupNoData)
+		}
 	} else {
 		content = i18n.GetMessage(locale, i18n.GdprFollowupSuccess)
 	}
@@ -191,21 +661,50 @@ func (c *Callback) sendEphemeralFollowup(ctx context.Context, request gdprrelay.
 		Flags:   uint(message.FlagEphemeral),
 	}
 
-	_, err := rest.CreateFollowupMessage(ctx, request.InteractionToken, c.rateLimiter, request.ApplicationId, data)
+	// Attached here rather than to the original response, which can't be edited to add files
+	if report := c.guildReport(locale, result, request.GuildNames); report != nil {
+		data.Attachments = reportAttachments(report)
+	}
+
+	return c.createFollowup(ctx, request, data)
+}
+
+// createFollowup sends a follow-up to the interaction. Those with files are sent by executing the
+// interaction's webhook, the same endpoint, as only that call can upload them.
+func (c *Callback) createFollowup(ctx context.Context, request gdprrelay.GDPRRequest, data rest.WebhookBody) (err error) {
+	defer func() { c.deliveries.record(ChannelFollowup, err) }()
+
+	if len(data.Attachments) > 0 {
+		_, err = rest.ExecuteWebhook(ctx, request.InteractionToken, c.rateLimiter, request.ApplicationId, true, data)
+		return err
+	}
+
+	_, err = rest.CreateFollowupMessage(ctx, request.InteractionToken, c.rateLimiter, request.ApplicationId, data)
 	return err
 }
 
-func (c *Callback) sendCompletionViaDM(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+func (c *Callback) sendCompletionViaDM(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) (err error) {
+	defer func() { c.deliveries.record(ChannelDM, err) }()
+
 	scrambledUserId := utils.ScrambleUserId(request.UserId)
 
-	if config.Conf.Discord.Token == "" {
+	token, rateLimiter, err := c.botToken(ctx, request.ApplicationId)
+	if err != nil {
+		c.logger.Error("Failed to get bot token for DM",
+			zap.Error(err),
+			zap.String("scrambled_user_id", scrambledUserId),
+		)
+		return err
+	}
+
+	if token == "" {
 		c.logger.Error("Discord token not configured, cannot send DM",
 			zap.String("scrambled_user_id", scrambledUserId),
 		)
 		return fmt.Errorf("discord token not configured")
 	}
 
-	dmChannel, err := rest.CreateDM(ctx, config.Conf.Discord.Token, c.rateLimiter, request.UserId)
+	dmChannel, err := rest.CreateDM(ctx, token, rateLimiter, request.UserId)
 	if err != nil {
 		c.logger.Error("Failed to create DM channel",
 			zap.Error(err),
@@ -214,14 +713,19 @@ func (c *Callback) sendCompletionViaDM(ctx context.Context, request gdprrelay.GD
 		return fmt.Errorf("failed to create DM channel: %w", err)
 	}
 
-	components := c.buildResultComponents(locale, result, request.GuildNames)
+	components, omitted := c.buildResultComponents(locale, result, request.GuildNames)
 
 	data := rest.CreateMessageData{
 		Components: components,
 		Flags:      uint(message.FlagComponentsV2),
 	}
 
-	_, err = rest.CreateMessage(ctx, config.Conf.Discord.Token, c.rateLimiter, dmChannel.Id, data)
+	if report := c.guildReport(locale, result, request.GuildNames); report != nil {
+		data.Components = append(data.Components, reportComponent())
+		data.Attachments = reportAttachments(report)
+	}
+
+	_, err = rest.CreateMessage(ctx, token, rateLimiter, dmChannel.Id, data)
 	if err != nil {
 		c.logger.Error("Failed to send DM message",
 			zap.Error(err),
@@ -231,5 +735,104 @@ func (c *Callback) sendCompletionViaDM(ctx context.Context, request gdprrelay.GD
 		return fmt.Errorf("failed to send DM message: %w", err)
 	}
 
+	// Servers that didn't fit follow in messages of their own. The results were delivered, so a
+	// failure here isn't returned, which would have them sent again.
+	for _, page := range guildPages(omitted, maxResultLength) {
+		if _, err := rest.CreateMessage(ctx, token, rateLimiter, dmChannel.Id, rest.CreateMessageData{
+			Components: []component.Component{
+				component.BuildTextDisplay(component.TextDisplay{
+					Content: page,
+				}),
+			},
+			Flags: uint(message.FlagComponentsV2),
+		}); err != nil {
+			c.logger.Error("Failed to send remaining servers via DM",
+				zap.Error(err),
+				zap.String("scrambled_user_id", scrambledUserId),
+				zap.Uint64("channel_id", dmChannel.Id),
+			)
+			break
+		}
+	}
+
+	return nil
+}
+
+// canDM reports whether results may be sent to the user by DM, which deployments can turn off and
+// producers can rule out for a request
+func canDM(request gdprrelay.GDPRRequest) bool {
+	return config.Conf.Callback.DmFallback && request.NotifyVia != gdpr.NotifyViaInteraction
+}
+
+// sendCompletionOutOfBand delivers results once the interaction can no longer be used: by DM, or if
+// the user's DMs are closed, by email to the address they verified
+func (c *Callback) sendCompletionOutOfBand(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+	if request.NotifyVia == gdpr.NotifyViaInteraction {
+		c.logger.Debug("Interaction can no longer be used and the user is only notified through it, skipping callback")
+		return nil
+	}
+
+	// Nothing is returned when there's no way left to reach the user, as retrying wouldn't help
+	if !canDM(request) {
+		if c.mailer == nil || request.Email == "" {
+			c.logger.Debug("DMs are disabled and there is no email address to send results to, skipping callback")
+			return nil
+		}
+
+		return c.sendCompletionViaEmail(ctx, request, locale, result)
+	}
+
+	dmErr := c.sendCompletionViaDM(ctx, request, locale, result)
+	if dmErr == nil || c.mailer == nil || request.Email == "" {
+		return dmErr
+	}
+
+	c.logger.Warn("Failed to send completion via DM, falling back to email",
+		zap.Error(dmErr),
+		zap.String("scrambled_user_id", utils.ScrambleUserId(request.UserId)),
+	)
+
+	return c.sendCompletionViaEmail(ctx, request, locale, result)
+}
+
+func (c *Callback) sendCompletionViaEmail(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) (err error) {
+	defer func() { c.deliveries.record(ChannelEmail, err) }()
+
+	subject := c.brand.title(resultTitle(locale, result))
+
+	content, _ := c.buildResultMessage(locale, result, request.GuildNames, 0)
+	body := plainText(content) + "\n\n" + i18n.GetMessage(locale, i18n.GdprEmailFooter)
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	defer cancel()
+
+	if err := c.mailer.Send(sendCtx, email.Message{
+		To:      request.Email,
+		Subject: subject,
+		Body:    body,
+	}); err != nil {
+		c.logger.Error("Failed to send completion via email",
+			zap.Error(err),
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.UserId)),
+		)
+		return fmt.Errorf("failed to send completion via email: %w", err)
+	}
+
 	return nil
 }
+
+// discordTimestamp matches Discord's timestamp markup, e.g. <t:1700000000:R>
+var discordTimestamp = regexp.MustCompile(`<t:(-?\d+)(?::[tTdDfFR])?>`)
+
+// plainText replaces the Discord markup in a message that email clients can't render, writing
+// timestamps out in UTC
+func plainText(content string) string {
+	return discordTimestamp.ReplaceAllStringFunc(content, func(match string) string {
+		unix, err := strconv.ParseInt(discordTimestamp.FindStringSubmatch(match)[1], 10, 64)
+		if err != nil {
+			return match
+		}
+
+		return time.Unix(unix, 0).UTC().Format("2 January 2006 15:04 MST")
+	})
+}
@@ -0,0 +1,102 @@
+package callback
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// Channels a result can be delivered through
+const (
+	ChannelInteractionEdit = "interaction_edit"
+	ChannelFollowup        = "followup"
+	ChannelDM              = "dm"
+	ChannelEmail           = "email"
+)
+
+// deliveryLog collects the outcome of each delivery attempt made for a request, so support can
+// later tell whether the user was ever notified
+type deliveryLog struct {
+	requestId  int
+	resultCode gdpr.ResultCode
+
+	mu            sync.Mutex
+	notifications []database.Notification
+}
+
+func newDeliveryLog(requestId int, resultCode gdpr.ResultCode) *deliveryLog {
+	return &deliveryLog{
+		requestId:  requestId,
+		resultCode: resultCode,
+	}
+}
+
+// record notes the outcome of an attempt through channel. Safe to call on a nil log.
+func (d *deliveryLog) record(channel string, err error) {
+	if d == nil {
+		return
+	}
+
+	notification := database.Notification{
+		RequestId:   d.requestId,
+		Channel:     channel,
+		ResultCode:  string(d.resultCode),
+		Success:     err == nil,
+		AttemptedAt: time.Now(),
+	}
+
+	if err != nil {
+		notification.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.notifications = append(d.notifications, notification)
+}
+
+// delivered returns the channels an attempt has succeeded through so far. Safe to call on a nil log.
+func (d *deliveryLog) delivered() map[string]bool {
+	channels := make(map[string]bool)
+	if d == nil {
+		return channels
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, notification := range d.notifications {
+		if notification.Success {
+			channels[notification.Channel] = true
+		}
+	}
+
+	return channels
+}
+
+// persist stores the recorded attempts. Safe to call on a nil log.
+func (d *deliveryLog) persist(ctx context.Context, logger *zap.Logger) {
+	if d == nil || database.Notifications == nil {
+		return
+	}
+
+	d.mu.Lock()
+	notifications := d.notifications
+	d.notifications = nil
+	d.mu.Unlock()
+
+	if len(notifications) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	if err := database.Notifications.Insert(ctx, notifications); err != nil {
+		logger.Error("Failed to record notification delivery outcomes", zap.Error(err))
+	}
+}
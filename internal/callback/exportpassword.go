@@ -0,0 +1,136 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
+	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
+	"github.com/TicketsBot-cloud/gdl/rest"
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/email"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// sendExportPassword sends the password of a data export in a message of its own. Channels the
+// download link wasn't delivered through are tried first, so one message alone doesn't open the
+// export, before falling back to a separate message through the same channel.
+func (c *Callback) sendExportPassword(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+	type passwordChannel struct {
+		name   string
+		usable bool
+		send   func() error
+	}
+
+	channels := []passwordChannel{
+		{
+			name:   ChannelDM,
+			usable: request.UserId != 0 && canDM(request),
+			send:   func() error { return c.sendPasswordViaDM(ctx, request, locale, result) },
+		},
+		{
+			name:   ChannelFollowup,
+			usable: request.InteractionToken != "" && request.NotifyVia != gdpr.NotifyViaDM,
+			send:   func() error { return c.sendPasswordViaFollowup(ctx, request, locale, result) },
+		},
+		{
+			name:   ChannelEmail,
+			usable: c.mailer != nil && request.Email != "" && request.NotifyVia != gdpr.NotifyViaInteraction,
+			send:   func() error { return c.sendPasswordViaEmail(ctx, request, locale, result) },
+		},
+	}
+
+	linkChannels := c.deliveries.delivered()
+	if linkChannels[ChannelInteractionEdit] {
+		// Follow-ups appear beneath the edited response
+		linkChannels[ChannelFollowup] = true
+	}
+
+	var errs []error
+	for _, separate := range []bool{true, false} {
+		for _, channel := range channels {
+			if !channel.usable || linkChannels[channel.name] != separate {
+				continue
+			}
+
+			err := channel.send()
+			if err == nil {
+				return nil
+			}
+
+			errs = append(errs, fmt.Errorf("%s: %w", channel.name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		c.logger.Debug("No way to reach the user with the data export password")
+		return nil
+	}
+
+	c.logger.Error("Failed to send data export password",
+		zap.Error(errors.Join(errs...)),
+		zap.String("scrambled_user_id", utils.ScrambleUserId(request.UserId)),
+	)
+	return fmt.Errorf("failed to send data export password: %w", errors.Join(errs...))
+}
+
+func (c *Callback) exportPasswordContent(locale *i18n.Locale, result ResultData) string {
+	return i18n.GetMessage(locale, i18n.GdprExportPassword,
+		i18n.Named("password", result.ExportPassword),
+		i18n.Named("expires", result.ExportExpiresAt.Unix()),
+	)
+}
+
+func (c *Callback) sendPasswordViaDM(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) (err error) {
+	defer func() { c.deliveries.record(ChannelDM, err) }()
+
+	token, rateLimiter, err := c.botToken(ctx, request.ApplicationId)
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		return fmt.Errorf("discord token not configured")
+	}
+
+	dmChannel, err := rest.CreateDM(ctx, token, rateLimiter, request.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to create DM channel: %w", err)
+	}
+
+	_, err = rest.CreateMessage(ctx, token, rateLimiter, dmChannel.Id, rest.CreateMessageData{
+		Components: []component.Component{
+			c.brand.container(utils.Green, i18n.GetMessage(locale, i18n.GdprExportPasswordTitle), []component.Component{
+				component.BuildTextDisplay(component.TextDisplay{
+					Content: c.exportPasswordContent(locale, result),
+				}),
+			}),
+		},
+		Flags: uint(message.FlagComponentsV2),
+	})
+	return err
+}
+
+func (c *Callback) sendPasswordViaFollowup(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) error {
+	return c.createFollowup(ctx, request, rest.WebhookBody{
+		Content: c.exportPasswordContent(locale, result),
+		Flags:   uint(message.FlagEphemeral),
+	})
+}
+
+func (c *Callback) sendPasswordViaEmail(ctx context.Context, request gdprrelay.GDPRRequest, locale *i18n.Locale, result ResultData) (err error) {
+	defer func() { c.deliveries.record(ChannelEmail, err) }()
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	defer cancel()
+
+	return c.mailer.Send(sendCtx, email.Message{
+		To:      request.Email,
+		Subject: c.brand.title(i18n.GetMessage(locale, i18n.GdprExportPasswordTitle)),
+		Body:    plainText(c.exportPasswordContent(locale, result)) + "\n\n" + i18n.GetMessage(locale, i18n.GdprEmailFooter),
+	})
+}
@@ -0,0 +1,103 @@
+package callback
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
+	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
+	"github.com/TicketsBot-cloud/gdl/rest"
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"go.uber.org/zap"
+)
+
+// GuildNotifier tells a server's staff when transcripts or messages in it were erased, by posting a
+// notice in the log channel configured in the bot, so they aren't surprised by missing transcripts.
+// Notices never identify the user who made the request.
+type GuildNotifier struct {
+	token       string
+	rateLimiter *ratelimit.Ratelimiter
+	logger      *zap.Logger
+}
+
+func NewGuildNotifier(token string, logger *zap.Logger) *GuildNotifier {
+	return &GuildNotifier{
+		token:       token,
+		rateLimiter: ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0),
+		logger:      logger,
+	}
+}
+
+// Notify posts a notice in the log channel of each server the request erased anything in. Servers
+// without a log channel are skipped, and failures are logged rather than returned, as the request
+// itself has completed.
+func (n *GuildNotifier) Notify(ctx context.Context, queued gdprrelay.QueuedRequest, guildResults map[uint64]processor.GuildResult) {
+	for _, guildId := range queued.Request.GuildIds {
+		guildResult, ok := guildResults[guildId]
+		if !ok || guildResult.Rejected || (guildResult.TranscriptsDeleted == 0 && guildResult.MessagesDeleted == 0) {
+			continue
+		}
+
+		if err := n.notifyGuild(ctx, guildId, guildResult); err != nil && ctx.Err() == nil {
+			n.logger.Error("Failed to post GDPR notice to guild log channel",
+				zap.Int("request_id", queued.RequestID),
+				zap.Uint64("guild_id", guildId),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (n *GuildNotifier) notifyGuild(ctx context.Context, guildId uint64, guildResult processor.GuildResult) error {
+	channelId, err := database.Client.ArchiveChannel.Get(ctx, guildId)
+	if err != nil {
+		return fmt.Errorf("failed to get log channel: %w", err)
+	}
+
+	if channelId == nil {
+		return nil
+	}
+
+	language, err := database.Client.ActiveLanguage.Get(ctx, guildId)
+	if err != nil {
+		return fmt.Errorf("failed to get guild language: %w", err)
+	}
+
+	locale := i18n.ResolveLocale(language)
+
+	var lines []string
+	if guildResult.TranscriptsDeleted > 0 {
+		lines = append(lines, i18n.GetMessage(locale, i18n.GdprGuildNoticeTranscripts, i18n.Named("count", i18n.Number(locale, guildResult.TranscriptsDeleted))))
+	}
+	if guildResult.MessagesDeleted > 0 {
+		lines = append(lines, i18n.GetMessage(locale, i18n.GdprGuildNoticeMessages, i18n.Named("count", i18n.Number(locale, guildResult.MessagesDeleted))))
+	}
+	lines = append(lines, i18n.GetMessage(locale, i18n.GdprGuildNoticeFooter))
+
+	container := utils.BuildContainerWithAccent(utils.Orange.ToRGB(), i18n.GetMessage(locale, i18n.GdprGuildNoticeTitle), []component.Component{
+		component.BuildTextDisplay(component.TextDisplay{
+			Content: strings.Join(lines, "\n\n"),
+		}),
+	})
+
+	if _, err := rest.CreateMessage(ctx, n.token, n.rateLimiter, *channelId, rest.CreateMessageData{
+		Components:      []component.Component{container},
+		Flags:           uint(message.FlagComponentsV2),
+		AllowedMentions: message.AllowedMention{},
+	}); err != nil {
+		return fmt.Errorf("failed to send notice: %w", err)
+	}
+
+	n.logger.Debug("Posted GDPR notice to guild log channel",
+		zap.Uint64("guild_id", guildId),
+		zap.Uint64("channel_id", *channelId),
+	)
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package callback
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
+	"github.com/TicketsBot-cloud/gdl/rest/request"
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+)
+
+// guildReportFileName is the name the per-server results file is attached under
+const guildReportFileName = "gdpr-results.csv"
+
+var guildReportHeader = []string{
+	"guild_id",
+	"guild_name",
+	"status",
+	"transcripts_deleted",
+	"messages_redacted",
+	"attachments_deleted",
+	"skipped",
+	"no_transcript",
+	"withheld",
+	"failed",
+	"already_deleted",
+	"error",
+}
+
+// guildReport writes the per-server results file attached to the completion of a request covering
+// several servers, as the message only has room for a line per server. Returns nil for requests
+// covering a single server, and for those without per-server results.
+func (c *Callback) guildReport(locale *i18n.Locale, result ResultData, guildNames map[uint64]string) []byte {
+	if len(result.GuildIds) < 2 || len(result.GuildResults) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write(guildReportHeader); err != nil {
+		return nil
+	}
+
+	for _, guildId := range result.GuildIds {
+		guildResult, ok := result.GuildResults[guildId]
+
+		var errorMessage string
+		if ok && guildResult.Error != nil {
+			errorMessage = sanitizeError(guildResult.Error.Error())
+			if errorMessage == "" {
+				errorMessage = i18n.GetMessage(locale, i18n.GdprErrorInternal)
+			}
+		}
+
+		if err := writer.Write([]string{
+			strconv.FormatUint(guildId, 10),
+			spreadsheetSafe(guildNames[guildId]),
+			guildStatus(guildResult, ok),
+			strconv.Itoa(guildResult.TranscriptsDeleted),
+			strconv.Itoa(guildResult.MessagesDeleted),
+			strconv.Itoa(guildResult.AttachmentsDeleted),
+			strconv.Itoa(guildResult.Skipped),
+			strconv.Itoa(guildResult.NoTranscript),
+			strconv.Itoa(guildResult.Withheld),
+			strconv.Itoa(guildResult.Failed),
+			strconv.Itoa(len(guildResult.AlreadyDeleted)),
+			spreadsheetSafe(errorMessage),
+		}); err != nil {
+			return nil
+		}
+	}
+
+	writer.Flush()
+	if writer.Error() != nil {
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+// guildStatus summarises the outcome within a server for the status column
+func guildStatus(guildResult processor.GuildResult, ok bool) string {
+	switch {
+	case !ok:
+		return "unknown"
+	case guildResult.Rejected:
+		return "rejected"
+	case guildResult.Error != nil && guildResult.Failed == 0:
+		return "failed"
+	case guildResult.Failed > 0 || guildResult.Withheld > 0 || len(guildResult.AlreadyDeleted) > 0:
+		return "partial"
+	default:
+		return "completed"
+	}
+}
+
+// spreadsheetSafe keeps spreadsheet applications from evaluating a cell as a formula, as server
+// names are chosen by whoever owns the server
+func spreadsheetSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// reportAttachments attaches a results file. New attachments are needed for each message sent, as
+// sending one consumes its reader.
+func reportAttachments(report []byte) []request.Attachment {
+	return []request.Attachment{{
+		FileName: guildReportFileName,
+		File: request.File{
+			ContentType: "text/csv",
+			Reader:      bytes.NewReader(report),
+		},
+	}}
+}
+
+// reportComponent shows the attached results file in messages using components
+func reportComponent() component.Component {
+	return component.BuildFile(component.File{
+		File: component.UnfurledMediaItem{
+			Url: "attachment://" + guildReportFileName,
+		},
+	})
+}
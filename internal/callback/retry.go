@@ -0,0 +1,256 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+const (
+	keyRetry  = "tickets:gdpr:callbacks:retry"  // Redis sorted set of undelivered callbacks, scored by when to next attempt them
+	keyFailed = "tickets:gdpr:callbacks:failed" // Redis list of callbacks that could not be delivered within the attempt limit
+
+	retryPollInterval = time.Second
+	retryBatch        = 10
+	retrySendTimeout  = 30 * time.Second
+)
+
+// RetryPolicy controls how often undelivered callbacks are retried
+type RetryPolicy struct {
+	MaxAttempts int           // Total delivery attempts, including the first
+	BaseDelay   time.Duration // Delay before the first retry, doubled after each failed attempt
+	MaxDelay    time.Duration // Upper bound on the delay between attempts
+}
+
+// RetryQueue delivers completion callbacks, queueing those that fail for another attempt with
+// exponential backoff. Losing a notification hides from the user whether their data was erased, so
+// callbacks are only given up on once the attempt limit is reached.
+type RetryQueue struct {
+	redisClient redis.UniversalClient
+	callback    *Callback
+	policy      RetryPolicy
+	logger      *zap.Logger
+	clock       clock.Clock
+}
+
+func NewRetryQueue(redisClient redis.UniversalClient, callback *Callback, policy RetryPolicy, logger *zap.Logger) *RetryQueue {
+	return &RetryQueue{
+		redisClient: redisClient,
+		callback:    callback,
+		policy:      policy,
+		logger:      logger,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock retries are scheduled with. Must be called before Run.
+func (q *RetryQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// pendingCallback is a queued delivery. Errors don't survive a JSON round trip, so they are
+// carried as messages and restored before the callback is retried.
+type pendingCallback struct {
+	Request      gdprrelay.QueuedRequest `json:"request"`
+	Result       ResultData              `json:"result"`
+	Error        string                  `json:"error,omitempty"`
+	GuildErrors  map[uint64]string       `json:"guild_errors,omitempty"`
+	Attempts     int                     `json:"attempts"`
+	LastError    string                  `json:"last_error"`
+	FirstFailure time.Time               `json:"first_failure"`
+}
+
+// SendCompletion attempts delivery once, queueing the callback for retry if it fails. The error of
+// the first attempt is still returned, so callers can record that delivery was delayed.
+func (q *RetryQueue) SendCompletion(ctx context.Context, queued gdprrelay.QueuedRequest, result ResultData) error {
+	err := q.callback.SendCompletion(ctx, queued, result)
+	if err == nil || q.policy.MaxAttempts <= 1 {
+		return err
+	}
+
+	pending := newPendingCallback(queued, result)
+	pending.Attempts = 1
+	pending.LastError = err.Error()
+	pending.FirstFailure = q.clock.Now()
+
+	if enqueueErr := q.schedule(context.WithoutCancel(ctx), pending); enqueueErr != nil {
+		q.logger.Error("Failed to queue callback for retry",
+			zap.Int("request_id", queued.RequestID),
+			zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
+			zap.Error(enqueueErr),
+		)
+	}
+
+	return err
+}
+
+// Run retries queued callbacks as they become due, until ctx is cancelled
+func (q *RetryQueue) Run(ctx context.Context) {
+	ticker := q.clock.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := q.retryDue(ctx); err != nil && ctx.Err() == nil {
+			q.logger.Error("Failed to retry queued callbacks", zap.Error(err))
+		}
+	}
+}
+
+func (q *RetryQueue) retryDue(ctx context.Context) error {
+	members, err := q.redisClient.ZRangeByScore(ctx, keyRetry, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(q.clock.Now().UnixMilli(), 10),
+		Count: retryBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		// Several workers may poll the same set, whoever removes the entry owns the attempt
+		removed, err := q.redisClient.ZRem(ctx, keyRetry, member).Result()
+		if err != nil {
+			return err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		var pending pendingCallback
+		if err := json.Unmarshal([]byte(member), &pending); err != nil {
+			q.logger.Error("Failed to unmarshal queued callback", zap.Error(err))
+			continue
+		}
+
+		q.attempt(ctx, pending)
+	}
+
+	return nil
+}
+
+func (q *RetryQueue) attempt(ctx context.Context, pending pendingCallback) {
+	logger := q.logger.With(
+		zap.Int("request_id", pending.Request.RequestID),
+		zap.String("scrambled_user_id", utils.ScrambleUserId(pending.Request.Request.UserId)),
+		zap.Int("attempt", pending.Attempts+1),
+	)
+
+	sendCtx, cancel := context.WithTimeout(ctx, retrySendTimeout)
+	sendCtx, span := tracing.Start(tracing.WithRequestId(sendCtx, pending.Request.RequestID), "gdpr.callback.retry",
+		attribute.Int("gdpr.attempt", pending.Attempts+1),
+	)
+	err := q.callback.SendCompletion(sendCtx, pending.Request, pending.result())
+	tracing.End(span, err)
+	cancel()
+
+	if err == nil {
+		logger.Info("Delivered queued callback", zap.Duration("delay", q.clock.Since(pending.FirstFailure)))
+		return
+	}
+
+	pending.Attempts++
+	pending.LastError = err.Error()
+
+	if pending.Attempts >= q.policy.MaxAttempts {
+		logger.Error("Giving up on callback after reaching attempt limit", zap.Error(err))
+
+		if marshalled, marshalErr := json.Marshal(pending); marshalErr == nil {
+			if pushErr := q.redisClient.LPush(ctx, keyFailed, string(marshalled)).Err(); pushErr != nil {
+				logger.Error("Failed to move callback to failed list", zap.Error(pushErr))
+			}
+		}
+		return
+	}
+
+	logger.Warn("Queued callback failed, retrying later", zap.Error(err))
+	if err := q.schedule(ctx, pending); err != nil {
+		logger.Error("Failed to requeue callback", zap.Error(err))
+	}
+}
+
+// schedule queues the callback for its next attempt, backing off exponentially with each failure
+func (q *RetryQueue) schedule(ctx context.Context, pending pendingCallback) error {
+	marshalled, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("failed to marshal callback: %w", err)
+	}
+
+	dueAt := q.clock.Now().Add(q.policy.backoff(pending.Attempts))
+
+	return q.redisClient.ZAdd(ctx, keyRetry, redis.Z{
+		Score:  float64(dueAt.UnixMilli()),
+		Member: string(marshalled),
+	}).Err()
+}
+
+// backoff returns how long to wait before the next attempt, once attempts have failed
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+func newPendingCallback(queued gdprrelay.QueuedRequest, result ResultData) pendingCallback {
+	pending := pendingCallback{
+		Request: queued,
+		Result:  result,
+	}
+
+	if result.Error != nil {
+		pending.Error = result.Error.Error()
+	}
+
+	pending.Result.Error = nil
+	pending.Result.GuildResults = make(map[uint64]processor.GuildResult, len(result.GuildResults))
+	for guildId, guildResult := range result.GuildResults {
+		if guildResult.Error != nil {
+			if pending.GuildErrors == nil {
+				pending.GuildErrors = make(map[uint64]string)
+			}
+			pending.GuildErrors[guildId] = guildResult.Error.Error()
+		}
+
+		guildResult.Error = nil
+		pending.Result.GuildResults[guildId] = guildResult
+	}
+
+	return pending
+}
+
+// result restores the result data the callback was queued with
+func (p pendingCallback) result() ResultData {
+	result := p.Result
+	if p.Error != "" {
+		result.Error = errors.New(p.Error)
+	}
+
+	for guildId, message := range p.GuildErrors {
+		guildResult := result.GuildResults[guildId]
+		guildResult.Error = errors.New(message)
+		result.GuildResults[guildId] = guildResult
+	}
+
+	return result
+}
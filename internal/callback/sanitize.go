@@ -0,0 +1,96 @@
+package callback
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+)
+
+// maxErrorRunes is the longest error shown to a user, counted in characters rather than bytes so
+// messages in any script are cut at the same length
+const maxErrorRunes = 250
+
+// redactedMarker replaces hostnames and addresses in errors shown to users
+const redactedMarker = "***"
+
+var (
+	// Everything from the first SQL fragment or stack frame on is dropped
+	internalDetailPattern = regexp.MustCompile(`(?is)\b(?:SELECT\s.+?\sFROM|INSERT\s+INTO|UPDATE\s+\S+\s+SET|DELETE\s+FROM|SQLSTATE|ERROR:\s|pq:|goroutine\s+\d+)|\S+\.go:\d+`)
+
+	// Hosts and addresses are replaced in place
+	urlPattern          = regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+	ipPattern           = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b|\[[0-9a-fA-F:]*:[0-9a-fA-F:]*\](?::\d+)?`)
+	hostPortPattern     = regexp.MustCompile(`\b[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*:\d{2,5}\b`)
+	internalHostPattern = regexp.MustCompile(`(?i)\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:internal|local|localdomain|svc|cluster|lan|corp)\b`)
+)
+
+// userFacingError renders an error for the user, stripping hostnames, SQL and stack traces and
+// truncating it. If nothing meaningful is left a generic message is shown instead. The request ID
+// is given as a reference, the full error is kept in the job history under it.
+func (c *Callback) userFacingError(locale *i18n.Locale, err error) string {
+	message := sanitizeError(err.Error())
+	if message == "" {
+		message = i18n.GetMessage(locale, i18n.GdprErrorInternal)
+	}
+
+	if c.requestId != 0 {
+		message += " " + i18n.GetMessage(locale, i18n.GdprErrorReference, i18n.Named("request_id", c.requestId))
+	}
+
+	return message
+}
+
+// sanitizeError removes internal details from an error message and truncates it to maxErrorRunes
+func sanitizeError(message string) string {
+	// Multi-line errors are almost always stack traces or query dumps past the first line
+	message, _, _ = strings.Cut(message, "\n")
+
+	if loc := internalDetailPattern.FindStringIndex(message); loc != nil {
+		message = message[:loc[0]]
+	}
+
+	message = urlPattern.ReplaceAllString(message, redactedMarker)
+	message = ipPattern.ReplaceAllString(message, redactedMarker)
+	message = hostPortPattern.ReplaceAllString(message, redactedMarker)
+	message = internalHostPattern.ReplaceAllString(message, redactedMarker)
+
+	message = strings.TrimRightFunc(message, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ':' || r == ','
+	})
+
+	// A message that was all internal detail carries nothing for the user
+	if strings.Trim(message, redactedMarker+" :,") == "" {
+		return ""
+	}
+
+	return truncateRunes(message, maxErrorRunes)
+}
+
+// truncateRunes cuts text to at most limit characters, ending in an ellipsis. Text written with
+// spaces is cut at the last word boundary near the limit, scripts written without them, such as
+// Chinese or Japanese, at the limit itself.
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	runes := []rune(text)[:limit-1]
+	if i := lastSpace(runes); i >= limit*4/5 {
+		runes = runes[:i]
+	}
+
+	return strings.TrimRightFunc(string(runes), unicode.IsSpace) + "…"
+}
+
+func lastSpace(runes []rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,88 @@
+package callback
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+)
+
+// guildListSeparator separates the servers of a per-server breakdown, which is shown as a list
+const guildListSeparator = "\n* "
+
+// fitGuildList joins as many servers of a breakdown as fit within budget characters, ending with a
+// count of those left out. Returns the servers left out.
+func fitGuildList(locale *i18n.Locale, lines []string, budget int) (string, []string) {
+	list := strings.Join(lines, guildListSeparator)
+	if utf8.RuneCountInString(list) <= budget {
+		return list, nil
+	}
+
+	var b strings.Builder
+	length := 0
+	for i, line := range lines {
+		entry := line
+		if i > 0 {
+			entry = guildListSeparator + line
+		}
+
+		// Room is left for the count of the servers that would follow
+		reserve := 0
+		if i < len(lines)-1 {
+			reserve = utf8.RuneCountInString(guildListSeparator + i18n.GetMessage(locale, i18n.GdprCompletedGuildsMore, i18n.Named("count", i18n.Number(locale, len(lines)-i-1))))
+		}
+
+		entryLength := utf8.RuneCountInString(entry)
+		if length+entryLength+reserve > budget {
+			more := i18n.GetMessage(locale, i18n.GdprCompletedGuildsMore, i18n.Named("count", i18n.Number(locale, len(lines)-i)))
+			if i > 0 {
+				more = guildListSeparator + more
+			}
+
+			b.WriteString(more)
+			return b.String(), lines[i:]
+		}
+
+		b.WriteString(entry)
+		length += entryLength
+	}
+
+	return b.String(), nil
+}
+
+// guildPages splits the servers left out of a breakdown into lists of at most maxLength
+// characters, to be sent as messages of their own
+func guildPages(lines []string, maxLength int) []string {
+	var pages []string
+	var page strings.Builder
+
+	for _, line := range lines {
+		entry := "* " + line
+		if page.Len() > 0 {
+			entry = "\n" + entry
+		}
+
+		if page.Len() > 0 && utf8.RuneCountInString(page.String())+utf8.RuneCountInString(entry) > maxLength {
+			pages = append(pages, page.String())
+			page.Reset()
+			entry = "* " + line
+		}
+
+		page.WriteString(limitText(entry, maxLength))
+	}
+
+	if page.Len() > 0 {
+		pages = append(pages, page.String())
+	}
+
+	return pages
+}
+
+// limitText cuts text down to maxLength characters, ending it with an ellipsis if anything was cut
+func limitText(text string, maxLength int) string {
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	return string([]rune(text)[:maxLength-1]) + "…"
+}
@@ -0,0 +1,165 @@
+package callback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/webhook"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookPollInterval = time.Second
+	webhookBatch        = 10
+	webhookSendTimeout  = 30 * time.Second
+	webhookLease        = 2 * webhookSendTimeout // How long a claimed delivery is hidden from other workers
+	webhookErrorBody    = 512                    // Bytes of a rejected delivery's response kept in its error
+)
+
+// WebhookDispatcher sends the outcome of requests to an external compliance system. Deliveries are
+// written to the outbox table before anything is sent, so they survive restarts, and are retried
+// with exponential backoff until the receiver accepts them or the attempt limit is reached.
+type WebhookDispatcher struct {
+	url        string
+	secret     string
+	policy     RetryPolicy
+	outbox     *database.WebhookOutboxTable
+	httpClient *http.Client
+	logger     *zap.Logger
+	clock      clock.Clock
+}
+
+func NewWebhookDispatcher(url, secret string, policy RetryPolicy, outbox *database.WebhookOutboxTable, logger *zap.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		url:        url,
+		secret:     secret,
+		policy:     policy,
+		outbox:     outbox,
+		httpClient: &http.Client{},
+		logger:     logger,
+		clock:      clock.Real,
+	}
+}
+
+// SetClock replaces the clock deliveries are scheduled with. Must be called before Run.
+func (d *WebhookDispatcher) SetClock(clk clock.Clock) {
+	d.clock = clk
+}
+
+// Enqueue writes a delivery to the outbox, to be sent on the next poll
+func (d *WebhookDispatcher) Enqueue(ctx context.Context, payload webhook.Payload) error {
+	marshalled, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	if err := d.outbox.Add(ctx, payload.RequestId, marshalled, d.clock.Now()); err != nil {
+		return fmt.Errorf("failed to add webhook delivery to outbox: %w", err)
+	}
+
+	return nil
+}
+
+// Run sends deliveries as they become due, until ctx is cancelled
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := d.clock.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := d.sendDue(ctx); err != nil && ctx.Err() == nil {
+			d.logger.Error("Failed to send webhook deliveries", zap.Error(err))
+		}
+	}
+}
+
+func (d *WebhookDispatcher) sendDue(ctx context.Context) error {
+	now := d.clock.Now()
+
+	entries, err := d.outbox.ClaimDue(ctx, now, now.Add(webhookLease), webhookBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		d.attempt(ctx, entry)
+	}
+
+	return nil
+}
+
+func (d *WebhookDispatcher) attempt(ctx context.Context, entry database.OutboxEntry) {
+	attempts := entry.Attempts + 1
+	logger := d.logger.With(
+		zap.Int("request_id", entry.RequestId),
+		zap.Int64("delivery_id", entry.Id),
+		zap.Int("attempt", attempts),
+	)
+
+	sendCtx, cancel := context.WithTimeout(ctx, webhookSendTimeout)
+	err := d.send(sendCtx, entry)
+	cancel()
+
+	if err == nil {
+		if err := d.outbox.MarkDelivered(ctx, entry.Id, attempts, d.clock.Now()); err != nil {
+			logger.Error("Failed to mark webhook delivery as delivered", zap.Error(err))
+		}
+
+		logger.Debug("Delivered webhook", zap.Duration("delay", d.clock.Since(entry.CreatedAt)))
+		return
+	}
+
+	if attempts >= d.policy.MaxAttempts {
+		logger.Error("Giving up on webhook delivery after reaching attempt limit", zap.Error(err))
+
+		if err := d.outbox.MarkFailed(ctx, entry.Id, attempts, err.Error(), d.clock.Now()); err != nil {
+			logger.Error("Failed to mark webhook delivery as failed", zap.Error(err))
+		}
+		return
+	}
+
+	logger.Warn("Webhook delivery failed, retrying later", zap.Error(err))
+	if err := d.outbox.Reschedule(ctx, entry.Id, attempts, err.Error(), d.clock.Now().Add(d.policy.backoff(attempts))); err != nil {
+		logger.Error("Failed to reschedule webhook delivery", zap.Error(err))
+	}
+}
+
+// send makes a single signed delivery, failing unless the receiver responds with a 2xx status
+func (d *WebhookDispatcher) send(ctx context.Context, entry database.OutboxEntry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.HeaderDelivery, strconv.FormatInt(entry.Id, 10))
+	if err := webhook.SignRequest(req, d.secret, entry.Payload); err != nil {
+		return fmt.Errorf("failed to sign webhook request: %w", err)
+	}
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, webhookErrorBody))
+		return fmt.Errorf("webhook receiver responded with %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package callback
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+)
+
+// botLimiters holds a rate limiter for each whitelabel bot messages were sent as, as Discord rate
+// limits each bot separately
+type botLimiters struct {
+	mu       sync.Mutex
+	limiters map[uint64]*ratelimit.Ratelimiter
+}
+
+func newBotLimiters() *botLimiters {
+	return &botLimiters{
+		limiters: make(map[uint64]*ratelimit.Ratelimiter),
+	}
+}
+
+func (l *botLimiters) get(botId uint64) *ratelimit.Ratelimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[botId]
+	if !ok {
+		limiter = ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0)
+		l.limiters[botId] = limiter
+	}
+
+	return limiter
+}
+
+// botToken returns the token of the bot the request was made with, so whitelabel bots reach users
+// as themselves. Requests made with the shared bot, or with a whitelabel bot that has since been
+// removed, use the configured token. Returns an empty token if there is none to use.
+func (c *Callback) botToken(ctx context.Context, applicationId uint64) (string, *ratelimit.Ratelimiter, error) {
+	if applicationId != 0 && database.Client != nil {
+		bot, err := database.Client.Whitelabel.GetByBotId(ctx, applicationId)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get whitelabel bot: %w", err)
+		}
+
+		if bot.Token != "" {
+			return bot.Token, c.botLimiters.get(applicationId), nil
+		}
+	}
+
+	return config.Conf.Discord.Token, c.rateLimiter, nil
+}
@@ -0,0 +1,190 @@
+// Package clock abstracts the passage of time, so behaviour that depends on it, such as retry
+// backoff, heartbeats and scheduled work, can be driven deterministically in tests or shifted in
+// staging.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and schedules wakeups. Real is used unless a component is given another.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C until stopped, see time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Offset returns a clock that runs at the speed of base but reads offset ahead of it, for seeing
+// how TTLs and retry windows play out at a later time in staging
+func Offset(base Clock, offset time.Duration) Clock {
+	return offsetClock{
+		base:   base,
+		offset: offset,
+	}
+}
+
+type offsetClock struct {
+	base   Clock
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time                         { return c.base.Now().Add(c.offset) }
+func (c offsetClock) Since(t time.Time) time.Duration        { return c.Now().Sub(t) }
+func (c offsetClock) After(d time.Duration) <-chan time.Time { return c.base.After(d) }
+func (c offsetClock) NewTicker(d time.Duration) Ticker       { return c.base.NewTicker(d) }
+
+// Fake is a clock that only moves when Advance is called. Timers and tickers fire synchronously
+// from Advance once their time is reached, dropping ticks nobody received like time.Ticker does.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+var _ Clock = (*Fake)(nil)
+
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // Zero for one-shot timers
+	ch     chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now: now,
+	}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+
+	f.waiters = append(f.waiters, &fakeWaiter{
+		at: f.now.Add(d),
+		ch: ch,
+	})
+
+	return ch
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	waiter := &fakeWaiter{
+		at:     f.now.Add(d),
+		period: d,
+		ch:     make(chan time.Time, 1),
+	}
+	f.waiters = append(f.waiters, waiter)
+
+	return &fakeTicker{
+		clock:  f,
+		waiter: waiter,
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker that falls due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	remaining := f.waiters[:0]
+	for _, waiter := range f.waiters {
+		if waiter.at.After(f.now) {
+			remaining = append(remaining, waiter)
+			continue
+		}
+
+		select {
+		case waiter.ch <- f.now:
+		default:
+		}
+
+		if waiter.period > 0 {
+			for !waiter.at.After(f.now) {
+				waiter.at = waiter.at.Add(waiter.period)
+			}
+			remaining = append(remaining, waiter)
+		}
+	}
+
+	f.waiters = remaining
+}
+
+func (f *Fake) remove(target *fakeWaiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, waiter := range f.waiters {
+		if waiter == target {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	waiter *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.remove(t.waiter)
+}
@@ -1,15 +1,37 @@
 package config
 
 import (
+	"time"
+
 	"github.com/caarlos0/env/v10"
 	"go.uber.org/zap/zapcore"
 )
 
 type Config struct {
-	JsonLogs        bool          `env:"JSON_LOGS" envDefault:"false"`
-	LogLevel        zapcore.Level `env:"LOG_LEVEL" envDefault:"info"`
-	MaxConcurrency  int           `env:"MAX_CONCURRENCY" envDefault:"1"`
-	MaxRetries      int           `env:"MAX_RETRIES" envDefault:"3"`
+	JsonLogs            bool          `env:"JSON_LOGS" envDefault:"false"`
+	LogLevel            zapcore.Level `env:"LOG_LEVEL" envDefault:"info"`
+	MaxConcurrency      int           `env:"MAX_CONCURRENCY" envDefault:"1"`
+	MaxRetries          int           `env:"MAX_RETRIES" envDefault:"3"`
+	RetryBaseDelay      time.Duration `env:"RETRY_BASE_DELAY" envDefault:"30s"` // Delay before the first retry, 0 retries immediately
+	RetryMultiplier     float64       `env:"RETRY_MULTIPLIER" envDefault:"2"`
+	RetryMaxDelay       time.Duration `env:"RETRY_MAX_DELAY" envDefault:"30m"`
+	RetryJitter         float64       `env:"RETRY_JITTER" envDefault:"0.2"` // Fraction each delay is randomly shortened or lengthened by
+	CompletedTTL        time.Duration `env:"COMPLETED_TTL" envDefault:"168h"`
+	SummaryStreamMaxLen int64         `env:"SUMMARY_STREAM_MAX_LEN" envDefault:"10000"`
+	ShutdownTimeout     time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	DryRun              bool          `env:"DRY_RUN" envDefault:"false"`
+	VerifyTicketGuild   bool          `env:"VERIFY_TICKET_GUILD" envDefault:"true"`
+	VerificationMode    string        `env:"VERIFICATION_MODE" envDefault:"owner"` // Who may make server-wide requests that don't specify, owner or admin
+	OwnerRecheckAfter   time.Duration `env:"OWNER_RECHECK_AFTER" envDefault:"0"`   // Verify a server again before deleting from it if verified longer ago than this, 0 to disable
+	ProgressEvery       int           `env:"PROGRESS_EVERY" envDefault:"25"`
+	ProgressInterval    time.Duration `env:"PROGRESS_INTERVAL" envDefault:"15s"`
+	CheckpointEvery     int           `env:"CHECKPOINT_EVERY" envDefault:"25"`
+	CheckpointTTL       time.Duration `env:"CHECKPOINT_TTL" envDefault:"72h"`
+	ConflictWait        time.Duration `env:"CONFLICT_WAIT" envDefault:"5m"` // How long message cleaning waits for a deletion of the same server's transcripts, and vice versa, before retrying later
+	ClockOffset         time.Duration `env:"CLOCK_OFFSET" envDefault:"0"`   // Shifts the worker's clock, for simulating later times in staging
+
+	RequestTimeout      time.Duration            `env:"REQUEST_TIMEOUT" envDefault:"1h"` // How long a request may run before it is cancelled and rejected, 0 for no limit
+	RequestTypeTimeouts map[string]time.Duration `env:"REQUEST_TYPE_TIMEOUTS"`           // Per type overrides, e.g. AllTranscripts:4h,AllMessages:30m
 
 	Database struct {
 		Host     string `env:"HOST"`
@@ -20,20 +42,231 @@ type Config struct {
 	} `envPrefix:"DATABASE_"`
 
 	Redis struct {
+		Mode     string `env:"MODE" envDefault:"standalone"` // standalone, sentinel or cluster
 		Address  string `env:"ADDR"`
+		Username string `env:"USERNAME"` // ACL user, the default user if empty
 		Password string `env:"PASSWD"`
-		Threads  int    `env:"THREADS"`
+		Threads  int    `env:"THREADS"` // Connections per node
+
+		SentinelMasterName string   `env:"SENTINEL_MASTER_NAME"`
+		SentinelAddrs      []string `env:"SENTINEL_ADDRS" envSeparator:","`
+		SentinelPassword   string   `env:"SENTINEL_PASSWD"`
+
+		ClusterAddrs []string `env:"CLUSTER_ADDRS" envSeparator:","` // Seed nodes, the rest of the cluster is discovered from them
+
+		TLS           bool   `env:"TLS" envDefault:"false"`
+		TLSCAFile     string `env:"TLS_CA_FILE"`   // Trusts the system roots if empty
+		TLSCertFile   string `env:"TLS_CERT_FILE"` // Client certificate, for servers that require one
+		TLSKeyFile    string `env:"TLS_KEY_FILE"`
+		TLSServerName string `env:"TLS_SERVER_NAME"` // Defaults to the host dialled
+		TLSSkipVerify bool   `env:"TLS_SKIP_VERIFY" envDefault:"false"`
 	} `envPrefix:"REDIS_"`
 
+	Queue struct {
+		Backend            string         `env:"BACKEND" envDefault:"list"` // list, stream, kafka, postgres, or memory for local development and tests
+		MemorySize         int            `env:"MEMORY_SIZE" envDefault:"1024"`
+		StreamGroup        string         `env:"STREAM_GROUP" envDefault:"gdpr-workers"`
+		StreamConsumer     string         `env:"STREAM_CONSUMER"`
+		StreamClaimMinIdle time.Duration  `env:"STREAM_CLAIM_MIN_IDLE" envDefault:"5m"`
+		ListLeaseTTL       time.Duration  `env:"LIST_LEASE_TTL" envDefault:"2m"` // Also the lease on requests claimed from the Postgres queue
+		VisibilityTimeout  time.Duration  `env:"VISIBILITY_TIMEOUT" envDefault:"0"`
+		TypeConcurrency    map[string]int `env:"TYPE_CONCURRENCY"` // Types consumed from a dedicated queue, e.g. AllTranscripts:1,AllMessages:4
+
+		ArchiveTTL    time.Duration `env:"ARCHIVE_TTL" envDefault:"72h"` // How long summaries of acknowledged requests are kept for, 0 to disable
+		ArchiveMaxLen int64         `env:"ARCHIVE_MAX_LEN" envDefault:"10000"`
+	} `envPrefix:"QUEUE_"`
+
+	Kafka struct {
+		Brokers         []string `env:"BROKERS" envSeparator:","`
+		Topic           string   `env:"TOPIC" envDefault:"gdpr-requests"`
+		DeadLetterTopic string   `env:"DEAD_LETTER_TOPIC" envDefault:"gdpr-requests-dead-letter"` // Requests that exhausted their retries or can't be decoded
+		Group           string   `env:"GROUP" envDefault:"gdpr-workers"`
+
+		TLS           bool   `env:"TLS" envDefault:"false"`
+		SASLMechanism string `env:"SASL_MECHANISM"` // plain, scram-sha-256 or scram-sha-512, no authentication if empty
+		SASLUsername  string `env:"SASL_USERNAME"`
+		SASLPassword  string `env:"SASL_PASSWORD"`
+	} `envPrefix:"KAFKA_"`
+
 	Archiver struct {
-		Url    string `env:"URL"`
-		AesKey string `env:"AES_KEY"`
+		Url          string        `env:"URL"`
+		AesKey       string        `env:"AES_KEY"`
+		PurgeTimeout time.Duration `env:"PURGE_TIMEOUT" envDefault:"10m"`
+		BatchDelete  bool          `env:"BATCH_DELETE" envDefault:"true"`
+		BatchSize    int           `env:"BATCH_SIZE" envDefault:"500"`
+
+		StreamThreshold int `env:"STREAM_THRESHOLD" envDefault:"8388608"` // Transcripts of at least this many bytes are redacted while streaming, 0 to disable
 	} `envPrefix:"ARCHIVER_"`
 
+	TranscriptStorage struct {
+		Endpoint  string `env:"ENDPOINT"`
+		AccessKey string `env:"ACCESS_KEY"`
+		SecretKey string `env:"SECRET_KEY"`
+		Bucket    string `env:"BUCKET"`
+		Secure    bool   `env:"SECURE" envDefault:"true"`
+	} `envPrefix:"TRANSCRIPT_S3_"`
+
+	AttachmentStorage struct {
+		Endpoint  string `env:"ENDPOINT"`
+		AccessKey string `env:"ACCESS_KEY"`
+		SecretKey string `env:"SECRET_KEY"`
+		Bucket    string `env:"BUCKET"`
+		Secure    bool   `env:"SECURE" envDefault:"true"`
+		UrlPrefix string `env:"URL_PREFIX"` // Public URL attachments are served under, e.g. https://attachments.example.com
+	} `envPrefix:"ATTACHMENT_S3_"`
+
 	Discord struct {
 		ProxyUrl string `env:"PROXY_URL"`
 		Token    string `env:"TOKEN"`
 	} `envPrefix:"DISCORD_"`
+
+	Callback struct {
+		DmFallback      bool `env:"DM_FALLBACK" envDefault:"true"`      // DM users results that can't be shown through the interaction, or that they asked to be DMed
+		FollowupEnabled bool `env:"FOLLOWUP_ENABLED" envDefault:"true"` // Send an ephemeral follow-up after showing results in the original response
+	} `envPrefix:"CALLBACK_"`
+
+	CallbackRetry struct {
+		Enabled     bool          `env:"ENABLED" envDefault:"true"`
+		MaxAttempts int           `env:"MAX_ATTEMPTS" envDefault:"8"`
+		BaseDelay   time.Duration `env:"BASE_DELAY" envDefault:"30s"`
+		MaxDelay    time.Duration `env:"MAX_DELAY" envDefault:"1h"`
+	} `envPrefix:"CALLBACK_RETRY_"`
+
+	CallbackWebhook struct {
+		Url         string        `env:"URL"` // Receives the signed outcome of every request, disabled if empty
+		Secret      string        `env:"SECRET"`
+		MaxAttempts int           `env:"MAX_ATTEMPTS" envDefault:"12"`
+		BaseDelay   time.Duration `env:"BASE_DELAY" envDefault:"30s"`
+		MaxDelay    time.Duration `env:"MAX_DELAY" envDefault:"1h"`
+	} `envPrefix:"CALLBACK_WEBHOOK_"`
+
+	Branding struct {
+		File string `env:"FILE"` // JSON file styling whitelabel bots' callbacks, see callback.Brand
+	} `envPrefix:"BRANDING_"`
+
+	Email struct {
+		SmtpAddr    string `env:"SMTP_ADDR"` // host:port of the SMTP relay or SES SMTP endpoint, email is disabled if empty
+		Username    string `env:"USERNAME"`
+		Password    string `env:"PASSWORD"`
+		From        string `env:"FROM"`                            // e.g. Tickets <noreply@example.com>
+		ImplicitTLS bool   `env:"IMPLICIT_TLS" envDefault:"false"` // Connect over TLS, as on port 465, rather than with STARTTLS
+	} `envPrefix:"EMAIL_"`
+
+	Export struct {
+		Endpoint   string        `env:"S3_ENDPOINT"`
+		AccessKey  string        `env:"S3_ACCESS_KEY"`
+		SecretKey  string        `env:"S3_SECRET_KEY"`
+		Bucket     string        `env:"S3_BUCKET"`
+		Secure     bool          `env:"S3_SECURE" envDefault:"true"`
+		LinkExpiry time.Duration `env:"LINK_EXPIRY" envDefault:"72h"`
+	} `envPrefix:"EXPORT_"`
+
+	Metrics struct {
+		Addr             string        `env:"ADDR" envDefault:":9090"`
+		SLOWindow        time.Duration `env:"SLO_WINDOW" envDefault:"1h"`
+		SLOPendingMaxAge time.Duration `env:"SLO_PENDING_MAX_AGE" envDefault:"1h"`
+		SLOTarget        float64       `env:"SLO_TARGET" envDefault:"0.99"`
+	} `envPrefix:"METRICS_"`
+
+	Autoscale struct {
+		Enabled     bool          `env:"ENABLED" envDefault:"false"`    // Publish the desired replica count for an external scaler
+		DrainTarget time.Duration `env:"DRAIN_TARGET" envDefault:"15m"` // How soon a backlog should be worked through
+		MinReplicas int           `env:"MIN_REPLICAS" envDefault:"1"`
+		MaxReplicas int           `env:"MAX_REPLICAS" envDefault:"10"`
+	} `envPrefix:"AUTOSCALE_"`
+
+	Tracing struct {
+		Endpoint    string  `env:"ENDPOINT"` // OTLP/HTTP collector URL, e.g. http://tempo:4318, tracing is disabled if empty
+		ServiceName string  `env:"SERVICE_NAME" envDefault:"gdpr-worker"`
+		SampleRatio float64 `env:"SAMPLE_RATIO" envDefault:"1"`
+	} `envPrefix:"TRACING_"`
+
+	Placeholder struct {
+		UserId   uint64 `env:"USER_ID" envDefault:"0"`
+		Username string `env:"USERNAME" envDefault:"Removed for privacy"`
+		Avatar   string `env:"AVATAR"`
+	} `envPrefix:"PLACEHOLDER_"`
+
+	Certificate struct {
+		SigningKey string `env:"SIGNING_KEY"` // Base64 encoded Ed25519 seed, certificates are disabled if empty
+	} `envPrefix:"CERTIFICATE_"`
+
+	Control struct {
+		Secret string `env:"SECRET"`
+	} `envPrefix:"CONTROL_"`
+
+	Admin struct {
+		Addr       string `env:"ADDR"`
+		Token      string `env:"TOKEN"`       // Granted every scope
+		TokensFile string `env:"TOKENS_FILE"` // JSON file of scoped service tokens, see admin.ServiceToken
+
+		StuckAfter time.Duration `env:"STUCK_AFTER" envDefault:"30m"` // Processing requests older than this are counted as stuck in queue stats
+	} `envPrefix:"ADMIN_"`
+
+	OfflineBuffer struct {
+		Dir            string        `env:"DIR"` // Where writes to Redis that fail during an outage are kept until replayed, disabled if empty
+		Key            string        `env:"KEY"` // Base64 encoded 32 byte AES key buffered writes are encrypted with
+		MaxEntries     int           `env:"MAX_ENTRIES" envDefault:"10000"`
+		ReplayInterval time.Duration `env:"REPLAY_INTERVAL" envDefault:"5s"`
+	} `envPrefix:"OFFLINE_BUFFER_"`
+
+	History struct {
+		TTL        time.Duration `env:"TTL" envDefault:"720h"` // How long a user's request history is kept after their last request, 0 to disable
+		MaxEntries int           `env:"MAX_ENTRIES" envDefault:"25"`
+	} `envPrefix:"HISTORY_"`
+
+	State struct {
+		TTL time.Duration `env:"TTL" envDefault:"168h"` // How long the state of a request is kept after its last update, 0 to disable
+	} `envPrefix:"STATE_"`
+
+	GuildPurge struct {
+		Enabled     bool          `env:"ENABLED" envDefault:"false"`     // Erase the transcripts of guilds the bot was kicked from or that were deleted
+		GracePeriod time.Duration `env:"GRACE_PERIOD" envDefault:"720h"` // How long after removal the purge is queued, cancelled if the bot is added back in time
+	} `envPrefix:"GUILD_PURGE_"`
+
+	Quota struct {
+		MaxRequests int           `env:"MAX_REQUESTS" envDefault:"0"` // Requests a user may make per window, unlimited if zero
+		Window      time.Duration `env:"WINDOW" envDefault:"720h"`
+	} `envPrefix:"QUOTA_"`
+
+	SoftDelete struct {
+		Enabled       bool          `env:"ENABLED" envDefault:"false"`     // Keep deleted transcripts for a grace period in which support can reverse the request
+		GracePeriod   time.Duration `env:"GRACE_PERIOD" envDefault:"168h"` // How long deleted transcripts are kept before being permanently deleted
+		SweepInterval time.Duration `env:"SWEEP_INTERVAL" envDefault:"1m"`
+	} `envPrefix:"SOFT_DELETE_"`
+
+	LateSweep struct {
+		Delay time.Duration `env:"DELAY" envDefault:"15m"` // How long after a message erasure its tickets still awaiting a transcript are checked again, 0 to disable
+	} `envPrefix:"LATE_SWEEP_"`
+
+	GuildNotice struct {
+		Enabled bool `env:"ENABLED" envDefault:"false"` // Post a notice in a server's log channel when transcripts or messages in it are erased
+	} `envPrefix:"GUILD_NOTICE_"`
+
+	DeadLetter struct {
+		TTL            time.Duration `env:"TTL" envDefault:"720h"`            // How long failed requests are kept before they are dropped, 0 to keep them until requeued or purged
+		MaxLen         int64         `env:"MAX_LEN" envDefault:"10000"`       // Oldest failed requests beyond this many are dropped, 0 for no limit
+		Interval       time.Duration `env:"INTERVAL" envDefault:"1m"`         // How often the failed queue is swept, 0 to disable sweeping and alerts
+		AlertWebhook   string        `env:"ALERT_WEBHOOK"`                    // Discord webhook URL alerts are posted to
+		AlertChannelId uint64        `env:"ALERT_CHANNEL_ID"`                 // Ops channel alerts are posted to as the bot, if no webhook is set
+		AlertThreshold int64         `env:"ALERT_THRESHOLD" envDefault:"100"` // Length past which the failed queue is alerted on, 0 to disable
+		AlertCooldown  time.Duration `env:"ALERT_COOLDOWN" envDefault:"6h"`
+	} `envPrefix:"DEAD_LETTER_"`
+
+	PlatformErasure struct {
+		Enabled          bool          `env:"ENABLED" envDefault:"false"`        // Carry out erasures of banned servers ordered through the admin API
+		TicketsPerSecond float64       `env:"TICKETS_PER_SECOND" envDefault:"2"` // Cap on transcripts deleted per second, unlimited if zero
+		MaxAttempts      int           `env:"MAX_ATTEMPTS" envDefault:"10"`
+		RetryDelay       time.Duration `env:"RETRY_DELAY" envDefault:"10m"`
+		Interval         time.Duration `env:"INTERVAL" envDefault:"1m"` // How often ordered erasures are checked for
+	} `envPrefix:"PLATFORM_ERASURE_"`
+
+	Locale struct {
+		BundleUrl       string        `env:"BUNDLE_URL"`    // Gzipped tarball of locale files downloaded at startup, the bundled locales are used if empty
+		BundleSha256    string        `env:"BUNDLE_SHA256"` // Expected checksum of the bundle, required if BUNDLE_URL is set
+		Dir             string        `env:"DIR" envDefault:"/tmp/gdpr-worker-locale"`
+		DownloadTimeout time.Duration `env:"DOWNLOAD_TIMEOUT" envDefault:"30s"`
+	} `envPrefix:"LOCALE_"`
 }
 
 var Conf Config
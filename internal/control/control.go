@@ -0,0 +1,211 @@
+package control
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/i18n"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	Channel      = "tickets:gdpr:control" // Redis pub/sub channel that control commands are published to
+	MaxClockSkew = 60 * time.Second       // How old a signed command may be before it is rejected
+)
+
+// Command is a signed instruction published to the control channel
+type Command struct {
+	Command   string   `json:"command"`
+	Args      []string `json:"args,omitempty"`
+	Timestamp int64    `json:"timestamp"` // Unix timestamp the command was signed at
+	Nonce     string   `json:"nonce"`     // Unique to each command, so a captured command can't be replayed
+	Signature string   `json:"signature"` // Hex encoded HMAC-SHA256 of the signing payload
+}
+
+// Handler is implemented by the component that executes control commands
+type Handler interface {
+	Pause()
+	Resume()
+	Drain()
+	SetConcurrency(concurrency int) error
+	Cancel(requestId int) bool
+}
+
+// Sign computes the signature for a command, for use by publishers
+func Sign(secret string, command Command) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingPayload(command)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce generates a random nonce for a command, for use by publishers
+func NewNonce() string {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// signingPayload encodes the signed fields of a command as JSON, so each argument keeps its own
+// boundaries and no two different commands share a payload
+func signingPayload(command Command) string {
+	args := command.Args
+	if args == nil {
+		args = []string{}
+	}
+
+	payload, _ := json.Marshal(struct {
+		Timestamp int64    `json:"timestamp"`
+		Nonce     string   `json:"nonce"`
+		Command   string   `json:"command"`
+		Args      []string `json:"args"`
+	}{command.Timestamp, command.Nonce, command.Command, args})
+
+	return string(payload)
+}
+
+// seenNonces remembers the nonces of accepted commands until their timestamps leave the allowed
+// window, after which the commands are rejected as too old anyway
+type seenNonces map[string]time.Time
+
+func (s seenNonces) add(nonce string, expiresAt, now time.Time) bool {
+	for seen, seenExpiresAt := range s {
+		if now.After(seenExpiresAt) {
+			delete(s, seen)
+		}
+	}
+
+	if _, ok := s[nonce]; ok {
+		return false
+	}
+
+	s[nonce] = expiresAt
+	return true
+}
+
+func Listen(ctx context.Context, redisClient redis.UniversalClient, secret, localePath string, handler Handler, logger *zap.Logger) {
+	if secret == "" {
+		logger.Warn("Control secret not configured, control channel disabled")
+		return
+	}
+
+	pubsub := redisClient.Subscribe(ctx, Channel)
+	defer pubsub.Close()
+
+	logger.Info("Listening for control commands", zap.String("channel", Channel))
+
+	nonces := make(seenNonces)
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			var command Command
+			if err := json.Unmarshal([]byte(msg.Payload), &command); err != nil {
+				logger.Warn("Failed to unmarshal control command", zap.Error(err))
+				continue
+			}
+
+			if err := verify(secret, command, nonces); err != nil {
+				logger.Warn("Rejected control command",
+					zap.String("command", command.Command),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			if err := execute(handler, localePath, command); err != nil {
+				logger.Error("Failed to execute control command",
+					zap.String("command", command.Command),
+					zap.Strings("args", command.Args),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			logger.Info("Executed control command",
+				zap.String("command", command.Command),
+				zap.Strings("args", command.Args),
+			)
+		}
+	}
+}
+
+func verify(secret string, command Command, nonces seenNonces) error {
+	expected := Sign(secret, command)
+	if !hmac.Equal([]byte(expected), []byte(command.Signature)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	now := time.Now()
+	signedAt := time.Unix(command.Timestamp, 0)
+
+	age := now.Sub(signedAt)
+	if age > MaxClockSkew || age < -MaxClockSkew {
+		return fmt.Errorf("command timestamp outside of allowed window")
+	}
+
+	if command.Nonce == "" {
+		return fmt.Errorf("missing nonce")
+	}
+
+	if !nonces.add(command.Nonce, signedAt.Add(MaxClockSkew), now) {
+		return fmt.Errorf("nonce has already been used")
+	}
+
+	return nil
+}
+
+func execute(handler Handler, localePath string, command Command) error {
+	switch command.Command {
+	case "pause":
+		handler.Pause()
+	case "resume":
+		handler.Resume()
+	case "drain":
+		handler.Drain()
+	case "set-concurrency":
+		if len(command.Args) != 1 {
+			return fmt.Errorf("set-concurrency expects exactly one argument")
+		}
+
+		concurrency, err := strconv.Atoi(command.Args[0])
+		if err != nil {
+			return fmt.Errorf("invalid concurrency: %w", err)
+		}
+
+		return handler.SetConcurrency(concurrency)
+	case "reload-locales":
+		return i18n.Reload(localePath)
+	case "cancel":
+		if len(command.Args) != 1 {
+			return fmt.Errorf("cancel expects exactly one argument")
+		}
+
+		requestId, err := strconv.Atoi(command.Args[0])
+		if err != nil {
+			return fmt.Errorf("invalid request ID: %w", err)
+		}
+
+		if !handler.Cancel(requestId) {
+			return fmt.Errorf("request %d is not in flight", requestId)
+		}
+	default:
+		return fmt.Errorf("unknown command %q", command.Command)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/platformerasure"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AdminActions holds the audit log of changes made through the admin API, nil until Connect is called
+var AdminActions *AdminActionTable
+
+type AdminActionTable struct {
+	*pgxpool.Pool
+}
+
+var _ platformerasure.Auditor = (*AdminActionTable)(nil)
+
+// Categories the audit log is split into, so erasures ordered by the bot operator can be reviewed
+// apart from changes to user requests
+const (
+	AuditCategoryAdmin           = "admin"
+	AuditCategoryPlatformErasure = "platform-erasure"
+)
+
+// AdminAction is a single change made through the admin API
+type AdminAction struct {
+	Category    string    `json:"category"`
+	Actor       string    `json:"actor"` // Name of the service token used
+	Action      string    `json:"action"`
+	RequestId   int       `json:"request_id"` // Negative for platform erasures, see platformerasure.Erasure.RequestId
+	Success     bool      `json:"success"`
+	Detail      string    `json:"detail,omitempty"` // Outcome or error message
+	PerformedAt time.Time `json:"performed_at"`
+}
+
+func newAdminActions(db *pgxpool.Pool) *AdminActionTable {
+	return &AdminActionTable{
+		db,
+	}
+}
+
+func (s AdminActionTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_admin_actions (
+	id BIGSERIAL PRIMARY KEY,
+	actor VARCHAR(64) NOT NULL,
+	action VARCHAR(32) NOT NULL,
+	request_id INT NOT NULL,
+	success BOOLEAN NOT NULL,
+	detail TEXT,
+	performed_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS gdpr_admin_actions_request_id ON gdpr_admin_actions(request_id);
+CREATE INDEX IF NOT EXISTS gdpr_admin_actions_actor ON gdpr_admin_actions(actor);
+ALTER TABLE gdpr_admin_actions ADD COLUMN IF NOT EXISTS category VARCHAR(32) NOT NULL DEFAULT 'admin';
+CREATE INDEX IF NOT EXISTS gdpr_admin_actions_category ON gdpr_admin_actions(category, performed_at);
+`
+}
+
+func (s *AdminActionTable) Insert(ctx context.Context, action AdminAction) error {
+	query := `
+INSERT INTO gdpr_admin_actions (category, actor, action, request_id, success, detail, performed_at)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7);`
+
+	category := action.Category
+	if category == "" {
+		category = AuditCategoryAdmin
+	}
+
+	_, err := s.Exec(ctx, query,
+		category,
+		action.Actor,
+		action.Action,
+		action.RequestId,
+		action.Success,
+		action.Detail,
+		action.PerformedAt,
+	)
+	return err
+}
+
+// RecordPlatformErasure records what happened to a platform erasure under its own category,
+// attributed to the service token that ordered it
+func (s *AdminActionTable) RecordPlatformErasure(ctx context.Context, erasure platformerasure.Erasure, action string, success bool, detail string) error {
+	return s.Insert(ctx, AdminAction{
+		Category:    AuditCategoryPlatformErasure,
+		Actor:       erasure.OrderedBy,
+		Action:      action,
+		RequestId:   erasure.RequestId(),
+		Success:     success,
+		Detail:      detail,
+		PerformedAt: time.Now(),
+	})
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Certificates holds issued certificates of erasure, nil until Connect is called
+var Certificates *ErasureCertificatesTable
+
+type ErasureCertificatesTable struct {
+	*pgxpool.Pool
+}
+
+type ErasureCertificate struct {
+	RequestId   int             `json:"request_id"`
+	UserHash    string          `json:"user_hash"` // Sha256 hash of the user ID
+	Certificate json.RawMessage `json:"certificate"`
+	Signature   string          `json:"signature"`
+	PublicKey   string          `json:"public_key"`
+	IssuedAt    time.Time       `json:"issued_at"`
+}
+
+func newErasureCertificates(db *pgxpool.Pool) *ErasureCertificatesTable {
+	return &ErasureCertificatesTable{
+		db,
+	}
+}
+
+func (s ErasureCertificatesTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_erasure_certificates (
+	request_id INT PRIMARY KEY,
+	user_hash VARCHAR(64) NOT NULL,
+	certificate JSONB NOT NULL,
+	signature TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	issued_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS gdpr_erasure_certificates_user_hash ON gdpr_erasure_certificates(user_hash);
+`
+}
+
+func (s *ErasureCertificatesTable) Insert(ctx context.Context, certificate ErasureCertificate) error {
+	query := `
+INSERT INTO gdpr_erasure_certificates (request_id, user_hash, certificate, signature, public_key, issued_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (request_id) DO NOTHING;`
+
+	_, err := s.Exec(ctx, query,
+		certificate.RequestId,
+		certificate.UserHash,
+		[]byte(certificate.Certificate),
+		certificate.Signature,
+		certificate.PublicKey,
+		certificate.IssuedAt,
+	)
+	return err
+}
+
+func (s *ErasureCertificatesTable) Get(ctx context.Context, requestId int) (ErasureCertificate, bool, error) {
+	query := `
+SELECT request_id, user_hash, certificate, signature, public_key, issued_at
+FROM gdpr_erasure_certificates
+WHERE request_id = $1;`
+
+	var certificate ErasureCertificate
+	var raw []byte
+	err := s.QueryRow(ctx, query, requestId).Scan(
+		&certificate.RequestId,
+		&certificate.UserHash,
+		&raw,
+		&certificate.Signature,
+		&certificate.PublicKey,
+		&certificate.IssuedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return ErasureCertificate{}, false, nil
+	} else if err != nil {
+		return ErasureCertificate{}, false, err
+	}
+
+	certificate.Certificate = raw
+	return certificate, true, nil
+}
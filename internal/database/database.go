@@ -5,16 +5,19 @@ import (
 	"fmt"
 
 	"github.com/TicketsBot-cloud/database"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"go.uber.org/zap"
 )
 
 var Client *database.Database
 
-func Connect(logger *zap.Logger, host, dbName, username, password string, threads int) error {
-	uri := fmt.Sprintf("postgres://%s:%s@%s/%s?pool_max_conns=%d", username, password, host, dbName, threads)
+func uri(host, dbName, username, password string) string {
+	return fmt.Sprintf("postgres://%s:%s@%s/%s", username, password, host, dbName)
+}
 
-	pool, err := pgxpool.Connect(context.Background(), uri)
+func Connect(logger *zap.Logger, host, dbName, username, password string, threads int) error {
+	pool, err := pgxpool.Connect(context.Background(), fmt.Sprintf("%s?pool_max_conns=%d", uri(host, dbName, username, password), threads))
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -22,6 +25,72 @@ func Connect(logger *zap.Logger, host, dbName, username, password string, thread
 	logger.Info("Connected to database")
 
 	Client = database.NewDatabase(pool)
+	Certificates = newErasureCertificates(pool)
+	Jobs = newJobHistory(pool)
+	Notifications = newNotifications(pool)
+	AdminActions = newAdminActions(pool)
+	WebhookOutbox = newWebhookOutbox(pool)
+	LegalHolds = newLegalHolds(pool)
+	Quarantine = newQuarantine(pool)
+	PlatformErasures = newPlatformErasures(pool)
+	RequestQueue = newRequestQueue(pool)
+
+	if _, err := pool.Exec(context.Background(), Certificates.Schema()); err != nil {
+		return fmt.Errorf("failed to create erasure certificates table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), Jobs.Schema()); err != nil {
+		return fmt.Errorf("failed to create job history table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), Notifications.Schema()); err != nil {
+		return fmt.Errorf("failed to create notifications table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), AdminActions.Schema()); err != nil {
+		return fmt.Errorf("failed to create admin actions table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), WebhookOutbox.Schema()); err != nil {
+		return fmt.Errorf("failed to create webhook outbox table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), LegalHolds.Schema()); err != nil {
+		return fmt.Errorf("failed to create legal holds table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), Quarantine.Schema()); err != nil {
+		return fmt.Errorf("failed to create quarantine table: %w", err)
+	}
+
+	if _, err := pool.Exec(context.Background(), PlatformErasures.Schema()); err != nil {
+		return fmt.Errorf("failed to create platform erasures table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateRequestQueue creates the table of the postgres queue backend. Only deployments using that
+// backend call it, so others need no rights over the table or its trigger.
+func CreateRequestQueue(ctx context.Context) error {
+	if _, err := RequestQueue.Exec(ctx, RequestQueue.Schema()); err != nil {
+		return fmt.Errorf("failed to create request queue table: %w", err)
+	}
+
+	return nil
+}
+
+// Ping opens a single connection to the database and checks it responds, without creating tables
+func Ping(ctx context.Context, host, dbName, username, password string) error {
+	conn, err := pgx.Connect(ctx, uri(host, dbName, username, password))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if err := conn.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
 
 	return nil
 }
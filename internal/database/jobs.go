@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Jobs holds the history of every GDPR request the worker has processed, nil until Connect is called
+var Jobs *JobHistoryTable
+
+type JobHistoryTable struct {
+	*pgxpool.Pool
+}
+
+// Job is the latest known state of a GDPR request, updated after every delivery
+type Job struct {
+	RequestId           int       `json:"request_id"`
+	RequestType         string    `json:"request_type"`
+	UserId              uint64    `json:"user_id,string"`
+	GuildIds            []uint64  `json:"guild_ids"`
+	TicketCount         int       `json:"ticket_count"`
+	DryRun              bool      `json:"dry_run"`
+	Status              string    `json:"status"`
+	Attempts            int       `json:"attempts"`
+	Error               string    `json:"error,omitempty"`
+	Locale              string    `json:"locale"` // Locale the user was notified in
+	TranscriptsDeleted  int       `json:"transcripts_deleted"`
+	MessagesDeleted     int       `json:"messages_deleted"`
+	TranscriptsExported int       `json:"transcripts_exported"`
+	ReferencesScrubbed  int       `json:"references_scrubbed"`
+	FeedbackDeleted     int       `json:"feedback_deleted"`
+	BytesFetched        int64     `json:"bytes_fetched"` // Transcript data moved to and from the archiver, summed over every attempt
+	BytesRewritten      int64     `json:"bytes_rewritten"`
+	BytesDeleted        int64     `json:"bytes_deleted"`
+	QueuedAt            time.Time `json:"queued_at"`
+	FirstStartedAt      time.Time `json:"first_started_at"`
+	LastStartedAt       time.Time `json:"last_started_at"`
+	FinishedAt          time.Time `json:"finished_at"`
+	DurationMs          int64     `json:"duration_ms"` // Processing time summed over every attempt
+
+	VerifiedOwners map[uint64]uint64 `json:"verified_owners,omitempty"` // Owner of each server when the requester was last verified against it
+}
+
+// JobFilter narrows down a job history query, zero values match everything
+type JobFilter struct {
+	UserId      uint64
+	GuildId     uint64
+	RequestType string
+	Status      string
+	Since       time.Time // Only jobs queued at or after this time
+	Until       time.Time // Only jobs queued before this time
+	Limit       int
+	Offset      int
+}
+
+func newJobHistory(db *pgxpool.Pool) *JobHistoryTable {
+	return &JobHistoryTable{
+		db,
+	}
+}
+
+func (s JobHistoryTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_jobs (
+	request_id INT PRIMARY KEY,
+	request_type VARCHAR(32) NOT NULL,
+	user_id INT8 NOT NULL,
+	guild_ids INT8[] NOT NULL DEFAULT '{}',
+	ticket_count INT NOT NULL DEFAULT 0,
+	dry_run BOOLEAN NOT NULL DEFAULT false,
+	status VARCHAR(32) NOT NULL,
+	attempts INT NOT NULL DEFAULT 1,
+	error TEXT,
+	transcripts_deleted INT NOT NULL DEFAULT 0,
+	messages_deleted INT NOT NULL DEFAULT 0,
+	transcripts_exported INT NOT NULL DEFAULT 0,
+	references_scrubbed INT NOT NULL DEFAULT 0,
+	feedback_deleted INT NOT NULL DEFAULT 0,
+	queued_at TIMESTAMPTZ NOT NULL,
+	first_started_at TIMESTAMPTZ NOT NULL,
+	last_started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL,
+	duration_ms INT8 NOT NULL DEFAULT 0
+);
+ALTER TABLE gdpr_jobs ADD COLUMN IF NOT EXISTS locale VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE gdpr_jobs ADD COLUMN IF NOT EXISTS verified_owners JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE gdpr_jobs ADD COLUMN IF NOT EXISTS bytes_fetched INT8 NOT NULL DEFAULT 0;
+ALTER TABLE gdpr_jobs ADD COLUMN IF NOT EXISTS bytes_rewritten INT8 NOT NULL DEFAULT 0;
+ALTER TABLE gdpr_jobs ADD COLUMN IF NOT EXISTS bytes_deleted INT8 NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS gdpr_jobs_user_id ON gdpr_jobs(user_id);
+CREATE INDEX IF NOT EXISTS gdpr_jobs_guild_ids ON gdpr_jobs USING GIN(guild_ids);
+CREATE INDEX IF NOT EXISTS gdpr_jobs_queued_at ON gdpr_jobs(queued_at);
+`
+}
+
+// Record stores the outcome of a delivery, keeping the time of the first attempt and accumulating
+// the processing time and archiver traffic across retries. Verified owners are merged, so an attempt that failed before
+// verification doesn't erase what earlier attempts observed.
+func (s *JobHistoryTable) Record(ctx context.Context, job Job) error {
+	query := `
+INSERT INTO gdpr_jobs (
+	request_id, request_type, user_id, guild_ids, ticket_count, dry_run, status, attempts, error,
+	transcripts_deleted, messages_deleted, transcripts_exported, references_scrubbed, feedback_deleted,
+	queued_at, first_started_at, last_started_at, finished_at, duration_ms, locale, verified_owners,
+	bytes_fetched, bytes_rewritten, bytes_deleted
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11, $12, $13, $14, $15, $16, $16, $17, $18, $19, $20, $21, $22, $23)
+ON CONFLICT (request_id) DO UPDATE SET
+	status = EXCLUDED.status,
+	attempts = EXCLUDED.attempts,
+	error = EXCLUDED.error,
+	locale = EXCLUDED.locale,
+	transcripts_deleted = EXCLUDED.transcripts_deleted,
+	messages_deleted = EXCLUDED.messages_deleted,
+	transcripts_exported = EXCLUDED.transcripts_exported,
+	references_scrubbed = EXCLUDED.references_scrubbed,
+	feedback_deleted = EXCLUDED.feedback_deleted,
+	last_started_at = EXCLUDED.last_started_at,
+	finished_at = EXCLUDED.finished_at,
+	duration_ms = gdpr_jobs.duration_ms + EXCLUDED.duration_ms,
+	bytes_fetched = gdpr_jobs.bytes_fetched + EXCLUDED.bytes_fetched,
+	bytes_rewritten = gdpr_jobs.bytes_rewritten + EXCLUDED.bytes_rewritten,
+	bytes_deleted = gdpr_jobs.bytes_deleted + EXCLUDED.bytes_deleted,
+	verified_owners = gdpr_jobs.verified_owners || EXCLUDED.verified_owners;`
+
+	guildIds := job.GuildIds
+	if guildIds == nil {
+		guildIds = []uint64{}
+	}
+
+	verifiedOwners := []byte("{}")
+	if len(job.VerifiedOwners) > 0 {
+		var err error
+		if verifiedOwners, err = json.Marshal(job.VerifiedOwners); err != nil {
+			return fmt.Errorf("failed to marshal verified owners: %w", err)
+		}
+	}
+
+	_, err := s.Exec(ctx, query,
+		job.RequestId,
+		job.RequestType,
+		job.UserId,
+		guildIds,
+		job.TicketCount,
+		job.DryRun,
+		job.Status,
+		job.Attempts,
+		job.Error,
+		job.TranscriptsDeleted,
+		job.MessagesDeleted,
+		job.TranscriptsExported,
+		job.ReferencesScrubbed,
+		job.FeedbackDeleted,
+		job.QueuedAt,
+		job.LastStartedAt,
+		job.FinishedAt,
+		job.DurationMs,
+		job.Locale,
+		verifiedOwners,
+		job.BytesFetched,
+		job.BytesRewritten,
+		job.BytesDeleted,
+	)
+	return err
+}
+
+const jobColumns = `request_id, request_type, user_id, guild_ids, ticket_count, dry_run, status, attempts, COALESCE(error, ''),
+	transcripts_deleted, messages_deleted, transcripts_exported, references_scrubbed, feedback_deleted,
+	queued_at, first_started_at, last_started_at, finished_at, duration_ms, locale, verified_owners,
+	bytes_fetched, bytes_rewritten, bytes_deleted`
+
+func (s *JobHistoryTable) Get(ctx context.Context, requestId int) (Job, bool, error) {
+	query := `SELECT ` + jobColumns + ` FROM gdpr_jobs WHERE request_id = $1;`
+
+	job, err := scanJob(s.QueryRow(ctx, query, requestId))
+	if err == pgx.ErrNoRows {
+		return Job{}, false, nil
+	} else if err != nil {
+		return Job{}, false, err
+	}
+
+	return job, true, nil
+}
+
+// List returns the jobs matching the filter, most recently queued first
+func (s *JobHistoryTable) List(ctx context.Context, filter JobFilter) ([]Job, error) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserId != 0 {
+		addCondition("user_id = $%d", filter.UserId)
+	}
+	if filter.GuildId != 0 {
+		addCondition("$%d = ANY(guild_ids)", filter.GuildId)
+	}
+	if filter.RequestType != "" {
+		addCondition("request_type = $%d", filter.RequestType)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("queued_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("queued_at < $%d", filter.Until)
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM gdpr_jobs`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(` ORDER BY queued_at DESC, request_id DESC LIMIT $%d OFFSET $%d;`, len(args)-1, len(args))
+
+	rows, err := s.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+func scanJob(row pgx.Row) (Job, error) {
+	var job Job
+	var verifiedOwners []byte
+	err := row.Scan(
+		&job.RequestId,
+		&job.RequestType,
+		&job.UserId,
+		&job.GuildIds,
+		&job.TicketCount,
+		&job.DryRun,
+		&job.Status,
+		&job.Attempts,
+		&job.Error,
+		&job.TranscriptsDeleted,
+		&job.MessagesDeleted,
+		&job.TranscriptsExported,
+		&job.ReferencesScrubbed,
+		&job.FeedbackDeleted,
+		&job.QueuedAt,
+		&job.FirstStartedAt,
+		&job.LastStartedAt,
+		&job.FinishedAt,
+		&job.DurationMs,
+		&job.Locale,
+		&verifiedOwners,
+		&job.BytesFetched,
+		&job.BytesRewritten,
+		&job.BytesDeleted,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	if err := json.Unmarshal(verifiedOwners, &job.VerifiedOwners); err != nil {
+		return job, fmt.Errorf("failed to unmarshal verified owners: %w", err)
+	}
+
+	return job, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// LegalHolds holds the guilds and tickets whose data must not be deleted, nil until Connect is called
+var LegalHolds *LegalHoldTable
+
+type LegalHoldTable struct {
+	*pgxpool.Pool
+}
+
+var _ processor.LegalHoldStore = (*LegalHoldTable)(nil)
+
+// LegalHold keeps a guild, or a single ticket in it, from being deleted from until it is released
+// or expires
+type LegalHold struct {
+	Id         int64      `json:"id"`
+	GuildId    uint64     `json:"guild_id,string"`
+	TicketId   *int       `json:"ticket_id,omitempty"` // Nil if the whole guild is held
+	Reason     string     `json:"reason"`
+	Reference  string     `json:"reference,omitempty"` // Case or order number the hold was placed under
+	PlacedBy   string     `json:"placed_by"`           // Name of the service token used
+	PlacedAt   time.Time  `json:"placed_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Nil if the hold lasts until released
+	ReleasedAt *time.Time `json:"released_at,omitempty"`
+	ReleasedBy string     `json:"released_by,omitempty"`
+}
+
+func newLegalHolds(db *pgxpool.Pool) *LegalHoldTable {
+	return &LegalHoldTable{
+		db,
+	}
+}
+
+func (s LegalHoldTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_legal_holds (
+	id BIGSERIAL PRIMARY KEY,
+	guild_id INT8 NOT NULL,
+	ticket_id INT,
+	reason TEXT NOT NULL,
+	reference VARCHAR(255),
+	placed_by VARCHAR(64) NOT NULL,
+	placed_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	released_at TIMESTAMPTZ,
+	released_by VARCHAR(64)
+);
+CREATE INDEX IF NOT EXISTS gdpr_legal_holds_active ON gdpr_legal_holds(guild_id) WHERE released_at IS NULL;
+`
+}
+
+// Place records a new hold, returning it with its ID filled in
+func (s *LegalHoldTable) Place(ctx context.Context, hold LegalHold) (LegalHold, error) {
+	query := `
+INSERT INTO gdpr_legal_holds (guild_id, ticket_id, reason, reference, placed_by, placed_at, expires_at)
+VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+RETURNING id;`
+
+	err := s.QueryRow(ctx, query,
+		hold.GuildId,
+		hold.TicketId,
+		hold.Reason,
+		hold.Reference,
+		hold.PlacedBy,
+		hold.PlacedAt,
+		hold.ExpiresAt,
+	).Scan(&hold.Id)
+	return hold, err
+}
+
+// Release ends an active hold, returning false if there is no active hold with the ID
+func (s *LegalHoldTable) Release(ctx context.Context, id int64, releasedBy string, releasedAt time.Time) (bool, error) {
+	query := `
+UPDATE gdpr_legal_holds
+SET released_at = $2, released_by = $3
+WHERE id = $1 AND released_at IS NULL AND (expires_at IS NULL OR expires_at > $2);`
+
+	tag, err := s.Exec(ctx, query, id, releasedAt, releasedBy)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListActive returns every hold not yet released or expired, optionally only those in a guild,
+// newest first
+func (s *LegalHoldTable) ListActive(ctx context.Context, guildId uint64) ([]LegalHold, error) {
+	query := `
+SELECT id, guild_id, ticket_id, reason, COALESCE(reference, ''), placed_by, placed_at, expires_at, released_at, COALESCE(released_by, '')
+FROM gdpr_legal_holds
+WHERE released_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) AND ($1::INT8 = 0 OR guild_id = $1)
+ORDER BY placed_at DESC, id DESC;`
+
+	rows, err := s.Query(ctx, query, guildId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make([]LegalHold, 0)
+	for rows.Next() {
+		var hold LegalHold
+		if err := rows.Scan(
+			&hold.Id,
+			&hold.GuildId,
+			&hold.TicketId,
+			&hold.Reason,
+			&hold.Reference,
+			&hold.PlacedBy,
+			&hold.PlacedAt,
+			&hold.ExpiresAt,
+			&hold.ReleasedAt,
+			&hold.ReleasedBy,
+		); err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	return holds, rows.Err()
+}
+
+// ActiveLegalHolds returns the holds active in the given guilds, or in every guild if guildIds is nil
+func (s *LegalHoldTable) ActiveLegalHolds(ctx context.Context, guildIds []uint64) (processor.LegalHolds, error) {
+	query := `
+SELECT guild_id, ticket_id
+FROM gdpr_legal_holds
+WHERE released_at IS NULL AND (expires_at IS NULL OR expires_at > NOW()) AND ($1::INT8[] IS NULL OR guild_id = ANY($1));`
+
+	holds := processor.LegalHolds{
+		Guilds:  make(map[uint64]bool),
+		Tickets: make(map[uint64]map[int]bool),
+	}
+
+	rows, err := s.Query(ctx, query, guildIds)
+	if err != nil {
+		return holds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guildId uint64
+		var ticketId *int
+		if err := rows.Scan(&guildId, &ticketId); err != nil {
+			return holds, err
+		}
+
+		if ticketId == nil {
+			holds.Guilds[guildId] = true
+			continue
+		}
+
+		if holds.Tickets[guildId] == nil {
+			holds.Tickets[guildId] = make(map[int]bool)
+		}
+		holds.Tickets[guildId][*ticketId] = true
+	}
+
+	return holds, rows.Err()
+}
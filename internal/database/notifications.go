@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Notifications holds every attempt to tell a user the outcome of their request, nil until Connect
+// is called
+var Notifications *NotificationTable
+
+type NotificationTable struct {
+	*pgxpool.Pool
+}
+
+// Notification is a single attempt to deliver a result through one channel
+type Notification struct {
+	RequestId   int       `json:"request_id"`
+	Channel     string    `json:"channel"` // interaction_edit, followup, dm or email
+	ResultCode  string    `json:"result_code,omitempty"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+func newNotifications(db *pgxpool.Pool) *NotificationTable {
+	return &NotificationTable{
+		db,
+	}
+}
+
+func (s NotificationTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_notifications (
+	id BIGSERIAL PRIMARY KEY,
+	request_id INT NOT NULL,
+	channel VARCHAR(32) NOT NULL,
+	success BOOLEAN NOT NULL,
+	error TEXT,
+	attempted_at TIMESTAMPTZ NOT NULL
+);
+ALTER TABLE gdpr_notifications ADD COLUMN IF NOT EXISTS result_code VARCHAR(32) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS gdpr_notifications_request_id ON gdpr_notifications(request_id);
+`
+}
+
+// Insert stores the attempts made while delivering a result
+func (s *NotificationTable) Insert(ctx context.Context, notifications []Notification) error {
+	query := `
+INSERT INTO gdpr_notifications (request_id, channel, result_code, success, error, attempted_at)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6);`
+
+	batch := &pgx.Batch{}
+	for _, notification := range notifications {
+		batch.Queue(query,
+			notification.RequestId,
+			notification.Channel,
+			notification.ResultCode,
+			notification.Success,
+			notification.Error,
+			notification.AttemptedAt,
+		)
+	}
+
+	return s.SendBatch(ctx, batch).Close()
+}
+
+// ListForRequest returns every delivery attempt for a request, oldest first
+func (s *NotificationTable) ListForRequest(ctx context.Context, requestId int) ([]Notification, error) {
+	query := `
+SELECT request_id, channel, result_code, success, COALESCE(error, ''), attempted_at
+FROM gdpr_notifications
+WHERE request_id = $1
+ORDER BY attempted_at, id;`
+
+	rows, err := s.Query(ctx, query, requestId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(
+			&notification.RequestId,
+			&notification.Channel,
+			&notification.ResultCode,
+			&notification.Success,
+			&notification.Error,
+			&notification.AttemptedAt,
+		); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
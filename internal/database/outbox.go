@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// WebhookOutbox holds the webhook deliveries that are waiting to be sent, nil until Connect is called
+var WebhookOutbox *WebhookOutboxTable
+
+type WebhookOutboxTable struct {
+	*pgxpool.Pool
+}
+
+// OutboxEntry is a webhook delivery claimed for an attempt
+type OutboxEntry struct {
+	Id        int64
+	RequestId int
+	Payload   []byte
+	Attempts  int // Attempts made before this one
+	CreatedAt time.Time
+}
+
+func newWebhookOutbox(db *pgxpool.Pool) *WebhookOutboxTable {
+	return &WebhookOutboxTable{
+		db,
+	}
+}
+
+func (s WebhookOutboxTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_webhook_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	request_id INT NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT,
+	created_at TIMESTAMPTZ NOT NULL,
+	delivered_at TIMESTAMPTZ,
+	failed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS gdpr_webhook_outbox_due ON gdpr_webhook_outbox(next_attempt_at) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS gdpr_webhook_outbox_request_id ON gdpr_webhook_outbox(request_id);
+`
+}
+
+// Add stores a delivery, due immediately
+func (s *WebhookOutboxTable) Add(ctx context.Context, requestId int, payload []byte, now time.Time) error {
+	query := `
+INSERT INTO gdpr_webhook_outbox (request_id, payload, next_attempt_at, created_at)
+VALUES ($1, $2, $3, $3);`
+
+	_, err := s.Exec(ctx, query, requestId, payload, now)
+	return err
+}
+
+// ClaimDue returns up to limit deliveries that are due, pushing their next attempt back to
+// leaseUntil so other workers skip them. Should the claiming worker die mid-attempt, the
+// deliveries are picked up again once the lease runs out.
+func (s *WebhookOutboxTable) ClaimDue(ctx context.Context, now, leaseUntil time.Time, limit int) ([]OutboxEntry, error) {
+	query := `
+UPDATE gdpr_webhook_outbox
+SET next_attempt_at = $2
+WHERE id IN (
+	SELECT id
+	FROM gdpr_webhook_outbox
+	WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+	ORDER BY next_attempt_at
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, request_id, payload, attempts, created_at;`
+
+	rows, err := s.Query(ctx, query, now, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]OutboxEntry, 0)
+	for rows.Next() {
+		var entry OutboxEntry
+		if err := rows.Scan(
+			&entry.Id,
+			&entry.RequestId,
+			&entry.Payload,
+			&entry.Attempts,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// MarkDelivered records that a delivery was accepted by the receiver
+func (s *WebhookOutboxTable) MarkDelivered(ctx context.Context, id int64, attempts int, now time.Time) error {
+	query := `
+UPDATE gdpr_webhook_outbox
+SET attempts = $2, delivered_at = $3, last_error = NULL
+WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, attempts, now)
+	return err
+}
+
+// Reschedule records a failed attempt at a delivery, leaving it to be tried again at nextAttemptAt
+func (s *WebhookOutboxTable) Reschedule(ctx context.Context, id int64, attempts int, lastError string, nextAttemptAt time.Time) error {
+	query := `
+UPDATE gdpr_webhook_outbox
+SET attempts = $2, last_error = $3, next_attempt_at = $4
+WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, attempts, lastError, nextAttemptAt)
+	return err
+}
+
+// MarkFailed records that a delivery was given up on. It is kept, so it can be found and resent by
+// hand.
+func (s *WebhookOutboxTable) MarkFailed(ctx context.Context, id int64, attempts int, lastError string, now time.Time) error {
+	query := `
+UPDATE gdpr_webhook_outbox
+SET attempts = $2, last_error = $3, failed_at = $4
+WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, attempts, lastError, now)
+	return err
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/platformerasure"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PlatformErasures holds the erasures ordered by the bot operator, nil until Connect is called
+var PlatformErasures *PlatformErasureTable
+
+type PlatformErasureTable struct {
+	*pgxpool.Pool
+}
+
+var _ platformerasure.Store = (*PlatformErasureTable)(nil)
+
+func newPlatformErasures(db *pgxpool.Pool) *PlatformErasureTable {
+	return &PlatformErasureTable{
+		db,
+	}
+}
+
+func (s PlatformErasureTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_platform_erasures (
+	id BIGSERIAL PRIMARY KEY,
+	guild_id INT8 NOT NULL,
+	reason TEXT NOT NULL,
+	reference VARCHAR(255),
+	ordered_by VARCHAR(64) NOT NULL,
+	ordered_at TIMESTAMPTZ NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	claimed_until TIMESTAMPTZ,
+	transcripts_deleted INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	completed_at TIMESTAMPTZ,
+	failed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS gdpr_platform_erasures_pending ON gdpr_platform_erasures(next_attempt_at) WHERE completed_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS gdpr_platform_erasures_guild_id ON gdpr_platform_erasures(guild_id);
+`
+}
+
+const platformErasureColumns = `id, guild_id, reason, COALESCE(reference, ''), ordered_by, ordered_at, attempts, transcripts_deleted, COALESCE(last_error, ''), completed_at, failed_at`
+
+// Order records a new erasure, due straight away, returning it with its ID filled in
+func (s *PlatformErasureTable) Order(ctx context.Context, erasure platformerasure.Erasure) (platformerasure.Erasure, error) {
+	query := `
+INSERT INTO gdpr_platform_erasures (guild_id, reason, reference, ordered_by, ordered_at, next_attempt_at)
+VALUES ($1, $2, NULLIF($3, ''), $4, $5, $5)
+RETURNING id;`
+
+	err := s.QueryRow(ctx, query,
+		erasure.GuildId,
+		erasure.Reason,
+		erasure.Reference,
+		erasure.OrderedBy,
+		erasure.OrderedAt,
+	).Scan(&erasure.Id)
+	return erasure, err
+}
+
+// List returns the most recently ordered erasures, newest first, optionally only those of a guild
+func (s *PlatformErasureTable) List(ctx context.Context, guildId uint64, limit int) ([]platformerasure.Erasure, error) {
+	query := `
+SELECT ` + platformErasureColumns + `
+FROM gdpr_platform_erasures
+WHERE $1::INT8 = 0 OR guild_id = $1
+ORDER BY ordered_at DESC, id DESC
+LIMIT $2;`
+
+	rows, err := s.Query(ctx, query, guildId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	erasures := make([]platformerasure.Erasure, 0)
+	for rows.Next() {
+		erasure, err := scanPlatformErasure(rows)
+		if err != nil {
+			return nil, err
+		}
+		erasures = append(erasures, erasure)
+	}
+
+	return erasures, rows.Err()
+}
+
+// ClaimNext claims the erasure due soonest, unless another is claimed. The check and the claim are
+// serialised across workers with a transaction-level advisory lock.
+func (s *PlatformErasureTable) ClaimNext(ctx context.Context, now, claimUntil time.Time) (platformerasure.Erasure, bool, error) {
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return platformerasure.Erasure{}, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('gdpr_platform_erasures'));`); err != nil {
+		return platformerasure.Erasure{}, false, err
+	}
+
+	query := `
+UPDATE gdpr_platform_erasures
+SET claimed_until = $2, attempts = attempts + 1
+WHERE id = (
+	SELECT id
+	FROM gdpr_platform_erasures
+	WHERE completed_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1 AND (claimed_until IS NULL OR claimed_until < $1)
+	ORDER BY next_attempt_at, id
+	LIMIT 1
+) AND NOT EXISTS (
+	SELECT 1
+	FROM gdpr_platform_erasures
+	WHERE completed_at IS NULL AND failed_at IS NULL AND claimed_until >= $1
+)
+RETURNING ` + platformErasureColumns + `;`
+
+	erasure, err := scanPlatformErasure(tx.QueryRow(ctx, query, now, claimUntil))
+	if err == pgx.ErrNoRows {
+		return platformerasure.Erasure{}, false, nil
+	} else if err != nil {
+		return platformerasure.Erasure{}, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return platformerasure.Erasure{}, false, err
+	}
+
+	return erasure, true, nil
+}
+
+func (s *PlatformErasureTable) ExtendClaim(ctx context.Context, id int64, claimUntil time.Time) error {
+	query := `UPDATE gdpr_platform_erasures SET claimed_until = $2 WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, claimUntil)
+	return err
+}
+
+func (s *PlatformErasureTable) MarkCompleted(ctx context.Context, id int64, transcriptsDeleted int, completedAt time.Time) error {
+	query := `
+UPDATE gdpr_platform_erasures
+SET completed_at = $3, transcripts_deleted = $2, claimed_until = NULL, last_error = NULL
+WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, transcriptsDeleted, completedAt)
+	return err
+}
+
+func (s *PlatformErasureTable) Unclaim(ctx context.Context, id int64, lastError string, retryAt time.Time) error {
+	query := `UPDATE gdpr_platform_erasures SET next_attempt_at = $3, last_error = $2, claimed_until = NULL WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, lastError, retryAt)
+	return err
+}
+
+func (s *PlatformErasureTable) MarkFailed(ctx context.Context, id int64, lastError string, failedAt time.Time) error {
+	query := `UPDATE gdpr_platform_erasures SET failed_at = $3, last_error = $2, claimed_until = NULL WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, lastError, failedAt)
+	return err
+}
+
+func scanPlatformErasure(row pgx.Row) (platformerasure.Erasure, error) {
+	var erasure platformerasure.Erasure
+	err := row.Scan(
+		&erasure.Id,
+		&erasure.GuildId,
+		&erasure.Reason,
+		&erasure.Reference,
+		&erasure.OrderedBy,
+		&erasure.OrderedAt,
+		&erasure.Attempts,
+		&erasure.TranscriptsDeleted,
+		&erasure.LastError,
+		&erasure.CompletedAt,
+		&erasure.FailedAt,
+	)
+	return erasure, err
+}
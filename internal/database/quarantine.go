@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/quarantine"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Quarantine holds transcripts deleted softly that are awaiting permanent deletion, nil until
+// Connect is called
+var Quarantine *QuarantineTable
+
+type QuarantineTable struct {
+	*pgxpool.Pool
+}
+
+var (
+	_ processor.QuarantineStore = (*QuarantineTable)(nil)
+	_ quarantine.Store          = (*QuarantineTable)(nil)
+)
+
+func newQuarantine(db *pgxpool.Pool) *QuarantineTable {
+	return &QuarantineTable{
+		db,
+	}
+}
+
+func (s QuarantineTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_quarantined_transcripts (
+	id BIGSERIAL PRIMARY KEY,
+	request_id INT NOT NULL,
+	guild_id INT8 NOT NULL,
+	ticket_id INT NOT NULL,
+	quarantined_at TIMESTAMPTZ NOT NULL,
+	purge_at TIMESTAMPTZ NOT NULL,
+	claimed_until TIMESTAMPTZ,
+	purged_at TIMESTAMPTZ,
+	restored_at TIMESTAMPTZ,
+	restored_by VARCHAR(64)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS gdpr_quarantined_transcripts_pending ON gdpr_quarantined_transcripts(guild_id, ticket_id) WHERE purged_at IS NULL AND restored_at IS NULL;
+CREATE INDEX IF NOT EXISTS gdpr_quarantined_transcripts_purge_at ON gdpr_quarantined_transcripts(purge_at) WHERE purged_at IS NULL AND restored_at IS NULL;
+CREATE INDEX IF NOT EXISTS gdpr_quarantined_transcripts_request_id ON gdpr_quarantined_transcripts(request_id);
+`
+}
+
+// Quarantine records transcripts to be permanently deleted at purgeAt, pushing back the purge of
+// any already pending
+func (s *QuarantineTable) Quarantine(ctx context.Context, requestId int, guildId uint64, ticketIds []int, purgeAt time.Time) error {
+	query := `
+INSERT INTO gdpr_quarantined_transcripts (request_id, guild_id, ticket_id, quarantined_at, purge_at)
+SELECT $1, $2, ticket_id, NOW(), $4
+FROM UNNEST($3::INT[]) AS ticket_id
+ON CONFLICT (guild_id, ticket_id) WHERE purged_at IS NULL AND restored_at IS NULL
+DO UPDATE SET purge_at = GREATEST(gdpr_quarantined_transcripts.purge_at, EXCLUDED.purge_at);`
+
+	_, err := s.Exec(ctx, query, requestId, guildId, ticketIds, purgeAt)
+	return err
+}
+
+// ClaimDue claims up to limit transcripts whose grace period is over until claimUntil, so no other
+// worker purges or restores them in the meantime
+func (s *QuarantineTable) ClaimDue(ctx context.Context, now, claimUntil time.Time, limit int) ([]quarantine.Transcript, error) {
+	query := `
+UPDATE gdpr_quarantined_transcripts
+SET claimed_until = $2
+WHERE id IN (
+	SELECT id
+	FROM gdpr_quarantined_transcripts
+	WHERE purged_at IS NULL AND restored_at IS NULL AND purge_at <= $1 AND (claimed_until IS NULL OR claimed_until < $1)
+	ORDER BY purge_at
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, request_id, guild_id, ticket_id;`
+
+	rows, err := s.Query(ctx, query, now, claimUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transcripts := make([]quarantine.Transcript, 0)
+	for rows.Next() {
+		var transcript quarantine.Transcript
+		if err := rows.Scan(
+			&transcript.Id,
+			&transcript.RequestId,
+			&transcript.GuildId,
+			&transcript.TicketId,
+		); err != nil {
+			return nil, err
+		}
+		transcripts = append(transcripts, transcript)
+	}
+
+	return transcripts, rows.Err()
+}
+
+func (s *QuarantineTable) MarkPurged(ctx context.Context, id int64, purgedAt time.Time) error {
+	query := `UPDATE gdpr_quarantined_transcripts SET purged_at = $2, claimed_until = NULL WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, purgedAt)
+	return err
+}
+
+// Unclaim returns a claimed transcript to the quarantine, to be purged at retryAt
+func (s *QuarantineTable) Unclaim(ctx context.Context, id int64, retryAt time.Time) error {
+	query := `UPDATE gdpr_quarantined_transcripts SET purge_at = $2, claimed_until = NULL WHERE id = $1;`
+
+	_, err := s.Exec(ctx, query, id, retryAt)
+	return err
+}
+
+// Restore reverses the soft deletion of every transcript a request quarantined that hasn't been
+// purged yet, returning the number of tickets whose transcript is visible again. Transcripts being
+// purged at that moment are left to finish.
+func (s *QuarantineTable) Restore(ctx context.Context, requestId int, restoredBy string, restoredAt time.Time) (int, error) {
+	query := `
+WITH restored AS (
+	UPDATE gdpr_quarantined_transcripts
+	SET restored_at = $2, restored_by = $3
+	WHERE request_id = $1 AND purged_at IS NULL AND restored_at IS NULL AND (claimed_until IS NULL OR claimed_until < $2)
+	RETURNING guild_id, ticket_id
+)
+UPDATE tickets t
+SET has_transcript = true
+FROM restored r
+WHERE t.guild_id = r.guild_id AND t.id = r.ticket_id;`
+
+	tag, err := s.Exec(ctx, query, requestId, restoredAt, restoredBy)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RequestQueue holds the queued GDPR requests of the postgres queue backend, nil until Connect is
+// called
+var RequestQueue *RequestQueueTable
+
+// ChannelRequestQueue is notified whenever a request is inserted into the queue, to wake listeners
+const ChannelRequestQueue = "gdpr_queue"
+
+type RequestQueueTable struct {
+	*pgxpool.Pool
+}
+
+// QueueEntry is a queued request claimed for an attempt
+type QueueEntry struct {
+	Id      int64
+	Payload []byte
+}
+
+func newRequestQueue(db *pgxpool.Pool) *RequestQueueTable {
+	return &RequestQueueTable{
+		db,
+	}
+}
+
+// Schema creates the queue table. Producers insert a request_id and the marshalled QueuedRequest as
+// the payload, ideally in the transaction that records the request, and the trigger wakes the
+// workers listening for new requests. Replacing the trigger in place needs Postgres 14 or later.
+func (s RequestQueueTable) Schema() string {
+	return `
+CREATE TABLE IF NOT EXISTS gdpr_queue (
+	id BIGSERIAL PRIMARY KEY,
+	request_id INT NOT NULL,
+	payload JSONB NOT NULL,
+	available_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	claimed_by VARCHAR(255),
+	claimed_at TIMESTAMPTZ,
+	last_error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	failed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS gdpr_queue_due ON gdpr_queue(available_at, id) WHERE failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS gdpr_queue_request_id ON gdpr_queue(request_id);
+CREATE OR REPLACE FUNCTION gdpr_queue_notify() RETURNS TRIGGER AS $$
+BEGIN
+	PERFORM pg_notify('gdpr_queue', NEW.id::TEXT);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER gdpr_queue_notify AFTER INSERT ON gdpr_queue FOR EACH ROW EXECUTE PROCEDURE gdpr_queue_notify();
+`
+}
+
+// Enqueue inserts a request, due immediately
+func (s *RequestQueueTable) Enqueue(ctx context.Context, requestId int, payload []byte, now time.Time) error {
+	query := `
+INSERT INTO gdpr_queue (request_id, payload, available_at, created_at)
+VALUES ($1, $2, $3, $3);`
+
+	_, err := s.Exec(ctx, query, requestId, payload, now)
+	return err
+}
+
+// Claim takes the request that has been due the longest for owner, pushing its availability back to
+// leaseUntil so other workers skip it. Should the owner die mid-attempt, the request is claimed
+// again once the lease runs out. Returns false if no request is due.
+func (s *RequestQueueTable) Claim(ctx context.Context, owner string, now, leaseUntil time.Time) (QueueEntry, bool, error) {
+	query := `
+UPDATE gdpr_queue
+SET claimed_by = $1, claimed_at = $2, available_at = $3
+WHERE id = (
+	SELECT id
+	FROM gdpr_queue
+	WHERE failed_at IS NULL AND available_at <= $2
+	ORDER BY available_at, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, payload;`
+
+	var entry QueueEntry
+	if err := s.QueryRow(ctx, query, owner, now, leaseUntil).Scan(&entry.Id, &entry.Payload); err != nil {
+		if err == pgx.ErrNoRows {
+			return QueueEntry{}, false, nil
+		}
+		return QueueEntry{}, false, err
+	}
+
+	return entry, true, nil
+}
+
+// RenewLeases extends the leases owner holds on requests it is still processing
+func (s *RequestQueueTable) RenewLeases(ctx context.Context, owner string, ids []int64, leaseUntil time.Time) error {
+	query := `
+UPDATE gdpr_queue
+SET available_at = $3
+WHERE id = ANY($2) AND claimed_by = $1 AND failed_at IS NULL;`
+
+	_, err := s.Exec(ctx, query, owner, ids, leaseUntil)
+	return err
+}
+
+// Acknowledge deletes a processed request, returning false if owner no longer holds its lease
+func (s *RequestQueueTable) Acknowledge(ctx context.Context, owner string, id int64) (bool, error) {
+	query := `
+DELETE FROM gdpr_queue
+WHERE id = $1 AND claimed_by = $2;`
+
+	tag, err := s.Exec(ctx, query, id, owner)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// Release hands a request back to the queue with an updated payload, due again at availableAt.
+// Returns false if owner no longer holds its lease.
+func (s *RequestQueueTable) Release(ctx context.Context, owner string, id int64, payload []byte, lastError string, availableAt time.Time) (bool, error) {
+	query := `
+UPDATE gdpr_queue
+SET payload = $3, last_error = NULLIF($4, ''), available_at = $5, claimed_by = NULL, claimed_at = NULL
+WHERE id = $1 AND claimed_by = $2;`
+
+	tag, err := s.Exec(ctx, query, id, owner, payload, lastError, availableAt)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// MarkFailed records that a request exhausted its retries. It is kept, so it can be found and
+// requeued by hand. Returns false if owner no longer holds its lease.
+func (s *RequestQueueTable) MarkFailed(ctx context.Context, owner string, id int64, payload []byte, lastError string, now time.Time) (bool, error) {
+	query := `
+UPDATE gdpr_queue
+SET payload = $3, last_error = NULLIF($4, ''), failed_at = $5, claimed_by = NULL, claimed_at = NULL
+WHERE id = $1 AND claimed_by = $2;`
+
+	tag, err := s.Exec(ctx, query, id, owner, payload, lastError, now)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// Listen holds a connection listening for new requests, calling notify whenever one is inserted,
+// until ctx is cancelled or the connection fails
+func (s *RequestQueueTable) Listen(ctx context.Context, notify func()) error {
+	conn, err := s.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+ChannelRequestQueue); err != nil {
+		return err
+	}
+
+	// The session is returned to the pool afterwards, so it mustn't keep listening
+	defer conn.Exec(context.Background(), "UNLISTEN "+ChannelRequestQueue)
+
+	for {
+		if _, err := conn.Conn().WaitForNotification(ctx); err != nil {
+			return err
+		}
+
+		notify()
+	}
+}
@@ -0,0 +1,93 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
+	"github.com/TicketsBot-cloud/gdl/rest"
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+)
+
+const maxMessageLength = 2000 // Longest message content Discord accepts
+
+// noMentions keeps alerts from pinging anyone, as the errors they quote may hold arbitrary text
+var noMentions = message.AllowedMention{}
+
+// WebhookAlerter posts alerts through a Discord webhook
+type WebhookAlerter struct {
+	webhookId   uint64
+	token       string
+	rateLimiter *ratelimit.Ratelimiter
+}
+
+var _ Alerter = (*WebhookAlerter)(nil)
+
+// NewWebhookAlerter parses a Discord webhook URL, https://discord.com/api/webhooks/<id>/<token>
+func NewWebhookAlerter(webhookUrl string) (*WebhookAlerter, error) {
+	parsed, err := url.Parse(webhookUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook URL: %w", err)
+	}
+
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "webhooks" {
+		return nil, fmt.Errorf("webhook URL must end in /webhooks/<id>/<token>")
+	}
+
+	webhookId, err := strconv.ParseUint(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook ID: %w", err)
+	}
+
+	return &WebhookAlerter{
+		webhookId:   webhookId,
+		token:       parts[len(parts)-1],
+		rateLimiter: ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0),
+	}, nil
+}
+
+func (a *WebhookAlerter) Alert(ctx context.Context, content string) error {
+	_, err := rest.ExecuteWebhook(ctx, a.token, a.rateLimiter, a.webhookId, false, rest.WebhookBody{
+		Content:         limitContent(content),
+		AllowedMentions: noMentions,
+	})
+	return err
+}
+
+// ChannelAlerter posts alerts to an ops channel as the bot
+type ChannelAlerter struct {
+	token       string
+	channelId   uint64
+	rateLimiter *ratelimit.Ratelimiter
+}
+
+var _ Alerter = (*ChannelAlerter)(nil)
+
+func NewChannelAlerter(token string, channelId uint64) *ChannelAlerter {
+	return &ChannelAlerter{
+		token:       token,
+		channelId:   channelId,
+		rateLimiter: ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0),
+	}
+}
+
+func (a *ChannelAlerter) Alert(ctx context.Context, content string) error {
+	_, err := rest.CreateMessage(ctx, a.token, a.rateLimiter, a.channelId, rest.CreateMessageData{
+		Content:         limitContent(content),
+		AllowedMentions: noMentions,
+	})
+	return err
+}
+
+func limitContent(content string) string {
+	if utf8.RuneCountInString(content) <= maxMessageLength {
+		return content
+	}
+
+	return string([]rune(content)[:maxMessageLength-1]) + "…"
+}
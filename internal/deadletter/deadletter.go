@@ -0,0 +1,210 @@
+// Package deadletter keeps the failed queue in check. Entries are dropped once they have been kept
+// longer than a TTL, the list is capped at a maximum length, and operators are alerted as requests
+// land in it and when it grows past a threshold, rather than finding out once it has grown for weeks.
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	keyThresholdAlerted = "tickets:gdpr:failed:threshold_alerted" // Redis key held while the threshold alert is cooling down
+
+	maxListedEntries = 10  // Most new entries described in a single alert
+	maxErrorLength   = 100 // Longest error shown for an entry, in characters
+)
+
+// Alerter delivers alerts to operators
+type Alerter interface {
+	Alert(ctx context.Context, message string) error
+}
+
+// Monitor sweeps the failed queue on an interval. Several workers may run one, each new entry is
+// alerted on by whichever worker sees it first, and the threshold alert is sent once per cooldown.
+type Monitor struct {
+	redisClient redis.UniversalClient
+	alerter     Alerter // nil if alerts are disabled, entries are still dropped and logged
+	ttl         time.Duration
+	maxLen      int64
+	threshold   int64         // Length past which the list is alerted on, 0 to disable
+	cooldown    time.Duration // Least time between threshold alerts
+	interval    time.Duration
+	logger      *zap.Logger
+	clock       clock.Clock
+}
+
+func NewMonitor(redisClient redis.UniversalClient, alerter Alerter, ttl time.Duration, maxLen, threshold int64, cooldown, interval time.Duration, logger *zap.Logger) *Monitor {
+	return &Monitor{
+		redisClient: redisClient,
+		alerter:     alerter,
+		ttl:         ttl,
+		maxLen:      maxLen,
+		threshold:   threshold,
+		cooldown:    cooldown,
+		interval:    interval,
+		logger:      logger,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock entries are aged by. Must be called before Run.
+func (m *Monitor) SetClock(clk clock.Clock) {
+	m.clock = clk
+}
+
+// Run sweeps the failed queue every interval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := m.clock.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (m *Monitor) sweep(ctx context.Context) {
+	sweep, err := gdprrelay.SweepFailed(ctx, m.redisClient, m.clock.Now(), m.ttl, m.maxLen)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.logger.Error("Failed to sweep failed queue", zap.Error(err))
+		}
+		return
+	}
+
+	metrics.DeadLetterLength.Set(float64(sweep.Length))
+	metrics.DeadLetterEntries.WithLabelValues("new").Add(float64(len(sweep.New)))
+	metrics.DeadLetterEntries.WithLabelValues("expired").Add(float64(len(sweep.Expired)))
+	metrics.DeadLetterEntries.WithLabelValues("trimmed").Add(float64(sweep.Trimmed))
+
+	for _, entry := range sweep.New {
+		m.logger.Warn("GDPR request landed in failed queue", entryFields(entry)...)
+	}
+
+	for _, entry := range sweep.Expired {
+		m.logger.Warn("Dropped GDPR request kept in failed queue past its TTL",
+			append(entryFields(entry), zap.Duration("ttl", m.ttl))...,
+		)
+	}
+
+	if sweep.Trimmed > 0 {
+		m.logger.Warn("Dropped oldest GDPR requests beyond failed queue maximum length",
+			zap.Int64("dropped", sweep.Trimmed),
+			zap.Int64("max_len", m.maxLen),
+		)
+	}
+
+	if m.alerter == nil {
+		return
+	}
+
+	if len(sweep.New) > 0 || len(sweep.Expired) > 0 || sweep.Trimmed > 0 {
+		m.alert(ctx, describeSweep(sweep))
+	}
+
+	if m.threshold > 0 && sweep.Length > m.threshold {
+		// Only the worker that sets the key alerts, and not again until it expires
+		claimed, err := m.redisClient.SetNX(ctx, keyThresholdAlerted, sweep.Length, m.cooldown).Result()
+		if err != nil {
+			m.logger.Error("Failed to claim failed queue threshold alert", zap.Error(err))
+			return
+		}
+
+		if claimed {
+			m.alert(ctx, fmt.Sprintf("The GDPR failed queue holds %d requests, over the alert threshold of %d. Inspect it with `gdpr-worker inspect-failed`.", sweep.Length, m.threshold))
+		}
+	}
+}
+
+func (m *Monitor) alert(ctx context.Context, message string) {
+	if err := m.alerter.Alert(ctx, message); err != nil && ctx.Err() == nil {
+		m.logger.Error("Failed to send failed queue alert", zap.Error(err))
+	}
+}
+
+// describeSweep writes the alert for a sweep that changed the failed queue. It only identifies
+// users by their scrambled IDs, as alerts are read outside of the worker's logs.
+func describeSweep(sweep gdprrelay.FailedSweep) string {
+	var b strings.Builder
+
+	if len(sweep.New) > 0 {
+		fmt.Fprintf(&b, "%d GDPR requests landed in the failed queue:\n", len(sweep.New))
+		for i, entry := range sweep.New {
+			if i == maxListedEntries {
+				fmt.Fprintf(&b, "- and %d more\n", len(sweep.New)-maxListedEntries)
+				break
+			}
+
+			b.WriteString("- " + describeEntry(entry) + "\n")
+		}
+	}
+
+	if len(sweep.Expired) > 0 {
+		fmt.Fprintf(&b, "%d requests were dropped from the failed queue after their TTL.\n", len(sweep.Expired))
+	}
+
+	if sweep.Trimmed > 0 {
+		fmt.Fprintf(&b, "%d requests were dropped from the failed queue to keep it within its maximum length.\n", sweep.Trimmed)
+	}
+
+	fmt.Fprintf(&b, "The failed queue now holds %d requests.", sweep.Length)
+	return b.String()
+}
+
+func describeEntry(entry gdprrelay.FailedEntry) string {
+	if entry.DecodeErr != nil {
+		return fmt.Sprintf("invalid entry: %s", truncate(entry.DecodeErr.Error()))
+	}
+
+	request := entry.Request
+	description := fmt.Sprintf("request %d (%s, user %s) after %d retries",
+		request.RequestID,
+		utils.GetRequestTypeName(int(request.Request.Type)),
+		utils.ScrambleUserId(request.Request.UserId),
+		request.RetryCount,
+	)
+
+	if request.LastError != "" {
+		description += ": " + truncate(request.LastError)
+	}
+
+	return description
+}
+
+func entryFields(entry gdprrelay.FailedEntry) []zap.Field {
+	if entry.DecodeErr != nil {
+		return []zap.Field{zap.NamedError("decode_error", entry.DecodeErr)}
+	}
+
+	return []zap.Field{
+		zap.Int("request_id", entry.Request.RequestID),
+		zap.String("scrambled_user_id", utils.ScrambleUserId(entry.Request.Request.UserId)),
+		zap.String("request_type", utils.GetRequestTypeName(int(entry.Request.Request.Type))),
+		zap.Int("retry_count", entry.Request.RetryCount),
+		zap.String("last_error", entry.Request.LastError),
+	}
+}
+
+func truncate(s string) string {
+	if utf8.RuneCountInString(s) <= maxErrorLength {
+		return s
+	}
+
+	return string([]rune(s)[:maxErrorLength-1]) + "…"
+}
@@ -0,0 +1,128 @@
+// Package discordproxy routes Discord REST calls through an HTTP proxy such as twilight-http-proxy
+// or nirn-proxy, so that several workers share one view of Discord's rate limits.
+package discordproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdl/rest/request"
+	"go.uber.org/zap"
+)
+
+// fallbackPeriod is how long requests go directly to Discord after the proxy could not be reached,
+// so that an outage doesn't add a connection timeout to every call
+const fallbackPeriod = 30 * time.Second
+
+const discordHost = "discord.com"
+
+// Install points every REST call made through gdl at the proxy. gdl builds its URLs from a fixed
+// base, so the rewrite happens in the transport of its shared HTTP client.
+func Install(proxyUrl string, logger *zap.Logger) error {
+	transport, err := newTransport(proxyUrl, request.Client.Transport, logger)
+	if err != nil {
+		return err
+	}
+
+	request.Client.Transport = transport
+	return nil
+}
+
+// Transport sends requests for discord.com to the proxy, retrying them directly against Discord if
+// the proxy can't be connected to
+type Transport struct {
+	proxy  *url.URL
+	direct http.RoundTripper
+	logger *zap.Logger
+
+	mu            sync.Mutex
+	fallbackUntil time.Time
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+func newTransport(proxyUrl string, direct http.RoundTripper, logger *zap.Logger) (*Transport, error) {
+	if !strings.Contains(proxyUrl, "://") {
+		proxyUrl = "http://" + proxyUrl
+	}
+
+	parsed, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse discord proxy url: %w", err)
+	}
+
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("discord proxy url %q has no host", proxyUrl)
+	}
+
+	if direct == nil {
+		direct = http.DefaultTransport
+	}
+
+	return &Transport{
+		proxy:  parsed,
+		direct: direct,
+		logger: logger,
+	}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != discordHost || t.fallingBack() {
+		return t.direct.RoundTrip(req)
+	}
+
+	proxied := req.Clone(req.Context())
+	proxied.URL.Scheme = t.proxy.Scheme
+	proxied.URL.Host = t.proxy.Host
+	proxied.Host = ""
+
+	res, err := t.direct.RoundTrip(proxied)
+	if err == nil || !isConnectError(err) {
+		return res, err
+	}
+
+	t.logger.Warn("Failed to connect to Discord proxy, falling back to direct requests",
+		zap.Error(err),
+		zap.String("proxy_host", t.proxy.Host),
+		zap.Duration("fallback_period", fallbackPeriod),
+	)
+
+	t.mu.Lock()
+	t.fallbackUntil = time.Now().Add(fallbackPeriod)
+	t.mu.Unlock()
+
+	// The proxy never received the request, so it's safe to send again once the body is rewound
+	direct := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, err
+		}
+
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, fmt.Errorf("failed to rewind request body: %w", bodyErr)
+		}
+		direct.Body = body
+	}
+
+	return t.direct.RoundTrip(direct)
+}
+
+func (t *Transport) fallingBack() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return time.Now().Before(t.fallbackUntil)
+}
+
+// isConnectError reports whether err happened while dialing, before any of the request was sent
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
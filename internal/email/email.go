@@ -0,0 +1,172 @@
+// Package email sends plain text notifications to users who can't be reached on Discord
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var errHeaderInjection = errors.New("email header contains a line break")
+
+// SMTPSender delivers emails through an SMTP relay. Amazon SES is supported through its SMTP
+// interface, e.g. email-smtp.eu-west-1.amazonaws.com:587 with SMTP credentials.
+type SMTPSender struct {
+	addr        string
+	host        string
+	from        mail.Address
+	auth        smtp.Auth // nil if the relay doesn't require authentication
+	implicitTLS bool      // Whether to connect over TLS, rather than upgrading with STARTTLS
+}
+
+var _ Sender = (*SMTPSender)(nil)
+
+// NewSMTPSender creates a sender relaying through addr, a host:port pair, as from. Connections are
+// upgraded with STARTTLS unless implicitTLS is set, in which case TLS is used from the start, as on
+// port 465.
+func NewSMTPSender(addr, username, password, from string, implicitTLS bool) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address: %w", err)
+	}
+
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+
+	sender := &SMTPSender{
+		addr:        addr,
+		host:        host,
+		from:        *fromAddress,
+		implicitTLS: implicitTLS,
+	}
+
+	if username != "" {
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return sender, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message Message) error {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	body, err := s.build(*to, message)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+
+	// net/smtp doesn't take a context, so the deadline is applied to the connection instead
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if s.implicitTLS {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.host})
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if !s.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server doesn't support STARTTLS")
+		}
+
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return fmt.Errorf("failed to authenticate with SMTP server: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("failed to set recipient: %w", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// build renders the message with its headers, encoding the body as quoted-printable UTF-8
+func (s *SMTPSender) build(to mail.Address, message Message) ([]byte, error) {
+	if strings.ContainsAny(message.Subject, "\r\n") {
+		return nil, errHeaderInjection
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&buf)
+	if _, err := writer.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
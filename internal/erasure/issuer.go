@@ -0,0 +1,91 @@
+package erasure
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/certificate"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+)
+
+// Store persists certificates and hands out time-limited download links for them
+type Store interface {
+	Upload(ctx context.Context, name string, data []byte) (url string, expiresAt time.Time, err error)
+}
+
+// Issuer generates, signs and persists certificates of erasure for completed deletion requests
+type Issuer struct {
+	key   ed25519.PrivateKey
+	table *database.ErasureCertificatesTable
+	store Store // Optional, certificates are only persisted to the database if nil
+}
+
+// Issued is the outcome of issuing a certificate
+type Issued struct {
+	Signed    certificate.Signed
+	Url       string    // Download link, empty if no store is configured
+	ExpiresAt time.Time // When the download link stops working
+}
+
+func NewIssuer(key ed25519.PrivateKey, table *database.ErasureCertificatesTable, store Store) *Issuer {
+	return &Issuer{
+		key:   key,
+		table: table,
+		store: store,
+	}
+}
+
+// Issue signs a certificate recording the erasure performed for the request, persists it and
+// uploads it for download
+func (i *Issuer) Issue(ctx context.Context, queued gdpr.QueuedRequest, transcriptsDeleted, messagesDeleted int) (Issued, error) {
+	request := queued.Request
+	userHash := utils.ScrambleUserId(request.UserId)
+
+	signed, err := certificate.Sign(certificate.Certificate{
+		RequestId:          queued.RequestID,
+		UserHash:           userHash,
+		RequestType:        request.Type.String(),
+		GuildIds:           request.GuildIds,
+		TicketIds:          request.TicketIds,
+		TranscriptsDeleted: transcriptsDeleted,
+		MessagesDeleted:    messagesDeleted,
+		IssuedAt:           time.Now().UTC(),
+	}, i.key)
+	if err != nil {
+		return Issued{}, err
+	}
+
+	if err := i.table.Insert(ctx, database.ErasureCertificate{
+		RequestId:   queued.RequestID,
+		UserHash:    userHash,
+		Certificate: signed.Certificate,
+		Signature:   signed.Signature,
+		PublicKey:   signed.PublicKey,
+		IssuedAt:    time.Now(),
+	}); err != nil {
+		return Issued{}, fmt.Errorf("failed to persist certificate: %w", err)
+	}
+
+	issued := Issued{Signed: signed}
+	if i.store == nil {
+		return issued, nil
+	}
+
+	document, err := json.MarshalIndent(signed, "", "  ")
+	if err != nil {
+		return Issued{}, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+
+	name := fmt.Sprintf("certificates/%s/%d.json", userHash, queued.RequestID)
+	issued.Url, issued.ExpiresAt, err = i.store.Upload(ctx, name, document)
+	if err != nil {
+		return Issued{}, fmt.Errorf("failed to upload certificate: %w", err)
+	}
+
+	return issued, nil
+}
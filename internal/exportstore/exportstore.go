@@ -0,0 +1,64 @@
+package exportstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// S3Store stores data export bundles and erasure certificates in an S3 compatible bucket, encrypted at rest with
+// server-side encryption, and hands out presigned download links
+type S3Store struct {
+	client     *minio.Client
+	bucket     string
+	linkExpiry time.Duration
+}
+
+func NewS3Store(endpoint, accessKey, secretKey, bucket string, secure bool, linkExpiry time.Duration) (*S3Store, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: secure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Store{
+		client:     client,
+		bucket:     bucket,
+		linkExpiry: linkExpiry,
+	}, nil
+}
+
+func (s *S3Store) Upload(ctx context.Context, name string, data []byte) (string, time.Time, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:          contentType(name),
+		ServerSideEncryption: encrypt.NewSSE(),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to upload export: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.linkExpiry)
+
+	url, err := s.client.PresignedGetObject(ctx, s.bucket, name, s.linkExpiry, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to presign export download: %w", err)
+	}
+
+	return url.String(), expiresAt, nil
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
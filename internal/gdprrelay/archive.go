@@ -0,0 +1,107 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	keyArchive   = "tickets:gdpr:archive" // Redis stream of redacted summaries of acknowledged requests
+	archiveField = "entry"
+)
+
+// ArchivedEntry is what remains of a queue entry once it has been acknowledged, kept for a short
+// time so operators can reconstruct what the queue did during an incident. It holds nothing that
+// identifies the user, nor the interaction token.
+type ArchivedEntry struct {
+	RequestId       int       `json:"request_id"`
+	Queue           string    `json:"queue"` // Key of the queue the entry was acknowledged from
+	RequestType     string    `json:"request_type"`
+	ScrambledUserId string    `json:"scrambled_user_id"`
+	GuildCount      int       `json:"guild_count"`
+	TicketCount     int       `json:"ticket_count"`
+	DryRun          bool      `json:"dry_run,omitempty"`
+	RetryCount      int       `json:"retry_count"`
+	QueuedAt        time.Time `json:"queued_at"`
+	LastAttemptAt   time.Time `json:"last_attempt_at"`
+	AcknowledgedAt  time.Time `json:"acknowledged_at"`
+}
+
+func newArchivedEntry(queue string, request QueuedRequest, now time.Time) ArchivedEntry {
+	return ArchivedEntry{
+		RequestId:       request.RequestID,
+		Queue:           queue,
+		RequestType:     request.Request.Type.String(),
+		ScrambledUserId: utils.ScrambleUserId(request.Request.UserId),
+		GuildCount:      len(request.Request.GuildIds),
+		TicketCount:     len(request.Request.TicketIds),
+		DryRun:          request.Request.DryRun,
+		RetryCount:      request.RetryCount,
+		QueuedAt:        request.QueuedAt,
+		LastAttemptAt:   request.LastAttemptAt,
+		AcknowledgedAt:  now,
+	}
+}
+
+// archiveAcknowledged appends a redacted summary of an acknowledged request to the archive stream,
+// which is capped at QUEUE_ARCHIVE_MAX_LEN entries and drops those older than QUEUE_ARCHIVE_TTL.
+// The request has already left the queue, so failures are only logged.
+func archiveAcknowledged(ctx context.Context, redisClient redis.UniversalClient, queue string, request QueuedRequest, now time.Time, logger *zap.Logger) {
+	ttl := config.Conf.Queue.ArchiveTTL
+	if ttl <= 0 {
+		return
+	}
+
+	marshalled, err := json.Marshal(newArchivedEntry(queue, request, now))
+	if err != nil {
+		logger.Error("Failed to marshal archived GDPR request", zap.Error(err), zap.Int("request_id", request.RequestID))
+		return
+	}
+
+	// Entry IDs are assigned from the Redis server's time, so the cutoff is taken from the real
+	// time rather than the worker's clock
+	minId := fmt.Sprintf("%d-0", time.Now().Add(-ttl).UnixMilli())
+
+	if _, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: keyArchive,
+			MaxLen: config.Conf.Queue.ArchiveMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{archiveField: string(marshalled)},
+		})
+		pipe.XTrimMinIDApprox(ctx, keyArchive, minId, 0)
+		pipe.Expire(ctx, keyArchive, ttl)
+		return nil
+	}); err != nil {
+		logger.Error("Failed to archive acknowledged GDPR request", zap.Error(err), zap.Int("request_id", request.RequestID))
+	}
+}
+
+// ListArchived returns up to count archived entries, newest first
+func ListArchived(ctx context.Context, redisClient redis.UniversalClient, count int64) ([]ArchivedEntry, error) {
+	messages, err := redisClient.XRevRangeN(ctx, keyArchive, "+", "-", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	entries := make([]ArchivedEntry, 0, len(messages))
+	for _, message := range messages {
+		raw, _ := message.Values[archiveField].(string)
+
+		var entry ArchivedEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
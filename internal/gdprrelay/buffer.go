@@ -0,0 +1,259 @@
+package gdprrelay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bufferFileSuffix = ".op"
+	bufferTempPrefix = ".tmp-"
+)
+
+var ErrBufferFull = errors.New("offline buffer is full")
+
+// BufferedOpKind is a write to Redis that can be deferred until Redis is reachable again
+type BufferedOpKind string
+
+const (
+	BufferedAcknowledge BufferedOpKind = "acknowledge" // Remove the request from the queue
+	BufferedCompleted   BufferedOpKind = "completed"   // Record that the request completed, see MarkCompleted
+)
+
+// BufferedOp is a write to Redis that failed and is waiting to be replayed
+type BufferedOp struct {
+	Kind       BufferedOpKind `json:"kind"`
+	Request    QueuedRequest  `json:"request"`
+	TTL        time.Duration  `json:"ttl,omitempty"` // How long the completion is recorded for, for BufferedCompleted
+	BufferedAt time.Time      `json:"buffered_at"`
+}
+
+// OfflineBuffer keeps writes to Redis that failed during an outage on disk until they can be
+// replayed, so a request that completed isn't processed again once Redis comes back. Each write is
+// stored in its own file, encrypted with AES-GCM, and the buffer refuses writes once it holds
+// maxEntries.
+type OfflineBuffer struct {
+	dir        string
+	aead       cipher.AEAD
+	maxEntries int
+
+	mu        sync.Mutex
+	files     []string         // Names of the buffered writes, oldest first
+	completed map[int]struct{} // Requests with a buffered completion, by request ID
+	seq       int
+}
+
+// NewOfflineBuffer opens the buffer in dir, creating it if needed, with a 16, 24 or 32 byte AES key.
+// Writes buffered before a restart are loaded, to be replayed.
+func NewOfflineBuffer(dir string, key []byte, maxEntries int) (*OfflineBuffer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offline buffer key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offline buffer cipher: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create offline buffer directory: %w", err)
+	}
+
+	b := &OfflineBuffer{
+		dir:        dir,
+		aead:       aead,
+		maxEntries: maxEntries,
+		completed:  make(map[int]struct{}),
+	}
+
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *OfflineBuffer) load() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read offline buffer directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Left behind by a crash while writing, the write was never acknowledged as buffered
+		if strings.HasPrefix(name, bufferTempPrefix) {
+			_ = os.Remove(filepath.Join(b.dir, name))
+			continue
+		}
+
+		if !strings.HasSuffix(name, bufferFileSuffix) {
+			continue
+		}
+
+		op, err := b.read(name)
+		if err != nil {
+			return err
+		}
+
+		b.files = append(b.files, name)
+		if op.Kind == BufferedCompleted {
+			b.completed[op.Request.RequestID] = struct{}{}
+		}
+	}
+
+	slices.Sort(b.files)
+	return nil
+}
+
+// Add stores a write to be replayed, returning ErrBufferFull if the buffer is at capacity. The
+// interaction token and other details the replay doesn't need are dropped first.
+func (b *OfflineBuffer) Add(op BufferedOp) error {
+	op.Request.Request.InteractionToken = ""
+	op.Request.Request.Email = ""
+	op.Request.Request.GuildNames = nil
+
+	marshalled, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to marshal buffered write: %w", err)
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, marshalled, nil)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxEntries > 0 && len(b.files) >= b.maxEntries {
+		return ErrBufferFull
+	}
+
+	b.seq++
+	name := fmt.Sprintf("%020d-%06d%s", op.BufferedAt.UnixNano(), b.seq%1_000_000, bufferFileSuffix)
+
+	temp, err := os.CreateTemp(b.dir, bufferTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create buffer file: %w", err)
+	}
+
+	if _, err := temp.Write(sealed); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to write buffer file: %w", err)
+	}
+
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to sync buffer file: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to close buffer file: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), filepath.Join(b.dir, name)); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to move buffer file into place: %w", err)
+	}
+
+	b.files = append(b.files, name)
+	if op.Kind == BufferedCompleted {
+		b.completed[op.Request.RequestID] = struct{}{}
+	}
+
+	return nil
+}
+
+// IsCompleted reports whether a completion of the request is waiting to be replayed
+func (b *OfflineBuffer) IsCompleted(requestId int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.completed[requestId]
+	return ok
+}
+
+// Len returns the number of writes waiting to be replayed
+func (b *OfflineBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.files)
+}
+
+// Replay applies the buffered writes oldest first, removing each once apply succeeds. It stops at
+// the first write that fails, which is left for the next replay, and returns how many were applied.
+func (b *OfflineBuffer) Replay(ctx context.Context, apply func(ctx context.Context, op BufferedOp) error) (int, error) {
+	b.mu.Lock()
+	files := slices.Clone(b.files)
+	b.mu.Unlock()
+
+	for i, name := range files {
+		op, err := b.read(name)
+		if err != nil {
+			return i, err
+		}
+
+		if err := apply(ctx, op); err != nil {
+			return i, err
+		}
+
+		if err := os.Remove(filepath.Join(b.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return i, fmt.Errorf("failed to remove replayed buffer file: %w", err)
+		}
+
+		b.mu.Lock()
+		b.files = slices.DeleteFunc(b.files, func(file string) bool {
+			return file == name
+		})
+		if op.Kind == BufferedCompleted {
+			delete(b.completed, op.Request.RequestID)
+		}
+		b.mu.Unlock()
+	}
+
+	return len(files), nil
+}
+
+func (b *OfflineBuffer) read(name string) (BufferedOp, error) {
+	sealed, err := os.ReadFile(filepath.Join(b.dir, name))
+	if err != nil {
+		return BufferedOp{}, fmt.Errorf("failed to read buffer file %s: %w", name, err)
+	}
+
+	nonceSize := b.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return BufferedOp{}, fmt.Errorf("buffer file %s is truncated", name)
+	}
+
+	plaintext, err := b.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return BufferedOp{}, fmt.Errorf("failed to decrypt buffer file %s: %w", name, err)
+	}
+
+	var op BufferedOp
+	if err := json.Unmarshal(plaintext, &op); err != nil {
+		return BufferedOp{}, fmt.Errorf("failed to unmarshal buffer file %s: %w", name, err)
+	}
+
+	return op, nil
+}
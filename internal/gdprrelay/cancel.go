@@ -0,0 +1,174 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Remover is implemented by queues that can take a request out before it is dequeued
+type Remover interface {
+	// Remove takes a request that is waiting to be processed or retried out of the queue, returning
+	// false if it isn't waiting in the queue
+	Remove(ctx context.Context, requestId int) (QueuedRequest, bool, error)
+}
+
+var (
+	_ Remover = (*ListQueue)(nil)
+	_ Remover = (*StreamQueue)(nil)
+)
+
+// IsCancelled returns whether the user who made a request has cancelled it, see gdpr.KeyCancelledFor
+func IsCancelled(ctx context.Context, redisClient redis.UniversalClient, requestId int) (bool, error) {
+	exists, err := redisClient.Exists(ctx, gdpr.KeyCancelledFor(requestId)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check request cancellation: %w", err)
+	}
+
+	return exists > 0, nil
+}
+
+// ListenCancellations sends the ID of each request cancelled through gdpr.ChannelCancel on ch, until
+// ctx is cancelled
+func ListenCancellations(ctx context.Context, redisClient redis.UniversalClient, ch chan<- int, logger *zap.Logger) {
+	pubsub := redisClient.Subscribe(ctx, gdpr.ChannelCancel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			requestId, err := strconv.Atoi(message.Payload)
+			if err != nil {
+				logger.Warn("Ignoring invalid request cancellation", zap.String("payload", message.Payload))
+				continue
+			}
+
+			select {
+			case ch <- requestId:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Remove takes the request out of the pending or priority list, or the delayed set of either,
+// whichever it is waiting in
+func (q *ListQueue) Remove(ctx context.Context, requestId int) (QueuedRequest, bool, error) {
+	for _, key := range []string{q.priority, q.pending} {
+		pending, err := q.redisClient.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return QueuedRequest{}, false, fmt.Errorf("failed to read pending queue: %w", err)
+		}
+
+		for _, rawData := range pending {
+			queued, ok := matchEntry(rawData, requestId)
+			if !ok {
+				continue
+			}
+
+			// Zero if a worker dequeued it in the meantime, in which case it's no longer ours to remove
+			removed, err := q.redisClient.LRem(ctx, key, 1, rawData).Result()
+			if err != nil {
+				return QueuedRequest{}, false, fmt.Errorf("failed to remove from pending queue: %w", err)
+			}
+
+			return queued, removed > 0, nil
+		}
+
+		queued, removed, err := removeDelayed(ctx, q.redisClient, delayedKey(key), requestId)
+		if err != nil || removed {
+			return queued, removed, err
+		}
+	}
+
+	return QueuedRequest{}, false, nil
+}
+
+// Remove deletes the request's stream entry if no consumer has read it yet, or takes it out of the
+// delayed set
+func (q *StreamQueue) Remove(ctx context.Context, requestId int) (QueuedRequest, bool, error) {
+	messages, err := q.redisClient.XRange(ctx, q.stream, "-", "+").Result()
+	if err != nil {
+		return QueuedRequest{}, false, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	for _, message := range messages {
+		rawData, _ := message.Values[gdpr.StreamField].(string)
+
+		queued, ok := matchEntry(rawData, requestId)
+		if !ok {
+			continue
+		}
+
+		// Entries that were read are in flight on some worker, which stops them itself
+		inFlight, err := q.redisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: q.stream,
+			Group:  q.group,
+			Start:  message.ID,
+			End:    message.ID,
+			Count:  1,
+		}).Result()
+		if err != nil && !isNoGroupError(err) {
+			return QueuedRequest{}, false, fmt.Errorf("failed to read stream pending entries: %w", err)
+		}
+
+		if len(inFlight) > 0 {
+			return QueuedRequest{}, false, nil
+		}
+
+		removed, err := q.redisClient.XDel(ctx, q.stream, message.ID).Result()
+		if err != nil {
+			return QueuedRequest{}, false, fmt.Errorf("failed to delete stream entry: %w", err)
+		}
+
+		return queued, removed > 0, nil
+	}
+
+	return removeDelayed(ctx, q.redisClient, delayedKey(q.stream), requestId)
+}
+
+func removeDelayed(ctx context.Context, redisClient redis.UniversalClient, key string, requestId int) (QueuedRequest, bool, error) {
+	delayed, err := redisClient.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return QueuedRequest{}, false, fmt.Errorf("failed to read delayed requests: %w", err)
+	}
+
+	for _, rawData := range delayed {
+		queued, ok := matchEntry(rawData, requestId)
+		if !ok {
+			continue
+		}
+
+		removed, err := redisClient.ZRem(ctx, key, rawData).Result()
+		if err != nil {
+			return QueuedRequest{}, false, fmt.Errorf("failed to remove delayed request: %w", err)
+		}
+
+		return queued, removed > 0, nil
+	}
+
+	return QueuedRequest{}, false, nil
+}
+
+// matchEntry decodes a raw entry if it belongs to the given request
+func matchEntry(rawData string, requestId int) (QueuedRequest, bool) {
+	var queued QueuedRequest
+	if err := json.Unmarshal([]byte(rawData), &queued); err != nil || queued.RequestID != requestId {
+		return QueuedRequest{}, false
+	}
+
+	return queued, true
+}
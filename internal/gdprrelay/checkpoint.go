@@ -0,0 +1,62 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/redis/go-redis/v9"
+)
+
+const keyCheckpointPrefix = "tickets:gdpr:checkpoint:" // Redis key prefix holding per-request processing checkpoints
+
+// CheckpointStore persists processing checkpoints in Redis, expiring them after ttl so abandoned
+// requests don't leak keys
+type CheckpointStore struct {
+	redisClient redis.UniversalClient
+	ttl         time.Duration
+}
+
+var _ processor.CheckpointStore = (*CheckpointStore)(nil)
+
+func NewCheckpointStore(redisClient redis.UniversalClient, ttl time.Duration) *CheckpointStore {
+	return &CheckpointStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+func (s *CheckpointStore) Load(ctx context.Context, requestId int) (processor.Checkpoint, bool, error) {
+	data, err := s.redisClient.Get(ctx, checkpointKey(requestId)).Bytes()
+	if err == redis.Nil {
+		return processor.Checkpoint{}, false, nil
+	} else if err != nil {
+		return processor.Checkpoint{}, false, err
+	}
+
+	var checkpoint processor.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return processor.Checkpoint{}, false, fmt.Errorf("failed to unmarshal checkpoint: %w", err)
+	}
+
+	return checkpoint, true, nil
+}
+
+func (s *CheckpointStore) Save(ctx context.Context, requestId int, checkpoint processor.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	return s.redisClient.Set(ctx, checkpointKey(requestId), data, s.ttl).Err()
+}
+
+func (s *CheckpointStore) Clear(ctx context.Context, requestId int) error {
+	return s.redisClient.Del(ctx, checkpointKey(requestId)).Err()
+}
+
+func checkpointKey(requestId int) string {
+	return fmt.Sprintf("%s%d", keyCheckpointPrefix, requestId)
+}
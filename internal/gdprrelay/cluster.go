@@ -0,0 +1,69 @@
+package gdprrelay
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// clusterHashTag wraps the key namespace in a hash tag, so that every key lands in the same slot
+// of a Redis Cluster. Scripts, BLMOVE and transactions span several keys, which Redis Cluster only
+// allows within a single slot.
+const clusterHashTag = "{" + keyNamespace + "}"
+
+// ClusterKey returns the name key is stored under in a Redis Cluster. Producers and consumers that
+// share the queue with a worker in cluster mode must use the same names.
+func ClusterKey(key string) string {
+	if key == keyNamespace || strings.HasPrefix(key, keyNamespace+":") {
+		return clusterHashTag + strings.TrimPrefix(key, keyNamespace)
+	}
+
+	return key
+}
+
+// ClusterKeys is a hook that renames the keys of every command with ClusterKey. It is added to the
+// cluster client, so commands are routed to the renamed key's slot, and to each node client, which
+// transactions run on directly.
+type ClusterKeys struct{}
+
+var _ redis.Hook = ClusterKeys{}
+
+func (ClusterKeys) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (ClusterKeys) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		renameKeys(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (ClusterKeys) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			renameKeys(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// renameKeys renames every argument in the key namespace. Values never start with it, being JSON
+// documents, IDs or timestamps. Pub/sub channels are left alone, as subscriptions bypass hooks and
+// channels aren't bound to a slot anyway.
+func renameKeys(cmd redis.Cmder) {
+	if cmd.Name() == "publish" {
+		return
+	}
+
+	args := cmd.Args()
+	for i := 1; i < len(args); i++ {
+		if key, ok := args[i].(string); ok {
+			args[i] = ClusterKey(key)
+		}
+	}
+}
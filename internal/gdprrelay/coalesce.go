@@ -0,0 +1,56 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyInFlightPrefix prefixes the Redis key holding the ID of the request doing the work identified
+// by a request fingerprint, see gdpr.Request.Fingerprint
+const keyInFlightPrefix = "tickets:gdpr:inflight:"
+
+// claimScript records a request as doing the work behind a fingerprint, unless another already is.
+// KEYS[1] = in-flight key, ARGV[1] = request ID, ARGV[2] = TTL in ms.
+// Returns the ID of the request doing the work.
+var claimScript = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return tonumber(owner)
+end
+
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return tonumber(ARGV[1])
+`)
+
+// releaseScript removes the claim on a fingerprint if the request still holds it.
+// KEYS[1] = in-flight key, ARGV[1] = request ID.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// ClaimFingerprint records a request as doing the work behind a fingerprint for up to ttl, returning
+// the ID of the request already doing it if there is one. Retries of the claiming request claim it
+// again, extending the TTL.
+func ClaimFingerprint(ctx context.Context, redisClient redis.UniversalClient, fingerprint string, requestId int, ttl time.Duration) (int, error) {
+	owner, err := claimScript.Run(ctx, redisClient, []string{keyInFlightPrefix + fingerprint}, requestId, ttl.Milliseconds()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim request fingerprint: %w", err)
+	}
+
+	return owner, nil
+}
+
+// ReleaseFingerprint lets identical requests be processed again once a request has an outcome
+func ReleaseFingerprint(ctx context.Context, redisClient redis.UniversalClient, fingerprint string, requestId int) error {
+	if err := releaseScript.Run(ctx, redisClient, []string{keyInFlightPrefix + fingerprint}, requestId).Err(); err != nil {
+		return fmt.Errorf("failed to release request fingerprint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// keyDeletingPrefix prefixes the Redis key holding the ID of the request deleting a guild's transcripts
+	keyDeletingPrefix = "tickets:gdpr:transcripts:deleting:"
+
+	// keyRewritingPrefix prefixes the Redis sorted set of requests rewriting transcripts in a guild,
+	// scored by when each registration expires
+	keyRewritingPrefix = "tickets:gdpr:transcripts:rewriting:"
+)
+
+// unlockDeletionScript removes the mark on a guild's transcripts if the request still holds it.
+// KEYS[1] = deleting key, ARGV[1] = request ID.
+var unlockDeletionScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// lockRewriteScript registers a request as rewriting a transcript in a guild, unless another is
+// deleting the guild's transcripts. Checking and registering happen atomically, so a deletion either
+// sees the registration or the rewrite sees the deletion.
+// KEYS[1] = deleting key, KEYS[2] = rewriting key, ARGV[1] = request ID, ARGV[2] = expiry as a Unix
+// timestamp in ms, ARGV[3] = TTL in ms.
+// Returns the ID of the request deleting the guild's transcripts, 0 if the rewrite was registered.
+var lockRewriteScript = redis.NewScript(`
+local deleting = redis.call('GET', KEYS[1])
+if deleting and deleting ~= ARGV[1] then
+	return tonumber(deleting)
+end
+
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+return 0
+`)
+
+// TranscriptLocks orders transcript deletions and message cleaning through Redis, so requests
+// processed by different workers see each other
+type TranscriptLocks struct {
+	redisClient redis.UniversalClient
+}
+
+var _ processor.TranscriptLocks = (*TranscriptLocks)(nil)
+
+func NewTranscriptLocks(redisClient redis.UniversalClient) *TranscriptLocks {
+	return &TranscriptLocks{
+		redisClient: redisClient,
+	}
+}
+
+func (l *TranscriptLocks) LockDeletion(ctx context.Context, requestId int, guildIds []uint64, ttl time.Duration) error {
+	for _, guildId := range guildIds {
+		if err := l.redisClient.Set(ctx, deletingKey(guildId), requestId, ttl).Err(); err != nil {
+			return fmt.Errorf("failed to lock guild %d for deletion: %w", guildId, err)
+		}
+	}
+
+	return nil
+}
+
+func (l *TranscriptLocks) UnlockDeletion(ctx context.Context, requestId int, guildIds []uint64) error {
+	for _, guildId := range guildIds {
+		if err := unlockDeletionScript.Run(ctx, l.redisClient, []string{deletingKey(guildId)}, requestId).Err(); err != nil {
+			return fmt.Errorf("failed to unlock guild %d after deletion: %w", guildId, err)
+		}
+	}
+
+	return nil
+}
+
+func (l *TranscriptLocks) DeletingRequest(ctx context.Context, guildId uint64) (int, error) {
+	requestId, err := l.redisClient.Get(ctx, deletingKey(guildId)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get request deleting guild %d: %w", guildId, err)
+	}
+
+	return requestId, nil
+}
+
+func (l *TranscriptLocks) LockRewrite(ctx context.Context, requestId int, guildId uint64, ttl time.Duration) (int, error) {
+	keys := []string{deletingKey(guildId), rewritingKey(guildId)}
+	expiresAt := time.Now().Add(ttl).UnixMilli()
+
+	deleting, err := lockRewriteScript.Run(ctx, l.redisClient, keys, requestId, expiresAt, ttl.Milliseconds()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock guild %d for rewriting: %w", guildId, err)
+	}
+
+	return deleting, nil
+}
+
+func (l *TranscriptLocks) UnlockRewrite(ctx context.Context, requestId int, guildId uint64) error {
+	if err := l.redisClient.ZRem(ctx, rewritingKey(guildId), strconv.Itoa(requestId)).Err(); err != nil {
+		return fmt.Errorf("failed to unlock guild %d after rewriting: %w", guildId, err)
+	}
+
+	return nil
+}
+
+// Rewriting ignores registrations past their expiry, left behind by workers that crashed mid-rewrite
+func (l *TranscriptLocks) Rewriting(ctx context.Context, guildId uint64) (bool, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	count, err := l.redisClient.ZCount(ctx, rewritingKey(guildId), "("+now, "+inf").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rewrites in guild %d: %w", guildId, err)
+	}
+
+	return count > 0, nil
+}
+
+func deletingKey(guildId uint64) string {
+	return keyDeletingPrefix + strconv.FormatUint(guildId, 10)
+}
+
+func rewritingKey(guildId uint64) string {
+	return keyRewritingPrefix + strconv.FormatUint(guildId, 10)
+}
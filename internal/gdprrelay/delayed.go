@@ -0,0 +1,129 @@
+package gdprrelay
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	delayedPollInterval = time.Second
+	delayedPromoteBatch = 100
+)
+
+// delayedKey returns the Redis sorted set holding rejected requests waiting to be retried on a
+// queue, scored by the unix millisecond timestamp they become ready at
+func delayedKey(queue string) string {
+	return queue + ":delayed"
+}
+
+// rejectDelayedScript removes a request from the processing list by its ID, along with its lease,
+// and schedules its updated payload for a later retry.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = delayed set,
+// KEYS[4] = lease expiry set, KEYS[5] = lease owners hash, ARGV[1] = request ID,
+// ARGV[2] = updated payload, ARGV[3] = ready-at timestamp, ARGV[4] = owner.
+// Returns 1 if the request was moved, 0 if it was not being processed or its lease is held by
+// another worker.
+var rejectDelayedScript = redis.NewScript(`
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+
+local owner = redis.call('HGET', KEYS[5], ARGV[1])
+if owner and owner ~= ARGV[4] then
+	return 0
+end
+
+redis.call('LREM', KEYS[2], 1, raw)
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[2])
+return 1
+`)
+
+// promoteListScript moves requests that are ready to be retried onto a pending list.
+// KEYS[1] = delayed set, KEYS[2] = pending list, ARGV[1] = current timestamp, ARGV[2] = batch size.
+// Returns the number of requests moved.
+var promoteListScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, raw in ipairs(due) do
+	redis.call('ZREM', KEYS[1], raw)
+	redis.call('LPUSH', KEYS[2], raw)
+end
+return #due
+`)
+
+// promoteStreamScript moves requests that are ready to be retried onto a stream.
+// KEYS[1] = delayed set, KEYS[2] = stream, ARGV[1] = current timestamp, ARGV[2] = batch size,
+// ARGV[3] = stream field.
+// Returns the number of requests moved.
+var promoteStreamScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, raw in ipairs(due) do
+	redis.call('ZREM', KEYS[1], raw)
+	redis.call('XADD', KEYS[2], '*', ARGV[3], raw)
+end
+return #due
+`)
+
+// retryDelay returns how long a request waits before its next attempt, given how many attempts
+// have failed so far. The delay grows by the configured multiplier with every failure up to the
+// maximum, and is spread by the jitter fraction so requests failing together don't retry together.
+func retryDelay(retryCount int) time.Duration {
+	base := config.Conf.RetryBaseDelay
+	if base <= 0 || retryCount < 1 {
+		return 0
+	}
+
+	delay := float64(base) * math.Pow(max(config.Conf.RetryMultiplier, 1), float64(retryCount-1))
+	if maxDelay := config.Conf.RetryMaxDelay; maxDelay > 0 {
+		delay = math.Min(delay, float64(maxDelay))
+	}
+
+	if jitter := config.Conf.RetryJitter; jitter > 0 {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(delay)
+}
+
+// readyAt returns the score of a request retried after delay
+func readyAt(clk clock.Clock, delay time.Duration) int64 {
+	return clk.Now().Add(delay).UnixMilli()
+}
+
+// promoteDelayed runs promote every poll interval until ctx is cancelled, moving requests whose
+// retry delay has passed back onto the queue
+func promoteDelayed(ctx context.Context, redisClient redis.UniversalClient, clk clock.Clock, script *redis.Script, delayed, target string, logger *zap.Logger, extraArgs ...interface{}) {
+	ticker := clk.NewTicker(delayedPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		args := append([]interface{}{clk.Now().UnixMilli(), delayedPromoteBatch}, extraArgs...)
+		promoted, err := script.Run(ctx, redisClient, []string{delayed, target}, args...).Int()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Failed to promote delayed GDPR requests", zap.Error(err), zap.String("key", delayed))
+			}
+			continue
+		}
+
+		if promoted > 0 {
+			logger.Debug("Promoted delayed GDPR requests", zap.Int("count", promoted), zap.String("key", target))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// EntryState is where in its queue a request currently is
+type EntryState string
+
+const (
+	EntryPending    EntryState = "pending"
+	EntryProcessing EntryState = "processing"
+	EntryDelayed    EntryState = "delayed"
+	EntryFailed     EntryState = "failed"
+)
+
+// Entry is a request found in one of the queues. Entries that could not be decoded are kept with
+// the decoding error, like FailedEntry.
+type Entry struct {
+	Queue     string
+	State     EntryState
+	Request   QueuedRequest
+	RetryAt   time.Time // When a delayed entry becomes ready, zero otherwise
+	DecodeErr error
+}
+
+func newEntry(queue string, state EntryState, rawData string) Entry {
+	queued, err := decodeEntry(rawData)
+	return Entry{
+		Queue:     queue,
+		State:     state,
+		Request:   queued,
+		DecodeErr: err,
+	}
+}
+
+// ListQueueEntries reads the entries of the shared list queue if requestType is nil, or the
+// dedicated list of the type otherwise. At most limit pending and limit delayed entries are
+// returned from each of the pending and priority lists, next to be processed first.
+func ListQueueEntries(ctx context.Context, redisClient redis.UniversalClient, requestType *RequestType, limit int64) ([]Entry, error) {
+	name, pending, processing := "shared", keyPending, keyProcessing
+	if requestType != nil {
+		name = requestType.String()
+		pending, processing = gdpr.KeyPendingFor(*requestType), listProcessingKey(*requestType)
+	}
+
+	// The priority list is read from first
+	keys := []string{gdpr.KeyPriorityFor(pending), pending}
+
+	var (
+		pendingRaw    = make([]*redis.StringSliceCmd, len(keys))
+		delayedRaw    = make([]*redis.ZSliceCmd, len(keys))
+		processingRaw *redis.StringSliceCmd
+	)
+	if _, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			pendingRaw[i] = pipe.LRange(ctx, key, -limit, -1) // Entries are pushed on the left and read from the right
+			delayedRaw[i] = pipe.ZRangeWithScores(ctx, delayedKey(key), 0, limit-1)
+		}
+		processingRaw = pipe.LRange(ctx, processing, 0, -1)
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to read list queue %s: %w", pending, err)
+	}
+
+	var entries []Entry
+	for _, rawData := range processingRaw.Val() {
+		entries = append(entries, newEntry(name, EntryProcessing, rawData))
+	}
+
+	for i := range keys {
+		pendingEntries := pendingRaw[i].Val()
+		slices.Reverse(pendingEntries)
+		for _, rawData := range pendingEntries {
+			entries = append(entries, newEntry(name, EntryPending, rawData))
+		}
+	}
+
+	var delayed []Entry
+	for i := range keys {
+		delayed = append(delayed, delayedEntries(name, delayedRaw[i].Val())...)
+	}
+	slices.SortStableFunc(delayed, func(a, b Entry) int {
+		return a.RetryAt.Compare(b.RetryAt)
+	})
+
+	return append(entries, delayed...), nil
+}
+
+// StreamQueueEntries reads the entries of the shared stream if requestType is nil, or the
+// dedicated stream of the type otherwise, as seen by the consumer group. At most limit stream and
+// limit delayed entries are returned, oldest first.
+func StreamQueueEntries(ctx context.Context, redisClient redis.UniversalClient, requestType *RequestType, group string, limit int64) ([]Entry, error) {
+	name, stream := "shared", keyStream
+	if requestType != nil {
+		name = requestType.String()
+		stream = gdpr.KeyStreamFor(*requestType)
+	}
+
+	var (
+		messages   *redis.XMessageSliceCmd
+		inFlight   *redis.XPendingExtCmd
+		delayedRaw *redis.ZSliceCmd
+	)
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		messages = pipe.XRangeN(ctx, stream, "-", "+", limit)
+		inFlight = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  "-",
+			End:    "+",
+			Count:  statsPendingScanLimit,
+		})
+		delayedRaw = pipe.ZRangeWithScores(ctx, delayedKey(stream), 0, limit-1)
+		return nil
+	})
+
+	// A stream or group that doesn't exist yet has nothing in it
+	if err != nil && err != redis.Nil && !isNoGroupError(err) {
+		return nil, fmt.Errorf("failed to read stream %s: %w", stream, err)
+	}
+
+	processing := make(map[string]bool, len(inFlight.Val()))
+	for _, entry := range inFlight.Val() {
+		processing[entry.ID] = true
+	}
+
+	var entries []Entry
+	for _, message := range messages.Val() {
+		state := EntryPending
+		if processing[message.ID] {
+			state = EntryProcessing
+		}
+
+		rawData, _ := message.Values[gdpr.StreamField].(string)
+		entries = append(entries, newEntry(name, state, rawData))
+	}
+
+	return append(entries, delayedEntries(name, delayedRaw.Val())...), nil
+}
+
+func delayedEntries(queue string, delayed []redis.Z) []Entry {
+	entries := make([]Entry, 0, len(delayed))
+	for _, z := range delayed {
+		rawData, _ := z.Member.(string)
+
+		entry := newEntry(queue, EntryDelayed, rawData)
+		entry.RetryAt = time.UnixMilli(int64(z.Score))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,278 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyFailedSeen is a Redis sorted set of hashes of the entries in the failed list, scored by when
+// a sweep first saw them, as the entries themselves record no time of failure
+const keyFailedSeen = keyFailed + ":seen"
+
+// FailedEntry is an entry of the failed queue. Entries that could not be decoded are kept with the
+// decoding error, so they can still be inspected and purged.
+type FailedEntry struct {
+	Raw       string
+	Request   QueuedRequest
+	DecodeErr error
+}
+
+// requeueFailedListScript moves an entry from the failed list onto a pending list.
+// KEYS[1] = failed list, KEYS[2] = pending list, ARGV[1] = entry as stored, ARGV[2] = updated payload.
+// Returns 1 if the entry was moved, 0 if it was no longer in the failed list.
+var requeueFailedListScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+
+redis.call('LPUSH', KEYS[2], ARGV[2])
+return 1
+`)
+
+// requeueFailedStreamScript moves an entry from the failed list onto a stream.
+// KEYS[1] = failed list, KEYS[2] = stream, ARGV[1] = entry as stored, ARGV[2] = stream field,
+// ARGV[3] = updated payload.
+// Returns 1 if the entry was moved, 0 if it was no longer in the failed list.
+var requeueFailedStreamScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+
+redis.call('XADD', KEYS[2], '*', ARGV[2], ARGV[3])
+return 1
+`)
+
+// ListFailed returns every entry of the failed queue, most recently failed first
+func ListFailed(ctx context.Context, redisClient redis.UniversalClient) ([]FailedEntry, error) {
+	raw, err := redisClient.LRange(ctx, keyFailed, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failed queue: %w", err)
+	}
+
+	entries := make([]FailedEntry, len(raw))
+	for i, rawData := range raw {
+		queued, err := decodeEntry(rawData)
+		entries[i] = FailedEntry{
+			Raw:       rawData,
+			Request:   queued,
+			DecodeErr: err,
+		}
+	}
+
+	return entries, nil
+}
+
+// RequeueFailed moves the failed request with the given ID back onto the shared queue with its
+// retries reset, returning false if no such request is in the failed queue. useStream selects the
+// stream backend's queue over the list backend's.
+func RequeueFailed(ctx context.Context, redisClient redis.UniversalClient, requestId int, useStream bool) (bool, error) {
+	entries, err := ListFailed(ctx, redisClient)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.DecodeErr != nil || entry.Request.RequestID != requestId {
+			continue
+		}
+
+		request := entry.Request
+		request.RetryCount = 0
+		request.LastError = ""
+
+		marshalled, err := json.Marshal(request)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal queued request: %w", err)
+		}
+
+		var moved int
+		if useStream {
+			moved, err = requeueFailedStreamScript.Run(ctx, redisClient, []string{keyFailed, keyStream}, entry.Raw, gdpr.StreamField, string(marshalled)).Int()
+		} else {
+			pending := keyPending
+			if request.Request.IsPriority() {
+				pending = gdpr.KeyPriorityFor(pending)
+			}
+
+			moved, err = requeueFailedListScript.Run(ctx, redisClient, []string{keyFailed, pending}, entry.Raw, string(marshalled)).Int()
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to requeue request: %w", err)
+		}
+
+		return moved == 1, nil
+	}
+
+	return false, nil
+}
+
+// RemoveFailed deletes the failed request with the given ID without processing it, returning false
+// if no such request is in the failed queue
+func RemoveFailed(ctx context.Context, redisClient redis.UniversalClient, requestId int) (bool, error) {
+	entries, err := ListFailed(ctx, redisClient)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.DecodeErr != nil || entry.Request.RequestID != requestId {
+			continue
+		}
+
+		removed, err := redisClient.LRem(ctx, keyFailed, 1, entry.Raw).Result()
+		if err != nil {
+			return false, fmt.Errorf("failed to remove request from failed queue: %w", err)
+		}
+
+		return removed == 1, nil
+	}
+
+	return false, nil
+}
+
+// PurgeFailed deletes every entry of the failed queue, returning how many were deleted
+func PurgeFailed(ctx context.Context, redisClient redis.UniversalClient) (int64, error) {
+	var length *redis.IntCmd
+	if _, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.LLen(ctx, keyFailed)
+		pipe.Del(ctx, keyFailed, keyFailedSeen)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to purge failed queue: %w", err)
+	}
+
+	return length.Val(), nil
+}
+
+// FailedSweep is the outcome of a sweep of the failed queue
+type FailedSweep struct {
+	New     []FailedEntry // Entries no sweep had seen before, claimed by this sweep
+	Expired []FailedEntry // Entries dropped for being kept longer than the TTL
+	Trimmed int64         // Oldest entries dropped to keep the list within its maximum length
+	Length  int64         // Length of the list once swept
+}
+
+// SweepFailed drops entries of the failed queue seen longer than ttl ago, then trims the oldest
+// entries beyond maxLen, either of which may be 0 to disable it. Several workers may sweep at once,
+// each new entry is reported to whichever sweep sees it first.
+func SweepFailed(ctx context.Context, redisClient redis.UniversalClient, now time.Time, ttl time.Duration, maxLen int64) (FailedSweep, error) {
+	entries, err := ListFailed(ctx, redisClient)
+	if err != nil {
+		return FailedSweep{}, err
+	}
+
+	hashes := make([]string, len(entries))
+	added := make([]*redis.IntCmd, len(entries))
+	scores := make([]*redis.FloatCmd, len(entries))
+	if _, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, entry := range entries {
+			hashes[i] = hashFailedEntry(entry.Raw)
+			added[i] = pipe.ZAddNX(ctx, keyFailedSeen, redis.Z{Score: float64(now.UnixMilli()), Member: hashes[i]})
+			scores[i] = pipe.ZScore(ctx, keyFailedSeen, hashes[i])
+		}
+		return nil
+	}); err != nil {
+		return FailedSweep{}, fmt.Errorf("failed to record failed queue entries: %w", err)
+	}
+
+	var sweep FailedSweep
+	kept := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if added[i].Val() == 1 {
+			sweep.New = append(sweep.New, entry)
+		}
+
+		seenAt := time.UnixMilli(int64(scores[i].Val()))
+		if ttl > 0 && now.Sub(seenAt) > ttl {
+			removed, err := redisClient.LRem(ctx, keyFailed, 1, entry.Raw).Result()
+			if err != nil {
+				return sweep, fmt.Errorf("failed to remove expired entry from failed queue: %w", err)
+			}
+
+			if removed == 1 {
+				sweep.Expired = append(sweep.Expired, entry)
+			}
+			continue
+		}
+
+		kept[hashes[i]] = true
+	}
+
+	if maxLen > 0 {
+		var length *redis.IntCmd
+		if _, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			length = pipe.LLen(ctx, keyFailed)
+			pipe.LTrim(ctx, keyFailed, 0, maxLen-1)
+			return nil
+		}); err != nil {
+			return sweep, fmt.Errorf("failed to trim failed queue: %w", err)
+		}
+
+		sweep.Trimmed = max(length.Val()-maxLen, 0)
+	}
+
+	sweep.Length, err = redisClient.LLen(ctx, keyFailed).Result()
+	if err != nil {
+		return sweep, fmt.Errorf("failed to read failed queue length: %w", err)
+	}
+
+	if err := pruneFailedSeen(ctx, redisClient, now, kept, sweep.Trimmed > 0); err != nil {
+		return sweep, err
+	}
+
+	return sweep, nil
+}
+
+// pruneFailedSeen forgets entries that left the failed queue. Entries first seen during this sweep
+// are skipped, as a concurrent sweep may have seen an entry pushed after this one listed the queue.
+// If the list was trimmed, the entries still in it are listed again to tell which were dropped.
+func pruneFailedSeen(ctx context.Context, redisClient redis.UniversalClient, now time.Time, kept map[string]bool, trimmed bool) error {
+	if trimmed {
+		raw, err := redisClient.LRange(ctx, keyFailed, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read failed queue: %w", err)
+		}
+
+		kept = make(map[string]bool, len(raw))
+		for _, rawData := range raw {
+			kept[hashFailedEntry(rawData)] = true
+		}
+	}
+
+	seen, err := redisClient.ZRangeByScore(ctx, keyFailedSeen, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read seen failed queue entries: %w", err)
+	}
+
+	var gone []interface{}
+	for _, hash := range seen {
+		if !kept[hash] {
+			gone = append(gone, hash)
+		}
+	}
+
+	if len(gone) > 0 {
+		if err := redisClient.ZRem(ctx, keyFailedSeen, gone...).Err(); err != nil {
+			return fmt.Errorf("failed to forget removed failed queue entries: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func hashFailedEntry(raw string) string {
+	h := fnv.New64a()
+	h.Write([]byte(raw))
+	return hex.EncodeToString(h.Sum(nil))
+}
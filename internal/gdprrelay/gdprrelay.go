@@ -6,260 +6,137 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
 	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
-	"github.com/go-redis/redis/v8"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
 	"go.uber.org/zap"
 )
 
-// RequestType defines the type of GDPR data deletion request
-type RequestType int
+// RequestType, GDPRRequest and QueuedRequest are aliases of the public contract types, kept so existing
+// callers within the worker don't need to change
+type (
+	RequestType   = gdpr.RequestType
+	GDPRRequest   = gdpr.Request
+	QueuedRequest = gdpr.QueuedRequest
+)
 
 const (
-	RequestTypeAllTranscripts      RequestType = iota // Delete all transcript archives for specified guilds
-	RequestTypeSpecificTranscripts                    // Delete specific transcript archives by ticket IDs
-	RequestTypeAllMessages                            // Delete all ticket messages for specified guilds
-	RequestTypeSpecificMessages                       // Delete specific ticket messages by ticket IDs
+	RequestTypeAllTranscripts      = gdpr.RequestTypeAllTranscripts
+	RequestTypeSpecificTranscripts = gdpr.RequestTypeSpecificTranscripts
+	RequestTypeAllMessages         = gdpr.RequestTypeAllMessages
+	RequestTypeSpecificMessages    = gdpr.RequestTypeSpecificMessages
+	RequestTypeDataExport          = gdpr.RequestTypeDataExport
+	RequestTypeFeedback            = gdpr.RequestTypeFeedback
 )
 
-// GDPRRequest represents a user's request to delete their data under GDPR regulations
-type GDPRRequest struct {
-	Type               RequestType       `json:"type"`
-	UserId             uint64            `json:"user_id"`
-	GuildIds           []uint64          `json:"guild_ids,omitempty"`
-	GuildNames         map[uint64]string `json:"guild_names,omitempty"`
-	TicketIds          []int             `json:"ticket_ids,omitempty"`
-	Language           string            `json:"language,omitempty"`
-	InteractionToken   string            `json:"interaction_token,omitempty"`
-	InteractionGuildId uint64            `json:"interaction_guild_id,omitempty"`
-	ApplicationId      uint64            `json:"application_id,omitempty"`
-}
-
-// QueuedRequest wraps a GDPR request with metadata for reliable queue processing
-type QueuedRequest struct {
-	Request       GDPRRequest `json:"request"`
-	QueuedAt      time.Time   `json:"queued_at"`
-	RetryCount    int         `json:"retry_count"`
-	LastAttemptAt time.Time   `json:"last_attempt_at,omitempty"`
-	RequestID     int         `json:"request_id"`
-}
-
 const (
-	keyPending    = "tickets:gdpr:pending"    // Redis list for queued GDPR requests awaiting processing
-	keyProcessing = "tickets:gdpr:processing" // Redis list for GDPR requests currently being processed
-	keyFailed     = "tickets:gdpr:failed"     // Redis list for GDPR requests that exceeded max retries
+	keyPending    = gdpr.KeyPending
+	keyProcessing = gdpr.KeyProcessing
+	keyFailed     = gdpr.KeyFailed
+	keyStream     = gdpr.KeyStream
 )
 
-func Listen(redisClient *redis.Client, ch chan QueuedRequest, logger *zap.Logger) {
-	ctx := context.Background()
-
-	if err := recoverStalledRequests(ctx, redisClient, logger); err != nil {
-		logger.Error("Failed to recover stalled requests", zap.Error(err))
-	}
-
-	for {
-		rawData, err := redisClient.BRPopLPush(ctx, keyPending, keyProcessing, 0).Result()
-		if err != nil {
-			if err == redis.Nil {
-				continue
-			}
-			logger.Error("Failed to read from GDPR queue",
-				zap.Error(err),
-				zap.String("key", keyPending),
-			)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		var queued QueuedRequest
-		if err := json.Unmarshal([]byte(rawData), &queued); err != nil {
-			logger.Error("Failed to unmarshal GDPR request",
-				zap.Error(err),
-				zap.String("raw_data", rawData),
-			)
-			redisClient.LRem(ctx, keyProcessing, 1, rawData)
-			continue
-		}
-
-		queued.LastAttemptAt = time.Now()
-
-		logger.Info("Dequeued GDPR request",
-			zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
-			zap.String("request_type", utils.GetRequestTypeName(int(queued.Request.Type))),
-			zap.Int("request_id", queued.RequestID),
-			zap.Int("retry_count", queued.RetryCount),
-		)
-
-		ch <- queued
-	}
+// Queue is the transport GDPR requests are consumed from
+type Queue interface {
+	// Listen sends dequeued requests on ch until ctx is cancelled or capacity stops accepting
+	// requests, after which ch is closed. Each request is only dequeued once capacity has a slot
+	// free for it.
+	Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest)
+	// Acknowledge removes a successfully processed request from the queue
+	Acknowledge(ctx context.Context, request QueuedRequest) error
+	// Reject requeues a failed request, or moves it to the failed queue once it has exhausted its
+	// retries. Returns whether the request was moved to the failed queue.
+	Reject(ctx context.Context, request QueuedRequest) (bool, error)
+	// Fail moves a request that failed with an error retrying can't resolve straight to the failed
+	// queue, without counting the attempt against its retries
+	Fail(ctx context.Context, request QueuedRequest) error
+	// Requeue hands a request back to the queue without counting it as a failed attempt
+	Requeue(ctx context.Context, request QueuedRequest) error
 }
 
-func Acknowledge(ctx context.Context, redisClient *redis.Client, request GDPRRequest, logger *zap.Logger) error {
-	processingItems, err := redisClient.LRange(ctx, keyProcessing, 0, -1).Result()
-	if err != nil {
-		return fmt.Errorf("failed to read processing queue: %w", err)
-	}
-
-	for _, item := range processingItems {
-		var queued QueuedRequest
-		if err := json.Unmarshal([]byte(item), &queued); err != nil {
-			continue
-		}
-
-		if requestsMatch(queued.Request, request) {
-			_, err := redisClient.LRem(ctx, keyProcessing, 1, item).Result()
-			if err != nil {
-				return fmt.Errorf("failed to remove from processing queue: %w", err)
-			}
-			return nil
-		}
-	}
-
-	logger.Warn("Request not found in processing queue for acknowledgment",
-		zap.String("scrambled_user_id", utils.ScrambleUserId(request.UserId)),
-		zap.String("request_type", utils.GetRequestTypeName(int(request.Type))),
-	)
-	return nil
+// Capacity is the consumer of a queue's readiness to start another request. Listeners wait for a
+// free slot before each read, so requests stay in the pending queue while every slot is busy, where
+// they count as backlog rather than as being processed.
+type Capacity interface {
+	// AwaitSlot blocks until a request sent on the listener's channel would be started straight
+	// away, returning false if ctx is done or the consumer is no longer accepting requests
+	AwaitSlot(ctx context.Context) bool
 }
 
-func Reject(ctx context.Context, redisClient *redis.Client, request GDPRRequest, logger *zap.Logger) error {
-	processingItems, err := redisClient.LRange(ctx, keyProcessing, 0, -1).Result()
-	if err != nil {
-		return fmt.Errorf("failed to read processing queue: %w", err)
-	}
-
-	for _, item := range processingItems {
-		var queued QueuedRequest
-		if jsonErr := json.Unmarshal([]byte(item), &queued); jsonErr != nil {
-			continue
-		}
+// listenPollTimeout bounds each blocking read so listeners notice shutdown promptly
+const listenPollTimeout = 5 * time.Second
 
-		if requestsMatch(queued.Request, request) {
-			if _, removeErr := redisClient.LRem(ctx, keyProcessing, 1, item).Result(); removeErr != nil {
-				logger.Error("Failed to remove from processing queue",
-					zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
-					zap.Int("request_id", queued.RequestID),
-					zap.Error(removeErr),
-				)
-				return removeErr
-			}
+// priorityPollTimeout bounds each blocking read of a list queue's pending list, so requests pushed
+// onto its priority list meanwhile are picked up soon after
+const priorityPollTimeout = time.Second
 
-			queued.RetryCount++
+// listenReadGrace is how much longer than listenPollTimeout a blocking read may take to return
+// before its connection is given up on
+const listenReadGrace = 2 * time.Second
 
-			if queued.RetryCount >= config.Conf.MaxRetries {
-				logger.Warn("GDPR request exceeded max retries",
-					zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
-					zap.Int("request_id", queued.RequestID),
-					zap.Int("retry_count", queued.RetryCount),
-					zap.Error(err),
-				)
-
-				marshalled, _ := json.Marshal(queued)
-				return redisClient.LPush(ctx, keyFailed, string(marshalled)).Err()
-			}
-
-			logger.Info("Requeuing failed GDPR request",
-				zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
-				zap.Int("request_id", queued.RequestID),
-				zap.Int("retry_count", queued.RetryCount),
-				zap.Error(err),
-			)
-
-			marshalled, marshalErr := json.Marshal(queued)
-			if marshalErr != nil {
-				return fmt.Errorf("failed to marshal queued request: %w", marshalErr)
-			}
-
-			return redisClient.LPush(ctx, keyPending, string(marshalled)).Err()
-		}
+// decodeEntry parses a raw queue entry, returning an error if it is malformed or uses a schema
+// version this worker doesn't understand
+func decodeEntry(rawData string) (QueuedRequest, error) {
+	var queued QueuedRequest
+	if err := json.Unmarshal([]byte(rawData), &queued); err != nil {
+		return QueuedRequest{}, fmt.Errorf("failed to unmarshal GDPR request: %w", err)
 	}
 
-	logger.Warn("Request not found in processing queue for rejection",
-		zap.String("scrambled_user_id", utils.ScrambleUserId(request.UserId)),
-		zap.String("request_type", utils.GetRequestTypeName(int(request.Type))),
-	)
-	return nil
-}
-
-func recoverStalledRequests(ctx context.Context, redisClient *redis.Client, logger *zap.Logger) error {
-	processingItems, err := redisClient.LRange(ctx, keyProcessing, 0, -1).Result()
-	if err != nil {
-		return fmt.Errorf("failed to read processing queue: %w", err)
+	if !queued.IsSupported() {
+		return QueuedRequest{}, fmt.Errorf("unsupported schema version %d, supported up to %d", queued.Version, gdpr.SchemaVersion)
 	}
 
-	if len(processingItems) == 0 {
-		return nil
+	// Legacy producers don't assign request IDs, derive one so the request can still be
+	// acknowledged by ID and correlated across logs and retries
+	if queued.RequestID == 0 {
+		queued.RequestID = queued.DeriveRequestID()
 	}
 
-	logger.Info("Recovering stalled requests", zap.Int("count", len(processingItems)))
+	// Normalized after deriving the ID, so entries queued before normalization keep theirs
+	queued.Request.Normalize()
 
-	recovered := 0
-	for i := len(processingItems) - 1; i >= 0; i-- {
-		item := processingItems[i]
+	return queued, nil
+}
 
-		var queued QueuedRequest
-		if err := json.Unmarshal([]byte(item), &queued); err != nil {
-			logger.Error("Failed to unmarshal stalled request",
-				zap.Error(err),
-				zap.String("raw_data", item),
-			)
-			redisClient.LRem(ctx, keyProcessing, 1, item)
-			continue
-		}
+// nextAttempt counts a failed attempt against the request, reporting whether it has now exhausted
+// its retries and should be moved to the failed queue, and if not how long to wait before retrying
+func nextAttempt(request *QueuedRequest, logger *zap.Logger) (bool, time.Duration) {
+	request.RetryCount++
 
-		logger.Info("Recovering stalled request",
-			zap.Int("request_id", queued.RequestID),
-			zap.Int("retry_count", queued.RetryCount),
+	if request.RetryCount >= config.Conf.MaxRetries {
+		logger.Warn("GDPR request exceeded max retries",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.Int("request_id", request.RequestID),
+			zap.Int("retry_count", request.RetryCount),
 		)
-
-		marshalled, err := json.Marshal(queued)
-		if err != nil {
-			logger.Error("Failed to marshal stalled request",
-				zap.Error(err),
-				zap.Int("request_id", queued.RequestID),
-			)
-			continue
-		}
-
-		if err := redisClient.LPush(ctx, keyPending, string(marshalled)).Err(); err != nil {
-			logger.Error("Failed to requeue stalled request",
-				zap.Error(err),
-				zap.Int("request_id", queued.RequestID),
-			)
-			continue
-		}
-
-		redisClient.LRem(ctx, keyProcessing, 1, item)
-		recovered++
+		return true, 0
 	}
 
-	logger.Info("Stalled request recovery complete", zap.Int("recovered", recovered))
-
-	return nil
+	delay := retryDelay(request.RetryCount)
+	logger.Info("Requeuing failed GDPR request",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+		zap.Int("request_id", request.RequestID),
+		zap.Int("retry_count", request.RetryCount),
+		zap.Duration("retry_delay", delay),
+	)
+	return false, delay
 }
 
-func requestsMatch(a, b GDPRRequest) bool {
-	if a.Type != b.Type || a.UserId != b.UserId {
-		return false
-	}
-
-	if len(a.GuildIds) != len(b.GuildIds) {
-		return false
-	}
-	for i := range a.GuildIds {
-		if a.GuildIds[i] != b.GuildIds[i] {
-			return false
-		}
-	}
-
-	if len(a.TicketIds) != len(b.TicketIds) {
-		return false
-	}
-	for i := range a.TicketIds {
-		if a.TicketIds[i] != b.TicketIds[i] {
-			return false
-		}
-	}
+// logPermanentFailure reports a request being moved to the failed queue without being retried
+func logPermanentFailure(logger *zap.Logger, request QueuedRequest) {
+	logger.Warn("GDPR request failed permanently, moving to failed queue without retrying",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+		zap.Int("request_id", request.RequestID),
+		zap.Int("retry_count", request.RetryCount),
+		zap.String("last_error", request.LastError),
+	)
+}
 
-	return true
+func logDequeued(logger *zap.Logger, queued QueuedRequest) {
+	logger.Info("Dequeued GDPR request",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(queued.Request.UserId)),
+		zap.String("request_type", utils.GetRequestTypeName(int(queued.Request.Type))),
+		zap.Int("request_id", queued.RequestID),
+		zap.Int("retry_count", queued.RetryCount),
+	)
 }
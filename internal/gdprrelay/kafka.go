@@ -0,0 +1,463 @@
+package gdprrelay
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"go.uber.org/zap"
+)
+
+const (
+	headerReadyAt = "gdpr-ready-at" // Unix milliseconds before which a retried request isn't started
+	headerError   = "gdpr-error"    // Why a message was moved to the dead-letter topic
+)
+
+// KafkaQueue consumes requests from a Kafka topic as part of a consumer group, so partitions are
+// rebalanced across the workers sharing the group as they come and go. A partition's offset is only
+// committed once every request read from it up to that point has been acknowledged or handed back,
+// so requests in flight when a worker stops are redelivered to whichever worker takes the partition
+// over. Retried requests are published again with the time they're due, and requests that exhaust
+// their retries, or can't be decoded, are published to the dead-letter topic.
+type KafkaQueue struct {
+	reader      *kafka.Reader
+	writer      *kafka.Writer
+	redisClient redis.UniversalClient // For the archive of acknowledged requests
+	logger      *zap.Logger
+	clock       clock.Clock
+
+	topic           string
+	deadLetterTopic string
+
+	mu       sync.Mutex
+	inFlight map[int]kafka.Message // Request ID -> message, for requests currently being processed
+	offsets  map[int]*partitionOffsets
+
+	commitMu sync.Mutex // Serialises commits, so a partition's offset never moves backwards
+
+	held []heldMessage // Fetched requests whose retry delay hasn't passed, only used by the listener
+}
+
+// partitionOffsets tracks the messages of a partition that have been fetched but not yet finished
+type partitionOffsets struct {
+	pending   map[int64]struct{}
+	fetched   int64 // Highest offset fetched
+	committed int64 // Offset the group resumes from
+}
+
+type heldMessage struct {
+	message kafka.Message
+	readyAt time.Time
+}
+
+var _ Queue = (*KafkaQueue)(nil)
+
+func NewKafkaQueue(
+	redisClient redis.UniversalClient,
+	brokers []string,
+	topic, deadLetterTopic, group string,
+	tlsConfig *tls.Config,
+	mechanism sasl.Mechanism,
+	logger *zap.Logger,
+) *KafkaQueue {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		GroupID: group,
+		Topic:   topic,
+		Dialer: &kafka.Dialer{
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		StartOffset:    kafka.FirstOffset,
+		CommitInterval: 0, // Offsets are committed as requests finish, rather than periodically
+		MaxWait:        time.Second,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			logger.Warn("Kafka consumer error", zap.String("error", fmt.Sprintf(msg, args...)))
+		}),
+	})
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond, // Messages are written one at a time, don't wait to fill a batch
+		Transport: &kafka.Transport{
+			TLS:  tlsConfig,
+			SASL: mechanism,
+		},
+	}
+
+	return &KafkaQueue{
+		reader:          reader,
+		writer:          writer,
+		redisClient:     redisClient,
+		logger:          logger,
+		clock:           clock.Real,
+		topic:           topic,
+		deadLetterTopic: deadLetterTopic,
+		inFlight:        make(map[int]kafka.Message),
+		offsets:         make(map[int]*partitionOffsets),
+	}
+}
+
+// SetClock replaces the clock retry delays and attempt times are measured with
+func (q *KafkaQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Enqueue publishes a request to the topic, as a producer would, so requests raised by the worker
+// itself reach the queue
+func (q *KafkaQueue) Enqueue(ctx context.Context, request QueuedRequest) (QueuedRequest, error) {
+	if !request.IsSupported() {
+		return request, fmt.Errorf("unsupported schema version %d, supported up to %d", request.Version, gdpr.SchemaVersion)
+	}
+
+	if request.QueuedAt.IsZero() {
+		request.QueuedAt = q.clock.Now()
+	}
+
+	if request.RequestID == 0 {
+		request.RequestID = request.DeriveRequestID()
+	}
+
+	request.Request.Normalize()
+
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return request, fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	if err := q.writer.WriteMessages(ctx, kafka.Message{
+		Topic: q.topic,
+		Key:   []byte(strconv.Itoa(request.RequestID)),
+		Value: marshalled,
+	}); err != nil {
+		return request, fmt.Errorf("failed to publish request: %w", err)
+	}
+
+	return request, nil
+}
+
+// Listen reads requests from the topic on behalf of the consumer group and sends them on ch, until
+// ctx is cancelled or the queue is closed, after which ch is closed
+func (q *KafkaQueue) Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest) {
+	defer close(ch)
+
+	for {
+		if !capacity.AwaitSlot(ctx) {
+			return
+		}
+
+		message, err := q.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return
+			}
+
+			q.logger.Error("Failed to read from GDPR topic", zap.Error(err), zap.String("topic", q.topic))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		q.dispatch(ctx, ch, message)
+	}
+}
+
+// next returns the next request due to be started: a held retry whose delay has passed, or else the
+// next message fetched from the topic. Fetched retries that aren't yet due are held back, their
+// offsets staying uncommitted until they have been processed.
+func (q *KafkaQueue) next(ctx context.Context) (kafka.Message, error) {
+	for {
+		now := q.clock.Now()
+
+		wait := listenPollTimeout
+		for i, held := range q.held {
+			if !held.readyAt.After(now) {
+				q.held = append(q.held[:i], q.held[i+1:]...)
+				return held.message, nil
+			}
+
+			if untilReady := held.readyAt.Sub(now); untilReady < wait {
+				wait = untilReady
+			}
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, wait)
+		message, err := q.reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				continue
+			}
+			return kafka.Message{}, err
+		}
+
+		if !q.track(message) {
+			// Redelivered after a rebalance handed the partition back, and already dealt with
+			continue
+		}
+
+		if readyAt, ok := messageReadyAt(message); ok && readyAt.After(now) {
+			q.held = append(q.held, heldMessage{message: message, readyAt: readyAt})
+			continue
+		}
+
+		return message, nil
+	}
+}
+
+// dispatch decodes a message and sends it on ch. Messages that can't be decoded are moved to the
+// dead-letter topic.
+func (q *KafkaQueue) dispatch(ctx context.Context, ch chan QueuedRequest, message kafka.Message) {
+	queued, err := decodeEntry(string(message.Value))
+	if err != nil {
+		q.logger.Error("Invalid GDPR request, moving to dead-letter topic",
+			zap.Error(err),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.String("raw_data", string(message.Value)),
+		)
+
+		if err := q.publish(ctx, q.deadLetterTopic, message.Key, message.Value, kafka.Header{Key: headerError, Value: []byte(err.Error())}); err != nil {
+			// Left uncommitted, so it's read again once the partition is next assigned
+			q.logger.Error("Failed to move invalid GDPR request to dead-letter topic", zap.Error(err))
+			return
+		}
+
+		q.finish(ctx, message)
+		return
+	}
+
+	q.mu.Lock()
+	if existing, ok := q.inFlight[queued.RequestID]; ok {
+		q.mu.Unlock()
+
+		q.logger.Warn("Request is already being processed, skipping duplicate message",
+			zap.Int("request_id", queued.RequestID),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Int64("processing_offset", existing.Offset),
+		)
+		q.finish(ctx, message)
+		return
+	}
+	q.inFlight[queued.RequestID] = message
+	q.mu.Unlock()
+
+	queued.LastAttemptAt = q.clock.Now()
+	logDequeued(q.logger, queued)
+
+	ch <- queued
+}
+
+func (q *KafkaQueue) Acknowledge(ctx context.Context, request QueuedRequest) error {
+	message, ok := q.takeMessage(request)
+	if !ok {
+		return nil
+	}
+
+	if err := q.finish(ctx, message); err != nil {
+		return err
+	}
+
+	archiveAcknowledged(ctx, q.redisClient, q.topic, request, q.clock.Now(), q.logger)
+
+	return nil
+}
+
+func (q *KafkaQueue) Reject(ctx context.Context, request QueuedRequest) (bool, error) {
+	exhausted, delay := nextAttempt(&request, q.logger)
+	if err := q.replace(ctx, request, exhausted, delay); err != nil {
+		return false, err
+	}
+
+	return exhausted, nil
+}
+
+func (q *KafkaQueue) Fail(ctx context.Context, request QueuedRequest) error {
+	logPermanentFailure(q.logger, request)
+
+	return q.replace(ctx, request, true, 0)
+}
+
+func (q *KafkaQueue) Requeue(ctx context.Context, request QueuedRequest) error {
+	return q.replace(ctx, request, false, 0)
+}
+
+// replace publishes the request again, to be retried once delay has passed, or to the dead-letter
+// topic if failed is set, and then finishes the message it was read from
+func (q *KafkaQueue) replace(ctx context.Context, request QueuedRequest, failed bool, delay time.Duration) error {
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	message, ok := q.takeMessage(request)
+	if !ok {
+		return nil
+	}
+
+	var (
+		topic   = q.topic
+		headers []kafka.Header
+	)
+	if failed {
+		topic = q.deadLetterTopic
+		headers = append(headers, kafka.Header{Key: headerError, Value: []byte(request.LastError)})
+	} else if delay > 0 {
+		headers = append(headers, kafka.Header{Key: headerReadyAt, Value: []byte(strconv.FormatInt(readyAt(q.clock, delay), 10))})
+	}
+
+	if err := q.publish(ctx, topic, message.Key, marshalled, headers...); err != nil {
+		// Kept, so the request can be handed back again once Kafka is reachable
+		q.mu.Lock()
+		q.inFlight[request.RequestID] = message
+		q.mu.Unlock()
+
+		return fmt.Errorf("failed to publish request to %s: %w", topic, err)
+	}
+
+	// The request is safely on the topic again, a failed commit only means it may be read twice
+	if err := q.finish(ctx, message); err != nil {
+		q.logger.Warn("Failed to commit offset of requeued GDPR request",
+			zap.Int("request_id", request.RequestID),
+			zap.Error(err),
+		)
+	}
+
+	return nil
+}
+
+func (q *KafkaQueue) publish(ctx context.Context, topic string, key, value []byte, headers ...kafka.Header) error {
+	// The original key is kept, so a request stays on the same partition as before
+	return q.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: headers,
+	})
+}
+
+// takeMessage removes and returns the message of an in-flight request
+func (q *KafkaQueue) takeMessage(request QueuedRequest) (kafka.Message, bool) {
+	q.mu.Lock()
+	message, ok := q.inFlight[request.RequestID]
+	delete(q.inFlight, request.RequestID)
+	q.mu.Unlock()
+
+	if !ok {
+		q.logger.Warn("Request not found in Kafka in-flight messages",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+	}
+
+	return message, ok
+}
+
+// track records a fetched message as pending, returning false if it has been fetched before
+func (q *KafkaQueue) track(message kafka.Message) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	offsets, ok := q.offsets[message.Partition]
+	if !ok {
+		offsets = &partitionOffsets{
+			pending:   make(map[int64]struct{}),
+			fetched:   -1,
+			committed: message.Offset,
+		}
+		q.offsets[message.Partition] = offsets
+	}
+
+	if message.Offset <= offsets.fetched {
+		return false
+	}
+
+	offsets.pending[message.Offset] = struct{}{}
+	offsets.fetched = message.Offset
+	return true
+}
+
+// finish marks a message as dealt with, and commits its partition's offset as far as every earlier
+// message has been finished too
+func (q *KafkaQueue) finish(ctx context.Context, message kafka.Message) error {
+	q.commitMu.Lock()
+	defer q.commitMu.Unlock()
+
+	q.mu.Lock()
+	offsets, ok := q.offsets[message.Partition]
+	if !ok {
+		q.mu.Unlock()
+		return nil
+	}
+
+	delete(offsets.pending, message.Offset)
+
+	position := offsets.fetched + 1
+	for offset := range offsets.pending {
+		if offset < position {
+			position = offset
+		}
+	}
+
+	if position <= offsets.committed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.mu.Unlock()
+
+	// Committing a message commits the offset after it, i.e. the next one to be read
+	if err := q.reader.CommitMessages(ctx, kafka.Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    position - 1,
+	}); err != nil {
+		return fmt.Errorf("failed to commit offset: %w", err)
+	}
+
+	q.mu.Lock()
+	offsets.committed = position
+	q.mu.Unlock()
+
+	return nil
+}
+
+// Close leaves the consumer group and closes the connections to Kafka. Called once the workers have
+// stopped, as they finish their requests through the queue.
+func (q *KafkaQueue) Close() error {
+	return errors.Join(q.reader.Close(), q.writer.Close())
+}
+
+// messageReadyAt returns when a retried request is due, if it has been given a retry delay
+func messageReadyAt(message kafka.Message) (time.Time, bool) {
+	for _, header := range message.Headers {
+		if header.Key != headerReadyAt {
+			continue
+		}
+
+		readyAt, err := strconv.ParseInt(string(header.Value), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		return time.UnixMilli(readyAt), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,372 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// A list queue leases each request it moves onto its processing list to the worker that dequeued
+// it. The worker renews its leases while it processes the requests, and once a lease expires, as
+// its worker died or lost its connection, any worker sharing the queue moves the request back onto
+// the pending list. Leases are kept in two keys alongside the processing list, see leaseKeys.
+
+// leaseKeys returns the sorted set of request IDs scored by the unix millisecond timestamp their
+// lease expires at, and the hash of request ID to the worker holding the lease, of a processing list
+func leaseKeys(processing string) (string, string) {
+	return processing + ":leases", processing + ":owners"
+}
+
+// attemptsKey returns the sorted set of request IDs on a processing list scored by the unix
+// millisecond timestamp their current attempt started at, kept if a visibility timeout is set.
+// Entries of requests no longer processing are pruned by the reaper rather than removed with them.
+func attemptsKey(processing string) string {
+	return processing + ":started"
+}
+
+// renewLeaseScript extends the leases still held by a worker.
+// KEYS[1] = lease expiry set, KEYS[2] = lease owners hash, ARGV[1] = owner,
+// ARGV[2] = new expiry timestamp, ARGV[3...] = request IDs.
+// Returns the request IDs whose lease the worker no longer holds.
+var renewLeaseScript = redis.NewScript(`
+local lost = {}
+for i = 3, #ARGV do
+	if redis.call('HGET', KEYS[2], ARGV[i]) == ARGV[1] then
+		redis.call('ZADD', KEYS[1], ARGV[2], ARGV[i])
+	else
+		table.insert(lost, ARGV[i])
+	end
+end
+return lost
+`)
+
+// leaseOrphanScript gives a request on the processing list a lease held by nobody, if it has none.
+// The request is checked to still be processing, as it may have been acknowledged since it was read.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = lease expiry set,
+// ARGV[1] = request ID, ARGV[2] = entry, ARGV[3] = expiry timestamp.
+// Returns 1 if the request was given a lease, 0 otherwise.
+var leaseOrphanScript = redis.NewScript(`
+if not redis.call('LPOS', KEYS[2], ARGV[2]) then
+	return 0
+end
+
+redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2])
+return redis.call('ZADD', KEYS[3], 'NX', ARGV[3], ARGV[1])
+`)
+
+// reclaimScript moves a request whose lease has expired from the processing list back onto a
+// pending list. The expiry is checked again, as the lease may have been renewed since it was read.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = lease expiry set,
+// KEYS[4] = lease owners hash, KEYS[5] = pending list, ARGV[1] = request ID,
+// ARGV[2] = current timestamp.
+// Returns 1 if the request was moved, 0 if its lease hasn't expired or it's no longer processing.
+var reclaimScript = redis.NewScript(`
+local expiry = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if not expiry or tonumber(expiry) > tonumber(ARGV[2]) then
+	return 0
+end
+
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+
+redis.call('LREM', KEYS[2], 1, raw)
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('LPUSH', KEYS[5], raw)
+return 1
+`)
+
+// reapScript moves a request whose attempt started before the cutoff from the processing list onto
+// a target list, with its lease. The start is checked again, as the request may have been handed
+// back and dequeued since it was read.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = lease expiry set,
+// KEYS[4] = lease owners hash, KEYS[5] = attempt start set, KEYS[6] = target list,
+// ARGV[1] = request ID, ARGV[2] = cutoff timestamp, ARGV[3] = updated payload.
+// Returns 1 if the request was moved, 0 if its attempt is recent enough or it's no longer processing.
+var reapScript = redis.NewScript(`
+local started = redis.call('ZSCORE', KEYS[5], ARGV[1])
+if not started or tonumber(started) > tonumber(ARGV[2]) then
+	return 0
+end
+
+redis.call('ZREM', KEYS[5], ARGV[1])
+
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+
+redis.call('LREM', KEYS[2], 1, raw)
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('LPUSH', KEYS[6], ARGV[3])
+return 1
+`)
+
+// maintainLeases renews the leases of requests this worker is processing and reclaims expired ones
+// every third of the lease duration, until ctx is cancelled
+func (q *ListQueue) maintainLeases(ctx context.Context) {
+	ticker := q.clock.NewTicker(q.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		// Bounded, so a connection that stops responding can't hold up renewals past the lease
+		passCtx, cancel := context.WithTimeout(ctx, q.leaseTTL/3)
+		q.renewLeases(passCtx)
+		q.leaseOrphans(passCtx)
+		q.reclaimExpired(passCtx)
+		q.reapTimedOut(passCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// acquireLease records that this worker holds the lease of a request it dequeued. Done in the same
+// step as indexing the request, so the request can be reclaimed by ID should the worker die.
+func (q *ListQueue) acquireLease(ctx context.Context, requestId int, rawData string) error {
+	leases, owners := leaseKeys(q.processing)
+	expiry := q.clock.Now().Add(q.leaseTTL).UnixMilli()
+
+	if _, err := q.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, q.processingItems, requestId, rawData)
+		pipe.HSet(ctx, owners, requestId, q.owner)
+		pipe.ZAdd(ctx, leases, redis.Z{Score: float64(expiry), Member: requestId})
+		if q.visibilityTimeout > 0 {
+			pipe.ZAdd(ctx, attemptsKey(q.processing), redis.Z{Score: float64(q.clock.Now().UnixMilli()), Member: requestId})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.held[requestId] = struct{}{}
+	q.mu.Unlock()
+
+	return nil
+}
+
+// dropLease forgets a request once this worker has handed it back to the queue
+func (q *ListQueue) dropLease(requestId int) {
+	q.mu.Lock()
+	delete(q.held, requestId)
+	q.mu.Unlock()
+}
+
+// renewLeases extends the leases of the requests this worker is processing. Requests whose lease
+// was reclaimed meanwhile are forgotten, as another worker may be processing them now.
+func (q *ListQueue) renewLeases(ctx context.Context) {
+	q.mu.Lock()
+	ids := make([]interface{}, 0, len(q.held))
+	for requestId := range q.held {
+		ids = append(ids, requestId)
+	}
+	q.mu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	leases, owners := leaseKeys(q.processing)
+	expiry := q.clock.Now().Add(q.leaseTTL).UnixMilli()
+
+	args := append([]interface{}{q.owner, expiry}, ids...)
+	lost, err := renewLeaseScript.Run(ctx, q.redisClient, []string{leases, owners}, args...).StringSlice()
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("Failed to renew GDPR request leases", zap.Error(err))
+		}
+		return
+	}
+
+	for _, rawId := range lost {
+		requestId, err := strconv.Atoi(rawId)
+		if err != nil {
+			continue
+		}
+
+		q.logger.Warn("Lost lease on GDPR request, another worker may process it again",
+			zap.Int("request_id", requestId),
+		)
+		q.dropLease(requestId)
+	}
+}
+
+// leaseOrphans gives a lease to requests on the processing list that have none, as they were
+// dequeued before leases were introduced or their worker died before acquiring it. Nobody holds
+// these leases, so the requests are reclaimed once they expire. Requests that turn out to be
+// dequeued by a live worker that hadn't acquired its lease yet are unaffected, as acquiring it
+// replaces the orphan's.
+func (q *ListQueue) leaseOrphans(ctx context.Context) {
+	items, err := q.redisClient.LRange(ctx, q.processing, 0, -1).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("Failed to read processing queue", zap.Error(err))
+		}
+		return
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	leases, _ := leaseKeys(q.processing)
+	expiry := q.clock.Now().Add(q.leaseTTL).UnixMilli()
+
+	for _, item := range items {
+		queued, err := decodeEntry(item)
+		if err != nil {
+			q.logger.Error("Failed to decode request in processing queue, removing it",
+				zap.Error(err),
+				zap.String("raw_data", item),
+			)
+			q.redisClient.LRem(ctx, q.processing, 1, item)
+			continue
+		}
+
+		leased, err := leaseOrphanScript.Run(ctx, q.redisClient, []string{q.processingItems, q.processing, leases},
+			queued.RequestID, item, expiry).Int()
+		if err != nil {
+			if ctx.Err() == nil {
+				q.logger.Error("Failed to lease orphaned GDPR request", zap.Error(err), zap.Int("request_id", queued.RequestID))
+			}
+			continue
+		}
+
+		if leased == 1 {
+			q.logger.Info("Leased orphaned GDPR request, it will be reclaimed unless its worker renews it",
+				zap.Int("request_id", queued.RequestID),
+			)
+		}
+	}
+}
+
+// reclaimExpired moves requests whose lease has expired back onto the pending list they were taken
+// from, without counting an attempt against them
+func (q *ListQueue) reclaimExpired(ctx context.Context) {
+	leases, owners := leaseKeys(q.processing)
+	now := q.clock.Now().UnixMilli()
+
+	expired, err := q.redisClient.ZRangeByScore(ctx, leases, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now, 10),
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("Failed to read expired GDPR request leases", zap.Error(err))
+		}
+		return
+	}
+
+	for _, rawId := range expired {
+		// The request's payload decides which pending list it goes back onto
+		target := q.pending
+		if item, err := q.redisClient.HGet(ctx, q.processingItems, rawId).Result(); err == nil {
+			if queued, err := decodeEntry(item); err == nil {
+				target = q.pendingFor(queued)
+			}
+		}
+
+		moved, err := reclaimScript.Run(ctx, q.redisClient,
+			[]string{q.processingItems, q.processing, leases, owners, target}, rawId, now).Int()
+		if err != nil {
+			if ctx.Err() == nil {
+				q.logger.Error("Failed to reclaim GDPR request", zap.Error(err), zap.String("request_id", rawId))
+			}
+			continue
+		}
+
+		if moved == 1 {
+			q.logger.Warn("Reclaimed GDPR request with an expired lease", zap.String("request_id", rawId))
+		}
+	}
+}
+
+// reapTimedOut takes requests back from workers whose attempt has gone on for longer than the
+// visibility timeout, even though the worker is still renewing the lease, as the attempt is assumed
+// to be hung. The attempt counts as failed, so a request that hangs every time ends up on the failed
+// list. The worker's own outcome for the attempt is discarded, as it no longer holds the lease.
+func (q *ListQueue) reapTimedOut(ctx context.Context) {
+	if q.visibilityTimeout <= 0 {
+		return
+	}
+
+	started := attemptsKey(q.processing)
+	leases, owners := leaseKeys(q.processing)
+	cutoff := q.clock.Now().Add(-q.visibilityTimeout).UnixMilli()
+
+	timedOut, err := q.redisClient.ZRangeByScore(ctx, started, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff, 10),
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error("Failed to read GDPR request attempt start times", zap.Error(err))
+		}
+		return
+	}
+
+	for _, rawId := range timedOut {
+		item, err := q.redisClient.HGet(ctx, q.processingItems, rawId).Result()
+		if err == redis.Nil {
+			// Handed back to the queue since, so there's nothing left to reap
+			q.redisClient.ZRem(ctx, started, rawId)
+			continue
+		} else if err != nil {
+			if ctx.Err() == nil {
+				q.logger.Error("Failed to read timed out GDPR request", zap.Error(err), zap.String("request_id", rawId))
+			}
+			continue
+		}
+
+		queued, err := decodeEntry(item)
+		if err != nil {
+			// Left for the orphan pass to remove
+			continue
+		}
+
+		queued.RetryCount++
+		target := q.pendingFor(queued)
+		if queued.RetryCount >= config.Conf.MaxRetries {
+			target = keyFailed
+		}
+
+		marshalled, err := json.Marshal(queued)
+		if err != nil {
+			q.logger.Error("Failed to marshal timed out GDPR request", zap.Error(err), zap.Int("request_id", queued.RequestID))
+			continue
+		}
+
+		moved, err := reapScript.Run(ctx, q.redisClient,
+			[]string{q.processingItems, q.processing, leases, owners, started, target}, rawId, cutoff, string(marshalled)).Int()
+		if err != nil {
+			if ctx.Err() == nil {
+				q.logger.Error("Failed to reap timed out GDPR request", zap.Error(err), zap.Int("request_id", queued.RequestID))
+			}
+			continue
+		}
+
+		if moved == 1 {
+			q.logger.Warn("Took back GDPR request that exceeded the visibility timeout",
+				zap.Int("request_id", queued.RequestID),
+				zap.Int("retry_count", queued.RetryCount),
+				zap.Bool("failed", target == keyFailed),
+				zap.Duration("visibility_timeout", q.visibilityTimeout),
+			)
+		}
+	}
+}
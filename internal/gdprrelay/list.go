@@ -0,0 +1,279 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ListQueue consumes requests from Redis lists, moving them between pending, processing and failed
+// lists with BLMOVE. This is the format producers push to by default. Requests on the pending list's
+// priority list, see gdpr.KeyPriorityFor, are taken first. Each request being processed is leased
+// to its worker, so several workers can share a single queue safely, see lease.go.
+type ListQueue struct {
+	redisClient    redis.UniversalClient
+	listenerClient redis.UniversalClient // Dedicated client for blocking reads
+	logger         *zap.Logger
+	clock          clock.Clock
+
+	pending         string
+	priority        string
+	processing      string
+	processingItems string
+
+	owner             string        // Name leases are held under, unique to this worker
+	leaseTTL          time.Duration // How long a lease lasts without being renewed
+	visibilityTimeout time.Duration // How long an attempt may hold a request before it's taken back, 0 for no limit
+
+	mu   sync.Mutex
+	held map[int]struct{} // IDs of the requests this worker holds the lease of
+}
+
+var _ Queue = (*ListQueue)(nil)
+
+func NewListQueue(
+	redisClient, listenerClient redis.UniversalClient,
+	owner string,
+	leaseTTL time.Duration,
+	logger *zap.Logger,
+) *ListQueue {
+	return &ListQueue{
+		redisClient:     redisClient,
+		listenerClient:  listenerClient,
+		logger:          logger,
+		clock:           clock.Real,
+		pending:         keyPending,
+		priority:        gdpr.KeyPriorityFor(keyPending),
+		processing:      keyProcessing,
+		processingItems: keyProcessingItems,
+		owner:           owner,
+		leaseTTL:        leaseTTL,
+		held:            make(map[int]struct{}),
+	}
+}
+
+// NewListQueueForType creates a queue consuming only the dedicated pending list of a request type,
+// see gdpr.KeyPendingFor. Requests that exhaust their retries still go to the shared failed list.
+func NewListQueueForType(
+	redisClient, listenerClient redis.UniversalClient,
+	requestType RequestType,
+	owner string,
+	leaseTTL time.Duration,
+	logger *zap.Logger,
+) *ListQueue {
+	q := NewListQueue(redisClient, listenerClient, owner, leaseTTL, logger)
+	q.pending = gdpr.KeyPendingFor(requestType)
+	q.priority = gdpr.KeyPriorityFor(q.pending)
+	q.processing = listProcessingKey(requestType)
+	q.processingItems = q.processing + ":items"
+	return q
+}
+
+// pendingFor returns the list a request is pushed back onto, the priority list if it's small enough
+// to have been queued there
+func (q *ListQueue) pendingFor(request QueuedRequest) string {
+	if request.Request.IsPriority() {
+		return q.priority
+	}
+
+	return q.pending
+}
+
+// listProcessingKey returns the processing list of a request type's dedicated queue
+func listProcessingKey(requestType RequestType) string {
+	return keyProcessing + ":" + strings.ToLower(requestType.String())
+}
+
+// SetVisibilityTimeout takes requests back from workers that have been processing them for longer
+// than timeout, renewed lease or not, 0 for no limit. It should be well above the request timeout,
+// as the worker isn't told and may finish the attempt regardless. Must be called before Listen.
+func (q *ListQueue) SetVisibilityTimeout(timeout time.Duration) {
+	q.visibilityTimeout = timeout
+}
+
+// SetClock replaces the clock retry delays, leases and attempt times are measured with
+func (q *ListQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Listen moves requests from the pending queue to the processing queue and sends them on ch,
+// one at a time as capacity frees up, until ctx is cancelled, after which ch is closed. Requests
+// whose lease expired, as the worker processing them died, are moved back to the pending queue.
+func (q *ListQueue) Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest) {
+	defer close(ch)
+
+	redisClient, logger := q.listenerClient, q.logger
+
+	go q.maintainLeases(ctx)
+	go promoteDelayed(ctx, q.redisClient, q.clock, promoteListScript, delayedKey(q.pending), q.pending, logger)
+	go promoteDelayed(ctx, q.redisClient, q.clock, promoteListScript, delayedKey(q.priority), q.priority, logger)
+
+	for ctx.Err() == nil {
+		if !capacity.AwaitSlot(ctx) {
+			return
+		}
+
+		rawData, err := q.dequeue(ctx)
+		if err != nil {
+			if err == redis.Nil || ctx.Err() != nil {
+				continue
+			}
+			logger.Error("Failed to read from GDPR queue",
+				zap.Error(err),
+				zap.String("key", q.pending),
+			)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		queued, err := decodeEntry(rawData)
+		if err != nil {
+			logger.Error("Invalid GDPR request, moving to failed queue",
+				zap.Error(err),
+				zap.String("raw_data", rawData),
+			)
+			redisClient.LPush(ctx, keyFailed, rawData)
+			redisClient.LRem(ctx, q.processing, 1, rawData)
+			continue
+		}
+
+		// Without a lease the request is treated as orphaned, and reclaimed once that lease expires
+		if err := q.acquireLease(ctx, queued.RequestID, rawData); err != nil {
+			logger.Error("Failed to lease GDPR request in processing queue",
+				zap.Error(err),
+				zap.Int("request_id", queued.RequestID),
+			)
+		}
+
+		queued.LastAttemptAt = q.clock.Now()
+		logDequeued(logger, queued)
+
+		ch <- queued
+	}
+}
+
+// dequeue moves the next request onto the processing list, from the priority list if it has any.
+// Only the pending list can be blocked on, so a priority request pushed while the pending list is
+// empty waits up to priorityPollTimeout to be noticed.
+func (q *ListQueue) dequeue(ctx context.Context) (string, error) {
+	// Bounded by a deadline as well as the server-side timeout, so a connection that stops
+	// responding can't keep the listener from noticing shutdown
+	readCtx, cancel := context.WithTimeout(ctx, priorityPollTimeout+listenReadGrace)
+	defer cancel()
+
+	rawData, err := q.listenerClient.LMove(readCtx, q.priority, q.processing, "RIGHT", "LEFT").Result()
+	if err != redis.Nil {
+		return rawData, err
+	}
+
+	return q.listenerClient.BLMove(readCtx, q.pending, q.processing, "RIGHT", "LEFT", priorityPollTimeout).Result()
+}
+
+func (q *ListQueue) Acknowledge(ctx context.Context, request QueuedRequest) error {
+	leases, owners := leaseKeys(q.processing)
+	removed, err := ackScript.Run(ctx, q.redisClient, []string{q.processingItems, q.processing, leases, owners}, request.RequestID, q.owner).Int()
+	if err != nil {
+		return fmt.Errorf("failed to remove from processing queue: %w", err)
+	}
+	q.dropLease(request.RequestID)
+
+	if removed == 0 {
+		q.logger.Warn("Request not found in processing queue for acknowledgment, or leased by another worker",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+		return nil
+	}
+
+	archiveAcknowledged(ctx, q.redisClient, q.pending, request, q.clock.Now(), q.logger)
+
+	return nil
+}
+
+func (q *ListQueue) Reject(ctx context.Context, request QueuedRequest) (bool, error) {
+	exhausted, delay := nextAttempt(&request, q.logger)
+
+	moved, err := q.reject(ctx, request, exhausted, delay)
+	return moved && exhausted, err
+}
+
+func (q *ListQueue) Fail(ctx context.Context, request QueuedRequest) error {
+	logPermanentFailure(q.logger, request)
+
+	_, err := q.reject(ctx, request, true, 0)
+	return err
+}
+
+// reject moves the request out of the processing queue, back onto its pending list once delay has
+// passed, or onto the failed list if failed is set. Returns false if the request wasn't found.
+func (q *ListQueue) reject(ctx context.Context, request QueuedRequest, failed bool, delay time.Duration) (bool, error) {
+	target := q.pendingFor(request)
+	if failed {
+		target = keyFailed
+	}
+
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	leases, owners := leaseKeys(q.processing)
+
+	var moved int
+	if !failed && delay > 0 {
+		moved, err = rejectDelayedScript.Run(ctx, q.redisClient, []string{q.processingItems, q.processing, delayedKey(target), leases, owners},
+			request.RequestID, string(marshalled), readyAt(q.clock, delay), q.owner).Int()
+	} else {
+		moved, err = rejectScript.Run(ctx, q.redisClient, []string{q.processingItems, q.processing, target, leases, owners},
+			request.RequestID, string(marshalled), q.owner).Int()
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to move request out of processing queue: %w", err)
+	}
+	q.dropLease(request.RequestID)
+
+	if moved == 0 {
+		q.logger.Warn("Request not found in processing queue for rejection, or leased by another worker",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (q *ListQueue) Requeue(ctx context.Context, request QueuedRequest) error {
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	leases, owners := leaseKeys(q.processing)
+	moved, err := rejectScript.Run(ctx, q.redisClient, []string{q.processingItems, q.processing, q.pendingFor(request), leases, owners},
+		request.RequestID, string(marshalled), q.owner).Int()
+	if err != nil {
+		return fmt.Errorf("failed to move request out of processing queue: %w", err)
+	}
+	q.dropLease(request.RequestID)
+
+	if moved == 0 {
+		q.logger.Warn("Request not found in processing queue for requeue, or leased by another worker",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.Int("request_id", request.RequestID),
+		)
+	}
+
+	return nil
+}
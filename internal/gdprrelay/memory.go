@@ -0,0 +1,203 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// DefaultMemoryQueueSize is how many pending requests a MemoryQueue holds before Enqueue blocks
+const DefaultMemoryQueueSize = 1024
+
+// MemoryQueue keeps requests in process on a channel, for local development and integration tests
+// that run without Redis. Nothing survives a restart, so it must never be used in production.
+type MemoryQueue struct {
+	logger  *zap.Logger
+	clock   clock.Clock
+	pending chan QueuedRequest
+
+	mu         sync.Mutex
+	processing map[int]QueuedRequest
+	failed     []QueuedRequest
+}
+
+var _ Queue = (*MemoryQueue)(nil)
+
+func NewMemoryQueue(size int, logger *zap.Logger) *MemoryQueue {
+	if size <= 0 {
+		size = DefaultMemoryQueueSize
+	}
+
+	return &MemoryQueue{
+		logger:     logger,
+		clock:      clock.Real,
+		pending:    make(chan QueuedRequest, size),
+		processing: make(map[int]QueuedRequest),
+	}
+}
+
+// SetClock replaces the clock retry delays and attempt times are measured with
+func (q *MemoryQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Enqueue adds a request to the queue, as a producer would. It blocks while the queue is full, until
+// ctx is cancelled.
+func (q *MemoryQueue) Enqueue(ctx context.Context, request QueuedRequest) (QueuedRequest, error) {
+	if !request.IsSupported() {
+		return request, fmt.Errorf("unsupported schema version %d, supported up to %d", request.Version, gdpr.SchemaVersion)
+	}
+
+	if request.QueuedAt.IsZero() {
+		request.QueuedAt = q.clock.Now()
+	}
+
+	if request.RequestID == 0 {
+		request.RequestID = request.DeriveRequestID()
+	}
+
+	request.Request.Normalize()
+
+	select {
+	case q.pending <- request:
+		return request, nil
+	case <-ctx.Done():
+		return request, ctx.Err()
+	}
+}
+
+// Listen sends requests on ch as they are enqueued, until ctx is cancelled, after which ch is closed
+func (q *MemoryQueue) Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest) {
+	defer close(ch)
+
+	for {
+		if !capacity.AwaitSlot(ctx) {
+			return
+		}
+
+		var queued QueuedRequest
+		select {
+		case <-ctx.Done():
+			return
+		case queued = <-q.pending:
+		}
+
+		q.mu.Lock()
+		q.processing[queued.RequestID] = queued
+		q.mu.Unlock()
+
+		queued.LastAttemptAt = q.clock.Now()
+		logDequeued(q.logger, queued)
+
+		ch <- queued
+	}
+}
+
+func (q *MemoryQueue) Acknowledge(_ context.Context, request QueuedRequest) error {
+	if !q.remove(request.RequestID) {
+		q.logger.Warn("Request not found in processing queue for acknowledgment",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+	}
+
+	return nil
+}
+
+func (q *MemoryQueue) Reject(_ context.Context, request QueuedRequest) (bool, error) {
+	if !q.remove(request.RequestID) {
+		q.logger.Warn("Request not found in processing queue for rejection",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+		return false, nil
+	}
+
+	exhausted, delay := nextAttempt(&request, q.logger)
+	if exhausted {
+		q.mu.Lock()
+		q.failed = append(q.failed, request)
+		q.mu.Unlock()
+		return true, nil
+	}
+
+	go func() {
+		<-q.clock.After(delay)
+		q.pending <- request
+	}()
+
+	return false, nil
+}
+
+func (q *MemoryQueue) Fail(_ context.Context, request QueuedRequest) error {
+	if !q.remove(request.RequestID) {
+		q.logger.Warn("Request not found in processing queue for failure",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+		return nil
+	}
+
+	logPermanentFailure(q.logger, request)
+
+	q.mu.Lock()
+	q.failed = append(q.failed, request)
+	q.mu.Unlock()
+
+	return nil
+}
+
+func (q *MemoryQueue) Requeue(_ context.Context, request QueuedRequest) error {
+	if !q.remove(request.RequestID) {
+		q.logger.Warn("Request not found in processing queue for requeue",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.Int("request_id", request.RequestID),
+		)
+		return nil
+	}
+
+	// Sent asynchronously as the queue may be full, and the listener may already have stopped
+	go func() {
+		q.pending <- request
+	}()
+
+	return nil
+}
+
+// Failed returns the requests that exhausted their retries
+func (q *MemoryQueue) Failed() []QueuedRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	failed := make([]QueuedRequest, len(q.failed))
+	copy(failed, q.failed)
+	return failed
+}
+
+// Processing returns how many requests have been handed to the worker and not yet finished
+func (q *MemoryQueue) Processing() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.processing)
+}
+
+func (q *MemoryQueue) remove(requestId int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.processing[requestId]; !ok {
+		return false
+	}
+
+	delete(q.processing, requestId)
+	return true
+}
@@ -0,0 +1,474 @@
+package gdprrelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyNamespace is the prefix every queue key is under unless the queue has been migrated elsewhere
+const keyNamespace = "tickets:gdpr"
+
+// migrateAttempts bounds how often a move is retried when its keys change while being read
+const migrateAttempts = 5
+
+// MigrationLayout describes how a generation of the queue is stored: the backend its entries are
+// in and the namespace its keys are under
+type MigrationLayout struct {
+	Stream    bool   // Entries are stream entries rather than list items
+	Group     string // Consumer group of the streams, whose pending entries are acknowledged as they move
+	Namespace string // Prefix of the queue keys, tickets:gdpr if empty
+}
+
+func (l MigrationLayout) namespace() string {
+	if l.Namespace == "" {
+		return keyNamespace
+	}
+
+	return l.Namespace
+}
+
+// key returns where a key of the default namespace lives in this layout
+func (l MigrationLayout) key(key string) string {
+	return l.namespace() + strings.TrimPrefix(key, keyNamespace)
+}
+
+// queueKey returns the list or stream requests waiting to be consumed are kept in, the shared one
+// if requestType is nil or the type's dedicated one otherwise
+func (l MigrationLayout) queueKey(requestType *RequestType) string {
+	switch {
+	case l.Stream && requestType != nil:
+		return l.key(gdpr.KeyStreamFor(*requestType))
+	case l.Stream:
+		return l.key(keyStream)
+	case requestType != nil:
+		return l.key(gdpr.KeyPendingFor(*requestType))
+	default:
+		return l.key(keyPending)
+	}
+}
+
+// priorityKey returns where requests on a list queue's priority list go in this layout: its own
+// priority list, or the stream itself as streams have none
+func (l MigrationLayout) priorityKey(requestType *RequestType) string {
+	if l.Stream {
+		return l.queueKey(requestType)
+	}
+
+	return gdpr.KeyPriorityFor(l.queueKey(requestType))
+}
+
+// processingKeys returns the processing list and its items hash, for the list backend
+func (l MigrationLayout) processingKeys(requestType *RequestType) (string, string) {
+	if requestType != nil {
+		processing := l.key(listProcessingKey(*requestType))
+		return processing, processing + ":items"
+	}
+
+	return l.key(keyProcessing), l.key(keyProcessingItems)
+}
+
+// MigrationResult counts the entries moved out of one source key
+type MigrationResult struct {
+	Source      string
+	Destination string
+	Found       int // Entries in the source when it was read
+	Moved       int // Entries written to the destination and removed from the source
+}
+
+// MigrateQueue moves every waiting, in-flight, delayed and failed request from one queue layout to
+// another, converting between list items and stream entries as needed. Each source is moved in a
+// single transaction that is retried if it changes while being read, so requests pushed by
+// producers during the migration are neither lost nor duplicated, and the length of every
+// destination is checked against the number of entries written. In-flight requests are moved back
+// to waiting, so no worker may be consuming the source. With dryRun set the sources are only
+// counted.
+func MigrateQueue(ctx context.Context, redisClient redis.UniversalClient, from, to MigrationLayout, dryRun bool) ([]MigrationResult, error) {
+	if from.Stream == to.Stream && from.namespace() == to.namespace() {
+		return nil, errors.New("source and destination layouts are the same")
+	}
+
+	requestTypes := []*RequestType{nil}
+	for t := RequestTypeAllTranscripts; t <= RequestTypeFeedback; t++ {
+		requestType := t
+		requestTypes = append(requestTypes, &requestType)
+	}
+
+	var results []MigrationResult
+	add := func(result []MigrationResult, err error) error {
+		for _, r := range result {
+			if r.Found > 0 {
+				results = append(results, r)
+			}
+		}
+
+		return err
+	}
+
+	for _, requestType := range requestTypes {
+		if err := add(migrateWaiting(ctx, redisClient, from, to, requestType, dryRun)); err != nil {
+			return results, err
+		}
+
+		source, destination := delayedKey(from.queueKey(requestType)), delayedKey(to.queueKey(requestType))
+		if err := add(migrateDelayed(ctx, redisClient, source, destination, dryRun)); err != nil {
+			return results, err
+		}
+
+		if from.Stream {
+			continue
+		}
+
+		source, destination = from.priorityKey(requestType), to.priorityKey(requestType)
+		if err := add(migratePriority(ctx, redisClient, source, destination, to.Stream, dryRun)); err != nil {
+			return results, err
+		}
+
+		if err := add(migrateDelayed(ctx, redisClient, delayedKey(source), delayedKey(destination), dryRun)); err != nil {
+			return results, err
+		}
+	}
+
+	// Both backends share the failed list, so it only moves with the namespace
+	if from.namespace() != to.namespace() {
+		if err := add(migrateFailed(ctx, redisClient, from.key(keyFailed), to.key(keyFailed), dryRun)); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// migrateWaiting moves the requests waiting on, or being processed from, a queue. In-flight
+// requests were dequeued first, so they are placed ahead of the waiting ones.
+func migrateWaiting(ctx context.Context, redisClient redis.UniversalClient, from, to MigrationLayout, requestType *RequestType, dryRun bool) ([]MigrationResult, error) {
+	source, destination := from.queueKey(requestType), to.queueKey(requestType)
+	processing, processingItems := from.processingKeys(requestType)
+
+	leases, owners := leaseKeys(processing)
+
+	keys := []string{source, destination}
+	if !from.Stream {
+		keys = append(keys, processing, processingItems, leases, owners, attemptsKey(processing))
+	}
+
+	var results []MigrationResult
+	err := watchMove(ctx, redisClient, keys, func(tx *redis.Tx) error {
+		results = nil
+
+		var (
+			entries     []string // Oldest first
+			entryIds    []string // Of the entries, for a stream source
+			acknowledge bool     // Whether the stream has the consumer group the entries are pending in
+		)
+		if from.Stream {
+			messages, err := tx.XRange(ctx, source, "-", "+").Result()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", source, err)
+			}
+
+			// Entries are only pending in the group if it exists, acknowledging them otherwise fails
+			if from.Group != "" {
+				err := tx.XPending(ctx, source, from.Group).Err()
+				if err != nil && !isNoGroupError(err) {
+					return fmt.Errorf("failed to read consumer group of %s: %w", source, err)
+				}
+
+				acknowledge = err == nil
+			}
+
+			// Entries without a request can't be moved and are left for an operator to look at
+			for _, message := range messages {
+				if raw, ok := message.Values[gdpr.StreamField].(string); ok {
+					entries = append(entries, raw)
+					entryIds = append(entryIds, message.ID)
+				}
+			}
+
+			results = append(results, MigrationResult{Source: source, Destination: destination, Found: len(messages)})
+		} else {
+			inFlight, err := tx.LRange(ctx, processing, 0, -1).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", processing, err)
+			}
+
+			waiting, err := tx.LRange(ctx, source, 0, -1).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", source, err)
+			}
+
+			// Lists are pushed on the left and consumed from the right
+			slices.Reverse(inFlight)
+			slices.Reverse(waiting)
+			entries = append(inFlight, waiting...)
+
+			results = append(results,
+				MigrationResult{Source: processing, Destination: destination, Found: len(inFlight)},
+				MigrationResult{Source: source, Destination: destination, Found: len(waiting)},
+			)
+		}
+
+		if dryRun || len(entries) == 0 {
+			return nil
+		}
+
+		before, err := queueLength(ctx, tx, destination, to.Stream)
+		if err != nil {
+			return err
+		}
+
+		var deleted, length *redis.IntCmd
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			writeEntries(ctx, pipe, destination, to.Stream, entries)
+			length = lengthCmd(ctx, pipe, destination, to.Stream)
+
+			if from.Stream {
+				if acknowledge {
+					pipe.XAck(ctx, source, from.Group, entryIds...)
+				}
+				deleted = pipe.XDel(ctx, source, entryIds...)
+			} else {
+				pipe.Del(ctx, processing, processingItems, leases, owners, attemptsKey(processing), source)
+			}
+
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", source, destination, err)
+		}
+
+		if deleted != nil && deleted.Val() != int64(len(entryIds)) {
+			return fmt.Errorf("removed %d of %d entries from %s", deleted.Val(), len(entryIds), source)
+		}
+
+		if err := checkLength(destination, length, before+int64(len(entries))); err != nil {
+			return err
+		}
+
+		for i := range results {
+			results[i].Moved = results[i].Found
+		}
+		if from.Stream {
+			results[0].Moved = len(entryIds)
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// migratePriority moves the requests waiting on a list queue's priority list. They were never
+// dequeued, so there's no processing list to move with them.
+func migratePriority(ctx context.Context, redisClient redis.UniversalClient, source, destination string, toStream, dryRun bool) ([]MigrationResult, error) {
+	var result MigrationResult
+	err := watchMove(ctx, redisClient, []string{source, destination}, func(tx *redis.Tx) error {
+		entries, err := tx.LRange(ctx, source, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", source, err)
+		}
+
+		result = MigrationResult{Source: source, Destination: destination, Found: len(entries)}
+		if dryRun || len(entries) == 0 {
+			return nil
+		}
+
+		// Lists are pushed on the left and consumed from the right
+		slices.Reverse(entries)
+
+		before, err := queueLength(ctx, tx, destination, toStream)
+		if err != nil {
+			return err
+		}
+
+		var length *redis.IntCmd
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			writeEntries(ctx, pipe, destination, toStream, entries)
+			length = lengthCmd(ctx, pipe, destination, toStream)
+			pipe.Del(ctx, source)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", source, destination, err)
+		}
+
+		if err := checkLength(destination, length, before+int64(len(entries))); err != nil {
+			return err
+		}
+
+		result.Moved = len(entries)
+		return nil
+	})
+
+	return []MigrationResult{result}, err
+}
+
+// migrateDelayed moves the requests waiting to be retried, keeping when they become ready. The
+// entries are stored the same way for both backends.
+func migrateDelayed(ctx context.Context, redisClient redis.UniversalClient, source, destination string, dryRun bool) ([]MigrationResult, error) {
+	var result MigrationResult
+	err := watchMove(ctx, redisClient, []string{source, destination}, func(tx *redis.Tx) error {
+		entries, err := tx.ZRangeWithScores(ctx, source, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", source, err)
+		}
+
+		result = MigrationResult{Source: source, Destination: destination, Found: len(entries)}
+		if dryRun || len(entries) == 0 {
+			return nil
+		}
+
+		before, err := tx.ZCard(ctx, destination).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", destination, err)
+		}
+
+		var length *redis.IntCmd
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, destination, entries...)
+			pipe.Del(ctx, source)
+			length = pipe.ZCard(ctx, destination)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", source, destination, err)
+		}
+
+		after := length.Val()
+
+		// An identical entry already in the destination is the same request delayed twice
+		if after < before || after > before+int64(len(entries)) {
+			return fmt.Errorf("%s has %d entries after adding %d to %d", destination, after, len(entries), before)
+		}
+
+		result.Moved = len(entries)
+		return nil
+	})
+
+	return []MigrationResult{result}, err
+}
+
+// migrateFailed appends the failed requests of one namespace to the older end of another's
+func migrateFailed(ctx context.Context, redisClient redis.UniversalClient, source, destination string, dryRun bool) ([]MigrationResult, error) {
+	var result MigrationResult
+	err := watchMove(ctx, redisClient, []string{source, destination}, func(tx *redis.Tx) error {
+		entries, err := tx.LRange(ctx, source, 0, -1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", source, err)
+		}
+
+		result = MigrationResult{Source: source, Destination: destination, Found: len(entries)}
+		if dryRun || len(entries) == 0 {
+			return nil
+		}
+
+		values := make([]interface{}, len(entries))
+		for i, entry := range entries {
+			values[i] = entry
+		}
+
+		before, err := tx.LLen(ctx, destination).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", destination, err)
+		}
+
+		var length *redis.IntCmd
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			length = pipe.RPush(ctx, destination, values...)
+			pipe.Del(ctx, source)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", source, destination, err)
+		}
+
+		if err := checkLength(destination, length, before+int64(len(entries))); err != nil {
+			return err
+		}
+
+		result.Moved = len(entries)
+		return nil
+	})
+
+	return []MigrationResult{result}, err
+}
+
+// watchMove runs fn with keys watched, retrying if any of them changes before fn's transaction
+// is executed
+func watchMove(ctx context.Context, redisClient redis.UniversalClient, keys []string, fn func(tx *redis.Tx) error) error {
+	// A cluster client picks the node to watch on before hooks see the keys, so they're renamed here
+	if _, ok := redisClient.(*redis.ClusterClient); ok {
+		renamed := make([]string, len(keys))
+		for i, key := range keys {
+			renamed[i] = ClusterKey(key)
+		}
+		keys = renamed
+	}
+
+	for attempt := 0; attempt < migrateAttempts; attempt++ {
+		err := redisClient.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%s kept changing while being migrated, try again once producers are quieter", keys[0])
+}
+
+// writeEntries adds entries, oldest first, so that they are consumed before anything already in
+// the destination
+func writeEntries(ctx context.Context, pipe redis.Pipeliner, destination string, stream bool, entries []string) {
+	if stream {
+		for _, entry := range entries {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: destination,
+				Values: map[string]interface{}{gdpr.StreamField: entry},
+			})
+		}
+		return
+	}
+
+	// Consumed from the right, so the oldest entry goes last
+	values := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		values[len(entries)-1-i] = entry
+	}
+	pipe.RPush(ctx, destination, values...)
+}
+
+func queueLength(ctx context.Context, redisClient redis.Cmdable, key string, stream bool) (int64, error) {
+	var (
+		length int64
+		err    error
+	)
+	if stream {
+		length, err = redisClient.XLen(ctx, key).Result()
+	} else {
+		length, err = redisClient.LLen(ctx, key).Result()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	return length, nil
+}
+
+// lengthCmd queues a read of the length of a list or stream
+func lengthCmd(ctx context.Context, pipe redis.Pipeliner, key string, stream bool) *redis.IntCmd {
+	if stream {
+		return pipe.XLen(ctx, key)
+	}
+
+	return pipe.LLen(ctx, key)
+}
+
+// checkLength compares the length of a destination, read in the same transaction as the write,
+// with the length it should have
+func checkLength(key string, length *redis.IntCmd, expected int64) error {
+	if length.Val() != expected {
+		return fmt.Errorf("%s has %d entries after migrating, expected %d", key, length.Val(), expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// PostgresQueue consumes requests from the gdpr_queue table, for minimal deployments without a
+// reliable Redis. Requests are claimed with FOR UPDATE SKIP LOCKED under a lease this worker renews
+// while processing them, so several workers can share the table and requests held by a worker that
+// died are claimed again once their lease runs out. Inserts wake listeners through LISTEN/NOTIFY,
+// and producers can enqueue in the same transaction that records the request.
+type PostgresQueue struct {
+	table       *database.RequestQueueTable
+	redisClient redis.UniversalClient // For the archive of acknowledged requests
+	logger      *zap.Logger
+	clock       clock.Clock
+
+	owner    string
+	leaseTTL time.Duration
+
+	mu       sync.Mutex
+	inFlight map[int]int64 // Request ID -> row ID, for requests currently being processed
+
+	wake chan struct{}
+}
+
+var _ Queue = (*PostgresQueue)(nil)
+
+func NewPostgresQueue(
+	table *database.RequestQueueTable,
+	redisClient redis.UniversalClient,
+	owner string,
+	leaseTTL time.Duration,
+	logger *zap.Logger,
+) *PostgresQueue {
+	return &PostgresQueue{
+		table:       table,
+		redisClient: redisClient,
+		logger:      logger,
+		clock:       clock.Real,
+		owner:       owner,
+		leaseTTL:    leaseTTL,
+		inFlight:    make(map[int]int64),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// SetClock replaces the clock retry delays, leases and attempt times are measured with
+func (q *PostgresQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Enqueue inserts a request into the table, as a producer would
+func (q *PostgresQueue) Enqueue(ctx context.Context, request QueuedRequest) (QueuedRequest, error) {
+	if !request.IsSupported() {
+		return request, fmt.Errorf("unsupported schema version %d, supported up to %d", request.Version, gdpr.SchemaVersion)
+	}
+
+	if request.QueuedAt.IsZero() {
+		request.QueuedAt = q.clock.Now()
+	}
+
+	if request.RequestID == 0 {
+		request.RequestID = request.DeriveRequestID()
+	}
+
+	request.Request.Normalize()
+
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return request, fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	if err := q.table.Enqueue(ctx, request.RequestID, marshalled, q.clock.Now()); err != nil {
+		return request, fmt.Errorf("failed to insert request: %w", err)
+	}
+
+	return request, nil
+}
+
+// Listen claims due requests from the table and sends them on ch, one at a time as capacity frees
+// up, until ctx is cancelled, after which ch is closed. The table is checked again as soon as a
+// request is inserted, and otherwise every poll interval, which picks up retries once they're due.
+func (q *PostgresQueue) Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest) {
+	defer close(ch)
+
+	go q.listenNotifications(ctx)
+	go q.maintainLeases(ctx)
+
+	for ctx.Err() == nil {
+		if !capacity.AwaitSlot(ctx) {
+			return
+		}
+
+		now := q.clock.Now()
+		entry, ok, err := q.table.Claim(ctx, q.owner, now, now.Add(q.leaseTTL))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			q.logger.Error("Failed to claim from GDPR queue table", zap.Error(err))
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			case <-q.clock.After(listenPollTimeout):
+			}
+			continue
+		}
+
+		q.dispatch(ctx, ch, entry)
+	}
+}
+
+// dispatch decodes a claimed row and sends it on ch. Rows that can't be decoded are marked failed,
+// and rows duplicating a request that is already being processed are deleted.
+func (q *PostgresQueue) dispatch(ctx context.Context, ch chan QueuedRequest, entry database.QueueEntry) {
+	queued, err := decodeEntry(string(entry.Payload))
+	if err != nil {
+		q.logger.Error("Invalid GDPR request, marking as failed",
+			zap.Error(err),
+			zap.Int64("row_id", entry.Id),
+			zap.String("raw_data", string(entry.Payload)),
+		)
+
+		if _, err := q.table.MarkFailed(ctx, q.owner, entry.Id, entry.Payload, err.Error(), q.clock.Now()); err != nil {
+			q.logger.Error("Failed to mark invalid GDPR request as failed", zap.Error(err))
+		}
+		return
+	}
+
+	q.mu.Lock()
+	if existing, ok := q.inFlight[queued.RequestID]; ok {
+		q.mu.Unlock()
+
+		// Our own lease lapsed and the claim handed the row back to us, which renews the lease. The
+		// attempt is still running and will acknowledge or release the row when done.
+		if existing == entry.Id {
+			q.logger.Warn("Reclaimed request whose lease lapsed while processing",
+				zap.Int("request_id", queued.RequestID),
+				zap.Int64("row_id", entry.Id),
+			)
+			return
+		}
+
+		q.logger.Warn("Request is already being processed, deleting duplicate row",
+			zap.Int("request_id", queued.RequestID),
+			zap.Int64("row_id", entry.Id),
+			zap.Int64("processing_row_id", existing),
+		)
+
+		if _, err := q.table.Acknowledge(ctx, q.owner, entry.Id); err != nil {
+			q.logger.Error("Failed to delete duplicate GDPR request row", zap.Error(err))
+		}
+		return
+	}
+	q.inFlight[queued.RequestID] = entry.Id
+	q.mu.Unlock()
+
+	queued.LastAttemptAt = q.clock.Now()
+	logDequeued(q.logger, queued)
+
+	ch <- queued
+}
+
+// listenNotifications wakes the listener whenever a request is inserted, until ctx is cancelled.
+// Requests are still found by polling while the notification connection is down.
+func (q *PostgresQueue) listenNotifications(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := q.table.Listen(ctx, func() {
+			select {
+			case q.wake <- struct{}{}:
+			default:
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		q.logger.Warn("Lost GDPR queue notification connection, reconnecting", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.clock.After(5 * time.Second):
+		}
+	}
+}
+
+// maintainLeases renews the leases of the requests this worker is processing every third of the
+// lease duration, until ctx is cancelled
+func (q *PostgresQueue) maintainLeases(ctx context.Context) {
+	ticker := q.clock.NewTicker(q.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		q.mu.Lock()
+		ids := make([]int64, 0, len(q.inFlight))
+		for _, id := range q.inFlight {
+			ids = append(ids, id)
+		}
+		q.mu.Unlock()
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		// Bounded, so a connection that stops responding can't hold up renewals past the lease
+		renewCtx, cancel := context.WithTimeout(ctx, q.leaseTTL/3)
+		if err := q.table.RenewLeases(renewCtx, q.owner, ids, q.clock.Now().Add(q.leaseTTL)); err != nil && ctx.Err() == nil {
+			q.logger.Error("Failed to renew GDPR queue leases", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+func (q *PostgresQueue) Acknowledge(ctx context.Context, request QueuedRequest) error {
+	id, ok := q.takeRow(request)
+	if !ok {
+		return nil
+	}
+
+	held, err := q.table.Acknowledge(ctx, q.owner, id)
+	if err != nil {
+		// Kept, so the acknowledgement can be tried again once the database is reachable
+		q.mu.Lock()
+		q.inFlight[request.RequestID] = id
+		q.mu.Unlock()
+
+		return fmt.Errorf("failed to delete queue row: %w", err)
+	}
+
+	if !held {
+		q.logLeaseLost(request, "acknowledgement")
+		return nil
+	}
+
+	archiveAcknowledged(ctx, q.redisClient, "gdpr_queue", request, q.clock.Now(), q.logger)
+
+	return nil
+}
+
+func (q *PostgresQueue) Reject(ctx context.Context, request QueuedRequest) (bool, error) {
+	exhausted, delay := nextAttempt(&request, q.logger)
+	if err := q.release(ctx, request, exhausted, delay); err != nil {
+		return false, err
+	}
+
+	return exhausted, nil
+}
+
+func (q *PostgresQueue) Fail(ctx context.Context, request QueuedRequest) error {
+	logPermanentFailure(q.logger, request)
+
+	return q.release(ctx, request, true, 0)
+}
+
+func (q *PostgresQueue) Requeue(ctx context.Context, request QueuedRequest) error {
+	return q.release(ctx, request, false, 0)
+}
+
+// release hands the request's row back to the queue, due again once delay has passed, or marks it
+// failed if failed is set
+func (q *PostgresQueue) release(ctx context.Context, request QueuedRequest, failed bool, delay time.Duration) error {
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	id, ok := q.takeRow(request)
+	if !ok {
+		return nil
+	}
+
+	var held bool
+	if failed {
+		held, err = q.table.MarkFailed(ctx, q.owner, id, marshalled, request.LastError, q.clock.Now())
+	} else {
+		held, err = q.table.Release(ctx, q.owner, id, marshalled, request.LastError, q.clock.Now().Add(delay))
+	}
+	if err != nil {
+		q.mu.Lock()
+		q.inFlight[request.RequestID] = id
+		q.mu.Unlock()
+
+		return fmt.Errorf("failed to update queue row: %w", err)
+	}
+
+	if !held {
+		q.logLeaseLost(request, "release")
+	}
+
+	return nil
+}
+
+// takeRow removes and returns the row ID of an in-flight request
+func (q *PostgresQueue) takeRow(request QueuedRequest) (int64, bool) {
+	q.mu.Lock()
+	id, ok := q.inFlight[request.RequestID]
+	delete(q.inFlight, request.RequestID)
+	q.mu.Unlock()
+
+	if !ok {
+		q.logger.Warn("Request not found in GDPR queue table claims",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+	}
+
+	return id, ok
+}
+
+// logLeaseLost reports a request whose lease ran out while it was processed, so another worker may
+// have claimed it meanwhile
+func (q *PostgresQueue) logLeaseLost(request QueuedRequest, operation string) {
+	q.logger.Warn("Lease on GDPR request was lost before "+operation+", another worker may have claimed it",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+		zap.Int("request_id", request.RequestID),
+	)
+}
@@ -0,0 +1,103 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// Producer pushes requests onto the Redis queues the same way the bot and dashboard do, for
+// requests the worker schedules itself
+type Producer struct {
+	redisClient redis.UniversalClient
+	useStream   bool
+	dedicated   []RequestType // Types consumed from a dedicated queue rather than the shared one
+	clock       clock.Clock
+	states      *StateStore // Records requests as queued, nil if disabled
+}
+
+func NewProducer(redisClient redis.UniversalClient, useStream bool, dedicated []RequestType) *Producer {
+	return &Producer{
+		redisClient: redisClient,
+		useStream:   useStream,
+		dedicated:   dedicated,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock queue times are taken from
+func (p *Producer) SetClock(clk clock.Clock) {
+	p.clock = clk
+}
+
+// SetStates records every request pushed as queued, for status queries
+func (p *Producer) SetStates(states *StateStore) {
+	p.states = states
+}
+
+// Enqueue pushes a request onto the queue its type is consumed from, assigning a queue time and
+// derived request ID if it has none. On lists, requests that are quick to process go on the
+// priority list, see gdpr.Request.IsPriority.
+func (p *Producer) Enqueue(ctx context.Context, request QueuedRequest) (QueuedRequest, error) {
+	if !request.IsSupported() {
+		return request, fmt.Errorf("unsupported schema version %d, supported up to %d", request.Version, gdpr.SchemaVersion)
+	}
+
+	if request.QueuedAt.IsZero() {
+		request.QueuedAt = p.clock.Now()
+	}
+
+	if request.RequestID == 0 {
+		request.RequestID = request.DeriveRequestID()
+	}
+
+	request.Request.Normalize()
+
+	marshalled, err := request.Marshal()
+	if err != nil {
+		return request, fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	// Saved before pushing, so it can't overwrite the stage of a worker that picks the request up
+	// straight away. The state is only informational, so the request is pushed even if it fails.
+	if p.states != nil {
+		_ = p.states.SetState(ctx, gdpr.RequestState{
+			RequestId: request.RequestID,
+			Stage:     gdpr.StageQueued,
+			Attempt:   request.RetryCount + 1,
+			UpdatedAt: request.QueuedAt,
+		})
+	}
+
+	dedicated := slices.Contains(p.dedicated, request.Request.Type)
+	if p.useStream {
+		stream := keyStream
+		if dedicated {
+			stream = gdpr.KeyStreamFor(request.Request.Type)
+		}
+
+		err = p.redisClient.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: map[string]interface{}{gdpr.StreamField: string(marshalled)},
+		}).Err()
+	} else {
+		pending := keyPending
+		if dedicated {
+			pending = gdpr.KeyPendingFor(request.Request.Type)
+		}
+		if request.Request.IsPriority() {
+			pending = gdpr.KeyPriorityFor(pending)
+		}
+
+		err = p.redisClient.LPush(ctx, pending, string(marshalled)).Err()
+	}
+	if err != nil {
+		return request, fmt.Errorf("failed to push request onto queue: %w", err)
+	}
+
+	return request, nil
+}
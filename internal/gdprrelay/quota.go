@@ -0,0 +1,53 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyQuotaPrefix prefixes the Redis sorted set of each user's recently admitted requests, scored by
+// when they were admitted. Keyed by scrambled user ID, so the key doesn't expose the user.
+const keyQuotaPrefix = "tickets:gdpr:quota:"
+
+// admitScript admits a request if the user has fewer than the limit admitted within the window.
+// KEYS[1] = quota set, ARGV[1] = request ID, ARGV[2] = now in ms, ARGV[3] = window in ms, ARGV[4] = limit.
+// Returns 0 if the request was admitted, now or on an earlier delivery, otherwise when in ms the
+// oldest request in the window leaves it.
+var admitScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', tonumber(ARGV[2]) - tonumber(ARGV[3]))
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return tonumber(oldest[2]) + tonumber(ARGV[3])
+end
+
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 0
+`)
+
+// AdmitRequest counts a request against its user's quota of limit requests per window. Retries and
+// duplicate deliveries of an admitted request are admitted again without being counted twice. If
+// the quota is used up, the time another request will be admitted is returned instead.
+func AdmitRequest(ctx context.Context, redisClient redis.UniversalClient, userId uint64, requestId, limit int, window time.Duration, now time.Time) (bool, time.Time, error) {
+	retryAt, err := admitScript.Run(ctx, redisClient,
+		[]string{keyQuotaPrefix + utils.ScrambleUserId(userId)},
+		requestId, now.UnixMilli(), window.Milliseconds(), limit,
+	).Int64()
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("failed to check request quota: %w", err)
+	}
+
+	if retryAt == 0 {
+		return true, time.Time{}, nil
+	}
+
+	return false, time.UnixMilli(retryAt), nil
+}
@@ -0,0 +1,38 @@
+package gdprrelay
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyCompletedPrefix = "tickets:gdpr:completed:" // Redis key prefix recording request IDs that completed successfully
+
+// MarkCompleted records that a request completed, so duplicate deliveries of it can be ignored
+func MarkCompleted(ctx context.Context, redisClient redis.UniversalClient, requestId int, ttl time.Duration) error {
+	if requestId == 0 {
+		return nil
+	}
+
+	return redisClient.Set(ctx, completedKey(requestId), time.Now().Unix(), ttl).Err()
+}
+
+// IsCompleted reports whether a request with the given ID has already completed
+func IsCompleted(ctx context.Context, redisClient redis.UniversalClient, requestId int) (bool, error) {
+	if requestId == 0 {
+		return false, nil
+	}
+
+	exists, err := redisClient.Exists(ctx, completedKey(requestId)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return exists > 0, nil
+}
+
+func completedKey(requestId int) string {
+	return fmt.Sprintf("%s%d", keyCompletedPrefix, requestId)
+}
@@ -0,0 +1,56 @@
+package gdprrelay
+
+import "github.com/redis/go-redis/v9"
+
+// keyProcessingItems is a Redis hash of request ID to the exact entry stored in keyProcessing, so
+// that entries can be removed by ID without scanning and re-decoding the whole processing list
+const keyProcessingItems = "tickets:gdpr:processing:items"
+
+// ackScript removes a request from the processing list by its ID, along with its lease.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = lease expiry set,
+// KEYS[4] = lease owners hash, ARGV[1] = request ID, ARGV[2] = owner.
+// Returns 1 if the request was removed, 0 if it was not being processed or its lease is held by
+// another worker.
+var ackScript = redis.NewScript(`
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+
+local owner = redis.call('HGET', KEYS[4], ARGV[1])
+if owner and owner ~= ARGV[2] then
+	return 0
+end
+
+redis.call('LREM', KEYS[2], 1, raw)
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+return 1
+`)
+
+// rejectScript removes a request from the processing list by its ID, along with its lease, and
+// pushes its updated payload onto the target list in the same step.
+// KEYS[1] = processing items hash, KEYS[2] = processing list, KEYS[3] = target list,
+// KEYS[4] = lease expiry set, KEYS[5] = lease owners hash, ARGV[1] = request ID,
+// ARGV[2] = updated payload, ARGV[3] = owner.
+// Returns 1 if the request was moved, 0 if it was not being processed or its lease is held by
+// another worker.
+var rejectScript = redis.NewScript(`
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if not raw then
+	return 0
+end
+
+local owner = redis.call('HGET', KEYS[5], ARGV[1])
+if owner and owner ~= ARGV[3] then
+	return 0
+end
+
+redis.call('LREM', KEYS[2], 1, raw)
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('LPUSH', KEYS[3], ARGV[2])
+return 1
+`)
@@ -0,0 +1,58 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/redis/go-redis/v9"
+)
+
+// StateStore keeps the live state of requests in Redis for the bot to poll, expiring each ttl after
+// its last update
+type StateStore struct {
+	redisClient redis.UniversalClient
+	ttl         time.Duration
+}
+
+var _ processor.StateStore = (*StateStore)(nil)
+
+func NewStateStore(redisClient redis.UniversalClient, ttl time.Duration) *StateStore {
+	return &StateStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+func (s *StateStore) SetState(ctx context.Context, state gdpr.RequestState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request state: %w", err)
+	}
+
+	if err := s.redisClient.Set(ctx, gdpr.KeyStateFor(state.RequestId), data, s.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save request state: %w", err)
+	}
+
+	return nil
+}
+
+// GetState returns the state of a request, false if it has none or it expired
+func (s *StateStore) GetState(ctx context.Context, requestId int) (gdpr.RequestState, bool, error) {
+	data, err := s.redisClient.Get(ctx, gdpr.KeyStateFor(requestId)).Bytes()
+	if err == redis.Nil {
+		return gdpr.RequestState{}, false, nil
+	} else if err != nil {
+		return gdpr.RequestState{}, false, fmt.Errorf("failed to load request state: %w", err)
+	}
+
+	var state gdpr.RequestState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return gdpr.RequestState{}, false, fmt.Errorf("failed to unmarshal request state: %w", err)
+	}
+
+	return state, true, nil
+}
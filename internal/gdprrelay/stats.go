@@ -0,0 +1,139 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// statsPendingScanLimit caps how many in-flight stream entries are inspected for idle time
+const statsPendingScanLimit = 1000
+
+// QueueStats is a snapshot of one queue, used to diagnose backlogs and requests that stopped moving
+type QueueStats struct {
+	Name          string
+	Pending       int64
+	Processing    int64
+	Delayed       int64
+	OldestPending time.Time // Zero if nothing is pending, or the backend doesn't record it
+	// Stuck counts requests taken for processing longer than the threshold ago. Stream entries are
+	// measured by their idle time, list entries by when they were queued, as the processing list
+	// keeps entries as they were read.
+	Stuck int64
+}
+
+// ListQueueStats reads the depths of the shared list queue if requestType is nil, or the dedicated
+// list of the type otherwise. Requests on the priority list count as pending.
+func ListQueueStats(ctx context.Context, redisClient redis.UniversalClient, requestType *RequestType, stuckAfter time.Duration, now time.Time) (QueueStats, error) {
+	stats := QueueStats{Name: "shared"}
+	pending, processing := keyPending, keyProcessing
+	if requestType != nil {
+		stats.Name = requestType.String()
+		pending, processing = gdpr.KeyPendingFor(*requestType), listProcessingKey(*requestType)
+	}
+
+	keys := []string{pending, gdpr.KeyPriorityFor(pending)}
+
+	var (
+		pendingLen    = make([]*redis.IntCmd, len(keys))
+		delayedLen    = make([]*redis.IntCmd, len(keys))
+		oldest        = make([]*redis.StringCmd, len(keys))
+		processingRaw *redis.StringSliceCmd
+	)
+	if _, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			pendingLen[i] = pipe.LLen(ctx, key)
+			delayedLen[i] = pipe.ZCard(ctx, delayedKey(key))
+			oldest[i] = pipe.LIndex(ctx, key, -1) // Entries are pushed on the left and read from the right
+		}
+		processingRaw = pipe.LRange(ctx, processing, 0, -1)
+		return nil
+	}); err != nil && err != redis.Nil {
+		return stats, fmt.Errorf("failed to read list queue %s: %w", pending, err)
+	}
+
+	for i := range keys {
+		stats.Pending += pendingLen[i].Val()
+		stats.Delayed += delayedLen[i].Val()
+
+		if raw := oldest[i].Val(); raw != "" {
+			var queued QueuedRequest
+			if err := json.Unmarshal([]byte(raw), &queued); err == nil &&
+				(stats.OldestPending.IsZero() || queued.QueuedAt.Before(stats.OldestPending)) {
+				stats.OldestPending = queued.QueuedAt
+			}
+		}
+	}
+	stats.Processing = int64(len(processingRaw.Val()))
+
+	for _, raw := range processingRaw.Val() {
+		var queued QueuedRequest
+		if err := json.Unmarshal([]byte(raw), &queued); err != nil || queued.QueuedAt.IsZero() {
+			continue
+		}
+
+		if now.Sub(queued.QueuedAt) > stuckAfter {
+			stats.Stuck++
+		}
+	}
+
+	return stats, nil
+}
+
+// StreamQueueStats reads the depths of the shared stream if requestType is nil, or the dedicated
+// stream of the type otherwise, as seen by the consumer group
+func StreamQueueStats(ctx context.Context, redisClient redis.UniversalClient, requestType *RequestType, group string, stuckAfter time.Duration) (QueueStats, error) {
+	stats := QueueStats{Name: "shared"}
+	stream := keyStream
+	if requestType != nil {
+		stats.Name = requestType.String()
+		stream = gdpr.KeyStreamFor(*requestType)
+	}
+
+	var (
+		streamLen  *redis.IntCmd
+		delayedLen *redis.IntCmd
+		inFlight   *redis.XPendingExtCmd
+	)
+	_, err := redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		streamLen = pipe.XLen(ctx, stream)
+		delayedLen = pipe.ZCard(ctx, delayedKey(stream))
+		inFlight = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  "-",
+			End:    "+",
+			Count:  statsPendingScanLimit,
+		})
+		return nil
+	})
+
+	// A stream or group that doesn't exist yet has nothing in it
+	if err != nil && err != redis.Nil && !isNoGroupError(err) {
+		return stats, fmt.Errorf("failed to read stream %s: %w", stream, err)
+	}
+
+	stats.Delayed = delayedLen.Val()
+	stats.Processing = int64(len(inFlight.Val()))
+	for _, entry := range inFlight.Val() {
+		if entry.Idle > stuckAfter {
+			stats.Stuck++
+		}
+	}
+
+	// Acknowledged entries are deleted, so whatever isn't in flight is still waiting to be read
+	if pending := streamLen.Val() - stats.Processing; pending > 0 {
+		stats.Pending = pending
+	}
+
+	return stats, nil
+}
+
+func isNoGroupError(err error) bool {
+	return strings.HasPrefix(err.Error(), "NOGROUP")
+}
@@ -0,0 +1,386 @@
+package gdprrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// StreamQueue consumes requests from a Redis stream using a consumer group. Each entry stays in
+// the consumer's pending entries list until it is acknowledged, and entries left idle by a crashed
+// consumer are claimed by the remaining ones, so several workers can share a single queue safely.
+type StreamQueue struct {
+	redisClient    redis.UniversalClient
+	listenerClient redis.UniversalClient // Dedicated client for blocking reads
+	logger         *zap.Logger
+	clock          clock.Clock
+
+	stream       string
+	group        string
+	consumer     string
+	claimMinIdle time.Duration
+
+	mu      sync.Mutex
+	entries map[int]string // Request ID -> stream entry ID, for requests currently being processed
+}
+
+var _ Queue = (*StreamQueue)(nil)
+
+func NewStreamQueue(
+	redisClient, listenerClient redis.UniversalClient,
+	group, consumer string,
+	claimMinIdle time.Duration,
+	logger *zap.Logger,
+) *StreamQueue {
+	return &StreamQueue{
+		redisClient:    redisClient,
+		listenerClient: listenerClient,
+		logger:         logger,
+		clock:          clock.Real,
+		stream:         keyStream,
+		group:          group,
+		consumer:       consumer,
+		claimMinIdle:   claimMinIdle,
+		entries:        make(map[int]string),
+	}
+}
+
+// NewStreamQueueForType creates a queue consuming only the dedicated stream of a request type, see
+// gdpr.KeyStreamFor. Requests that exhaust their retries still go to the shared failed list.
+func NewStreamQueueForType(
+	redisClient, listenerClient redis.UniversalClient,
+	requestType RequestType,
+	group, consumer string,
+	claimMinIdle time.Duration,
+	logger *zap.Logger,
+) *StreamQueue {
+	q := NewStreamQueue(redisClient, listenerClient, group, consumer, claimMinIdle, logger)
+	q.stream = gdpr.KeyStreamFor(requestType)
+	return q
+}
+
+// SetClock replaces the clock retry delays, keepalives and attempt times are measured with
+func (q *StreamQueue) SetClock(clk clock.Clock) {
+	q.clock = clk
+}
+
+// Listen reads entries from the stream on behalf of the consumer group and sends them on ch, until
+// ctx is cancelled, after which ch is closed. Entries this consumer had not acknowledged before a
+// restart are redelivered first, and entries idle in other consumers for longer than the claim
+// threshold are taken over.
+func (q *StreamQueue) Listen(ctx context.Context, capacity Capacity, ch chan QueuedRequest) {
+	defer close(ch)
+
+	if err := q.listenerClient.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err(); err != nil &&
+		!strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		q.logger.Error("Failed to create GDPR stream consumer group", zap.Error(err), zap.String("group", q.group))
+	}
+
+	go promoteDelayed(ctx, q.redisClient, q.clock, promoteStreamScript, delayedKey(q.stream), q.stream, q.logger, gdpr.StreamField)
+
+	// Redeliver entries that were delivered to this consumer but never acknowledged
+	if err := q.read(ctx, ch, "0", 0, -1); err != nil && ctx.Err() == nil {
+		q.logger.Error("Failed to recover pending stream entries", zap.Error(err))
+	}
+
+	lastKeepalive := q.clock.Now()
+	for ctx.Err() == nil {
+		if q.clock.Since(lastKeepalive) >= q.claimMinIdle/2 {
+			q.keepalive(ctx)
+			lastKeepalive = q.clock.Now()
+		}
+
+		// Entries already delivered to this consumer are recovered above regardless, as they're
+		// pending either way, but new and stalled entries are only taken on with a slot free. The
+		// wait is bounded so entries in flight are kept alive while every slot is busy.
+		waitCtx, cancel := context.WithTimeout(ctx, q.claimMinIdle/2)
+		ready := capacity.AwaitSlot(waitCtx)
+		timedOut := waitCtx.Err() != nil
+		cancel()
+		if !ready {
+			if timedOut && ctx.Err() == nil {
+				continue
+			}
+			return
+		}
+
+		claimed, err := q.claimStalled(ctx, ch)
+		if err != nil && ctx.Err() == nil {
+			q.logger.Error("Failed to claim stalled stream entries", zap.Error(err))
+		}
+
+		if claimed > 0 {
+			continue
+		}
+
+		if err := q.read(ctx, ch, ">", 1, listenPollTimeout); err != nil {
+			if err == redis.Nil || ctx.Err() != nil {
+				continue
+			}
+			q.logger.Error("Failed to read from GDPR stream",
+				zap.Error(err),
+				zap.String("key", q.stream),
+			)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+// read fetches entries for this consumer starting after id: ">" for new entries, or "0" for
+// entries already delivered to this consumer. A count of 0 reads every available entry, and a
+// negative block performs a non-blocking read.
+func (q *StreamQueue) read(ctx context.Context, ch chan QueuedRequest, id string, count int64, block time.Duration) error {
+	streams, err := q.listenerClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: q.consumer,
+		Streams:  []string{q.stream, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			q.dispatch(ctx, ch, message)
+		}
+	}
+
+	return nil
+}
+
+// claimStalled takes over an entry that has been idle in another consumer's pending entries list for
+// longer than the claim threshold, returning how many were dispatched. Only one is claimed per pass,
+// as the listener only has a slot for one.
+func (q *StreamQueue) claimStalled(ctx context.Context, ch chan QueuedRequest) (int, error) {
+	// XAUTOCLAIM is issued directly, as the reply grew a third element in Redis 7 which the typed
+	// command in this client version refuses to parse
+	reply, err := q.listenerClient.Do(ctx, "xautoclaim", q.stream, q.group, q.consumer,
+		q.claimMinIdle.Milliseconds(), "0-0", "count", 1).Slice()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(reply) < 2 {
+		return 0, fmt.Errorf("unexpected XAUTOCLAIM reply length %d", len(reply))
+	}
+
+	rawMessages, ok := reply[1].([]interface{})
+	if !ok {
+		return 0, fmt.Errorf("unexpected XAUTOCLAIM reply type %T", reply[1])
+	}
+
+	dispatched := 0
+	for _, rawMessage := range rawMessages {
+		message, ok := parseStreamMessage(rawMessage)
+		if !ok {
+			// Entries deleted while pending are returned as nil by Redis versions prior to 7
+			continue
+		}
+
+		q.logger.Warn("Claimed stalled GDPR stream entry", zap.String("entry_id", message.ID))
+		if q.dispatch(ctx, ch, message) {
+			dispatched++
+		}
+	}
+
+	return dispatched, nil
+}
+
+// keepalive resets the idle time of entries this consumer is still processing, so long-running
+// requests aren't claimed by other consumers
+func (q *StreamQueue) keepalive(ctx context.Context) {
+	q.mu.Lock()
+	ids := make([]string, 0, len(q.entries))
+	for _, id := range q.entries {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := q.listenerClient.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   q.stream,
+		Group:    q.group,
+		Consumer: q.consumer,
+		Messages: ids,
+	}).Err(); err != nil && ctx.Err() == nil {
+		q.logger.Error("Failed to refresh in-flight GDPR stream entries", zap.Error(err))
+	}
+}
+
+// dispatch decodes an entry and sends it on ch, returning false if the entry was not dispatched
+func (q *StreamQueue) dispatch(ctx context.Context, ch chan QueuedRequest, message redis.XMessage) bool {
+	rawData, _ := message.Values[gdpr.StreamField].(string)
+
+	queued, err := decodeEntry(rawData)
+	if err != nil {
+		q.logger.Error("Invalid GDPR request, moving to failed queue",
+			zap.Error(err),
+			zap.String("entry_id", message.ID),
+			zap.String("raw_data", rawData),
+		)
+
+		if _, err := q.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, keyFailed, rawData)
+			pipe.XAck(ctx, q.stream, q.group, message.ID)
+			pipe.XDel(ctx, q.stream, message.ID)
+			return nil
+		}); err != nil {
+			q.logger.Error("Failed to move invalid GDPR request to failed queue", zap.Error(err))
+		}
+		return false
+	}
+
+	q.mu.Lock()
+	if existing, ok := q.entries[queued.RequestID]; ok && existing == message.ID {
+		// Already being processed by this worker
+		q.mu.Unlock()
+		return false
+	}
+	q.entries[queued.RequestID] = message.ID
+	q.mu.Unlock()
+
+	queued.LastAttemptAt = q.clock.Now()
+	logDequeued(q.logger, queued)
+
+	ch <- queued
+	return true
+}
+
+func (q *StreamQueue) Acknowledge(ctx context.Context, request QueuedRequest) error {
+	entryId, ok := q.takeEntry(request)
+	if !ok {
+		return nil
+	}
+
+	if _, err := q.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, q.stream, q.group, entryId)
+		pipe.XDel(ctx, q.stream, entryId)
+		return nil
+	}); err != nil {
+		// Kept, so the acknowledgement can be tried again once Redis is reachable
+		q.mu.Lock()
+		q.entries[request.RequestID] = entryId
+		q.mu.Unlock()
+
+		return fmt.Errorf("failed to acknowledge stream entry: %w", err)
+	}
+
+	archiveAcknowledged(ctx, q.redisClient, q.stream, request, q.clock.Now(), q.logger)
+
+	return nil
+}
+
+func (q *StreamQueue) Reject(ctx context.Context, request QueuedRequest) (bool, error) {
+	exhausted, delay := nextAttempt(&request, q.logger)
+	if err := q.replace(ctx, request, exhausted, delay); err != nil {
+		return false, err
+	}
+
+	return exhausted, nil
+}
+
+func (q *StreamQueue) Fail(ctx context.Context, request QueuedRequest) error {
+	logPermanentFailure(q.logger, request)
+
+	return q.replace(ctx, request, true, 0)
+}
+
+func (q *StreamQueue) Requeue(ctx context.Context, request QueuedRequest) error {
+	return q.replace(ctx, request, false, 0)
+}
+
+// replace atomically acknowledges the request's current entry and re-adds it to the end of the
+// stream, to the stream's delayed set if delay is set, or to the failed list if failed is set
+func (q *StreamQueue) replace(ctx context.Context, request QueuedRequest, failed bool, delay time.Duration) error {
+	marshalled, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued request: %w", err)
+	}
+
+	entryId, ok := q.takeEntry(request)
+	if !ok {
+		return nil
+	}
+
+	if _, err := q.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, q.stream, q.group, entryId)
+		pipe.XDel(ctx, q.stream, entryId)
+		if failed {
+			pipe.LPush(ctx, keyFailed, string(marshalled))
+		} else if delay > 0 {
+			pipe.ZAdd(ctx, delayedKey(q.stream), redis.Z{
+				Score:  float64(readyAt(q.clock, delay)),
+				Member: string(marshalled),
+			})
+		} else {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: q.stream,
+				Values: map[string]interface{}{gdpr.StreamField: string(marshalled)},
+			})
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to move stream entry: %w", err)
+	}
+
+	return nil
+}
+
+// takeEntry removes and returns the stream entry ID of an in-flight request
+func (q *StreamQueue) takeEntry(request QueuedRequest) (string, bool) {
+	q.mu.Lock()
+	entryId, ok := q.entries[request.RequestID]
+	delete(q.entries, request.RequestID)
+	q.mu.Unlock()
+
+	if !ok {
+		q.logger.Warn("Request not found in stream pending entries",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(request.Request.UserId)),
+			zap.String("request_type", utils.GetRequestTypeName(int(request.Request.Type))),
+			zap.Int("request_id", request.RequestID),
+		)
+	}
+
+	return entryId, ok
+}
+
+// parseStreamMessage converts a raw [id, [field, value, ...]] stream entry reply into an XMessage
+func parseStreamMessage(raw interface{}) (redis.XMessage, bool) {
+	parts, ok := raw.([]interface{})
+	if !ok || len(parts) != 2 {
+		return redis.XMessage{}, false
+	}
+
+	id, ok := parts[0].(string)
+	if !ok {
+		return redis.XMessage{}, false
+	}
+
+	fields, ok := parts[1].([]interface{})
+	if !ok {
+		return redis.XMessage{}, false
+	}
+
+	values := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if key, ok := fields[i].(string); ok {
+			values[key] = fields[i+1]
+		}
+	}
+
+	return redis.XMessage{ID: id, Values: values}, true
+}
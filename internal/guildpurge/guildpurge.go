@@ -0,0 +1,284 @@
+// Package guildpurge erases the transcripts of servers the bot has been removed from. The gateway
+// reports removals on gdpr.KeyGuildRemovals, and once the server's retention period, or else a grace
+// period, has passed without the bot being added back, a transcript deletion covering the whole
+// server is queued.
+package guildpurge
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/permissions"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	keyScheduled = "tickets:gdpr:guild_purges" // Redis sorted set of guilds awaiting a purge, scored by when it is due
+
+	readBlock    = 5 * time.Second
+	readBatch    = 10
+	pollInterval = 30 * time.Second
+	dueBatch     = 10
+	retryDelay   = 5 * time.Minute // Delay before trying again when a due purge couldn't be queued
+
+	// Retention periods longer than this, about a century, keep the guild's transcripts indefinitely.
+	// Anything much longer would also overflow a time.Duration.
+	maxRetentionDays = 36500
+)
+
+// Enqueuer queues the purge of a guild
+type Enqueuer interface {
+	Enqueue(ctx context.Context, request gdpr.QueuedRequest) (gdpr.QueuedRequest, error)
+}
+
+// Scheduler consumes guild removals and queues the purge of each guild once its grace period has
+// passed. Several workers may run one, removals are shared out through a consumer group and each
+// due purge is queued by whichever worker claims it first.
+type Scheduler struct {
+	redisClient    redis.UniversalClient
+	listenerClient redis.UniversalClient // Dedicated client for blocking reads
+	enqueuer       Enqueuer
+	discordToken   string
+	rateLimiter    *ratelimit.Ratelimiter
+	gracePeriod    time.Duration
+	group          string
+	consumer       string
+	logger         *zap.Logger
+	clock          clock.Clock
+}
+
+func NewScheduler(
+	redisClient, listenerClient redis.UniversalClient,
+	enqueuer Enqueuer,
+	discordToken string,
+	gracePeriod time.Duration,
+	group, consumer string,
+	logger *zap.Logger,
+) *Scheduler {
+	return &Scheduler{
+		redisClient:    redisClient,
+		listenerClient: listenerClient,
+		enqueuer:       enqueuer,
+		discordToken:   discordToken,
+		rateLimiter:    ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0),
+		gracePeriod:    gracePeriod,
+		group:          group,
+		consumer:       consumer,
+		logger:         logger,
+		clock:          clock.Real,
+	}
+}
+
+// SetClock replaces the clock grace periods are measured with. Must be called before Run.
+func (s *Scheduler) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// Run consumes guild removals and queues due purges until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	if err := s.listenerClient.XGroupCreateMkStream(ctx, gdpr.KeyGuildRemovals, s.group, "0").Err(); err != nil &&
+		!strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		s.logger.Error("Failed to create guild removal consumer group", zap.Error(err), zap.String("group", s.group))
+	}
+
+	go s.runDue(ctx)
+
+	// Removals read but not scheduled before a restart are handled first
+	if err := s.read(ctx, "0", -1); err != nil && ctx.Err() == nil {
+		s.logger.Error("Failed to recover pending guild removals", zap.Error(err))
+	}
+
+	for ctx.Err() == nil {
+		if err := s.read(ctx, ">", readBlock); err != nil && err != redis.Nil && ctx.Err() == nil {
+			s.logger.Error("Failed to read guild removals", zap.Error(err))
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func (s *Scheduler) read(ctx context.Context, start string, block time.Duration) error {
+	streams, err := s.listenerClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{gdpr.KeyGuildRemovals, start},
+		Count:    readBatch,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			if err := s.schedule(ctx, message); err != nil {
+				// Left unacknowledged, to be picked up again after a restart
+				s.logger.Error("Failed to schedule guild purge", zap.Error(err), zap.String("entry_id", message.ID))
+				continue
+			}
+
+			if err := s.redisClient.XAck(ctx, gdpr.KeyGuildRemovals, s.group, message.ID).Err(); err != nil {
+				s.logger.Error("Failed to acknowledge guild removal", zap.Error(err), zap.String("entry_id", message.ID))
+			}
+		}
+	}
+
+	return nil
+}
+
+// schedule records when the guild in a removal is due to be purged, after the guild's own retention
+// period if the removal carries one. A later removal of the same guild, after the bot was added back
+// in between, pushes its purge back.
+func (s *Scheduler) schedule(ctx context.Context, message redis.XMessage) error {
+	raw, _ := message.Values[gdpr.GuildRemovalField].(string)
+
+	var removal gdpr.GuildRemoval
+	if err := json.Unmarshal([]byte(raw), &removal); err != nil || removal.GuildId == 0 {
+		// Retrying won't make a malformed entry valid, so it is acknowledged and dropped
+		s.logger.Error("Dropping invalid guild removal", zap.Error(err), zap.String("entry_id", message.ID))
+		return nil
+	}
+
+	removedAt := removal.RemovedAt
+	if removedAt.IsZero() {
+		removedAt = s.clock.Now()
+	}
+
+	retention := s.gracePeriod
+	if removal.RetentionDays != nil {
+		if *removal.RetentionDays > maxRetentionDays {
+			// A purge scheduled by an earlier removal of the guild is pushed back indefinitely too
+			if err := s.redisClient.ZRem(ctx, keyScheduled, strconv.FormatUint(removal.GuildId, 10)).Err(); err != nil {
+				return err
+			}
+
+			s.logger.Info("Not scheduling purge of guild with indefinite retention period",
+				zap.Uint64("guild_id", removal.GuildId),
+				zap.Int("retention_days", *removal.RetentionDays),
+			)
+			return nil
+		} else if *removal.RetentionDays >= 0 {
+			retention = time.Duration(*removal.RetentionDays) * 24 * time.Hour
+		} else {
+			s.logger.Warn("Ignoring negative retention period of guild removal",
+				zap.Uint64("guild_id", removal.GuildId),
+				zap.Int("retention_days", *removal.RetentionDays),
+			)
+		}
+	}
+
+	dueAt := removedAt.Add(retention)
+	if err := s.redisClient.ZAdd(ctx, keyScheduled, redis.Z{
+		Score:  float64(dueAt.UnixMilli()),
+		Member: strconv.FormatUint(removal.GuildId, 10),
+	}).Err(); err != nil {
+		return err
+	}
+
+	s.logger.Info("Scheduled purge of guild the bot was removed from",
+		zap.Uint64("guild_id", removal.GuildId),
+		zap.String("reason", string(removal.Reason)),
+		zap.Duration("retention", retention),
+		zap.Time("due_at", dueAt),
+	)
+
+	return nil
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	ticker := s.clock.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := s.purgeDue(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to queue due guild purges", zap.Error(err))
+		}
+	}
+}
+
+func (s *Scheduler) purgeDue(ctx context.Context) error {
+	members, err := s.redisClient.ZRangeByScore(ctx, keyScheduled, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(s.clock.Now().UnixMilli(), 10),
+		Count: dueBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		// Several workers may poll the same set, whoever removes the entry queues the purge
+		removed, err := s.redisClient.ZRem(ctx, keyScheduled, member).Result()
+		if err != nil {
+			return err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		guildId, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			s.logger.Error("Dropping invalid scheduled guild purge", zap.String("member", member))
+			continue
+		}
+
+		s.purge(ctx, guildId)
+	}
+
+	return nil
+}
+
+// purge queues the deletion of every transcript of a guild, unless the bot has been added back
+func (s *Scheduler) purge(ctx context.Context, guildId uint64) {
+	logger := s.logger.With(zap.Uint64("guild_id", guildId))
+
+	inGuild, err := permissions.BotInGuild(ctx, s.discordToken, s.rateLimiter, guildId)
+	if err != nil {
+		logger.Warn("Failed to check whether the bot is back in guild, retrying later", zap.Error(err))
+		s.reschedule(ctx, guildId)
+		return
+	}
+
+	if inGuild {
+		logger.Info("Bot was added back to guild, cancelling purge")
+		metrics.GuildPurges.WithLabelValues("cancelled").Inc()
+		return
+	}
+
+	queued, err := s.enqueuer.Enqueue(ctx, gdpr.NewQueuedRequest(gdpr.Request{
+		Type:             gdpr.RequestTypeAllTranscripts,
+		GuildIds:         []uint64{guildId},
+		VerificationMode: gdpr.VerificationModeGuildRemoved,
+	}, 0))
+	if err != nil {
+		logger.Error("Failed to queue guild purge, retrying later", zap.Error(err))
+		s.reschedule(ctx, guildId)
+		return
+	}
+
+	logger.Info("Queued purge of guild the bot was removed from", zap.Int("request_id", queued.RequestID))
+	metrics.GuildPurges.WithLabelValues("queued").Inc()
+}
+
+func (s *Scheduler) reschedule(ctx context.Context, guildId uint64) {
+	if err := s.redisClient.ZAdd(ctx, keyScheduled, redis.Z{
+		Score:  float64(s.clock.Now().Add(retryDelay).UnixMilli()),
+		Member: strconv.FormatUint(guildId, 10),
+	}).Err(); err != nil {
+		s.logger.Error("Failed to reschedule guild purge", zap.Uint64("guild_id", guildId), zap.Error(err))
+	}
+}
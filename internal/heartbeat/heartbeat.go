@@ -4,7 +4,8 @@ import (
 	"context"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -14,13 +15,13 @@ const (
 	HeartbeatTTL      = 30 * time.Second                 // How long before the heartbeat expires if not refreshed
 )
 
-func Start(ctx context.Context, redisClient *redis.Client, logger *zap.Logger) {
+func Start(ctx context.Context, redisClient redis.UniversalClient, clk clock.Clock, logger *zap.Logger) {
 	logger.Info("Starting heartbeat")
 
-	ticker := time.NewTicker(HeartbeatInterval)
+	ticker := clk.NewTicker(HeartbeatInterval)
 	defer ticker.Stop()
 
-	sendHeartbeat(ctx, redisClient, logger)
+	sendHeartbeat(ctx, redisClient, clk, logger)
 
 	for {
 		select {
@@ -30,14 +31,14 @@ func Start(ctx context.Context, redisClient *redis.Client, logger *zap.Logger) {
 				logger.Error("Failed to clear heartbeat on shutdown", zap.Error(err))
 			}
 			return
-		case <-ticker.C:
-			sendHeartbeat(ctx, redisClient, logger)
+		case <-ticker.C():
+			sendHeartbeat(ctx, redisClient, clk, logger)
 		}
 	}
 }
 
-func sendHeartbeat(ctx context.Context, redisClient *redis.Client, logger *zap.Logger) {
-	timestamp := time.Now().Unix()
+func sendHeartbeat(ctx context.Context, redisClient redis.UniversalClient, clk clock.Clock, logger *zap.Logger) {
+	timestamp := clk.Now().Unix()
 	err := redisClient.Set(ctx, HeartbeatKey, timestamp, HeartbeatTTL).Err()
 	if err != nil {
 		logger.Error("Failed to send heartbeat", zap.Error(err))
@@ -47,7 +48,7 @@ func sendHeartbeat(ctx context.Context, redisClient *redis.Client, logger *zap.L
 }
 
 // Check verifies if the GDPR worker is alive
-func Check(ctx context.Context, redisClient *redis.Client) (bool, error) {
+func Check(ctx context.Context, redisClient redis.UniversalClient) (bool, error) {
 	val, err := redisClient.Get(ctx, HeartbeatKey).Result()
 	if err == redis.Nil {
 		return false, nil
@@ -57,3 +58,15 @@ func Check(ctx context.Context, redisClient *redis.Client) (bool, error) {
 	}
 	return val != "", nil
 }
+
+// LastBeat returns when the worker last sent a heartbeat, or false if none is live
+func LastBeat(ctx context.Context, redisClient redis.UniversalClient) (time.Time, bool, error) {
+	timestamp, err := redisClient.Get(ctx, HeartbeatKey).Int64()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(timestamp, 0), true, nil
+}
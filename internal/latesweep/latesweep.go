@@ -0,0 +1,173 @@
+// Package latesweep catches transcripts stored after a message erasure completed. Tickets that were
+// still open, or not yet archived, while the request was processed are checked again once a delay
+// has passed, and any transcript stored for them in the meantime is cleaned of the user's messages.
+package latesweep
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	keyScheduled = "tickets:gdpr:late_sweeps" // Redis sorted set of sweeps awaiting their re-check, scored by when it is due
+
+	pollInterval = 30 * time.Second
+	dueBatch     = 10
+	retryDelay   = 5 * time.Minute // Delay before trying again when a sweep failed
+	maxAttempts  = 3
+)
+
+// Sweep is the re-check of the tickets of a completed request
+type Sweep struct {
+	RequestId int              `json:"request_id"`
+	UserId    uint64           `json:"user_id"`
+	Tickets   map[uint64][]int `json:"tickets"` // Ticket IDs by guild
+	Attempts  int              `json:"attempts,omitempty"`
+}
+
+// Cleaner cleans the user's messages from any transcript now stored for the tickets, implemented by
+// the processor
+type Cleaner interface {
+	SweepLateMessages(ctx context.Context, requestId int, userId uint64, tickets map[uint64][]int) (int, error)
+}
+
+// Scheduler records sweeps as message erasures complete, and runs them once due. Several workers
+// may run one, each due sweep is run by whichever worker claims it first.
+type Scheduler struct {
+	redisClient redis.UniversalClient
+	cleaner     Cleaner
+	delay       time.Duration
+	logger      *zap.Logger
+	clock       clock.Clock
+}
+
+func NewScheduler(redisClient redis.UniversalClient, cleaner Cleaner, delay time.Duration, logger *zap.Logger) *Scheduler {
+	return &Scheduler{
+		redisClient: redisClient,
+		cleaner:     cleaner,
+		delay:       delay,
+		logger:      logger,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock sweeps are scheduled by. Must be called before Run.
+func (s *Scheduler) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// Schedule records a sweep, due once the delay has passed
+func (s *Scheduler) Schedule(ctx context.Context, sweep Sweep) error {
+	return s.add(ctx, sweep, s.clock.Now().Add(s.delay))
+}
+
+func (s *Scheduler) add(ctx context.Context, sweep Sweep, dueAt time.Time) error {
+	member, err := json.Marshal(sweep)
+	if err != nil {
+		return err
+	}
+
+	return s.redisClient.ZAdd(ctx, keyScheduled, redis.Z{
+		Score:  float64(dueAt.UnixMilli()),
+		Member: string(member),
+	}).Err()
+}
+
+// Run runs due sweeps until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := s.clock.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := s.runDue(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to run due late sweeps", zap.Error(err))
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) error {
+	members, err := s.redisClient.ZRangeByScore(ctx, keyScheduled, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(s.clock.Now().UnixMilli(), 10),
+		Count: dueBatch,
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		// Several workers may poll the same set, whoever removes the entry runs the sweep
+		removed, err := s.redisClient.ZRem(ctx, keyScheduled, member).Result()
+		if err != nil {
+			return err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		var sweep Sweep
+		if err := json.Unmarshal([]byte(member), &sweep); err != nil {
+			s.logger.Error("Dropping invalid late sweep", zap.Error(err))
+			continue
+		}
+
+		s.sweep(ctx, sweep)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) sweep(ctx context.Context, sweep Sweep) {
+	logger := s.logger.With(
+		zap.Int("request_id", sweep.RequestId),
+		zap.String("scrambled_user_id", utils.ScrambleUserId(sweep.UserId)),
+	)
+
+	messagesDeleted, err := s.cleaner.SweepLateMessages(ctx, sweep.RequestId, sweep.UserId, sweep.Tickets)
+	if err != nil {
+		// A sweep interrupted by shutdown is tried again without counting against it
+		if ctx.Err() == nil {
+			sweep.Attempts++
+			if sweep.Attempts >= maxAttempts {
+				logger.Error("Late sweep failed, giving up", zap.Int("attempts", sweep.Attempts), zap.Error(err))
+				metrics.LateSweeps.WithLabelValues("abandoned").Inc()
+				return
+			}
+
+			logger.Warn("Late sweep failed, retrying later", zap.Int("attempts", sweep.Attempts), zap.Error(err))
+			metrics.LateSweeps.WithLabelValues("retried").Inc()
+		}
+
+		// Recorded even if the worker is shutting down, so the sweep isn't lost
+		retryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		if err := s.add(retryCtx, sweep, s.clock.Now().Add(retryDelay)); err != nil {
+			logger.Error("Failed to reschedule late sweep", zap.Error(err))
+		}
+		return
+	}
+
+	if messagesDeleted > 0 {
+		logger.Info("Late sweep cleaned transcripts stored after request completed", zap.Int("messages_deleted", messagesDeleted))
+		metrics.LateSweeps.WithLabelValues("cleaned").Inc()
+	} else {
+		logger.Debug("Late sweep found nothing left to clean")
+		metrics.LateSweeps.WithLabelValues("nothing_found").Inc()
+	}
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const namespace = "gdpr_worker"
+
+var (
+	RequestsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_processed_total",
+		Help:      "Number of GDPR requests processed, by request type and outcome",
+	}, []string{"request_type", "status"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to process a GDPR request, by request type",
+		Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600},
+	}, []string{"request_type"})
+
+	RequestsTimedOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_timed_out_total",
+		Help:      "Number of GDPR requests cancelled for exceeding their timeout, by request type",
+	}, []string{"request_type"})
+
+	GuildPurges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guild_purges_total",
+		Help:      "Number of due purges of guilds the bot was removed from, by whether they were queued or cancelled as the bot was added back",
+	}, []string{"outcome"})
+
+	TranscriptFlagMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transcript_flag_mismatches_total",
+		Help:      "Number of tickets whose has_transcript flag didn't match storage when it was updated, by the state found",
+	}, []string{"state"})
+
+	QuarantinePurges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quarantine_purges_total",
+		Help:      "Number of quarantined transcripts whose grace period ended, by whether they were purged, failed to purge or are under a legal hold",
+	}, []string{"outcome"})
+
+	PlatformErasures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "platform_erasures_total",
+		Help:      "Number of attempts at erasures ordered by the bot operator, by whether they completed, will be retried or were given up on",
+	}, []string{"outcome"})
+
+	LateSweeps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "late_sweeps_total",
+		Help:      "Number of re-checks of tickets archived after a message erasure completed, by whether messages were cleaned, none were found, or the sweep will be retried or was given up on",
+	}, []string{"outcome"})
+
+	DeadLetterEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letter_entries_total",
+		Help:      "Number of entries of the failed queue seen for the first time, dropped after their TTL, or trimmed beyond its maximum length",
+	}, []string{"outcome"})
+
+	DeadLetterLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dead_letter_length",
+		Help:      "Number of entries in the failed queue as of the latest sweep",
+	})
+
+	ArchiverBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "archiver_bytes_total",
+		Help:      "Bytes of stored transcript data moved to and from the archiver, by whether it was fetched, rewritten or deleted",
+	}, []string{"operation"})
+
+	DesiredReplicas = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "autoscale_desired_replicas",
+		Help:      "Number of worker replicas needed to work through the backlog within the drain target, for an external scaler to act on",
+	})
+
+	Backlog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "autoscale_backlog",
+		Help:      "Number of requests waiting in or being processed from every queue, as counted for the desired replica count",
+	})
+
+	ThroughputPerReplica = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "autoscale_throughput_per_replica",
+		Help:      "Requests processed per second by this replica over the SLO window",
+	})
+
+	LocaleMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "locale_messages_total",
+		Help:      "Number of messages rendered for users, by requested locale, message key and whether it was translated, taken from the parent language, fell back to English or missing",
+	}, []string{"locale", "message", "resolution"})
+)
+
+// Serve exposes the registered metrics over HTTP until ctx is cancelled
+func Serve(ctx context.Context, addr string, logger *zap.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Serving metrics", zap.String("addr", addr))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("Metrics server failed", zap.Error(err))
+	}
+}
@@ -0,0 +1,218 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// The SLO gauges are derived from queue state and recent outcomes so that alerting rules can
+// fire on them directly rather than reconstructing the business logic from raw counters
+var (
+	pendingOldestAge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_pending_oldest_age_seconds",
+		Help:      "Age of the oldest request waiting in the pending queue",
+	})
+
+	pendingOverThreshold = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_pending_over_threshold",
+		Help:      "Number of pending requests older than the configured pending age objective",
+	})
+
+	failureRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_failure_ratio",
+		Help:      "Ratio of failed to processed requests over the configured window",
+	})
+
+	burnRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slo_error_budget_burn_rate",
+		Help:      "Failure ratio divided by the error budget, values above 1 exhaust the budget before the window ends",
+	})
+)
+
+// SLOTracker records request outcomes and periodically refreshes the SLO gauges
+type SLOTracker struct {
+	redisClient      redis.UniversalClient
+	logger           *zap.Logger
+	window           time.Duration // Window the failure ratio is computed over
+	pendingThreshold time.Duration // Pending requests older than this count against the objective
+	target           float64       // Success ratio objective, e.g. 0.99
+	pendingKeys      []string      // Pending lists inspected for request age
+	clock            clock.Clock
+
+	mu       sync.Mutex
+	outcomes []outcome
+	started  time.Time // When Run was called, zero before
+}
+
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// sloRefreshInterval is how often queue based gauges are recomputed
+const sloRefreshInterval = 30 * time.Second
+
+func NewSLOTracker(redisClient redis.UniversalClient, logger *zap.Logger, window, pendingThreshold time.Duration, target float64) *SLOTracker {
+	return &SLOTracker{
+		redisClient:      redisClient,
+		logger:           logger,
+		window:           window,
+		pendingThreshold: pendingThreshold,
+		target:           target,
+		pendingKeys:      []string{gdpr.KeyPending, gdpr.KeyPriorityFor(gdpr.KeyPending)},
+		clock:            clock.Real,
+	}
+}
+
+// SetClock replaces the clock windows and request ages are measured with. Must be called before Run.
+func (t *SLOTracker) SetClock(clk clock.Clock) {
+	t.clock = clk
+}
+
+// WatchPending adds a dedicated pending list to the age objective. Must be called before Run.
+func (t *SLOTracker) WatchPending(key string) {
+	t.pendingKeys = append(t.pendingKeys, key)
+}
+
+// RecordOutcome registers a processed request for the failure ratio
+func (t *SLOTracker) RecordOutcome(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.outcomes = append(t.outcomes, outcome{at: t.clock.Now(), failed: failed})
+}
+
+// Throughput returns how many requests per second were processed over the window, or since Run was
+// called if that's more recent
+func (t *SLOTracker) Throughput() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	since := now.Add(-t.window)
+	if t.started.IsZero() {
+		return 0
+	} else if t.started.After(since) {
+		since = t.started
+	}
+
+	elapsed := now.Sub(since).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	processed := 0
+	for _, o := range t.outcomes {
+		if !o.at.Before(since) {
+			processed++
+		}
+	}
+
+	return float64(processed) / elapsed
+}
+
+func (t *SLOTracker) Run(ctx context.Context) {
+	t.mu.Lock()
+	t.started = t.clock.Now()
+	t.mu.Unlock()
+
+	ticker := t.clock.NewTicker(sloRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		t.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (t *SLOTracker) refresh(ctx context.Context) {
+	t.refreshFailureRatio()
+
+	if err := t.refreshPending(ctx); err != nil {
+		t.logger.Warn("Failed to refresh pending queue SLO gauges", zap.Error(err))
+	}
+}
+
+func (t *SLOTracker) refreshFailureRatio() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := t.clock.Now().Add(-t.window)
+
+	kept := t.outcomes[:0]
+	failures := 0
+	for _, o := range t.outcomes {
+		if o.at.Before(cutoff) {
+			continue
+		}
+
+		kept = append(kept, o)
+		if o.failed {
+			failures++
+		}
+	}
+	t.outcomes = kept
+
+	ratio := 0.0
+	if len(kept) > 0 {
+		ratio = float64(failures) / float64(len(kept))
+	}
+	failureRatio.Set(ratio)
+
+	if budget := 1 - t.target; budget > 0 {
+		burnRate.Set(ratio / budget)
+	}
+}
+
+func (t *SLOTracker) refreshPending(ctx context.Context) error {
+	var items []string
+	for _, key := range t.pendingKeys {
+		keyItems, err := t.redisClient.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		items = append(items, keyItems...)
+	}
+
+	now := t.clock.Now()
+	var oldest time.Duration
+	overThreshold := 0
+
+	for _, item := range items {
+		var queued gdpr.QueuedRequest
+		if err := json.Unmarshal([]byte(item), &queued); err != nil || queued.QueuedAt.IsZero() {
+			continue
+		}
+
+		age := now.Sub(queued.QueuedAt)
+		if age > oldest {
+			oldest = age
+		}
+		if age > t.pendingThreshold {
+			overThreshold++
+		}
+	}
+
+	pendingOldestAge.Set(oldest.Seconds())
+	pendingOverThreshold.Set(float64(overThreshold))
+
+	return nil
+}
@@ -0,0 +1,314 @@
+// Package permissions computes Discord member permissions over REST. The worker has no gateway
+// connection, so unlike the bot it can't rely on cached member, role and channel state.
+package permissions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel"
+	"github.com/TicketsBot-cloud/gdl/objects/guild"
+	"github.com/TicketsBot-cloud/gdl/objects/member"
+	"github.com/TicketsBot-cloud/gdl/rest"
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdl/rest/request"
+)
+
+// DefaultCacheTTL is how long fetched guilds, members and channels are reused for
+const DefaultCacheTTL = 5 * time.Minute
+
+const (
+	// Administrator is the permission bit granting every other permission
+	Administrator uint64 = 1 << 3
+	ManageGuild   uint64 = 1 << 5
+
+	// allPermissions is granted to guild owners and administrators
+	allPermissions = ^uint64(0)
+)
+
+// Resolver computes the permissions of guild members from REST lookups. Lookups are cached for the
+// TTL, as a single request usually checks the same guild several times.
+type Resolver struct {
+	token       string
+	rateLimiter *ratelimit.Ratelimiter
+
+	guilds   *cache[uint64, guild.Guild]
+	members  *cache[memberKey, member.Member]
+	channels *cache[uint64, channel.Channel]
+}
+
+type memberKey struct {
+	guildId uint64
+	userId  uint64
+}
+
+func NewResolver(token string, rateLimiter *ratelimit.Ratelimiter, ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	return &Resolver{
+		token:       token,
+		rateLimiter: rateLimiter,
+		guilds:      newCache[uint64, guild.Guild](ttl),
+		members:     newCache[memberKey, member.Member](ttl),
+		channels:    newCache[uint64, channel.Channel](ttl),
+	}
+}
+
+// GuildPermissions returns the guild-wide permissions of a member
+func (r *Resolver) GuildPermissions(ctx context.Context, guildId, userId uint64) (uint64, error) {
+	g, err := r.guild(ctx, guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	if g.OwnerId == userId {
+		return allPermissions, nil
+	}
+
+	m, err := r.member(ctx, guildId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return basePermissions(g, m), nil
+}
+
+// ChannelPermissions returns the permissions of a member in a channel, applying the channel's
+// permission overwrites on top of their guild-wide permissions
+func (r *Resolver) ChannelPermissions(ctx context.Context, guildId, channelId, userId uint64) (uint64, error) {
+	g, err := r.guild(ctx, guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	if g.OwnerId == userId {
+		return allPermissions, nil
+	}
+
+	m, err := r.member(ctx, guildId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	base := basePermissions(g, m)
+	if base&Administrator != 0 {
+		return allPermissions, nil
+	}
+
+	ch, err := r.channel(ctx, channelId)
+	if err != nil {
+		return 0, err
+	}
+
+	if ch.GuildId != guildId {
+		return 0, fmt.Errorf("channel %d does not belong to guild %d", channelId, guildId)
+	}
+
+	return applyOverwrites(base, guildId, m, ch.PermissionOverwrites), nil
+}
+
+// MemberRoles returns the IDs of the roles a member holds, excluding @everyone
+func (r *Resolver) MemberRoles(ctx context.Context, guildId, userId uint64) ([]uint64, error) {
+	m, err := r.member(ctx, guildId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Roles, nil
+}
+
+// GuildOwner returns the ID of the guild's owner
+func (r *Resolver) GuildOwner(ctx context.Context, guildId uint64) (uint64, error) {
+	g, err := r.guild(ctx, guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	return g.OwnerId, nil
+}
+
+// Forget drops the cached guild and member, so the next lookup sees their current state
+func (r *Resolver) Forget(guildId, userId uint64) {
+	r.guilds.delete(guildId)
+	r.members.delete(memberKey{guildId, userId})
+}
+
+// BotInGuild reports whether the bot the token belongs to is still in the guild. It's never cached,
+// as it decides whether a server the bot left may be erased. Discord answers with Unknown Guild or
+// Missing Access once the bot has been removed.
+func BotInGuild(ctx context.Context, token string, rateLimiter *ratelimit.Ratelimiter, guildId uint64) (bool, error) {
+	_, err := rest.GetGuild(ctx, token, rateLimiter, guildId)
+	if err == nil {
+		return true, nil
+	}
+
+	var restErr request.RestError
+	if errors.As(err, &restErr) && (restErr.StatusCode == http.StatusNotFound || restErr.StatusCode == http.StatusForbidden) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("failed to fetch guild: %w", err)
+}
+
+// HasPermissions reports whether a member holds every permission bit set in required guild-wide
+func (r *Resolver) HasPermissions(ctx context.Context, guildId, userId, required uint64) (bool, error) {
+	granted, err := r.GuildPermissions(ctx, guildId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return granted&required == required, nil
+}
+
+// HasChannelPermissions reports whether a member holds every permission bit set in required in a
+// channel
+func (r *Resolver) HasChannelPermissions(ctx context.Context, guildId, channelId, userId, required uint64) (bool, error) {
+	granted, err := r.ChannelPermissions(ctx, guildId, channelId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return granted&required == required, nil
+}
+
+func (r *Resolver) guild(ctx context.Context, guildId uint64) (guild.Guild, error) {
+	return r.guilds.getOrFetch(guildId, func() (guild.Guild, error) {
+		g, err := rest.GetGuild(ctx, r.token, r.rateLimiter, guildId)
+		if err != nil {
+			return guild.Guild{}, fmt.Errorf("failed to fetch guild: %w", err)
+		}
+		return g, nil
+	})
+}
+
+func (r *Resolver) member(ctx context.Context, guildId, userId uint64) (member.Member, error) {
+	return r.members.getOrFetch(memberKey{guildId, userId}, func() (member.Member, error) {
+		m, err := rest.GetGuildMember(ctx, r.token, r.rateLimiter, guildId, userId)
+		if err != nil {
+			return member.Member{}, fmt.Errorf("failed to fetch guild member: %w", err)
+		}
+		return m, nil
+	})
+}
+
+func (r *Resolver) channel(ctx context.Context, channelId uint64) (channel.Channel, error) {
+	return r.channels.getOrFetch(channelId, func() (channel.Channel, error) {
+		ch, err := rest.GetChannel(ctx, r.token, r.rateLimiter, channelId)
+		if err != nil {
+			return channel.Channel{}, fmt.Errorf("failed to fetch channel: %w", err)
+		}
+		return ch, nil
+	})
+}
+
+// basePermissions combines the @everyone role, which shares the guild's ID, with the member's roles
+func basePermissions(g guild.Guild, m member.Member) uint64 {
+	var permissions uint64
+	for _, role := range g.Roles {
+		if role.Id == g.Id || m.HasRole(role.Id) {
+			permissions |= role.Permissions
+		}
+	}
+
+	if permissions&Administrator != 0 {
+		return allPermissions
+	}
+
+	return permissions
+}
+
+// applyOverwrites applies channel overwrites in the order Discord does: @everyone, then the
+// member's roles combined, then the member themselves
+func applyOverwrites(permissions, guildId uint64, m member.Member, overwrites []channel.PermissionOverwrite) uint64 {
+	var roleAllow, roleDeny uint64
+	var memberOverwrite *channel.PermissionOverwrite
+
+	for i, overwrite := range overwrites {
+		switch {
+		case overwrite.Type == channel.PermissionTypeRole && overwrite.Id == guildId:
+			permissions &^= overwrite.Deny
+			permissions |= overwrite.Allow
+		case overwrite.Type == channel.PermissionTypeRole && m.HasRole(overwrite.Id):
+			roleAllow |= overwrite.Allow
+			roleDeny |= overwrite.Deny
+		case overwrite.Type == channel.PermissionTypeMember && overwrite.Id == m.User.Id:
+			memberOverwrite = &overwrites[i]
+		}
+	}
+
+	permissions &^= roleDeny
+	permissions |= roleAllow
+
+	if memberOverwrite != nil {
+		permissions &^= memberOverwrite.Deny
+		permissions |= memberOverwrite.Allow
+	}
+
+	return permissions
+}
+
+// cache holds fetched values until they expire. Expired entries are swept whenever a value is
+// stored, so the cache stays bounded by what was fetched within one TTL.
+type cache[K comparable, V any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[K]cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func newCache[K comparable, V any](ttl time.Duration) *cache[K, V] {
+	return &cache[K, V]{
+		ttl:     ttl,
+		entries: make(map[K]cacheEntry[V]),
+	}
+}
+
+func (c *cache[K, V]) getOrFetch(key K, fetch func() (V, error)) (V, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = cacheEntry[V]{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	}
+
+	return value, nil
+}
+
+func (c *cache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
@@ -0,0 +1,236 @@
+// Package platformerasure carries out erasures ordered by the bot operator rather than a user, such
+// as removing every transcript of a server banned from the platform. Orders are placed through the
+// admin API and run apart from the request queues, one at a time across every worker and at a capped
+// deletion rate, so they never crowd out user requests. Each is checkpointed like any other request,
+// so one interrupted by a restart or a failure resumes where it left off.
+package platformerasure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"go.uber.org/zap"
+)
+
+const (
+	claimDuration = 10 * time.Minute // Extended while the erasure runs, so only lapses if its worker dies
+	claimRenewal  = claimDuration / 3
+)
+
+// Audit log actions recorded for erasures, under their own category
+const (
+	ActionOrder         = "order"          // An erasure was ordered through the admin API
+	ActionComplete      = "complete"       // An erasure finished
+	ActionAttemptFailed = "attempt-failed" // An attempt failed, the erasure will be retried
+	ActionFail          = "fail"           // An erasure exhausted its attempts and was given up on
+)
+
+// Erasure is an erasure of a server's data ordered by the bot operator
+type Erasure struct {
+	Id                 int64      `json:"id"`
+	GuildId            uint64     `json:"guild_id,string"`
+	Reason             string     `json:"reason"`
+	Reference          string     `json:"reference,omitempty"` // Case or ticket number the erasure was ordered under
+	OrderedBy          string     `json:"ordered_by"`          // Name of the service token used
+	OrderedAt          time.Time  `json:"ordered_at"`
+	Attempts           int        `json:"attempts"`
+	TranscriptsDeleted int        `json:"transcripts_deleted"`
+	LastError          string     `json:"last_error,omitempty"`
+	CompletedAt        *time.Time `json:"completed_at,omitempty"`
+	FailedAt           *time.Time `json:"failed_at,omitempty"` // Set once the erasure exhausted its attempts
+}
+
+// RequestId is the ID the erasure is processed, checkpointed and audited under. Negative, so it
+// can't collide with the IDs of user requests, which are positive gdpr_logs row IDs.
+func (e Erasure) RequestId() int {
+	return -int(e.Id)
+}
+
+// Store tracks ordered erasures
+type Store interface {
+	// ClaimNext claims the erasure due soonest until claimUntil, counting it as an attempt. Nothing
+	// is claimed while another erasure is, so only one runs at a time across every worker.
+	ClaimNext(ctx context.Context, now, claimUntil time.Time) (Erasure, bool, error)
+	ExtendClaim(ctx context.Context, id int64, claimUntil time.Time) error
+	MarkCompleted(ctx context.Context, id int64, transcriptsDeleted int, completedAt time.Time) error
+	// Unclaim records a failed attempt, to be retried at retryAt
+	Unclaim(ctx context.Context, id int64, lastError string, retryAt time.Time) error
+	MarkFailed(ctx context.Context, id int64, lastError string, failedAt time.Time) error
+}
+
+// Auditor records what happens to each erasure in the audit log
+type Auditor interface {
+	RecordPlatformErasure(ctx context.Context, erasure Erasure, action string, success bool, detail string) error
+}
+
+// Processor runs the transcript deletion an erasure amounts to. Must be a processor created for
+// platform erasures, the only kind that accepts gdpr.VerificationModePlatform.
+type Processor interface {
+	Process(ctx context.Context, queued gdpr.QueuedRequest) processor.ProcessResult
+}
+
+// Runner carries out ordered erasures as they fall due. Several workers may run one, each erasure
+// is claimed by a single worker before it runs.
+type Runner struct {
+	store       Store
+	auditor     Auditor
+	processor   Processor
+	interval    time.Duration
+	maxAttempts int
+	retryDelay  time.Duration
+	logger      *zap.Logger
+	clock       clock.Clock
+}
+
+func NewRunner(store Store, auditor Auditor, processor Processor, interval time.Duration, maxAttempts int, retryDelay time.Duration, logger *zap.Logger) *Runner {
+	return &Runner{
+		store:       store,
+		auditor:     auditor,
+		processor:   processor,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+		logger:      logger,
+		clock:       clock.Real,
+	}
+}
+
+// SetClock replaces the clock claims and retries are timed with. Must be called before Run.
+func (r *Runner) SetClock(clk clock.Clock) {
+	r.clock = clk
+}
+
+// Run carries out due erasures until ctx is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		// Erasures due at the same time run back to back rather than one per tick
+		for ctx.Err() == nil {
+			now := r.clock.Now()
+			erasure, ok, err := r.store.ClaimNext(ctx, now, now.Add(claimDuration))
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("Failed to claim platform erasure", zap.Error(err))
+				}
+				break
+			}
+
+			if !ok {
+				break
+			}
+
+			r.run(ctx, erasure)
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, erasure Erasure) {
+	logger := r.logger.With(
+		zap.Int64("platform_erasure_id", erasure.Id),
+		zap.Int("request_id", erasure.RequestId()),
+		zap.Uint64("guild_id", erasure.GuildId),
+		zap.Int("attempt", erasure.Attempts),
+	)
+
+	logger.Info("Starting platform erasure")
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.keepClaimed(runCtx, cancel, erasure.Id, logger)
+
+	queued := gdpr.NewQueuedRequest(gdpr.Request{
+		Type:             gdpr.RequestTypeAllTranscripts,
+		GuildIds:         []uint64{erasure.GuildId},
+		VerificationMode: gdpr.VerificationModePlatform,
+	}, erasure.RequestId())
+	queued.RetryCount = erasure.Attempts - 1
+
+	result := r.processor.Process(runCtx, queued)
+
+	// Recorded even if the worker is shutting down, so the claim is released for the next worker
+	ctx = context.WithoutCancel(ctx)
+	now := r.clock.Now()
+
+	if result.Error == nil {
+		detail := fmt.Sprintf("guild %d: deleted %d transcript(s)", erasure.GuildId, result.TranscriptsDeleted)
+		if result.TicketsWithheld > 0 {
+			detail += fmt.Sprintf(", %d withheld under a legal hold", result.TicketsWithheld)
+		}
+
+		logger.Info("Platform erasure completed",
+			zap.Int("transcripts_deleted", result.TranscriptsDeleted),
+			zap.Int("tickets_withheld", result.TicketsWithheld),
+		)
+		metrics.PlatformErasures.WithLabelValues("completed").Inc()
+
+		if err := r.store.MarkCompleted(ctx, erasure.Id, result.TranscriptsDeleted, now); err != nil {
+			logger.Error("Failed to mark platform erasure completed", zap.Error(err))
+		}
+		r.audit(ctx, erasure, ActionComplete, true, detail, logger)
+		return
+	}
+
+	detail := fmt.Sprintf("guild %d: %s", erasure.GuildId, result.Error)
+	if erasure.Attempts >= r.maxAttempts {
+		logger.Error("Platform erasure failed, giving up", zap.Error(result.Error))
+		metrics.PlatformErasures.WithLabelValues("failed").Inc()
+
+		if err := r.store.MarkFailed(ctx, erasure.Id, result.Error.Error(), now); err != nil {
+			logger.Error("Failed to mark platform erasure failed", zap.Error(err))
+		}
+		r.audit(ctx, erasure, ActionFail, false, detail, logger)
+		return
+	}
+
+	logger.Warn("Platform erasure attempt failed, retrying later", zap.Error(result.Error))
+	metrics.PlatformErasures.WithLabelValues("retried").Inc()
+
+	if err := r.store.Unclaim(ctx, erasure.Id, result.Error.Error(), now.Add(r.retryDelay)); err != nil {
+		logger.Error("Failed to return platform erasure for retry", zap.Error(err))
+	}
+	r.audit(ctx, erasure, ActionAttemptFailed, false, detail, logger)
+}
+
+// keepClaimed extends the erasure's claim until ctx is done. If the claim can't be extended, the
+// erasure is stopped before another worker could pick it up, and resumes from its checkpoint later.
+func (r *Runner) keepClaimed(ctx context.Context, cancel context.CancelFunc, id int64, logger *zap.Logger) {
+	ticker := r.clock.NewTicker(claimRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := r.store.ExtendClaim(ctx, id, r.clock.Now().Add(claimDuration)); err != nil && ctx.Err() == nil {
+			logger.Error("Failed to extend platform erasure claim, stopping it", zap.Error(err))
+			cancel()
+			return
+		}
+	}
+}
+
+func (r *Runner) audit(ctx context.Context, erasure Erasure, action string, success bool, detail string, logger *zap.Logger) {
+	if r.auditor == nil {
+		return
+	}
+
+	if err := r.auditor.RecordPlatformErasure(ctx, erasure, action, success, detail); err != nil {
+		logger.Error("Failed to record platform erasure in audit log", zap.String("action", action), zap.Error(err))
+	}
+}
@@ -0,0 +1,143 @@
+// Package quarantine permanently deletes transcripts that were deleted softly, once the grace period
+// in which their request could be reversed is over
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"go.uber.org/zap"
+)
+
+const (
+	claimBatch    = 50
+	claimDuration = 10 * time.Minute // Long enough to purge a whole batch, after which another worker may take over
+	retryDelay    = 15 * time.Minute // Delay before trying again when a purge failed
+	holdDelay     = 24 * time.Hour   // Delay before checking again whether a legal hold was released
+)
+
+// Transcript is a transcript awaiting permanent deletion
+type Transcript struct {
+	Id        int64
+	RequestId int
+	GuildId   uint64
+	TicketId  int
+}
+
+// Store tracks quarantined transcripts
+type Store interface {
+	// ClaimDue claims transcripts whose grace period is over until claimUntil, so no other worker
+	// purges them or restores them in the meantime
+	ClaimDue(ctx context.Context, now, claimUntil time.Time, limit int) ([]Transcript, error)
+	MarkPurged(ctx context.Context, id int64, purgedAt time.Time) error
+	// Unclaim returns a claimed transcript to the quarantine, to be purged at retryAt
+	Unclaim(ctx context.Context, id int64, retryAt time.Time) error
+}
+
+// Purger permanently deletes a transcript, implemented by the processor
+type Purger interface {
+	PurgeQuarantined(ctx context.Context, guildId uint64, ticketId int) error
+}
+
+// Sweeper purges quarantined transcripts as their grace periods end. Several workers may run one,
+// each transcript is claimed by a single worker before it is purged.
+type Sweeper struct {
+	store    Store
+	purger   Purger
+	interval time.Duration
+	logger   *zap.Logger
+	clock    clock.Clock
+}
+
+func NewSweeper(store Store, purger Purger, interval time.Duration, logger *zap.Logger) *Sweeper {
+	return &Sweeper{
+		store:    store,
+		purger:   purger,
+		interval: interval,
+		logger:   logger,
+		clock:    clock.Real,
+	}
+}
+
+// SetClock replaces the clock grace periods are measured with. Must be called before Run.
+func (s *Sweeper) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// Run purges due transcripts until ctx is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		for ctx.Err() == nil {
+			purged, err := s.sweep(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error("Failed to purge quarantined transcripts", zap.Error(err))
+				}
+				break
+			}
+
+			// A full batch means more may be due, which are claimed straight away
+			if purged < claimBatch {
+				break
+			}
+		}
+	}
+}
+
+func (s *Sweeper) sweep(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+	transcripts, err := s.store.ClaimDue(ctx, now, now.Add(claimDuration), claimBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, transcript := range transcripts {
+		s.purge(ctx, transcript)
+	}
+
+	return len(transcripts), nil
+}
+
+func (s *Sweeper) purge(ctx context.Context, transcript Transcript) {
+	logger := s.logger.With(
+		zap.Int("request_id", transcript.RequestId),
+		zap.Uint64("guild_id", transcript.GuildId),
+		zap.Int("ticket_id", transcript.TicketId),
+	)
+
+	err := s.purger.PurgeQuarantined(ctx, transcript.GuildId, transcript.TicketId)
+	if err != nil {
+		delay := retryDelay
+		if errors.Is(err, processor.ErrLegalHold) {
+			logger.Info("Quarantined transcript is under a legal hold, keeping it")
+			metrics.QuarantinePurges.WithLabelValues("held").Inc()
+			delay = holdDelay
+		} else {
+			logger.Warn("Failed to purge quarantined transcript, retrying later", zap.Error(err))
+			metrics.QuarantinePurges.WithLabelValues("failed").Inc()
+		}
+
+		if err := s.store.Unclaim(context.WithoutCancel(ctx), transcript.Id, s.clock.Now().Add(delay)); err != nil {
+			logger.Error("Failed to return transcript to quarantine", zap.Error(err))
+		}
+		return
+	}
+
+	metrics.QuarantinePurges.WithLabelValues("purged").Inc()
+	if err := s.store.MarkPurged(context.WithoutCancel(ctx), transcript.Id, s.clock.Now()); err != nil {
+		logger.Error("Failed to mark quarantined transcript as purged", zap.Error(err))
+	}
+}
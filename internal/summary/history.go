@@ -0,0 +1,75 @@
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+)
+
+// RecordHistory stores the result of a request in the user's history hash, see gdpr.KeyHistoryFor,
+// refreshing its expiry and dropping the oldest results once it holds more than maxEntries
+func RecordHistory(ctx context.Context, redisClient redis.UniversalClient, userId uint64, entry gdpr.HistoryEntry, ttl time.Duration, maxEntries int) error {
+	marshalled, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+
+	key := gdpr.KeyHistoryFor(userId)
+
+	var length *redis.IntCmd
+	if _, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, strconv.Itoa(entry.RequestId), marshalled)
+		pipe.Expire(ctx, key, ttl)
+		length = pipe.HLen(ctx, key)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to record history entry: %w", err)
+	}
+
+	if maxEntries <= 0 || length.Val() <= int64(maxEntries) {
+		return nil
+	}
+
+	return trimHistory(ctx, redisClient, key, maxEntries)
+}
+
+// trimHistory removes the entries that finished longest ago until maxEntries remain
+func trimHistory(ctx context.Context, redisClient redis.UniversalClient, key string, maxEntries int) error {
+	raw, err := redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+
+	type stored struct {
+		field string
+		entry gdpr.HistoryEntry
+	}
+
+	entries := make([]stored, 0, len(raw))
+	for field, value := range raw {
+		var entry gdpr.HistoryEntry
+		_ = json.Unmarshal([]byte(value), &entry) // Undecodable entries sort as oldest and are dropped first
+		entries = append(entries, stored{field: field, entry: entry})
+	}
+
+	slices.SortFunc(entries, func(a, b stored) int {
+		return a.entry.FinishedAt.Compare(b.entry.FinishedAt)
+	})
+
+	fields := make([]string, 0, len(entries)-maxEntries)
+	for _, entry := range entries[:len(entries)-maxEntries] {
+		fields = append(fields, entry.field)
+	}
+
+	if err := redisClient.HDel(ctx, key, fields...).Err(); err != nil {
+		return fmt.Errorf("failed to trim history: %w", err)
+	}
+
+	return nil
+}
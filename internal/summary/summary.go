@@ -0,0 +1,82 @@
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const StreamKey = "tickets:gdpr:summaries" // Redis stream that per-request summary events are appended to
+
+type Status string
+
+const (
+	StatusCompleted   Status = "completed"
+	StatusFailed      Status = "failed"
+	StatusCancelled   Status = "cancelled"
+	StatusDuplicate   Status = "duplicate"
+	StatusRequeued    Status = "requeued"
+	StatusPanicked    Status = "panicked"
+	StatusRateLimited Status = "rate_limited"
+	StatusCoalesced   Status = "coalesced"
+)
+
+// Event is a machine-readable summary of a single processed request, emitted once per delivery for
+// ingestion by the data protection team's reporting pipeline
+type Event struct {
+	RequestId           int       `json:"request_id"`
+	RequestType         string    `json:"request_type"`
+	ScrambledUserId     string    `json:"scrambled_user_id"`
+	GuildIds            []uint64  `json:"guild_ids,omitempty"`
+	TicketCount         int       `json:"ticket_count"`
+	RetryCount          int       `json:"retry_count"`
+	Status              Status    `json:"status"`
+	TranscriptsDeleted  int       `json:"transcripts_deleted"`
+	MessagesDeleted     int       `json:"messages_deleted"`
+	TranscriptsExported int       `json:"transcripts_exported"`
+	ReferencesScrubbed  int       `json:"references_scrubbed"`
+	FeedbackDeleted     int       `json:"feedback_deleted"`
+	LeftoverObjects     int       `json:"leftover_objects,omitempty"`
+	TicketsWithheld     int       `json:"tickets_withheld,omitempty"`
+	TicketsNoTranscript int       `json:"tickets_no_transcript,omitempty"`
+	BytesFetched        int64     `json:"bytes_fetched"`   // Transcript data downloaded from the archiver
+	BytesRewritten      int64     `json:"bytes_rewritten"` // Cleaned transcript data uploaded to the archiver
+	BytesDeleted        int64     `json:"bytes_deleted"`   // Transcript data deleted, where the archiver reports it
+	DryRun              bool      `json:"dry_run,omitempty"`
+	Locale              string    `json:"locale"` // Locale the user was notified in
+	Error               string    `json:"error,omitempty"`
+	CallbackDelivered   bool      `json:"callback_delivered"`
+	CallbackError       string    `json:"callback_error,omitempty"`
+	StartedAt           time.Time `json:"started_at"`
+	FinishedAt          time.Time `json:"finished_at"`
+	DurationMs          int64     `json:"duration_ms"`
+
+	VerifiedOwners map[uint64]string `json:"verified_owners,omitempty"` // Scrambled ID of each server's owner when the requester was verified
+
+	PermanentlyFailed bool            `json:"permanently_failed,omitempty"` // Whether a failed request exhausted its retries
+	ResultCode        gdpr.ResultCode `json:"result_code,omitempty"`        // Classification of the outcome, unset for duplicate and requeued deliveries
+}
+
+// Publish writes the event to the log and appends it to the summary stream, capped at maxLen entries
+func Publish(ctx context.Context, redisClient redis.UniversalClient, event Event, maxLen int64, logger *zap.Logger) {
+	logger.Info("GDPR request summary", zap.Any("summary", event))
+
+	marshalled, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to marshal request summary", zap.Error(err), zap.Int("request_id", event.RequestId))
+		return
+	}
+
+	if err := redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: StreamKey,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": string(marshalled)},
+	}).Err(); err != nil {
+		logger.Error("Failed to publish request summary", zap.Error(err), zap.Int("request_id", event.RequestId))
+	}
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/TicketsBot-cloud/gdpr-worker"
+
+// Attribute keys shared by every span, so traces can be searched by request
+const (
+	AttributeRequestId   = attribute.Key("gdpr.request_id")
+	AttributeRequestType = attribute.Key("gdpr.request_type")
+	AttributeGuildId     = attribute.Key("gdpr.guild_id")
+	AttributeTicketId    = attribute.Key("gdpr.ticket_id")
+	AttributeTicketCount = attribute.Key("gdpr.ticket_count")
+	AttributeStatus      = attribute.Key("gdpr.status")
+)
+
+// Setup exports spans to the OTLP/HTTP collector at endpoint, e.g. http://tempo:4318. Until it is
+// called spans are no-ops, so tracing costs nothing when disabled. The returned function flushes
+// buffered spans and must be called on shutdown.
+func Setup(ctx context.Context, endpoint, serviceName string, sampleRatio float64) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+type requestIdKey struct{}
+
+// GuildId is a guild ID attribute. Snowflakes are carried as strings, as not every backend handles
+// unsigned 64-bit integers.
+func GuildId(guildId uint64) attribute.KeyValue {
+	return AttributeGuildId.String(strconv.FormatUint(guildId, 10))
+}
+
+// WithRequestId tags every span started from ctx with the request ID
+func WithRequestId(ctx context.Context, requestId int) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// Start opens a span as a child of any span in ctx, tagged with the request ID carried by ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if requestId, ok := ctx.Value(requestIdKey{}).(int); ok {
+		attrs = append(attrs, AttributeRequestId.Int(requestId))
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartAt opens a span that began at an earlier time, such as when a request was queued
+func StartAt(ctx context.Context, name string, at time.Time, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if requestId, ok := ctx.Value(requestIdKey{}).(int); ok {
+		attrs = append(attrs, AttributeRequestId.Int(requestId))
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...), trace.WithTimestamp(at))
+}
+
+// StartQuery opens a span for a database query
+func StartQuery(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.system", "postgresql"), attribute.String("db.operation.name", operation))
+	return Start(ctx, "db."+operation, attrs...)
+}
+
+// End closes the span, marking it as failed if err is non-nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -2,10 +2,12 @@ package utils
 
 import (
 	"crypto/sha256"
-	"strconv"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/TicketsBot-cloud/gdl/objects/interaction/component"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
 )
 
 type Colour int
@@ -30,6 +32,11 @@ func (c Colour) ToRGB() int {
 }
 
 func BuildContainerWithComponents(colour Colour, title string, innerComponents []component.Component) component.Component {
+	return BuildContainerWithAccent(colour.ToRGB(), title, innerComponents)
+}
+
+// BuildContainerWithAccent builds a container under a title with an accent colour given as RGB
+func BuildContainerWithAccent(accentColor int, title string, innerComponents []component.Component) component.Component {
 	components := []component.Component{
 		component.BuildTextDisplay(component.TextDisplay{
 			Content: fmt.Sprintf("### %s", title),
@@ -39,13 +46,25 @@ func BuildContainerWithComponents(colour Colour, title string, innerComponents [
 
 	components = append(components, innerComponents...)
 
-	accentColor := colour.ToRGB()
 	return component.BuildContainer(component.Container{
 		AccentColor: &accentColor,
 		Components:  components,
 	})
 }
 
+// ProgressBar renders done out of total as a text progress bar of the given width
+func ProgressBar(done, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * width / total
+	}
+	if filled > width {
+		filled = width
+	}
+
+	return fmt.Sprintf("`%s%s`", strings.Repeat("█", filled), strings.Repeat("░", width-filled))
+}
+
 func FormatGuildDisplay(guildId uint64, guildNames map[uint64]string) string {
 	if name, ok := guildNames[guildId]; ok && name != "" {
 		return name + " (" + strconv.FormatUint(guildId, 10) + ")"
@@ -62,18 +81,6 @@ func ScrambleUserId(userId uint64) string {
 }
 
 // GetRequestTypeName converts a request type integer to a human-readable string for logging
-// Request types: 0=AllTranscripts, 1=SpecificTranscripts, 2=AllMessages, 3=SpecificMessages
 func GetRequestTypeName(requestType int) string {
-	switch requestType {
-	case 0:
-		return "AllTranscripts"
-	case 1:
-		return "SpecificTranscripts"
-	case 2:
-		return "AllMessages"
-	case 3:
-		return "SpecificMessages"
-	default:
-		return fmt.Sprintf("Unknown(%d)", requestType)
-	}
+	return gdpr.RequestType(requestType).String()
 }
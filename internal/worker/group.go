@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Group fans control commands out to the shared worker and the workers of any dedicated per-type
+// queues, so they can be operated as one
+type Group struct {
+	shared *Worker
+	lanes  []*Worker
+}
+
+// NewGroup creates a group around the worker consuming the shared queue and the workers consuming
+// dedicated per-type queues
+func NewGroup(shared *Worker, lanes ...*Worker) *Group {
+	return &Group{
+		shared: shared,
+		lanes:  lanes,
+	}
+}
+
+func (g *Group) all() []*Worker {
+	return append([]*Worker{g.shared}, g.lanes...)
+}
+
+func (g *Group) Pause() {
+	for _, w := range g.all() {
+		w.Pause()
+	}
+}
+
+func (g *Group) Resume() {
+	for _, w := range g.all() {
+		w.Resume()
+	}
+}
+
+func (g *Group) Drain() {
+	for _, w := range g.all() {
+		w.Drain()
+	}
+}
+
+// SetConcurrency changes the concurrency of the shared worker. Dedicated queues keep the
+// concurrency they were configured with.
+func (g *Group) SetConcurrency(concurrency int) error {
+	return g.shared.SetConcurrency(concurrency)
+}
+
+// Status reports on the shared worker followed by the workers of dedicated queues
+func (g *Group) Status() []Status {
+	workers := g.all()
+
+	statuses := make([]Status, len(workers))
+	for i, w := range workers {
+		statuses[i] = w.Status()
+	}
+
+	return statuses
+}
+
+// ListenCancellations stops or removes requests as their users cancel them, until ctx is cancelled
+func (g *Group) ListenCancellations(ctx context.Context, redisClient redis.UniversalClient, logger *zap.Logger) {
+	ch := make(chan int)
+	go gdprrelay.ListenCancellations(ctx, redisClient, ch, logger)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case requestId := <-ch:
+			if !g.cancelByUser(ctx, requestId) {
+				logger.Debug("Cancelled GDPR request not found on this worker", zap.Int("request_id", requestId))
+			}
+		}
+	}
+}
+
+// cancelByUser stops the request if it's in flight, or otherwise takes it out of the queue
+func (g *Group) cancelByUser(ctx context.Context, requestId int) bool {
+	for _, w := range g.all() {
+		if w.cancel(requestId, cancelReasonUser) {
+			return true
+		}
+	}
+
+	for _, w := range g.all() {
+		if w.removeCancelled(ctx, requestId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Group) Cancel(requestId int) bool {
+	for _, w := range g.all() {
+		if w.Cancel(requestId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Shutdown shuts down every worker in parallel, returning whether they all finished in time
+func (g *Group) Shutdown(timeout time.Duration) bool {
+	workers := g.all()
+	results := make([]bool, len(workers))
+
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func(i int, w *Worker) {
+			defer wg.Done()
+			results[i] = w.Shutdown(timeout)
+		}(i, w)
+	}
+	wg.Wait()
+
+	for _, ok := range results {
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+)
+
+// completedCode classifies a request that was processed without error, by whether everything in
+// scope was handled and anything was found
+func completedCode(result processor.ProcessResult) gdpr.ResultCode {
+	partial := result.TicketsWithheld > 0 || len(result.UnmatchedTicketIds) > 0
+	for _, guildResult := range result.GuildResults {
+		if guildResult.Rejected || guildResult.Failed > 0 || guildResult.Error != nil {
+			partial = true
+		}
+	}
+
+	if partial {
+		return gdpr.ResultPartial
+	}
+
+	// An export always holds the user's account data, even without any transcripts
+	if result.TranscriptsDeleted == 0 && result.MessagesDeleted == 0 && result.FeedbackDeleted == 0 &&
+		result.ReferencesScrubbed == 0 && result.ExportUrl == "" {
+		return gdpr.ResultNoData
+	}
+
+	return gdpr.ResultCompleted
+}
+
+// failedCode classifies a failed attempt, by whether the request will be tried again and why it
+// failed
+func failedCode(result processor.ProcessResult, exhausted, timedOut bool) gdpr.ResultCode {
+	switch {
+	case processor.IsNotAuthorized(result.Error):
+		return gdpr.ResultNotAuthorized
+	case !exhausted:
+		return gdpr.ResultFailedTransient
+	case timedOut:
+		return gdpr.ResultExpired
+	default:
+		return gdpr.ResultFailedPermanent
+	}
+}
@@ -0,0 +1,1472 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/callback"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/clock"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/config"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/database"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/erasure"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/gdprrelay"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/latesweep"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/summary"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/processor"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/webhook"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+)
+
+// Worker dispatches dequeued GDPR requests to the processor, bounding how many run at once
+type Worker struct {
+	logger      *zap.Logger
+	redisClient redis.UniversalClient
+	queue       gdprrelay.Queue
+	processor   *processor.Processor
+	callback    Notifier
+	issuer      *erasure.Issuer // Issues certificates of erasure, nil if disabled
+	slo         *metrics.SLOTracker
+	clock       clock.Clock
+
+	name         string                             // Queue the worker consumes, as shown in status reports
+	timeout      time.Duration                      // How long a request may run before it is cancelled and rejected, 0 for no limit
+	typeTimeouts map[gdpr.RequestType]time.Duration // Per request type overrides of timeout
+	webhook      *callback.WebhookDispatcher        // Sends final outcomes to an external compliance system, nil if disabled
+	buffer       *gdprrelay.OfflineBuffer           // Holds completions that couldn't be written to Redis, nil if disabled
+	states       *gdprrelay.StateStore              // Keeps the state of each request for status queries, nil if disabled
+	lateSweeps   *latesweep.Scheduler               // Re-checks tickets archived after a message erasure, nil if disabled
+	guildNotices *callback.GuildNotifier            // Tells servers' staff when transcripts or messages in them were erased, nil if disabled
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	concurrency int                        // Maximum number of requests processed at once
+	running     int                        // Number of requests currently being processed
+	accepting   bool                       // Whether Run is waiting on a request it could start straight away
+	paused      bool                       // Whether new requests are being held back
+	stopping    bool                       // Whether the worker is shutting down and must not start new requests
+	inFlight    map[int]context.CancelFunc // Cancel functions of running requests, keyed by request ID
+	cancelled   map[int]cancelReason       // Why in-flight requests were cancelled, keyed by request ID
+	wg          sync.WaitGroup
+}
+
+// Notifier delivers the result of a request to the user who made it
+type Notifier interface {
+	SendCompletion(ctx context.Context, queued gdprrelay.QueuedRequest, result callback.ResultData) error
+}
+
+type cancelReason int
+
+const (
+	cancelReasonNone     cancelReason = iota
+	cancelReasonOperator              // Cancelled via the control channel
+	cancelReasonShutdown              // Cancelled because the worker is shutting down
+	cancelReasonUser                  // Cancelled by the user who made the request
+)
+
+// fingerprintClaimTTL is how long a request stays recorded as doing the work behind its fingerprint
+// after it was last delivered, so a claim left behind by a crashed worker doesn't block identical
+// requests forever
+const fingerprintClaimTTL = 24 * time.Hour
+
+// requeueGracePeriod is how long in-flight requests get to requeue themselves once cancelled during shutdown
+const requeueGracePeriod = 5 * time.Second
+
+func New(logger *zap.Logger, redisClient redis.UniversalClient, queue gdprrelay.Queue, proc *processor.Processor, callbackHandler Notifier, issuer *erasure.Issuer, slo *metrics.SLOTracker, concurrency int) *Worker {
+	w := &Worker{
+		logger:      logger,
+		redisClient: redisClient,
+		queue:       queue,
+		processor:   proc,
+		callback:    callbackHandler,
+		issuer:      issuer,
+		slo:         slo,
+		clock:       clock.Real,
+		name:        "shared",
+		concurrency: concurrency,
+		inFlight:    make(map[int]context.CancelFunc),
+		cancelled:   make(map[int]cancelReason),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	return w
+}
+
+// SetClock replaces the clock request timings and shutdown timeouts are measured with. Must be
+// called before Run.
+func (w *Worker) SetClock(clk clock.Clock) {
+	w.clock = clk
+}
+
+// SetName names the queue the worker consumes in status reports. Must be called before Run.
+func (w *Worker) SetName(name string) {
+	w.name = name
+}
+
+// SetTimeouts limits how long each request may be processed for, so a hung call can't hold on to a
+// slot forever. A request type's override takes precedence over timeout, 0 means no limit. Must be
+// called before Run.
+func (w *Worker) SetTimeouts(timeout time.Duration, overrides map[gdpr.RequestType]time.Duration) {
+	w.timeout = timeout
+	w.typeTimeouts = overrides
+}
+
+// SetWebhook sends the final outcome of every request through dispatcher. Must be called before Run.
+func (w *Worker) SetWebhook(dispatcher *callback.WebhookDispatcher) {
+	w.webhook = dispatcher
+}
+
+// SetBuffer keeps the acknowledgement and completion of requests that finish while Redis is
+// unreachable in buffer, until RunBufferReplay writes them. Must be called before Run.
+func (w *Worker) SetBuffer(buffer *gdprrelay.OfflineBuffer) {
+	w.buffer = buffer
+}
+
+// SetStates records the outcome of each request in the store the processor saves its stages to,
+// so status queries see it finish
+func (w *Worker) SetStates(states *gdprrelay.StateStore) {
+	w.states = states
+}
+
+// SetLateSweeps schedules a re-check of the tickets of completed message erasures that had no
+// transcript yet. Must be called before Run.
+func (w *Worker) SetLateSweeps(scheduler *latesweep.Scheduler) {
+	w.lateSweeps = scheduler
+}
+
+// SetGuildNotices posts a notice in the log channel of each server a completed request erased
+// anything in. Must be called before Run.
+func (w *Worker) SetGuildNotices(notifier *callback.GuildNotifier) {
+	w.guildNotices = notifier
+}
+
+func (w *Worker) timeoutFor(requestType gdpr.RequestType) time.Duration {
+	if timeout, ok := w.typeTimeouts[requestType]; ok {
+		return timeout
+	}
+
+	return w.timeout
+}
+
+// Run consumes requests from ch until it is closed. The listener feeding ch should be given the
+// worker as its gdprrelay.Capacity, so it only dequeues requests the worker has a slot for.
+func (w *Worker) Run(ch <-chan gdprrelay.QueuedRequest) {
+	for {
+		w.awaitCapacity()
+
+		request, ok := <-ch
+		if !ok {
+			return
+		}
+
+		if !w.acquire() {
+			// Dequeued just as shutdown started, hand it back rather than leaving it in processing
+			if err := w.queue.Requeue(context.Background(), request); err != nil {
+				w.logger.Error("Failed to requeue GDPR request on shutdown",
+					zap.Int("request_id", request.RequestID),
+					zap.Error(err),
+				)
+			}
+			continue
+		}
+
+		w.wg.Add(1)
+
+		go func(req gdprrelay.QueuedRequest) {
+			defer w.wg.Done()
+			defer w.release()
+			defer w.recoverPanic(req)
+
+			w.handle(req)
+		}(request)
+	}
+}
+
+// Pause stops new requests from being started, in-flight requests are unaffected
+func (w *Worker) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.paused = true
+	w.logger.Info("Worker paused")
+}
+
+func (w *Worker) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.paused = false
+	w.cond.Broadcast()
+	w.logger.Info("Worker resumed")
+}
+
+// Drain pauses the worker and logs once all in-flight requests have finished
+func (w *Worker) Drain() {
+	w.Pause()
+
+	go func() {
+		w.wg.Wait()
+		w.logger.Info("Worker drained, no requests in flight")
+	}()
+}
+
+func (w *Worker) SetConcurrency(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.concurrency = concurrency
+	w.cond.Broadcast()
+	w.logger.Info("Worker concurrency updated", zap.Int("concurrency", concurrency))
+
+	return nil
+}
+
+// Status is a snapshot of what a worker is doing
+type Status struct {
+	Queue       string `json:"queue"`
+	Concurrency int    `json:"concurrency"`
+	Running     int    `json:"running"`
+	Paused      bool   `json:"paused"`
+	Stopping    bool   `json:"stopping"`
+	InFlight    []int  `json:"in_flight"` // IDs of the requests being processed
+}
+
+func (w *Worker) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	inFlight := make([]int, 0, len(w.inFlight))
+	for requestId := range w.inFlight {
+		inFlight = append(inFlight, requestId)
+	}
+	slices.Sort(inFlight)
+
+	return Status{
+		Queue:       w.name,
+		Concurrency: w.concurrency,
+		Running:     w.running,
+		Paused:      w.paused,
+		Stopping:    w.stopping,
+		InFlight:    inFlight,
+	}
+}
+
+// Cancel aborts an in-flight request, returning false if no such request is running
+func (w *Worker) Cancel(requestId int) bool {
+	return w.cancel(requestId, cancelReasonOperator)
+}
+
+func (w *Worker) cancel(requestId int, reason cancelReason) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cancel, ok := w.inFlight[requestId]
+	if !ok {
+		return false
+	}
+
+	w.cancelled[requestId] = reason
+	cancel()
+
+	return true
+}
+
+// removeCancelled takes a request its user cancelled out of the queue if it's still waiting there,
+// and tells the user it was cancelled. Returns false if the request isn't waiting in this
+// worker's queue.
+func (w *Worker) removeCancelled(ctx context.Context, requestId int) bool {
+	remover, ok := w.queue.(gdprrelay.Remover)
+	if !ok {
+		return false
+	}
+
+	req, removed, err := remover.Remove(ctx, requestId)
+	if err != nil {
+		w.logger.Error("Failed to remove cancelled GDPR request from the queue",
+			zap.Int("request_id", requestId),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	if !removed {
+		return false
+	}
+
+	w.logger.Info("Removed GDPR request cancelled by its user from the queue",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(req.Request.UserId)),
+		zap.Int("request_id", requestId),
+	)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		event := w.newEvent(req)
+		w.finishCancelled(context.Background(), req, &event, processor.ProcessResult{})
+		w.finish(req, &event, nil)
+	}()
+
+	return true
+}
+
+// finishCancelled records a request cancelled by its user and tells them, including anything
+// deleted before it was stopped
+func (w *Worker) finishCancelled(ctx context.Context, req gdprrelay.QueuedRequest, event *summary.Event, result processor.ProcessResult) {
+	scrambledId := utils.ScrambleUserId(req.Request.UserId)
+	event.Status = summary.StatusCancelled
+	event.ResultCode = gdpr.ResultCancelled
+
+	if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Cancelled")); updateErr != nil {
+		w.logger.Error("Failed to update GDPR log",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(updateErr),
+		)
+	}
+
+	callbackCtx, callbackCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer callbackCancel()
+
+	callbackData := callback.ResultData{
+		TranscriptsDeleted: result.TranscriptsDeleted,
+		MessagesDeleted:    result.MessagesDeleted,
+		FeedbackDeleted:    result.FeedbackDeleted,
+		Cancelled:          true,
+		RequestType:        req.Request.Type,
+		ResultCode:         event.ResultCode,
+		GuildIds:           req.Request.GuildIds,
+		TicketIds:          req.Request.TicketIds,
+	}
+
+	if err := w.callback.SendCompletion(callbackCtx, req, callbackData); err != nil {
+		event.CallbackError = err.Error()
+
+		w.logger.Error("Failed to notify user of cancellation",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else {
+		event.CallbackDelivered = true
+	}
+}
+
+// checkQuota counts the request against its user's quota, returning when they may make another
+// request if it is used up. Requests made by the worker itself, such as guild purges, have no user
+// and aren't limited. The quota is only a guard against abuse, so it isn't enforced while Redis
+// can't be reached.
+func (w *Worker) checkQuota(ctx context.Context, req gdprrelay.QueuedRequest) (time.Time, bool) {
+	if config.Conf.Quota.MaxRequests <= 0 || req.Request.UserId == 0 {
+		return time.Time{}, false
+	}
+
+	admitted, retryAt, err := gdprrelay.AdmitRequest(ctx, w.redisClient, req.Request.UserId, req.RequestID,
+		config.Conf.Quota.MaxRequests, config.Conf.Quota.Window, w.clock.Now())
+	if err != nil {
+		w.logger.Error("Failed to check GDPR request quota, processing request anyway",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", utils.ScrambleUserId(req.Request.UserId)),
+			zap.Error(err),
+		)
+		return time.Time{}, false
+	}
+
+	return retryAt, !admitted
+}
+
+// finishRateLimited records a request rejected for exceeding its user's quota and tells them when
+// they can make another
+func (w *Worker) finishRateLimited(ctx context.Context, req gdprrelay.QueuedRequest, event *summary.Event, retryAt time.Time) {
+	scrambledId := utils.ScrambleUserId(req.Request.UserId)
+	event.Status = summary.StatusRateLimited
+	event.ResultCode = gdpr.ResultRateLimited
+
+	if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Rate Limited")); updateErr != nil {
+		w.logger.Error("Failed to update GDPR log",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(updateErr),
+		)
+	}
+
+	callbackCtx, callbackCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer callbackCancel()
+
+	callbackData := callback.ResultData{
+		RateLimitedUntil: retryAt,
+		RequestType:      req.Request.Type,
+		ResultCode:       event.ResultCode,
+		GuildIds:         req.Request.GuildIds,
+		TicketIds:        req.Request.TicketIds,
+	}
+
+	if err := w.callback.SendCompletion(callbackCtx, req, callbackData); err != nil {
+		event.CallbackError = err.Error()
+
+		w.logger.Error("Failed to notify user of rate limited request",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else {
+		event.CallbackDelivered = true
+	}
+}
+
+// checkInFlight records the request as doing the work behind its fingerprint, returning the ID of
+// the identical request already doing it if there is one, such as when a user repeats a command
+// before their first request finishes. Coalescing only saves work, so requests are processed as
+// usual while Redis can't be reached.
+func (w *Worker) checkInFlight(ctx context.Context, req gdprrelay.QueuedRequest) (int, bool) {
+	ownerId, err := gdprrelay.ClaimFingerprint(ctx, w.redisClient, req.Request.Fingerprint(), req.RequestID, fingerprintClaimTTL)
+	if err != nil {
+		w.logger.Error("Failed to check for identical GDPR requests, processing request anyway",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", utils.ScrambleUserId(req.Request.UserId)),
+			zap.Error(err),
+		)
+		return 0, false
+	}
+
+	return ownerId, ownerId != req.RequestID
+}
+
+// finishCoalesced records a request folded into an identical one already being processed and tells
+// its user the earlier request covers it
+func (w *Worker) finishCoalesced(ctx context.Context, req gdprrelay.QueuedRequest, event *summary.Event, ownerId int) {
+	scrambledId := utils.ScrambleUserId(req.Request.UserId)
+	event.Status = summary.StatusCoalesced
+	event.ResultCode = gdpr.ResultCoalesced
+
+	if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Coalesced")); updateErr != nil {
+		w.logger.Error("Failed to update GDPR log",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(updateErr),
+		)
+	}
+
+	callbackCtx, callbackCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer callbackCancel()
+
+	callbackData := callback.ResultData{
+		CoalescedInto: ownerId,
+		RequestType:   req.Request.Type,
+		ResultCode:    event.ResultCode,
+		GuildIds:      req.Request.GuildIds,
+		TicketIds:     req.Request.TicketIds,
+	}
+
+	if err := w.callback.SendCompletion(callbackCtx, req, callbackData); err != nil {
+		event.CallbackError = err.Error()
+
+		w.logger.Error("Failed to notify user of coalesced request",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else {
+		event.CallbackDelivered = true
+	}
+}
+
+// releaseInFlight lets identical requests be processed again once the request reaches an outcome.
+// Requests that will be delivered again keep their claim, and claim it again on redelivery.
+func (w *Worker) releaseInFlight(req gdprrelay.QueuedRequest, event summary.Event) {
+	switch {
+	case event.Status == summary.StatusRequeued, event.Status == summary.StatusCoalesced:
+		return
+	case (event.Status == summary.StatusFailed || event.Status == summary.StatusPanicked) && !event.PermanentlyFailed:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := gdprrelay.ReleaseFingerprint(ctx, w.redisClient, req.Request.Fingerprint(), req.RequestID); err != nil {
+		w.logger.Error("Failed to release GDPR request fingerprint",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", event.ScrambledUserId),
+			zap.Error(err),
+		)
+	}
+}
+
+// Shutdown stops new requests from starting and waits up to timeout for in-flight requests to
+// finish. Requests still running after that are cancelled and requeued. Returns whether every
+// request finished or was requeued in time.
+func (w *Worker) Shutdown(timeout time.Duration) bool {
+	w.mu.Lock()
+	w.stopping = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	if w.wait(timeout) {
+		return true
+	}
+
+	w.mu.Lock()
+	w.logger.Warn("Shutdown timeout reached, requeueing in-flight requests", zap.Int("in_flight", len(w.inFlight)))
+	for requestId, cancel := range w.inFlight {
+		w.cancelled[requestId] = cancelReasonShutdown
+		cancel()
+	}
+	w.mu.Unlock()
+
+	return w.wait(requeueGracePeriod)
+}
+
+func (w *Worker) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-w.clock.After(timeout):
+		return false
+	}
+}
+
+// AwaitSlot blocks until Run is waiting on a request it could start straight away, see
+// gdprrelay.Capacity
+func (w *Worker) AwaitSlot(ctx context.Context) bool {
+	// cond.Wait can't select on ctx, so waiters are woken to notice it's done
+	stop := context.AfterFunc(ctx, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		w.cond.Broadcast()
+	})
+	defer stop()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ctx.Err() == nil && !w.stopping && (w.paused || !w.accepting) {
+		w.cond.Wait()
+	}
+
+	return ctx.Err() == nil && !w.stopping
+}
+
+// awaitCapacity blocks until a request may be started, then marks Run as accepting one. Returns
+// straight away if the worker is shutting down, so requests dequeued meanwhile can be handed back.
+func (w *Worker) awaitCapacity() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for !w.stopping && (w.paused || w.running >= w.concurrency) {
+		w.cond.Wait()
+	}
+
+	if !w.stopping {
+		w.accepting = true
+		w.cond.Broadcast()
+	}
+}
+
+// acquire blocks until a request may be started, returning false if the worker is shutting down
+func (w *Worker) acquire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.accepting = false
+
+	for !w.stopping && (w.paused || w.running >= w.concurrency) {
+		w.cond.Wait()
+	}
+
+	if w.stopping {
+		return false
+	}
+
+	w.running++
+	return true
+}
+
+func (w *Worker) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.running--
+	w.cond.Broadcast()
+}
+
+func (w *Worker) track(requestId int, cancel context.CancelFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.inFlight[requestId] = cancel
+}
+
+// untrack removes a finished request and reports why it was cancelled, if it was
+func (w *Worker) untrack(requestId int) cancelReason {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cancelled := w.cancelled[requestId]
+	delete(w.inFlight, requestId)
+	delete(w.cancelled, requestId)
+
+	return cancelled
+}
+
+// recoverPanic keeps the worker alive should anything panic outside of handle's own recovery,
+// rejecting the request so it is retried or moved to the failed queue instead of being orphaned in
+// the processing queue
+func (w *Worker) recoverPanic(req gdprrelay.QueuedRequest) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	event := w.newEvent(req)
+	w.rejectPanicked(req, &event, r)
+}
+
+// rejectPanicked rejects a request whose processing panicked, recording in event whether it will
+// be retried, and tells the user if its retries are used up
+func (w *Worker) rejectPanicked(req gdprrelay.QueuedRequest, event *summary.Event, r any) {
+	w.untrack(req.RequestID)
+
+	scrambledId := utils.ScrambleUserId(req.Request.UserId)
+	w.logger.Error("Panic while processing GDPR request",
+		zap.String("scrambled_user_id", scrambledId),
+		zap.String("request_type", utils.GetRequestTypeName(int(req.Request.Type))),
+		zap.Int("request_id", req.RequestID),
+		zap.Any("panic", r),
+		zap.Stack("stack"),
+	)
+
+	req.LastError = fmt.Sprintf("panic: %v", r)
+
+	exhausted, rejectErr := w.queue.Reject(context.Background(), req)
+	if rejectErr != nil {
+		w.logger.Error("Failed to reject GDPR request after panic",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(rejectErr),
+		)
+	}
+
+	event.Status = summary.StatusPanicked
+	event.Error = req.LastError
+	event.PermanentlyFailed = exhausted
+	event.ResultCode = failedCode(processor.ProcessResult{}, exhausted, false)
+
+	if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Failed")); updateErr != nil {
+		w.logger.Error("Failed to update GDPR log",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(updateErr),
+		)
+	}
+
+	if exhausted {
+		callbackCtx, callbackCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer callbackCancel()
+
+		callbackData := callback.ResultData{
+			Error:             errors.New("an unexpected error occurred while processing your request"),
+			PermanentlyFailed: true,
+			RequestType:       req.Request.Type,
+			ResultCode:        event.ResultCode,
+			GuildIds:          req.Request.GuildIds,
+			TicketIds:         req.Request.TicketIds,
+		}
+
+		if err := w.callback.SendCompletion(callbackCtx, req, callbackData); err != nil {
+			event.CallbackError = err.Error()
+
+			w.logger.Error("Failed to notify user of permanent failure",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(err),
+			)
+		} else {
+			event.CallbackDelivered = true
+		}
+	}
+}
+
+func (w *Worker) handle(req gdprrelay.QueuedRequest) {
+	scrambledId := utils.ScrambleUserId(req.Request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(req.Request.Type))
+
+	// The trace starts when the request was queued, so time spent waiting for a worker shows up in it
+	queuedAt := req.QueuedAt
+	if queuedAt.IsZero() {
+		queuedAt = w.clock.Now()
+	}
+
+	traceCtx, span := tracing.StartAt(tracing.WithRequestId(context.Background(), req.RequestID), "gdpr.request", queuedAt,
+		tracing.AttributeRequestType.String(requestTypeName),
+		tracing.AttributeTicketCount.Int(len(req.Request.TicketIds)),
+		attribute.Int("gdpr.retry_count", req.RetryCount),
+	)
+
+	_, dequeueSpan := tracing.StartAt(traceCtx, "gdpr.dequeue", queuedAt)
+	dequeueSpan.End()
+
+	processCtx, processCancel := context.WithCancel(traceCtx)
+	defer processCancel()
+
+	timeout := w.timeoutFor(req.Request.Type)
+	if timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		processCtx, timeoutCancel = context.WithTimeout(processCtx, timeout)
+		defer timeoutCancel()
+	}
+
+	w.track(req.RequestID, processCancel)
+
+	event := w.newEvent(req)
+	var verifiedOwners map[uint64]uint64
+	defer func() {
+		// The request is rejected before the outcome is reported, so the report says whether it
+		// will be retried
+		if r := recover(); r != nil {
+			w.rejectPanicked(req, &event, r)
+		}
+
+		w.finish(req, &event, verifiedOwners)
+
+		span.SetAttributes(tracing.AttributeStatus.String(string(event.Status)))
+		if event.Status == summary.StatusFailed || event.Status == summary.StatusPanicked {
+			span.SetStatus(codes.Error, event.Error)
+		}
+		span.End()
+	}()
+
+	// Redis failovers can duplicate list entries, re-running a destructive deletion must be avoided.
+	// Completions buffered during an outage aren't in Redis yet, so the buffer is checked first.
+	var (
+		completed bool
+		err       error
+	)
+	if w.buffer != nil && w.buffer.IsCompleted(req.RequestID) {
+		completed = true
+	} else {
+		completed, err = gdprrelay.IsCompleted(processCtx, w.redisClient, req.RequestID)
+	}
+	if err != nil {
+		w.logger.Error("Failed to check whether GDPR request already completed",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else if completed {
+		w.untrack(req.RequestID)
+		event.Status = summary.StatusDuplicate
+
+		w.logger.Warn("Ignoring duplicate delivery of completed GDPR request",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.String("request_type", requestTypeName),
+			zap.Int("request_id", req.RequestID),
+		)
+
+		if ackErr := w.queue.Acknowledge(processCtx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge duplicate GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	// Users can cancel a request while it waits in the queue, which the cancellation listener may
+	// have missed, for example if this worker wasn't running at the time
+	cancelled, err := gdprrelay.IsCancelled(processCtx, w.redisClient, req.RequestID)
+	if err != nil {
+		w.logger.Error("Failed to check whether GDPR request was cancelled",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else if cancelled {
+		w.untrack(req.RequestID)
+
+		w.logger.Info("Skipping GDPR request cancelled by its user",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+		)
+
+		w.finishCancelled(processCtx, req, &event, processor.ProcessResult{})
+
+		if ackErr := w.queue.Acknowledge(processCtx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge cancelled GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	if ownerId, coalesced := w.checkInFlight(processCtx, req); coalesced {
+		w.untrack(req.RequestID)
+
+		w.logger.Info("Coalescing GDPR request into identical request already being processed",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+			zap.Int("coalesced_into", ownerId),
+		)
+
+		w.finishCoalesced(processCtx, req, &event, ownerId)
+
+		if ackErr := w.queue.Acknowledge(processCtx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge coalesced GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	if retryAt, limited := w.checkQuota(processCtx, req); limited {
+		w.untrack(req.RequestID)
+
+		w.logger.Info("Rejecting GDPR request as its user has used up their quota",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+			zap.Time("retry_at", retryAt),
+		)
+
+		w.finishRateLimited(processCtx, req, &event, retryAt)
+
+		if ackErr := w.queue.Acknowledge(processCtx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge rate limited GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	w.logger.Info("Processing GDPR request",
+		zap.String("scrambled_user_id", scrambledId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("request_id", req.RequestID),
+		zap.Int("retry_count", req.RetryCount),
+		zap.Time("queued_at", req.QueuedAt),
+	)
+
+	result := w.processor.Process(processCtx, req)
+
+	// Whatever the processor gave up with, the deadline is why, and it's what operators need to see
+	timedOut := result.Error != nil && errors.Is(processCtx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		result.Error = fmt.Errorf("request timed out after %s: %w", timeout, result.Error)
+		metrics.RequestsTimedOut.WithLabelValues(requestTypeName).Inc()
+
+		w.logger.Warn("GDPR request timed out",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.String("request_type", requestTypeName),
+			zap.Int("request_id", req.RequestID),
+			zap.Duration("timeout", timeout),
+		)
+	}
+
+	event.TranscriptsDeleted = result.TranscriptsDeleted
+	event.MessagesDeleted = result.MessagesDeleted
+	event.TranscriptsExported = result.TranscriptsExported
+	event.ReferencesScrubbed = result.ReferencesScrubbed
+	event.FeedbackDeleted = result.FeedbackDeleted
+	event.LeftoverObjects = result.LeftoverObjects
+	event.TicketsWithheld = result.TicketsWithheld
+	event.TicketsNoTranscript = result.TicketsNoTranscript
+	event.BytesFetched = result.ArchiverBytes.Fetched
+	event.BytesRewritten = result.ArchiverBytes.Rewritten
+	event.BytesDeleted = result.ArchiverBytes.Deleted
+	event.DryRun = result.DryRun
+	verifiedOwners = result.VerifiedOwners
+	if len(verifiedOwners) > 0 {
+		event.VerifiedOwners = make(map[uint64]string, len(verifiedOwners))
+		for guildId, ownerId := range verifiedOwners {
+			event.VerifiedOwners[guildId] = utils.ScrambleUserId(ownerId)
+		}
+	}
+	if result.Error != nil {
+		event.Error = result.Error.Error()
+	}
+
+	// Detach from cancellation from here on, processCtx may have been cancelled
+	ctx := context.WithoutCancel(processCtx)
+
+	reason := w.untrack(req.RequestID)
+	if reason == cancelReasonShutdown && errors.Is(processCtx.Err(), context.Canceled) {
+		event.Status = summary.StatusRequeued
+
+		w.logger.Info("GDPR request interrupted by shutdown, requeueing",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+		)
+
+		if requeueErr := w.queue.Requeue(ctx, req); requeueErr != nil {
+			w.logger.Error("Failed to requeue interrupted GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(requeueErr),
+			)
+		}
+
+		return
+	}
+
+	if reason == cancelReasonOperator && errors.Is(processCtx.Err(), context.Canceled) {
+		event.Status = summary.StatusCancelled
+		event.ResultCode = gdpr.ResultCancelled
+		w.logger.Info("GDPR request cancelled by operator",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+		)
+
+		if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Cancelled")); updateErr != nil {
+			w.logger.Error("Failed to update GDPR log",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(updateErr),
+			)
+		}
+
+		if ackErr := w.queue.Acknowledge(ctx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge cancelled GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	if reason == cancelReasonUser && errors.Is(processCtx.Err(), context.Canceled) {
+		w.logger.Info("GDPR request cancelled by its user",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Int("request_id", req.RequestID),
+		)
+
+		w.finishCancelled(ctx, req, &event, result)
+
+		if ackErr := w.queue.Acknowledge(ctx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge cancelled GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+		}
+
+		return
+	}
+
+	var permanentlyFailed bool
+	var issued *erasure.Issued
+	if result.Error != nil {
+		event.Status = summary.StatusFailed
+
+		w.logger.Error("Failed to process GDPR request",
+			zap.String("scrambled_user_id", scrambledId),
+			zap.String("request_type", requestTypeName),
+			zap.Int("request_id", req.RequestID),
+			zap.Error(result.Error),
+		)
+
+		// Kept with the entry so operators can see why it failed if it ends up in the failed queue
+		req.LastError = result.Error.Error()
+
+		// Requests the user may not make, or that are invalid, would fail the same way every time,
+		// so they don't consume retries and the user hears back straight away
+		var exhausted bool
+		var rejectErr error
+		if processor.IsPermanent(result.Error) {
+			rejectErr = w.queue.Fail(ctx, req)
+			exhausted = rejectErr == nil
+		} else {
+			exhausted, rejectErr = w.queue.Reject(ctx, req)
+		}
+		if rejectErr != nil {
+			w.logger.Error("Failed to reject GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(rejectErr),
+			)
+		}
+		permanentlyFailed = exhausted
+		event.PermanentlyFailed = exhausted
+
+		// Only known once rejected, as it depends on whether the request will be tried again
+		event.ResultCode = failedCode(result, exhausted, timedOut)
+		if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, gdpr.FormatLogStatus(event.ResultCode, "Failed")); updateErr != nil {
+			w.logger.Error("Failed to update GDPR log",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(updateErr),
+			)
+		}
+	} else {
+		event.Status = summary.StatusCompleted
+		event.ResultCode = completedCode(result)
+
+		if ackErr := w.queue.Acknowledge(ctx, req); ackErr != nil {
+			w.logger.Error("Failed to acknowledge GDPR request",
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(ackErr),
+			)
+			w.bufferWrite(gdprrelay.BufferedOp{Kind: gdprrelay.BufferedAcknowledge, Request: req})
+		}
+
+		// A dry run deleted nothing, so it mustn't block the real request from running later
+		status := "Dry Run"
+		if !result.DryRun {
+			status = "Completed"
+
+			if markErr := gdprrelay.MarkCompleted(ctx, w.redisClient, req.RequestID, config.Conf.CompletedTTL); markErr != nil {
+				w.logger.Error("Failed to record GDPR request completion",
+					zap.Int("request_id", req.RequestID),
+					zap.String("scrambled_user_id", scrambledId),
+					zap.Error(markErr),
+				)
+				w.bufferWrite(gdprrelay.BufferedOp{Kind: gdprrelay.BufferedCompleted, Request: req, TTL: config.Conf.CompletedTTL})
+			}
+
+			w.scheduleLateSweep(ctx, req, result)
+		}
+
+		// The log only holds a status, so tickets with nothing stored are noted there, telling a request
+		// that found nothing apart from one that failed to delete anything
+		if result.TicketsNoTranscript > 0 {
+			status = fmt.Sprintf("%s (%d without transcript)", status, result.TicketsNoTranscript)
+		}
+
+		status = gdpr.FormatLogStatus(event.ResultCode, status)
+		if updateErr := database.Client.GdprLogs.UpdateLogStatus(req.RequestID, status); updateErr != nil {
+			w.logger.Error("Failed to update GDPR log status",
+				zap.String("status", status),
+				zap.Int("request_id", req.RequestID),
+				zap.String("scrambled_user_id", scrambledId),
+				zap.Error(updateErr),
+			)
+		}
+
+		if w.issuer != nil && !result.DryRun && req.Request.Type != gdprrelay.RequestTypeDataExport {
+			certificate, issueErr := w.issuer.Issue(ctx, req, result.TranscriptsDeleted, result.MessagesDeleted)
+			if issueErr != nil {
+				w.logger.Error("Failed to issue certificate of erasure",
+					zap.Int("request_id", req.RequestID),
+					zap.String("scrambled_user_id", scrambledId),
+					zap.Error(issueErr),
+				)
+			} else {
+				issued = &certificate
+			}
+		}
+	}
+
+	callbackData := callback.ResultData{
+		TranscriptsDeleted:  result.TranscriptsDeleted,
+		MessagesDeleted:     result.MessagesDeleted,
+		FeedbackDeleted:     result.FeedbackDeleted,
+		TranscriptsExported: result.TranscriptsExported,
+		ExportUrl:           result.ExportUrl,
+		ExportExpiresAt:     result.ExportExpiresAt,
+		ExportPassword:      result.ExportPassword,
+		Error:               result.Error,
+		PermanentlyFailed:   permanentlyFailed,
+		DryRun:              result.DryRun,
+		UnmatchedTicketIds:  result.UnmatchedTicketIds,
+		TicketsWithheld:     result.TicketsWithheld,
+		TicketsNoTranscript: result.TicketsNoTranscript,
+		PurgeAt:             result.PurgeAt,
+		GuildResults:        result.GuildResults,
+		RequestType:         req.Request.Type,
+		ResultCode:          event.ResultCode,
+		GuildIds:            req.Request.GuildIds,
+		TicketIds:           req.Request.TicketIds,
+		CompletedAt:         w.clock.Now(),
+	}
+
+	if issued != nil {
+		callbackData.CertificateIssued = true
+		callbackData.CertificateUrl = issued.Url
+		callbackData.CertificateExpiresAt = issued.ExpiresAt
+	}
+
+	callbackCtx, callbackCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer callbackCancel()
+
+	if err := w.callback.SendCompletion(callbackCtx, req, callbackData); err != nil {
+		event.CallbackError = err.Error()
+
+		w.logger.Error("Failed to send completion callback",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", scrambledId),
+			zap.Error(err),
+		)
+	} else {
+		event.CallbackDelivered = true
+	}
+
+	// Purges and platform erasures have no user: the bot has left those servers, or they are banned
+	if w.guildNotices != nil && event.Status == summary.StatusCompleted && !result.DryRun && req.Request.UserId != 0 {
+		noticeCtx, noticeCancel := context.WithTimeout(ctx, 30*time.Second)
+		defer noticeCancel()
+
+		w.guildNotices.Notify(noticeCtx, req, result.GuildResults)
+	}
+}
+
+func (w *Worker) newEvent(req gdprrelay.QueuedRequest) summary.Event {
+	return summary.Event{
+		RequestId:       req.RequestID,
+		RequestType:     utils.GetRequestTypeName(int(req.Request.Type)),
+		ScrambledUserId: utils.ScrambleUserId(req.Request.UserId),
+		GuildIds:        req.Request.GuildIds,
+		TicketCount:     len(req.Request.TicketIds),
+		RetryCount:      req.RetryCount,
+		Locale:          callback.Locale(req.Request).IsoLongCode,
+		StartedAt:       w.clock.Now(),
+	}
+}
+
+// finish publishes the summary of a delivery once it has a status, recording it in the job and
+// user histories and the metrics
+func (w *Worker) finish(req gdprrelay.QueuedRequest, event *summary.Event, verifiedOwners map[uint64]uint64) {
+	event.FinishedAt = w.clock.Now()
+	event.DurationMs = event.FinishedAt.Sub(event.StartedAt).Milliseconds()
+	summary.Publish(context.Background(), w.redisClient, *event, config.Conf.SummaryStreamMaxLen, w.logger)
+	w.recordJob(req, *event, verifiedOwners)
+	w.recordHistory(req, *event)
+	w.recordState(req, *event)
+	w.enqueueWebhook(req, *event)
+	w.releaseInFlight(req, *event)
+
+	metrics.RequestsProcessed.WithLabelValues(event.RequestType, string(event.Status)).Inc()
+	if event.Status == summary.StatusCompleted || event.Status == summary.StatusFailed || event.Status == summary.StatusPanicked {
+		metrics.RequestDuration.WithLabelValues(event.RequestType).Observe(event.FinishedAt.Sub(event.StartedAt).Seconds())
+		w.slo.RecordOutcome(event.Status != summary.StatusCompleted)
+	}
+}
+
+// recordHistory stores the outcome of a delivery in the user's request history, read by the bot to show
+// users their recent requests. Deliveries that didn't reach an outcome are skipped.
+func (w *Worker) recordHistory(req gdprrelay.QueuedRequest, event summary.Event) {
+	if config.Conf.History.TTL <= 0 || req.Request.UserId == 0 {
+		return
+	}
+
+	var status gdpr.HistoryStatus
+	switch event.Status {
+	case summary.StatusCompleted:
+		status = gdpr.HistoryCompleted
+	case summary.StatusCancelled:
+		status = gdpr.HistoryCancelled
+	case summary.StatusRateLimited:
+		status = gdpr.HistoryRateLimited
+	case summary.StatusCoalesced:
+		status = gdpr.HistoryCoalesced
+	case summary.StatusFailed, summary.StatusPanicked:
+		status = gdpr.HistoryRetrying
+		if event.PermanentlyFailed {
+			status = gdpr.HistoryFailed
+		}
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entry := gdpr.HistoryEntry{
+		RequestId:           req.RequestID,
+		RequestType:         req.Request.Type,
+		Status:              status,
+		DryRun:              event.DryRun,
+		GuildIds:            req.Request.GuildIds,
+		TicketCount:         event.TicketCount,
+		TranscriptsDeleted:  event.TranscriptsDeleted,
+		MessagesDeleted:     event.MessagesDeleted,
+		TranscriptsExported: event.TranscriptsExported,
+		FeedbackDeleted:     event.FeedbackDeleted,
+		TicketsWithheld:     event.TicketsWithheld,
+		TicketsNoTranscript: event.TicketsNoTranscript,
+		Attempts:            req.RetryCount + 1,
+		QueuedAt:            req.QueuedAt,
+		FinishedAt:          event.FinishedAt,
+		ResultCode:          event.ResultCode,
+	}
+
+	if err := summary.RecordHistory(ctx, w.redisClient, req.Request.UserId, entry, config.Conf.History.TTL, config.Conf.History.MaxEntries); err != nil {
+		w.logger.Error("Failed to record GDPR request history",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", event.ScrambledUserId),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordState saves the outcome of a delivery as the request's state, or that it is queued again.
+// Duplicate deliveries are skipped, as the request already finished.
+func (w *Worker) recordState(req gdprrelay.QueuedRequest, event summary.Event) {
+	if w.states == nil {
+		return
+	}
+
+	state := gdpr.RequestState{
+		RequestId:          req.RequestID,
+		Attempt:            req.RetryCount + 1,
+		TranscriptsDeleted: event.TranscriptsDeleted,
+		MessagesDeleted:    event.MessagesDeleted,
+		UpdatedAt:          event.FinishedAt,
+	}
+
+	switch event.Status {
+	case summary.StatusCompleted:
+		state.Stage, state.Outcome = gdpr.StageDone, gdpr.HistoryCompleted
+	case summary.StatusCancelled:
+		state.Stage, state.Outcome = gdpr.StageDone, gdpr.HistoryCancelled
+	case summary.StatusRateLimited:
+		state.Stage, state.Outcome = gdpr.StageDone, gdpr.HistoryRateLimited
+	case summary.StatusCoalesced:
+		state.Stage, state.Outcome = gdpr.StageDone, gdpr.HistoryCoalesced
+	case summary.StatusRequeued:
+		state.Stage = gdpr.StageQueued
+	case summary.StatusFailed, summary.StatusPanicked:
+		state.Stage, state.Outcome = gdpr.StageQueued, gdpr.HistoryRetrying
+		if event.PermanentlyFailed {
+			state.Stage, state.Outcome = gdpr.StageFailed, gdpr.HistoryFailed
+		}
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := w.states.SetState(ctx, state); err != nil {
+		w.logger.Error("Failed to record GDPR request state",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", event.ScrambledUserId),
+			zap.Error(err),
+		)
+	}
+}
+
+// bufferWrite keeps a write to Redis that failed, to be replayed by RunBufferReplay
+func (w *Worker) bufferWrite(op gdprrelay.BufferedOp) {
+	if w.buffer == nil {
+		return
+	}
+
+	op.BufferedAt = w.clock.Now()
+	if err := w.buffer.Add(op); err != nil {
+		w.logger.Error("Failed to buffer GDPR request write for replay",
+			zap.Int("request_id", op.Request.RequestID),
+			zap.String("kind", string(op.Kind)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	w.logger.Warn("Buffered GDPR request write until Redis is reachable",
+		zap.Int("request_id", op.Request.RequestID),
+		zap.String("kind", string(op.Kind)),
+	)
+}
+
+// RunBufferReplay writes the buffered acknowledgements and completions to Redis every interval,
+// until ctx is cancelled
+func (w *Worker) RunBufferReplay(ctx context.Context, interval time.Duration) {
+	if w.buffer == nil {
+		return
+	}
+
+	ticker := w.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if w.buffer.Len() == 0 {
+			continue
+		}
+
+		replayed, err := w.buffer.Replay(ctx, w.replay)
+		if replayed > 0 {
+			w.logger.Info("Replayed buffered GDPR request writes", zap.Int("count", replayed))
+		}
+
+		if err != nil && ctx.Err() == nil {
+			w.logger.Warn("Failed to replay buffered GDPR request writes, retrying later",
+				zap.Int("remaining", w.buffer.Len()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (w *Worker) replay(ctx context.Context, op gdprrelay.BufferedOp) error {
+	switch op.Kind {
+	case gdprrelay.BufferedCompleted:
+		return gdprrelay.MarkCompleted(ctx, w.redisClient, op.Request.RequestID, op.TTL)
+	case gdprrelay.BufferedAcknowledge:
+		return w.queue.Acknowledge(ctx, op.Request)
+	default:
+		w.logger.Error("Dropping buffered GDPR request write of unknown kind",
+			zap.Int("request_id", op.Request.RequestID),
+			zap.String("kind", string(op.Kind)),
+		)
+		return nil
+	}
+}
+
+// enqueueWebhook queues a delivery of the outcome of a request to the compliance webhook, once it
+// has a final outcome
+func (w *Worker) enqueueWebhook(req gdprrelay.QueuedRequest, event summary.Event) {
+	if w.webhook == nil {
+		return
+	}
+
+	var status webhook.Status
+	switch {
+	case event.Status == summary.StatusCompleted:
+		status = webhook.StatusCompleted
+	case event.Status == summary.StatusCancelled:
+		status = webhook.StatusCancelled
+	case event.Status == summary.StatusRateLimited:
+		status = webhook.StatusRateLimited
+	case event.Status == summary.StatusCoalesced:
+		status = webhook.StatusCoalesced
+	case (event.Status == summary.StatusFailed || event.Status == summary.StatusPanicked) && event.PermanentlyFailed:
+		status = webhook.StatusFailed
+	default:
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload := webhook.Payload{
+		RequestId:           req.RequestID,
+		RequestType:         event.RequestType,
+		ScrambledUserId:     event.ScrambledUserId,
+		Status:              status,
+		DryRun:              event.DryRun,
+		GuildCount:          len(req.Request.GuildIds),
+		TicketCount:         event.TicketCount,
+		TranscriptsDeleted:  event.TranscriptsDeleted,
+		MessagesDeleted:     event.MessagesDeleted,
+		TranscriptsExported: event.TranscriptsExported,
+		ReferencesScrubbed:  event.ReferencesScrubbed,
+		FeedbackDeleted:     event.FeedbackDeleted,
+		TicketsWithheld:     event.TicketsWithheld,
+		TicketsNoTranscript: event.TicketsNoTranscript,
+		Attempts:            req.RetryCount + 1,
+		QueuedAt:            req.QueuedAt,
+		FinishedAt:          event.FinishedAt,
+		ResultCode:          event.ResultCode,
+	}
+
+	if err := w.webhook.Enqueue(ctx, payload); err != nil {
+		w.logger.Error("Failed to queue GDPR webhook delivery",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", event.ScrambledUserId),
+			zap.Error(err),
+		)
+	}
+}
+
+// scheduleLateSweep records a re-check of the tickets that had no transcript to clean yet, so those
+// stored once the request completed are cleaned too
+func (w *Worker) scheduleLateSweep(ctx context.Context, req gdprrelay.QueuedRequest, result processor.ProcessResult) {
+	if w.lateSweeps == nil || len(result.LateTickets) == 0 {
+		return
+	}
+
+	if err := w.lateSweeps.Schedule(ctx, latesweep.Sweep{
+		RequestId: req.RequestID,
+		UserId:    req.Request.UserId,
+		Tickets:   result.LateTickets,
+	}); err != nil {
+		w.logger.Error("Failed to schedule late sweep",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", utils.ScrambleUserId(req.Request.UserId)),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordJob stores the outcome of a delivery in the job history. Duplicate deliveries are skipped, as
+// they would overwrite the outcome of the delivery that actually processed the request.
+func (w *Worker) recordJob(req gdprrelay.QueuedRequest, event summary.Event, verifiedOwners map[uint64]uint64) {
+	if database.Jobs == nil || event.Status == summary.StatusDuplicate {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job := database.Job{
+		RequestId:           req.RequestID,
+		RequestType:         event.RequestType,
+		UserId:              req.Request.UserId,
+		GuildIds:            req.Request.GuildIds,
+		TicketCount:         event.TicketCount,
+		DryRun:              event.DryRun,
+		Status:              string(event.Status),
+		Attempts:            req.RetryCount + 1,
+		Error:               event.Error,
+		Locale:              event.Locale,
+		TranscriptsDeleted:  event.TranscriptsDeleted,
+		MessagesDeleted:     event.MessagesDeleted,
+		TranscriptsExported: event.TranscriptsExported,
+		ReferencesScrubbed:  event.ReferencesScrubbed,
+		FeedbackDeleted:     event.FeedbackDeleted,
+		BytesFetched:        event.BytesFetched,
+		BytesRewritten:      event.BytesRewritten,
+		BytesDeleted:        event.BytesDeleted,
+		QueuedAt:            req.QueuedAt,
+		LastStartedAt:       event.StartedAt,
+		FinishedAt:          event.FinishedAt,
+		DurationMs:          event.DurationMs,
+		VerifiedOwners:      verifiedOwners,
+	}
+
+	if err := database.Jobs.Record(ctx, job); err != nil {
+		w.logger.Error("Failed to record GDPR job history",
+			zap.Int("request_id", req.RequestID),
+			zap.String("scrambled_user_id", event.ScrambledUserId),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,93 @@
+// Package certificate defines the signed "certificate of erasure" issued once a GDPR deletion
+// request completes, and how to verify one.
+//
+// The signature covers the exact bytes of the certificate field, so verifiers must check the raw
+// JSON as issued rather than a re-encoding of it.
+package certificate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const Algorithm = "Ed25519"
+
+// Certificate records what was erased for a request, without identifying the user directly
+type Certificate struct {
+	RequestId          int       `json:"request_id"`
+	UserHash           string    `json:"user_hash"` // SHA256 hash of the user ID
+	RequestType        string    `json:"request_type"`
+	GuildIds           []uint64  `json:"guild_ids,omitempty"`
+	TicketIds          []int     `json:"ticket_ids,omitempty"`
+	TranscriptsDeleted int       `json:"transcripts_deleted"`
+	MessagesDeleted    int       `json:"messages_deleted"`
+	IssuedAt           time.Time `json:"issued_at"`
+}
+
+// Signed is the document handed to users, bundling the certificate with its signature and the
+// public key it can be verified with
+type Signed struct {
+	Certificate json.RawMessage `json:"certificate"`
+	Algorithm   string          `json:"algorithm"`
+	Signature   string          `json:"signature"`  // Base64 encoded
+	PublicKey   string          `json:"public_key"` // Base64 encoded
+}
+
+var ErrInvalidSignature = errors.New("invalid certificate signature")
+
+// Sign serialises and signs the certificate with the given key
+func Sign(certificate Certificate, key ed25519.PrivateKey) (Signed, error) {
+	marshalled, err := json.Marshal(certificate)
+	if err != nil {
+		return Signed{}, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+
+	return Signed{
+		Certificate: marshalled,
+		Algorithm:   Algorithm,
+		Signature:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, marshalled)),
+		PublicKey:   base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}, nil
+}
+
+// Verify checks the signature against the given public key, which should be obtained out of band
+// rather than trusted from the document itself, and returns the decoded certificate
+func Verify(signed Signed, publicKey ed25519.PublicKey) (Certificate, error) {
+	if signed.Algorithm != Algorithm {
+		return Certificate{}, fmt.Errorf("unsupported signature algorithm %q", signed.Algorithm)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, signed.Certificate, signature) {
+		return Certificate{}, ErrInvalidSignature
+	}
+
+	var certificate Certificate
+	if err := json.Unmarshal(signed.Certificate, &certificate); err != nil {
+		return Certificate{}, fmt.Errorf("failed to unmarshal certificate: %w", err)
+	}
+
+	return certificate, nil
+}
+
+// ParsePrivateKey decodes a base64 encoded 32 byte Ed25519 seed
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing key: %w", err)
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be a %d byte seed, got %d bytes", ed25519.SeedSize, len(seed))
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
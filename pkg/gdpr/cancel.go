@@ -0,0 +1,16 @@
+package gdpr
+
+import "strconv"
+
+const (
+	KeyCancelledPrefix = "tickets:gdpr:cancelled:" // Prefixes the marker of each request its user cancelled, see KeyCancelledFor
+	ChannelCancel      = "tickets:gdpr:cancel"     // Redis pubsub channel cancelled request IDs are published on
+)
+
+// KeyCancelledFor returns the key marking a request as cancelled by the user who made it. To cancel
+// a request, set this key with an expiry at least as long as the request may wait in the queue, then
+// publish the request ID on ChannelCancel. Workers take pending requests out of the queue and stop
+// in-flight ones when notified, and skip any request they dequeue while the key is set.
+func KeyCancelledFor(requestId int) string {
+	return KeyCancelledPrefix + strconv.Itoa(requestId)
+}
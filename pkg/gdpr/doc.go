@@ -0,0 +1,10 @@
+// Package gdpr defines the contract between producers of GDPR requests (the bot and dashboard) and
+// the gdpr-worker that consumes them: the request schema, the request types, the Redis keys
+// that make up the queue, the per-user history of results the worker publishes back, the result
+// codes outcomes are classified by, and the guild removals the gateway reports so the worker can
+// erase servers the bot was removed from.
+//
+// The schema is versioned by SchemaVersion. Additive, backwards compatible changes (new optional
+// fields, new request types) keep the version; anything that changes the meaning of an existing
+// field bumps it. The worker refuses entries with a version newer than the one it was built with.
+package gdpr
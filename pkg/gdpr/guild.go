@@ -0,0 +1,33 @@
+package gdpr
+
+import "time"
+
+const (
+	// KeyGuildRemovals is the Redis stream the gateway appends a GuildRemoval to whenever the bot
+	// is removed from a server or the server is deleted. Producers should cap it with MAXLEN.
+	KeyGuildRemovals = "tickets:gdpr:guild_removals"
+	// GuildRemovalField is the stream entry field holding the marshalled GuildRemoval
+	GuildRemovalField = "event"
+)
+
+// GuildRemovalReason is why the bot stopped being in a server
+type GuildRemovalReason string
+
+const (
+	GuildRemovalKicked  GuildRemovalReason = "kicked"  // The bot was kicked or banned, or the server was left
+	GuildRemovalDeleted GuildRemovalReason = "deleted" // The server was deleted
+)
+
+// GuildRemoval is published when the bot is removed from a server. Once the server's retention
+// period, or the worker's grace period if it has none, has passed without the bot being added back,
+// every transcript of the server is erased.
+type GuildRemoval struct {
+	GuildId   uint64             `json:"guild_id"`
+	Reason    GuildRemovalReason `json:"reason,omitempty"`
+	RemovedAt time.Time          `json:"removed_at"`
+
+	// RetentionDays is how many days the server's retention policy keeps its data for after the bot
+	// is removed, nil if the server has no policy of its own. 0 erases the data straight away, and
+	// periods beyond about a century keep it indefinitely.
+	RetentionDays *int `json:"retention_days,omitempty"`
+}
@@ -0,0 +1,51 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// KeyHistoryPrefix prefixes the Redis hash of each user's recent request results, see KeyHistoryFor
+const KeyHistoryPrefix = "tickets:gdpr:history:"
+
+// KeyHistoryFor returns the Redis hash holding the results of a user's recent requests, keyed by
+// request ID with HistoryEntry values. The key is derived from the hex SHA-256 of the decimal user
+// ID, the same scrambled ID the worker logs, so it doesn't expose the user.
+func KeyHistoryFor(userId uint64) string {
+	return fmt.Sprintf("%s%x", KeyHistoryPrefix, sha256.Sum256([]byte(fmt.Sprint(userId))))
+}
+
+// HistoryStatus is the outcome of a request as shown to the user who made it
+type HistoryStatus string
+
+const (
+	HistoryCompleted   HistoryStatus = "completed"
+	HistoryRetrying    HistoryStatus = "retrying" // The last attempt failed and the request will be tried again
+	HistoryFailed      HistoryStatus = "failed"   // Every attempt failed, the request won't be tried again
+	HistoryCancelled   HistoryStatus = "cancelled"
+	HistoryRateLimited HistoryStatus = "rate_limited" // Rejected as the user made too many requests recently
+	HistoryCoalesced   HistoryStatus = "coalesced"    // An identical request already being processed covers it
+)
+
+// HistoryEntry is the result of a request, overwritten as later attempts finish. It holds no
+// identifiers of the user, and no error details, which are for operators only.
+type HistoryEntry struct {
+	RequestId           int           `json:"request_id"`
+	RequestType         RequestType   `json:"request_type"`
+	Status              HistoryStatus `json:"status"`
+	DryRun              bool          `json:"dry_run,omitempty"`
+	GuildIds            []uint64      `json:"guild_ids,omitempty"`
+	TicketCount         int           `json:"ticket_count"`
+	TranscriptsDeleted  int           `json:"transcripts_deleted"`
+	MessagesDeleted     int           `json:"messages_deleted"`
+	TranscriptsExported int           `json:"transcripts_exported"`
+	FeedbackDeleted     int           `json:"feedback_deleted"`
+	TicketsWithheld     int           `json:"tickets_withheld,omitempty"`
+	TicketsNoTranscript int           `json:"tickets_no_transcript,omitempty"`
+	Attempts            int           `json:"attempts"`
+	QueuedAt            time.Time     `json:"queued_at"`
+	FinishedAt          time.Time     `json:"finished_at"`
+
+	ResultCode ResultCode `json:"result_code,omitempty"` // Unset in entries recorded before result codes were introduced
+}
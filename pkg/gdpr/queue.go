@@ -0,0 +1,124 @@
+package gdpr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the queued request schema described by this package
+const SchemaVersion = 1
+
+const (
+	KeyPending    = "tickets:gdpr:pending"    // Redis list for queued GDPR requests awaiting processing
+	KeyProcessing = "tickets:gdpr:processing" // Redis list for GDPR requests currently being processed
+	KeyFailed     = "tickets:gdpr:failed"     // Redis list for GDPR requests that exceeded max retries
+
+	KeyStream   = "tickets:gdpr:stream" // Redis stream for queued GDPR requests when the stream backend is used
+	StreamField = "request"             // Stream entry field holding the marshalled QueuedRequest
+)
+
+// TablePostgres is the Postgres table requests are queued in when the postgres backend is used.
+// Producers insert the request's ID into request_id and the marshalled QueuedRequest into payload,
+// in the same transaction that records the request, so a request is queued if and only if it was
+// recorded. The worker creates the table and is woken by a trigger on insert.
+const TablePostgres = "gdpr_queue"
+
+// KeyPendingFor returns the dedicated pending list for a request type. Workers configured with a
+// separate queue for the type consume it from here, with its own concurrency limit, instead of
+// sharing KeyPending with every other type.
+func KeyPendingFor(requestType RequestType) string {
+	return KeyPending + ":" + laneName(requestType)
+}
+
+// KeyPriorityFor returns the priority list of a pending list, KeyPending or one returned by
+// KeyPendingFor. Workers consuming lists take requests from it before those on the pending list, so
+// requests that IsPriority reports as quick aren't held up behind ones covering whole servers.
+func KeyPriorityFor(pending string) string {
+	return pending + ":priority"
+}
+
+// PriorityTicketLimit is the most tickets a request may name to be pushed onto the priority list
+const PriorityTicketLimit = 25
+
+// IsPriority reports whether a request should be pushed onto the priority list. Only requests naming
+// their tickets can be sized before they're processed, so those naming at most PriorityTicketLimit
+// tickets are, and those covering whole servers never are.
+func (r Request) IsPriority() bool {
+	switch r.Type {
+	case RequestTypeSpecificTranscripts, RequestTypeSpecificMessages:
+		return len(r.TicketIds) > 0 && len(r.TicketIds) <= PriorityTicketLimit
+	default:
+		return false
+	}
+}
+
+// KeyStreamFor returns the dedicated stream for a request type, when the stream backend is used
+func KeyStreamFor(requestType RequestType) string {
+	return KeyStream + ":" + laneName(requestType)
+}
+
+func laneName(requestType RequestType) string {
+	return strings.ToLower(requestType.String())
+}
+
+// QueuedRequest wraps a GDPR request with metadata for reliable queue processing
+type QueuedRequest struct {
+	Version       int       `json:"version,omitempty"` // Schema version the entry was produced with, 0 for legacy producers
+	Request       Request   `json:"request"`
+	QueuedAt      time.Time `json:"queued_at"`
+	RetryCount    int       `json:"retry_count"`
+	LastAttemptAt time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string    `json:"last_error,omitempty"` // Error of the most recent failed attempt, set by the worker
+	RequestID     int       `json:"request_id"`
+}
+
+// NewQueuedRequest wraps a request for pushing onto KeyPending
+func NewQueuedRequest(request Request, requestId int) QueuedRequest {
+	return QueuedRequest{
+		Version:   SchemaVersion,
+		Request:   request,
+		QueuedAt:  time.Now(),
+		RequestID: requestId,
+	}
+}
+
+// Marshal encodes the queued request in the format expected on the queue
+func (q QueuedRequest) Marshal() ([]byte, error) {
+	return json.Marshal(q)
+}
+
+// IsSupported reports whether the entry was produced with a schema version this package understands
+func (q QueuedRequest) IsSupported() bool {
+	return q.Version <= SchemaVersion
+}
+
+// DeriveRequestID computes a deterministic ID for entries queued without one, from the request
+// type, user, scope and queue time. Derived IDs are always negative so they can never collide with
+// IDs assigned by producers, which are positive gdpr_logs row IDs.
+func (q QueuedRequest) DeriveRequestID() int {
+	h := fnv.New64a()
+
+	buf := make([]byte, 8)
+	write := func(v uint64) {
+		binary.BigEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+
+	write(uint64(q.Request.Type))
+	write(q.Request.UserId)
+	write(uint64(len(q.Request.GuildIds)))
+	for _, guildId := range q.Request.GuildIds {
+		write(guildId)
+	}
+	write(uint64(len(q.Request.TicketIds)))
+	for _, ticketId := range q.Request.TicketIds {
+		write(uint64(ticketId))
+	}
+	write(uint64(q.QueuedAt.UnixNano()))
+
+	// Keep within 31 bits so the ID fits an int on every platform and a Postgres INTEGER
+	return -int(h.Sum64()&0x7fffffff) - 1
+}
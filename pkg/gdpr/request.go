@@ -0,0 +1,143 @@
+package gdpr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RequestType defines the type of GDPR data deletion request
+type RequestType int
+
+const (
+	RequestTypeAllTranscripts      RequestType = iota // Delete all transcript archives for specified guilds
+	RequestTypeSpecificTranscripts                    // Delete specific transcript archives by ticket IDs
+	RequestTypeAllMessages                            // Delete all ticket messages for specified guilds
+	RequestTypeSpecificMessages                       // Delete specific ticket messages by ticket IDs
+	RequestTypeDataExport                             // Export all data held about the user (right of access)
+	RequestTypeFeedback                               // Delete service ratings, exit surveys and close reasons left by the user
+)
+
+// String returns a human-readable name for the request type, for use in logs
+func (t RequestType) String() string {
+	switch t {
+	case RequestTypeAllTranscripts:
+		return "AllTranscripts"
+	case RequestTypeSpecificTranscripts:
+		return "SpecificTranscripts"
+	case RequestTypeAllMessages:
+		return "AllMessages"
+	case RequestTypeSpecificMessages:
+		return "SpecificMessages"
+	case RequestTypeDataExport:
+		return "DataExport"
+	case RequestTypeFeedback:
+		return "Feedback"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(t))
+	}
+}
+
+// ParseRequestType returns the request type with the given name, as returned by String, ignoring case
+func ParseRequestType(name string) (RequestType, bool) {
+	for t := RequestTypeAllTranscripts; t <= RequestTypeFeedback; t++ {
+		if strings.EqualFold(t.String(), name) {
+			return t, true
+		}
+	}
+
+	return 0, false
+}
+
+// Visibility controls where the completion message is shown to the user
+type Visibility string
+
+const (
+	VisibilityOriginal  Visibility = "original"  // Edit the original interaction response, the default
+	VisibilityEphemeral Visibility = "ephemeral" // Only show results in an ephemeral follow-up
+	VisibilityDM        Visibility = "dm"        // Only send results via direct message
+)
+
+// NotifyVia controls how the user is told about the outcome of their request
+type NotifyVia string
+
+const (
+	NotifyViaDefault     NotifyVia = ""            // Through the interaction, falling back to a DM, then email, once it can't be used
+	NotifyViaInteraction NotifyVia = "interaction" // Only through the interaction, never by DM or email
+	NotifyViaDM          NotifyVia = "dm"          // Only by DM, falling back to email, leaving the interaction untouched
+	NotifyViaSilent      NotifyVia = "silent"      // Not at all, for producers that tell the user themselves
+)
+
+// VerificationMode controls who may make a request covering a whole server
+type VerificationMode string
+
+const (
+	VerificationModeOwner VerificationMode = "owner" // Only the server owner
+	VerificationModeAdmin VerificationMode = "admin" // The owner, members with Administrator and admins configured on the dashboard
+
+	// VerificationModeOpener allows anyone to erase transcripts of tickets they opened themselves.
+	// Only valid for RequestTypeSpecificTranscripts.
+	VerificationModeOpener VerificationMode = "opener"
+
+	// VerificationModeGuildRemoved requires the bot to no longer be in the server, and is used by the
+	// worker for the purge it schedules once the bot is removed, see GuildRemoval. Such requests have
+	// no user. Only valid for RequestTypeAllTranscripts.
+	VerificationModeGuildRemoved VerificationMode = "guild_removed"
+
+	// VerificationModePlatform marks an erasure ordered by the bot operator through the admin API,
+	// such as of a server banned from the platform. Nothing is verified, so the worker never accepts
+	// it from the queue. Such requests have no user. Only valid for RequestTypeAllTranscripts.
+	VerificationModePlatform VerificationMode = "platform"
+)
+
+// Request represents a user's request to delete their data under GDPR regulations
+type Request struct {
+	Type               RequestType       `json:"type"`
+	UserId             uint64            `json:"user_id"`
+	GuildIds           []uint64          `json:"guild_ids,omitempty"`
+	GuildNames         map[uint64]string `json:"guild_names,omitempty"`
+	TicketIds          []int             `json:"ticket_ids,omitempty"`
+	Language           string            `json:"language,omitempty"` // Language configured for the user or server, used if Locale has no translation
+	Locale             string            `json:"locale,omitempty"`   // Discord locale of the interaction that made the request, e.g. "en-US"
+	InteractionToken   string            `json:"interaction_token,omitempty"`
+	InteractionGuildId uint64            `json:"interaction_guild_id,omitempty"`
+	ApplicationId      uint64            `json:"application_id,omitempty"`
+	DryRun             bool              `json:"dry_run,omitempty"`           // Count what would be deleted without deleting anything
+	Visibility         Visibility        `json:"visibility,omitempty"`        // Where to show the results, VisibilityOriginal if empty
+	VerificationMode   VerificationMode  `json:"verification_mode,omitempty"` // Who may make the request, the worker's configured mode if empty
+	NotifyVia          NotifyVia         `json:"notify_via,omitempty"`        // How the user is told about the outcome, NotifyViaDefault if empty
+
+	// Email is an address the user has verified, that results are emailed to if they can't be
+	// reached on Discord. Producers must only set addresses the user has proven they control.
+	Email string `json:"email,omitempty"`
+}
+
+// Normalize puts the request's IDs in canonical form: sorted, without duplicates and without zero
+// IDs, so a repeated ID isn't processed or counted twice. The worker normalizes every request it
+// dequeues, producers may do so too before recording the request.
+func (r *Request) Normalize() {
+	r.GuildIds = normalizeIds(r.GuildIds)
+	r.TicketIds = normalizeIds(r.TicketIds)
+}
+
+// Fingerprint identifies what the request deletes: two requests with the same fingerprint do the
+// same work, however they are delivered. Must be called on a normalized request.
+func (r Request) Fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d|%d|%v|%v|%t|%s", r.Type, r.UserId, r.GuildIds, r.TicketIds, r.DryRun, r.VerificationMode)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func normalizeIds[T uint64 | int](ids []T) []T {
+	if len(ids) == 0 {
+		return ids
+	}
+
+	normalized := slices.DeleteFunc(slices.Clone(ids), func(id T) bool {
+		return id <= 0
+	})
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized)
+}
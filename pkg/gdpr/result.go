@@ -0,0 +1,42 @@
+package gdpr
+
+import "strings"
+
+// ResultCode is a stable, machine-readable classification of how a request ended, carried in
+// callbacks, compliance webhook deliveries, the user's request history and the GdprLogs status, so
+// downstream systems can branch on outcomes without parsing localized text. New codes may be added,
+// so consumers should treat an unknown code like FAILED_PERMANENT.
+type ResultCode string
+
+const (
+	ResultCompleted       ResultCode = "COMPLETED"        // Everything in scope was deleted or exported, or previewed by a dry run
+	ResultPartial         ResultCode = "PARTIAL"          // Completed, but some servers or tickets were rejected, failed or withheld under a legal hold
+	ResultNoData          ResultCode = "NO_DATA"          // Completed, but nothing was stored for the user in scope
+	ResultNotAuthorized   ResultCode = "NOT_AUTHORIZED"   // The user may not make requests for any of the servers
+	ResultExpired         ResultCode = "EXPIRED"          // The final attempt ran past the request's timeout, it won't be tried again
+	ResultCancelled       ResultCode = "CANCELLED"        // Stopped by the user or an operator
+	ResultCoalesced       ResultCode = "COALESCED"        // Folded into an identical request already being processed, whose result applies
+	ResultRateLimited     ResultCode = "RATE_LIMITED"     // Refused as the user exceeded their quota, they may make the request again once it allows
+	ResultFailedTransient ResultCode = "FAILED_TRANSIENT" // Failed for now, the request is retried
+	ResultFailedPermanent ResultCode = "FAILED_PERMANENT" // Every attempt failed, or the request is invalid, it won't be tried again
+)
+
+// logStatusSeparator separates the result code from the status shown to people in a GdprLogs status
+const logStatusSeparator = ": "
+
+// FormatLogStatus returns the GdprLogs status of a request: its result code followed by the status
+// shown to people, e.g. "PARTIAL: Completed (2 without transcript)"
+func FormatLogStatus(code ResultCode, status string) string {
+	return string(code) + logStatusSeparator + status
+}
+
+// ParseLogStatus splits a GdprLogs status written by FormatLogStatus into its result code and the
+// status shown to people. Returns false for statuses written before result codes were recorded.
+func ParseLogStatus(logStatus string) (ResultCode, string, bool) {
+	code, status, ok := strings.Cut(logStatus, logStatusSeparator)
+	if !ok || code == "" || strings.ToUpper(code) != code || strings.Contains(code, " ") {
+		return "", logStatus, false
+	}
+
+	return ResultCode(code), status, true
+}
@@ -0,0 +1,44 @@
+package gdpr
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeyStatePrefix prefixes the Redis key holding the live state of each request, see KeyStateFor
+const KeyStatePrefix = "tickets:gdpr:state:"
+
+// KeyStateFor returns the Redis key holding the live state of a request as a JSON RequestState,
+// read by the bot to answer status queries. Producers should write StageQueued when enqueuing, so
+// a request has a state before a worker picks it up.
+func KeyStateFor(requestId int) string {
+	return fmt.Sprintf("%s%d", KeyStatePrefix, requestId)
+}
+
+// Stage is how far a request has got
+type Stage string
+
+const (
+	StageQueued    Stage = "queued"    // Waiting for a worker, including between attempts
+	StageVerifying Stage = "verifying" // Checking the user may make the request for the servers it covers
+	StageDeleting  Stage = "deleting"  // Working through the data, see TicketsProcessed and TicketsTotal
+	StageExporting Stage = "exporting" // Collecting the data for an export
+	StageDone      Stage = "done"      // Finished, see Outcome for how
+	StageFailed    Stage = "failed"    // Every attempt failed, the request won't be tried again
+)
+
+// RequestState is the live state of a request, overwritten as it moves through its stages
+type RequestState struct {
+	RequestId int           `json:"request_id"`
+	Stage     Stage         `json:"stage"`
+	Outcome   HistoryStatus `json:"outcome,omitempty"` // Set once done or failed, and to HistoryRetrying while queued for another attempt
+	Attempt   int           `json:"attempt"`           // 1 for the first attempt
+
+	// Counts of tickets are known while deleting, and grow as each server is enumerated
+	TicketsProcessed   int `json:"tickets_processed,omitempty"`
+	TicketsTotal       int `json:"tickets_total,omitempty"`
+	TranscriptsDeleted int `json:"transcripts_deleted,omitempty"`
+	MessagesDeleted    int `json:"messages_deleted,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
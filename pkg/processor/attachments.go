@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// AttachmentDeleter removes the stored files behind transcript attachments. Clearing the reference
+// from the transcript alone leaves the file itself downloadable.
+type AttachmentDeleter interface {
+	// DeleteAttachments removes the files of the given attachments, returning how many were
+	// deleted. Attachments it doesn't store, such as those still on Discord's CDN, are skipped.
+	DeleteAttachments(ctx context.Context, attachments []channel.Attachment) (int, error)
+}
+
+// deleteAttachments removes the files of attachments redacted from a ticket's transcript
+func (p *Processor) deleteAttachments(ctx context.Context, guildId uint64, ticketId int, attachments []channel.Attachment) (err error) {
+	if p.attachments == nil || len(attachments) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "archiver.delete_attachments", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId),
+		attribute.Int("gdpr.attachment_count", len(attachments)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	deleted, err := p.attachments.DeleteAttachments(ctx, attachments)
+	if err != nil {
+		return fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	p.results.attachmentsDeleted(guildId, deleted)
+
+	if deleted < len(attachments) {
+		p.logger.Debug("Skipped attachments not held in attachment storage",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("ticket_id", ticketId),
+			zap.Int("skipped", len(attachments)-deleted),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// DefaultBatchSize is how many tickets are deleted per batch call unless configured otherwise
+const DefaultBatchSize = 500
+
+// ErrBatchUnsupported is returned by a BatchDeleter when the archiver has no batch endpoint
+var ErrBatchUnsupported = errors.New("archiver does not support batch deletion")
+
+// BatchDeleter removes several of a guild's transcripts in a single archiver call
+type BatchDeleter interface {
+	DeleteTickets(ctx context.Context, guildId uint64, ticketIds []int) (BatchDeleteResult, error)
+}
+
+// BatchDeleteResult reports which tickets of a batch were deleted. Tickets missing from Deleted
+// were not removed, with the reason in Failed if the archiver gave one.
+type BatchDeleteResult struct {
+	Deleted []int          `json:"deleted"`
+	Failed  map[int]string `json:"failed,omitempty"`
+	Bytes   int64          `json:"bytes,omitempty"` // Stored size of the deleted transcripts, zero if the archiver doesn't report it
+}
+
+// deleteTranscriptsBatched deletes transcripts in chunks of batchSize, returning the number deleted
+// and the tickets that still need deleting one by one, which is all tickets from the first chunk
+// that couldn't be sent onwards, or that wasn't sent because the request was interrupted
+func (p *Processor) deleteTranscriptsBatched(ctx context.Context, guildId uint64, ticketIds []int) (int, []int) {
+	deleted := 0
+	for start := 0; start < len(ticketIds); start += p.batchSize {
+		if ctx.Err() != nil {
+			return deleted, ticketIds[start:]
+		}
+
+		chunk := ticketIds[start:min(start+p.batchSize, len(ticketIds))]
+		if err := p.pacer.wait(ctx, len(chunk)); err != nil {
+			return deleted, ticketIds[start:]
+		}
+
+		batchCtx, span := tracing.Start(ctx, "archiver.delete_tickets", tracing.GuildId(guildId), tracing.AttributeTicketCount.Int(len(chunk)))
+		result, err := p.batchDeleter.DeleteTickets(batchCtx, guildId, chunk)
+		tracing.End(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Left to the individual deletion loop, which stops as soon as it sees the cancellation
+				return deleted, ticketIds[start:]
+			}
+
+			if errors.Is(err, ErrBatchUnsupported) {
+				// Remembered for the lifetime of the worker so later requests don't retry it
+				p.batchUnsupported.Store(true)
+				p.logger.Info("Archiver does not support batch deletion, deleting transcripts individually")
+			} else {
+				p.logger.Warn("Batch transcript deletion failed, deleting remaining transcripts individually",
+					zap.Uint64("guild_id", guildId),
+					zap.Int("batch_size", len(chunk)),
+					zap.Error(err),
+				)
+			}
+
+			return deleted, ticketIds[start:]
+		}
+
+		p.clearHasTranscript(ctx, guildId, result.Deleted)
+		countArchiverBytes(ctx, archiverDelete, result.Bytes)
+
+		removed := make(map[int]bool, len(result.Deleted))
+		for _, ticketId := range result.Deleted {
+			removed[ticketId] = true
+		}
+
+		for _, ticketId := range chunk {
+			if removed[ticketId] {
+				deleted++
+				p.progress.ticketDone(ctx, 1, 0)
+				p.checkpoint.record(ctx, guildId, ticketId, true, 1, 0)
+				p.results.deleted(guildId, 1, 0)
+				continue
+			}
+
+			reason, ok := result.Failed[ticketId]
+			if !ok {
+				reason = "not reported as deleted"
+			}
+
+			p.progress.ticketDone(ctx, 0, 0)
+			p.checkpoint.record(ctx, guildId, ticketId, false, 0, 0)
+			p.results.failed(guildId, fmt.Errorf("failed to delete transcript for ticket %d: %s", ticketId, reason))
+		}
+	}
+
+	return deleted, nil
+}
+
+// clearHasTranscript marks the tickets as no longer having a transcript. Only tickets that were
+// selected for having one are passed, so any whose flag was already clear are reported.
+func (p *Processor) clearHasTranscript(ctx context.Context, guildId uint64, ticketIds []int) {
+	if len(ticketIds) == 0 {
+		return
+	}
+
+	ctx, span := tracing.StartQuery(ctx, "clear_has_transcript", tracing.GuildId(guildId), tracing.AttributeTicketCount.Int(len(ticketIds)))
+	rows, err := p.db.Tickets.Query(ctx,
+		`UPDATE tickets SET has_transcript = false WHERE guild_id = $1 AND id = ANY($2) AND has_transcript = true RETURNING id`,
+		guildId, ticketIds,
+	)
+
+	cleared := make(map[int]bool, len(ticketIds))
+	if err == nil {
+		for rows.Next() {
+			var ticketId int
+			if err = rows.Scan(&ticketId); err != nil {
+				break
+			}
+			cleared[ticketId] = true
+		}
+
+		rows.Close()
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+	tracing.End(span, err)
+
+	if err != nil {
+		p.logger.Error("Failed to update has_transcript flags after deletion",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("tickets", len(ticketIds)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if len(cleared) < len(ticketIds) {
+		unchanged := make([]int, 0, len(ticketIds)-len(cleared))
+		for _, ticketId := range ticketIds {
+			if !cleared[ticketId] {
+				unchanged = append(unchanged, ticketId)
+			}
+		}
+
+		p.reportFlagMismatch(guildId, unchanged, flagAlreadyCleared)
+	}
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// CheckpointStore persists how far through its tickets a request got, so a retry after a crash or
+// failure resumes where it left off rather than starting from zero
+type CheckpointStore interface {
+	Load(ctx context.Context, requestId int) (Checkpoint, bool, error)
+	Save(ctx context.Context, requestId int, checkpoint Checkpoint) error
+	Clear(ctx context.Context, requestId int) error
+}
+
+// Checkpoint is the persisted progress of a request. Tickets are processed in ID order within each
+// guild, so every ticket up to and including the guild's cursor has been handled.
+type Checkpoint struct {
+	Cursors            map[uint64]int `json:"cursors"` // Guild ID -> last ticket ID handled
+	TranscriptsDeleted int            `json:"transcripts_deleted"`
+	MessagesDeleted    int            `json:"messages_deleted"`
+}
+
+// checkpointer tracks a single request's checkpoint and persists it every `every` tickets
+type checkpointer struct {
+	store     CheckpointStore
+	requestId int
+	every     int
+	logger    *zap.Logger
+
+	mu         sync.Mutex
+	checkpoint Checkpoint
+	restored   Checkpoint      // Counts carried over from previous attempts
+	failed     map[uint64]bool // Guilds with a failed ticket, whose cursor must not move past it
+	unsaved    int
+}
+
+func newCheckpointer(ctx context.Context, store CheckpointStore, requestId, every int, logger *zap.Logger) *checkpointer {
+	c := &checkpointer{
+		store:     store,
+		requestId: requestId,
+		every:     every,
+		logger:    logger,
+		failed:    make(map[uint64]bool),
+	}
+
+	checkpoint, ok, err := store.Load(ctx, requestId)
+	if err != nil {
+		logger.Error("Failed to load checkpoint, starting from the beginning", zap.Error(err))
+	} else if ok {
+		logger.Info("Resuming GDPR request from checkpoint",
+			zap.Int("guilds", len(checkpoint.Cursors)),
+			zap.Int("transcripts_deleted", checkpoint.TranscriptsDeleted),
+			zap.Int("messages_deleted", checkpoint.MessagesDeleted),
+		)
+		c.checkpoint = checkpoint
+		c.restored = checkpoint
+	}
+
+	if c.checkpoint.Cursors == nil {
+		c.checkpoint.Cursors = make(map[uint64]int)
+	}
+
+	return c
+}
+
+// handled reports whether a previous attempt already processed the ticket. Safe to call on a nil
+// checkpointer.
+func (c *checkpointer) handled(guildId uint64, ticketId int) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cursor, ok := c.checkpoint.Cursors[guildId]
+	return ok && ticketId <= cursor
+}
+
+// record marks a ticket as processed, persisting the checkpoint if one is due. Safe to call on a
+// nil checkpointer.
+func (c *checkpointer) record(ctx context.Context, guildId uint64, ticketId int, success bool, transcriptsDeleted, messagesDeleted int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.checkpoint.TranscriptsDeleted += transcriptsDeleted
+	c.checkpoint.MessagesDeleted += messagesDeleted
+
+	if !success {
+		c.failed[guildId] = true
+	} else if !c.failed[guildId] {
+		c.checkpoint.Cursors[guildId] = ticketId
+	}
+
+	c.unsaved++
+	due := c.unsaved >= c.every
+	c.mu.Unlock()
+
+	if due {
+		c.save(ctx)
+	}
+}
+
+// save persists the checkpoint. Safe to call on a nil checkpointer.
+func (c *checkpointer) save(ctx context.Context) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.unsaved == 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	checkpoint := Checkpoint{
+		Cursors:            make(map[uint64]int, len(c.checkpoint.Cursors)),
+		TranscriptsDeleted: c.checkpoint.TranscriptsDeleted,
+		MessagesDeleted:    c.checkpoint.MessagesDeleted,
+	}
+	for guildId, cursor := range c.checkpoint.Cursors {
+		checkpoint.Cursors[guildId] = cursor
+	}
+	c.unsaved = 0
+	c.mu.Unlock()
+
+	// Losing a checkpoint only costs repeated work, so failures don't fail the request
+	if err := c.store.Save(context.WithoutCancel(ctx), c.requestId, checkpoint); err != nil {
+		c.logger.Error("Failed to save checkpoint", zap.Error(err))
+	}
+}
+
+// clear removes the checkpoint once the request has completed. Safe to call on a nil checkpointer.
+func (c *checkpointer) clear(ctx context.Context) {
+	if c == nil {
+		return
+	}
+
+	if err := c.store.Clear(ctx, c.requestId); err != nil {
+		c.logger.Error("Failed to clear checkpoint", zap.Error(err))
+	}
+}
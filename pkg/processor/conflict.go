@@ -0,0 +1,165 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TranscriptLocks orders requests deleting a guild's transcripts against requests rewriting them to
+// clean a user's messages. Deletion always wins: a rewrite never starts once a deletion has, and a
+// deletion waits for rewrites already underway to be stored first, so a cleaned copy of a transcript
+// is never stored back after the transcript was deleted.
+type TranscriptLocks interface {
+	// LockDeletion marks the guilds' transcripts as being deleted by the request, until
+	// UnlockDeletion is called or ttl passes. Locking again extends the TTL.
+	LockDeletion(ctx context.Context, requestId int, guildIds []uint64, ttl time.Duration) error
+	UnlockDeletion(ctx context.Context, requestId int, guildIds []uint64) error
+
+	// DeletingRequest returns the ID of the request deleting the guild's transcripts, 0 if none is
+	DeletingRequest(ctx context.Context, guildId uint64) (int, error)
+
+	// LockRewrite registers the request as rewriting a transcript in the guild, until UnlockRewrite
+	// is called or ttl passes. If another request is deleting the guild's transcripts, nothing is
+	// registered and its ID is returned instead.
+	LockRewrite(ctx context.Context, requestId int, guildId uint64, ttl time.Duration) (int, error)
+	UnlockRewrite(ctx context.Context, requestId int, guildId uint64) error
+
+	// Rewriting reports whether any request is rewriting a transcript in the guild
+	Rewriting(ctx context.Context, guildId uint64) (bool, error)
+}
+
+const (
+	DefaultConflictWait = 5 * time.Minute
+
+	conflictPollInterval = 2 * time.Second
+	deletionLockTTL      = time.Hour       // Extended before each guild, so only needs to cover deleting one
+	rewriteLockTTL       = 5 * time.Minute // Covers storing a single transcript
+)
+
+// errTranscriptGone is returned when a ticket's transcript was deleted before its messages could be
+// cleaned, which leaves nothing of the user's in it
+var errTranscriptGone = errors.New("transcript was already deleted")
+
+// errDeletionStarted is returned when a deletion of the guild's transcripts started while a ticket
+// was being cleaned, so the cleaned transcript wasn't stored
+var errDeletionStarted = errors.New("transcripts are being deleted by another request")
+
+// lockDeletion marks the guilds' transcripts as being deleted by the request being processed,
+// returning a function that releases them. Failing to lock fails the request, as deleting unlocked
+// could race a message cleaning request into storing a transcript back.
+func (p *Processor) lockDeletion(ctx context.Context, guildIds []uint64) (func(), error) {
+	if p.transcriptLocks == nil || p.dryRun || len(guildIds) == 0 {
+		return func() {}, nil
+	}
+
+	if err := p.transcriptLocks.LockDeletion(ctx, p.requestId, guildIds, deletionLockTTL); err != nil {
+		return nil, fmt.Errorf("failed to lock transcripts for deletion: %w", err)
+	}
+
+	return func() {
+		// Released even if the request was cancelled, rather than holding up cleaning until the TTL
+		if err := p.transcriptLocks.UnlockDeletion(context.WithoutCancel(ctx), p.requestId, guildIds); err != nil {
+			p.logger.Error("Failed to unlock transcripts after deletion", zap.Error(err))
+		}
+	}, nil
+}
+
+// awaitRewrites extends the deletion lock on the guilds still to be deleted from, then waits for
+// rewrites of the first one's transcripts that started before it was locked to be stored
+func (p *Processor) awaitRewrites(ctx context.Context, guildIds []uint64) error {
+	if p.transcriptLocks == nil || p.dryRun {
+		return nil
+	}
+
+	guildId := guildIds[0]
+	if err := p.transcriptLocks.LockDeletion(ctx, p.requestId, guildIds, deletionLockTTL); err != nil {
+		return fmt.Errorf("failed to lock transcripts for deletion: %w", err)
+	}
+
+	return p.awaitConflict(ctx, guildId, "Waiting for messages to be cleaned from transcripts before deleting them", func() (bool, error) {
+		rewriting, err := p.transcriptLocks.Rewriting(ctx, guildId)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for transcripts being rewritten: %w", err)
+		}
+
+		return rewriting, nil
+	})
+}
+
+// awaitDeletion waits for another request deleting the guild's transcripts to finish, so messages
+// are only cleaned from transcripts it leaves behind
+func (p *Processor) awaitDeletion(ctx context.Context, guildId uint64) error {
+	if p.transcriptLocks == nil || p.dryRun {
+		return nil
+	}
+
+	return p.awaitConflict(ctx, guildId, "Waiting for transcripts to be deleted before cleaning messages from them", func() (bool, error) {
+		deleting, err := p.transcriptLocks.DeletingRequest(ctx, guildId)
+		if err != nil {
+			return false, fmt.Errorf("failed to check for transcripts being deleted: %w", err)
+		}
+
+		return deleting != 0 && deleting != p.requestId, nil
+	})
+}
+
+// awaitConflict polls until conflicting returns false, failing once the conflict outlasts the
+// configured wait so the request is retried later rather than holding up the worker
+func (p *Processor) awaitConflict(ctx context.Context, guildId uint64, message string, conflicting func() (bool, error)) error {
+	deadline := time.Now().Add(p.conflictWait)
+	logged := false
+
+	for {
+		conflict, err := conflicting()
+		if err != nil || !conflict {
+			return err
+		}
+
+		if !logged {
+			p.logger.Info(message, zap.Uint64("guild_id", guildId))
+			logged = true
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("gave up waiting for a conflicting request in guild %d after %s", guildId, p.conflictWait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return interrupted(ctx)
+		case <-time.After(conflictPollInterval):
+		}
+	}
+}
+
+// lockRewrite registers the request being processed as rewriting a transcript in the guild,
+// returning a function that releases it, or errDeletionStarted if the guild's transcripts are being
+// deleted
+func (p *Processor) lockRewrite(ctx context.Context, guildId uint64) (func(), error) {
+	if p.transcriptLocks == nil || p.dryRun {
+		return func() {}, nil
+	}
+
+	deleting, err := p.transcriptLocks.LockRewrite(ctx, p.requestId, guildId, rewriteLockTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock transcript for rewriting: %w", err)
+	}
+
+	if deleting != 0 {
+		p.logger.Info("Transcript deletion started while cleaning messages, not storing cleaned transcript",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("deleting_request_id", deleting),
+		)
+		return nil, errDeletionStarted
+	}
+
+	return func() {
+		if err := p.transcriptLocks.UnlockRewrite(context.WithoutCancel(ctx), p.requestId, guildId); err != nil {
+			p.logger.Error("Failed to unlock transcript after rewriting", zap.Uint64("guild_id", guildId), zap.Error(err))
+		}
+	}, nil
+}
@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	v2 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v2"
+	"go.uber.org/zap"
+)
+
+// ExportStore persists data export bundles and hands out time-limited download links for them
+type ExportStore interface {
+	Upload(ctx context.Context, name string, data []byte) (url string, expiresAt time.Time, err error)
+}
+
+// DataExport is the machine-readable part of a right of access export
+type DataExport struct {
+	UserId       uint64               `json:"user_id"`
+	GeneratedAt  time.Time            `json:"generated_at"`
+	Tickets      []ExportedTicket     `json:"tickets_opened"`
+	Memberships  []ExportedTicketRef  `json:"ticket_memberships"`
+	Participated []ExportedTicketRef  `json:"tickets_participated"`
+	Claims       []ExportedTicketRef  `json:"tickets_claimed"`
+	CloseReasons []ExportedCloseEvent `json:"tickets_closed"`
+	Transcripts  []ExportedTranscript `json:"transcripts"`
+}
+
+type ExportedTicket struct {
+	GuildId   uint64     `json:"guild_id"`
+	TicketId  int        `json:"ticket_id"`
+	OpenTime  time.Time  `json:"open_time"`
+	CloseTime *time.Time `json:"close_time,omitempty"`
+}
+
+type ExportedTicketRef struct {
+	GuildId  uint64 `json:"guild_id"`
+	TicketId int    `json:"ticket_id"`
+}
+
+type ExportedCloseEvent struct {
+	GuildId  uint64  `json:"guild_id"`
+	TicketId int     `json:"ticket_id"`
+	Reason   *string `json:"reason,omitempty"`
+}
+
+// ExportedTranscript contains only the messages authored by the requesting user, as other
+// participants' messages are their personal data rather than the requester's
+type ExportedTranscript struct {
+	GuildId  uint64       `json:"guild_id"`
+	TicketId int          `json:"ticket_id"`
+	Messages []v2.Message `json:"messages"`
+}
+
+func (p *Processor) processDataExport(ctx context.Context, request gdpr.Request) ProcessResult {
+	if p.exportStore == nil {
+		return ProcessResult{Error: fmt.Errorf("data exports are not enabled")}
+	}
+
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	export, err := p.collectExport(ctx, request.UserId, request.GuildIds)
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to collect data export: %w", err)}
+	}
+
+	if p.dryRun {
+		return ProcessResult{TranscriptsExported: len(export.Transcripts)}
+	}
+
+	// The password reaches the user separately from the download link, so the link alone doesn't
+	// give access to their data
+	password := newExportPassword()
+
+	bundle, err := buildExportBundle(export, password)
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to build data export: %w", err)}
+	}
+
+	name := fmt.Sprintf("%s/%d.zip", scrambledUserId, export.GeneratedAt.Unix())
+	url, expiresAt, err := p.exportStore.Upload(ctx, name, bundle)
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to upload data export: %w", err)}
+	}
+
+	p.logger.Info("GDPR request completed",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("transcripts_exported", len(export.Transcripts)),
+		zap.Int("bundle_size", len(bundle)),
+	)
+
+	return ProcessResult{
+		TranscriptsExported: len(export.Transcripts),
+		ExportUrl:           url,
+		ExportExpiresAt:     expiresAt,
+		ExportPassword:      password,
+	}
+}
+
+func (p *Processor) collectExport(ctx context.Context, userId uint64, guildIds []uint64) (DataExport, error) {
+	export := DataExport{
+		UserId:      userId,
+		GeneratedAt: time.Now(),
+	}
+
+	// An empty guild list exports data from every guild
+	var guildFilter interface{}
+	if len(guildIds) > 0 {
+		guildFilter = guildIds
+	}
+
+	ticketRows, err := p.db.Tickets.Query(ctx,
+		`SELECT guild_id, id, open_time, close_time FROM tickets WHERE user_id = $1 AND ($2::int8[] IS NULL OR guild_id = ANY($2)) ORDER BY guild_id, id`,
+		userId, guildFilter,
+	)
+	if err != nil {
+		return DataExport{}, fmt.Errorf("failed to query opened tickets: %w", err)
+	}
+	for ticketRows.Next() {
+		var ticket ExportedTicket
+		if err := ticketRows.Scan(&ticket.GuildId, &ticket.TicketId, &ticket.OpenTime, &ticket.CloseTime); err != nil {
+			ticketRows.Close()
+			return DataExport{}, fmt.Errorf("failed to read opened ticket: %w", err)
+		}
+		export.Tickets = append(export.Tickets, ticket)
+	}
+	ticketRows.Close()
+	if err := ticketRows.Err(); err != nil {
+		return DataExport{}, fmt.Errorf("failed to query opened tickets: %w", err)
+	}
+
+	refQueries := []struct {
+		table string
+		dest  *[]ExportedTicketRef
+	}{
+		{"ticket_members", &export.Memberships},
+		{"participant", &export.Participated},
+		{"ticket_claims", &export.Claims},
+	}
+
+	for _, refQuery := range refQueries {
+		query := fmt.Sprintf(`SELECT guild_id, ticket_id FROM %s WHERE user_id = $1 AND ($2::int8[] IS NULL OR guild_id = ANY($2)) ORDER BY guild_id, ticket_id`, refQuery.table)
+		rows, err := p.db.Tickets.Query(ctx, query, userId, guildFilter)
+		if err != nil {
+			return DataExport{}, fmt.Errorf("failed to query %s: %w", refQuery.table, err)
+		}
+
+		for rows.Next() {
+			var ref ExportedTicketRef
+			if err := rows.Scan(&ref.GuildId, &ref.TicketId); err != nil {
+				rows.Close()
+				return DataExport{}, fmt.Errorf("failed to read %s: %w", refQuery.table, err)
+			}
+			*refQuery.dest = append(*refQuery.dest, ref)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return DataExport{}, fmt.Errorf("failed to query %s: %w", refQuery.table, err)
+		}
+	}
+
+	closeRows, err := p.db.Tickets.Query(ctx,
+		`SELECT guild_id, ticket_id, close_reason FROM close_reason WHERE closed_by = $1 AND ($2::int8[] IS NULL OR guild_id = ANY($2)) ORDER BY guild_id, ticket_id`,
+		userId, guildFilter,
+	)
+	if err != nil {
+		return DataExport{}, fmt.Errorf("failed to query close reasons: %w", err)
+	}
+	for closeRows.Next() {
+		var event ExportedCloseEvent
+		if err := closeRows.Scan(&event.GuildId, &event.TicketId, &event.Reason); err != nil {
+			closeRows.Close()
+			return DataExport{}, fmt.Errorf("failed to read close reason: %w", err)
+		}
+		export.CloseReasons = append(export.CloseReasons, event)
+	}
+	closeRows.Close()
+	if err := closeRows.Err(); err != nil {
+		return DataExport{}, fmt.Errorf("failed to query close reasons: %w", err)
+	}
+
+	var tickets []ticketInfo
+	if len(guildIds) > 0 {
+		tickets, err = p.getUserTicketsInGuilds(ctx, userId, guildIds)
+	} else {
+		tickets, err = p.getUserTickets(ctx, userId)
+	}
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	for _, ticket := range tickets {
+		if err := interrupted(ctx); err != nil {
+			return DataExport{}, err
+		}
+
+		transcript, err := p.getTranscript(ctx, ticket.GuildID, ticket.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return DataExport{}, interrupted(ctx)
+			}
+
+			// A ticket without a stored transcript has no messages to export
+			if errors.Is(err, errTranscriptNotFound) {
+				continue
+			}
+
+			// Anything else fails the export so it is retried, rather than handing the user an
+			// incomplete copy of their data as if it were complete
+			return DataExport{}, fmt.Errorf("failed to fetch transcript of ticket %d in guild %d: %w", ticket.ID, ticket.GuildID, err)
+		}
+
+		var messages []v2.Message
+		for _, msg := range transcript.Messages {
+			if msg.AuthorId == userId {
+				messages = append(messages, msg)
+			}
+		}
+
+		if len(messages) > 0 {
+			export.Transcripts = append(export.Transcripts, ExportedTranscript{
+				GuildId:  ticket.GuildID,
+				TicketId: ticket.ID,
+				Messages: messages,
+			})
+		}
+	}
+
+	return export, nil
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tickets data export</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:4px 8px}</style>
+</head>
+<body>
+<h1>Tickets data export</h1>
+<p>User ID {{.UserId}}, generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+<h2>Tickets opened</h2>
+<table><tr><th>Server</th><th>Ticket</th><th>Opened</th><th>Closed</th></tr>
+{{range .Tickets}}<tr><td>{{.GuildId}}</td><td>{{.TicketId}}</td><td>{{.OpenTime.Format "2006-01-02 15:04"}}</td><td>{{if .CloseTime}}{{.CloseTime.Format "2006-01-02 15:04"}}{{end}}</td></tr>
+{{end}}</table>
+<h2>Tickets closed by you</h2>
+<table><tr><th>Server</th><th>Ticket</th><th>Reason</th></tr>
+{{range .CloseReasons}}<tr><td>{{.GuildId}}</td><td>{{.TicketId}}</td><td>{{if .Reason}}{{.Reason}}{{end}}</td></tr>
+{{end}}</table>
+<h2>Your messages</h2>
+{{range .Transcripts}}<h3>Server {{.GuildId}}, ticket {{.TicketId}}</h3>
+<ul>{{range .Messages}}<li>{{.Timestamp.Format "2006-01-02 15:04"}}: {{.Content}}</li>{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// buildExportBundle packages the export as a ZIP encrypted under password, containing a JSON
+// document and an HTML rendering
+func buildExportBundle(export DataExport, password string) ([]byte, error) {
+	var jsonFile bytes.Buffer
+	encoder := json.NewEncoder(&jsonFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return nil, err
+	}
+
+	var htmlFile bytes.Buffer
+	if err := exportTemplate.Execute(&htmlFile, export); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := newEncryptedZip(&buf, password, export.GeneratedAt)
+
+	if err := archive.add("data.json", jsonFile.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := archive.add("index.html", htmlFile.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := archive.close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Export bundles are encrypted with WinZip's AES-256 scheme (AE-2), which 7-Zip, WinRAR, WinZip and
+// most other archivers open given the password
+const (
+	aesMethod        = 99     // Compression method recorded for AES encrypted entries
+	aesExtraId       = 0x9901 // ID of the extra field describing the encryption
+	aesVendorVersion = 2      // AE-2, which leaves the CRC out as the authentication code covers it
+	aesStrength      = 3      // AES-256
+	aesKeyLength     = 32
+	aesSaltLength    = 16
+	aesIterations    = 1000
+	aesAuthLength    = 10
+)
+
+// newExportPassword generates the password of a single export bundle
+func newExportPassword() string {
+	return rand.Text()
+}
+
+// encryptedZip writes a ZIP archive whose entries are deflated, then encrypted under password
+type encryptedZip struct {
+	archive  *zip.Writer
+	password string
+	modified time.Time
+}
+
+func newEncryptedZip(buf *bytes.Buffer, password string, modified time.Time) *encryptedZip {
+	return &encryptedZip{
+		archive:  zip.NewWriter(buf),
+		password: password,
+		modified: modified,
+	}
+}
+
+func (z *encryptedZip) add(name string, data []byte) error {
+	var compressed bytes.Buffer
+	deflater, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+
+	if _, err := deflater.Write(data); err != nil {
+		return err
+	}
+
+	if err := deflater.Close(); err != nil {
+		return err
+	}
+
+	encrypted, err := aesZipEncrypt(z.password, compressed.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", name, err)
+	}
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], aesExtraId)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], aesVendorVersion)
+	copy(extra[6:], "AE")
+	extra[8] = aesStrength
+	binary.LittleEndian.PutUint16(extra[9:], zip.Deflate)
+
+	header := &zip.FileHeader{
+		Name:               name,
+		Method:             aesMethod,
+		Flags:              0x1, // Encrypted
+		Extra:              extra,
+		Modified:           z.modified,
+		CompressedSize64:   uint64(len(encrypted)),
+		UncompressedSize64: uint64(len(data)),
+	}
+
+	w, err := z.archive.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(encrypted)
+	return err
+}
+
+func (z *encryptedZip) close() error {
+	return z.archive.Close()
+}
+
+// aesZipEncrypt encrypts the data of an entry, returning it framed as WinZip expects: the salt, the
+// password verifier, the ciphertext and then the authentication code
+func aesZipEncrypt(password string, data []byte) ([]byte, error) {
+	salt := make([]byte, aesSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	keys, err := pbkdf2.Key(sha1.New, password, salt, aesIterations, 2*aesKeyLength+2)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(keys[:aesKeyLength])
+	if err != nil {
+		return nil, err
+	}
+
+	// WinZip runs AES in counter mode with a little-endian counter starting at 1, which differs
+	// from the big-endian counter of cipher.NewCTR
+	ciphertext := make([]byte, len(data))
+	var counter, keystream [aes.BlockSize]byte
+	for offset, n := 0, uint64(1); offset < len(data); offset, n = offset+aes.BlockSize, n+1 {
+		binary.LittleEndian.PutUint64(counter[:8], n)
+		block.Encrypt(keystream[:], counter[:])
+
+		end := min(offset+aes.BlockSize, len(data))
+		for i := offset; i < end; i++ {
+			ciphertext[i] = data[i] ^ keystream[i-offset]
+		}
+	}
+
+	mac := hmac.New(sha1.New, keys[aesKeyLength:2*aesKeyLength])
+	mac.Write(ciphertext)
+
+	out := make([]byte, 0, aesSaltLength+2+len(ciphertext)+aesAuthLength)
+	out = append(out, salt...)
+	out = append(out, keys[2*aesKeyLength:]...)
+	out = append(out, ciphertext...)
+	out = append(out, mac.Sum(nil)[:aesAuthLength]...)
+
+	return out, nil
+}
@@ -0,0 +1,45 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// Ratings and exit surveys are left by the ticket opener, close reasons are written by whoever
+// closed the ticket. $2 is the guilds under a legal hold, whose feedback is kept.
+var feedbackStatements = []erasureStatement{
+	{"service_ratings", `
+DELETE FROM service_ratings r
+USING tickets t
+WHERE r.guild_id = t.guild_id AND r.ticket_id = t.id AND t.user_id = $1
+AND NOT r.guild_id = ANY($2)`, false},
+	{"exit_survey_responses", `
+DELETE FROM exit_survey_responses r
+USING tickets t
+WHERE r.guild_id = t.guild_id AND r.ticket_id = t.id AND t.user_id = $1
+AND NOT r.guild_id = ANY($2)`, false},
+	{"close_reason", `DELETE FROM close_reason WHERE closed_by = $1 AND NOT guild_id = ANY($2)`, false},
+	{"close_request", `UPDATE close_request SET close_reason = NULL WHERE user_id = $1 AND close_reason IS NOT NULL AND NOT guild_id = ANY($2)`, false},
+}
+
+func (p *Processor) processFeedback(ctx context.Context, request gdpr.Request) ProcessResult {
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	deleted, err := p.execErasure(ctx, request.UserId, feedbackStatements, p.holds.affectedGuilds())
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to delete feedback: %w", err)}
+	}
+
+	p.logger.Info("GDPR request completed",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("feedback_deleted", deleted),
+	)
+
+	return ProcessResult{FeedbackDeleted: deleted}
+}
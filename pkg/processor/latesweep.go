@@ -0,0 +1,103 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// archiveWindow is how long before a request started a ticket may have been closed and still be
+// waiting for its transcript to be stored
+const archiveWindow = 10 * time.Minute
+
+// findUnarchivedTickets returns the tickets in scope of a message erasure that had no transcript to
+// clean yet, as they were still open or had only just been closed, by guild. Their transcripts may
+// be stored after the request completes, so they're checked again by a late sweep. Without
+// ticketIds, the user's tickets in the guilds are in scope. The tickets are only a hint, so a failed
+// query finds none.
+func (p *Processor) findUnarchivedTickets(ctx context.Context, userId uint64, guildIds []uint64, ticketIds []int, startedAt time.Time) map[uint64][]int {
+	if p.dryRun || len(guildIds) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.StartQuery(ctx, "get_unarchived_tickets")
+	defer span.End()
+
+	query := `
+	SELECT DISTINCT t.guild_id, t.id
+	FROM tickets t
+	LEFT JOIN ticket_members tm ON t.guild_id = tm.guild_id AND t.id = tm.ticket_id
+	WHERE t.guild_id = ANY($2)
+	AND (($3::INT4[] IS NULL AND (tm.user_id = $1 OR t.user_id = $1)) OR t.id = ANY($3))
+	AND (t.open = true OR (t.has_transcript = false AND t.close_time >= $4))
+	`
+
+	rows, err := p.db.Tickets.Query(ctx, query, userId, guildIds, ticketIds, startedAt.Add(-archiveWindow))
+	if err != nil {
+		p.logger.Warn("Failed to look up tickets awaiting their transcript",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+			zap.Error(err),
+		)
+		return nil
+	}
+	defer rows.Close()
+
+	var tickets map[uint64][]int
+	for rows.Next() {
+		var ticket ticketInfo
+		if err := rows.Scan(&ticket.GuildID, &ticket.ID); err != nil {
+			continue
+		}
+
+		if tickets == nil {
+			tickets = make(map[uint64][]int)
+		}
+		tickets[ticket.GuildID] = append(tickets[ticket.GuildID], ticket.ID)
+	}
+
+	return tickets
+}
+
+// SweepLateMessages cleans the user's messages from transcripts stored for the tickets since the
+// request completed, returning the number of messages deleted. Tickets that still have no
+// transcript are left alone, as are those now under a legal hold.
+func (p *Processor) SweepLateMessages(ctx context.Context, requestId int, userId uint64, tickets map[uint64][]int) (int, error) {
+	ctx = tracing.WithRequestId(ctx, requestId)
+	p = p.withLogger(p.logger.With(zap.Int("request_id", requestId)))
+
+	guildIds := make([]uint64, 0, len(tickets))
+	candidates := make([]ticketInfo, 0, len(tickets))
+	for guildId, ticketIds := range tickets {
+		guildIds = append(guildIds, guildId)
+		for _, ticketId := range ticketIds {
+			candidates = append(candidates, ticketInfo{ID: ticketId, GuildID: guildId})
+		}
+	}
+
+	if err := p.loadLegalHolds(ctx, gdpr.Request{Type: gdpr.RequestTypeAllMessages, GuildIds: guildIds}); err != nil {
+		return 0, err
+	}
+
+	stored := p.validateTicketsForMessageCleaning(ctx, candidates)
+	if len(stored) == 0 {
+		return 0, nil
+	}
+
+	messagesDeleted, err := p.cleanUserMessagesInTickets(ctx, p.withholdTickets(stored), userId)
+	if err != nil {
+		return messagesDeleted, fmt.Errorf("failed to clean late transcripts: %w", err)
+	}
+
+	p.logger.Info("Cleaned transcripts stored after GDPR request completed",
+		zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+		zap.Int("tickets", len(stored)),
+		zap.Int("messages_deleted", messagesDeleted),
+	)
+
+	return messagesDeleted, nil
+}
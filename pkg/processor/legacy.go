@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel/message"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/logarchiver/pkg/model"
+	v1 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v1"
+	v2 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v2"
+)
+
+var errUnknownLegacyFormat = errors.New("not a known legacy transcript format")
+
+// getLegacyTranscript fetches a transcript the archiver client couldn't decode and converts it to
+// the v2 model. Transcripts stored before encryption and compression were introduced are plain,
+// or gzipped, JSON or CSV. Once cleaned they're stored back as v2 like any other transcript.
+func (p *Processor) getLegacyTranscript(ctx context.Context, guildId uint64, ticketId int) (transcript v2.Transcript, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.get_legacy_transcript", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	if p.retriever == nil {
+		return v2.Transcript{}, fmt.Errorf("archiver retriever not configured")
+	}
+
+	data, err := p.retriever.GetTicket(ctx, guildId, ticketId)
+	if err != nil {
+		return v2.Transcript{}, fmt.Errorf("failed to retrieve transcript: %w", err)
+	}
+
+	return decodeLegacyTranscript(data)
+}
+
+func decodeLegacyTranscript(data []byte) (v2.Transcript, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return v2.Transcript{}, fmt.Errorf("failed to decompress transcript: %w", err)
+		}
+
+		if data, err = io.ReadAll(reader); err != nil {
+			return v2.Transcript{}, fmt.Errorf("failed to decompress transcript: %w", err)
+		}
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return v2.Transcript{}, errUnknownLegacyFormat
+	}
+
+	switch trimmed[0] {
+	case '[', '{':
+		return decodeTranscriptJson(trimmed)
+	default:
+		return decodeLegacyCsv(trimmed)
+	}
+}
+
+// decodeTranscriptJson decodes a decrypted transcript of either model version into the v2 model
+func decodeTranscriptJson(data []byte) (v2.Transcript, error) {
+	if model.GetVersion(data) == model.V2 {
+		var transcript v2.Transcript
+		if err := json.Unmarshal(data, &transcript); err != nil {
+			return v2.Transcript{}, fmt.Errorf("failed to decode transcript: %w", err)
+		}
+
+		return transcript, nil
+	}
+
+	var messages []message.Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return v2.Transcript{}, fmt.Errorf("failed to decode v1 transcript: %w", err)
+	}
+
+	return v1.ConvertToV2(messages), nil
+}
+
+// legacyCsvColumns maps the header names used by CSV transcript exports to the field they hold
+var legacyCsvColumns = map[string]string{
+	"message_id":  "id",
+	"author_id":   "author_id",
+	"user_id":     "author_id",
+	"author":      "username",
+	"author_name": "username",
+	"username":    "username",
+	"content":     "content",
+	"message":     "content",
+	"timestamp":   "timestamp",
+	"time":        "timestamp",
+	"date":        "timestamp",
+}
+
+// decodeLegacyCsv reads a CSV transcript with a header row. Columns are matched by name, as the
+// layout changed between exports, and unknown columns are ignored.
+func decodeLegacyCsv(data []byte) (v2.Transcript, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return v2.Transcript{}, errUnknownLegacyFormat
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		if field, ok := legacyCsvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+
+	if _, ok := columns["author_id"]; !ok {
+		return v2.Transcript{}, errUnknownLegacyFormat
+	}
+	if _, ok := columns["content"]; !ok {
+		return v2.Transcript{}, errUnknownLegacyFormat
+	}
+
+	transcript := v2.Transcript{
+		Version: model.V2,
+		Entities: v2.Entities{
+			Users: make(map[uint64]v2.User),
+		},
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return v2.Transcript{}, fmt.Errorf("failed to decode csv transcript: %w", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		authorId, err := strconv.ParseUint(strings.TrimSpace(field("author_id")), 10, 64)
+		if err != nil {
+			return v2.Transcript{}, fmt.Errorf("invalid author ID %q in csv transcript", field("author_id"))
+		}
+
+		msg := v2.Message{
+			AuthorId: authorId,
+			Content:  field("content"),
+		}
+
+		if id, err := strconv.ParseUint(strings.TrimSpace(field("id")), 10, 64); err == nil {
+			msg.Id = id
+		}
+
+		if timestamp, err := time.Parse(time.RFC3339, strings.TrimSpace(field("timestamp"))); err == nil {
+			msg.Timestamp = timestamp
+		}
+
+		transcript.Messages = append(transcript.Messages, msg)
+
+		if _, ok := transcript.Entities.Users[authorId]; !ok {
+			transcript.Entities.Users[authorId] = v2.User{
+				Id:       authorId,
+				Username: field("username"),
+			}
+		}
+	}
+
+	return transcript, nil
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"context"
+	"slices"
+)
+
+// LegalHoldStore reports which guilds and tickets are under an active legal hold. Anything held is
+// left untouched by deletions and counted as withheld instead.
+type LegalHoldStore interface {
+	// ActiveLegalHolds returns the holds active in the given guilds, or in every guild if guildIds is nil
+	ActiveLegalHolds(ctx context.Context, guildIds []uint64) (LegalHolds, error)
+}
+
+// LegalHolds are the legal holds active when a request started processing
+type LegalHolds struct {
+	Guilds  map[uint64]bool         // Guilds held as a whole
+	Tickets map[uint64]map[int]bool // Tickets held individually, by guild
+}
+
+// affects reports whether the guild, or any ticket in it, is held
+func (h LegalHolds) affects(guildId uint64) bool {
+	return h.Guilds[guildId] || len(h.Tickets[guildId]) > 0
+}
+
+func (h LegalHolds) ticketHeld(guildId uint64, ticketId int) bool {
+	return h.Guilds[guildId] || h.Tickets[guildId][ticketId]
+}
+
+// affectedGuilds returns every guild with a hold in it, never nil so it can be bound as an array
+func (h LegalHolds) affectedGuilds() []uint64 {
+	guildIds := make([]uint64, 0, len(h.Guilds)+len(h.Tickets))
+	for guildId := range h.Guilds {
+		guildIds = append(guildIds, guildId)
+	}
+
+	for guildId, tickets := range h.Tickets {
+		if len(tickets) > 0 && !h.Guilds[guildId] {
+			guildIds = append(guildIds, guildId)
+		}
+	}
+
+	slices.Sort(guildIds)
+	return guildIds
+}
+
+// withholdTranscripts removes the held tickets of a guild, recording them as withheld
+func (p *Processor) withholdTranscripts(guildId uint64, ticketIds []int) []int {
+	if !p.holds.affects(guildId) {
+		return ticketIds
+	}
+
+	kept := ticketIds[:0:0]
+	for _, ticketId := range ticketIds {
+		if !p.holds.ticketHeld(guildId, ticketId) {
+			kept = append(kept, ticketId)
+		}
+	}
+
+	p.results.withheld(guildId, len(ticketIds)-len(kept))
+	return kept
+}
+
+// withholdTickets removes held tickets from those spanning several guilds, recording them as withheld
+func (p *Processor) withholdTickets(tickets []ticketInfo) []ticketInfo {
+	kept := tickets[:0:0]
+	for _, ticket := range tickets {
+		if p.holds.ticketHeld(ticket.GuildID, ticket.ID) {
+			p.results.withheld(ticket.GuildID, 1)
+			continue
+		}
+
+		kept = append(kept, ticket)
+	}
+
+	return kept
+}
+
+// unheldGuilds returns the guilds without any hold in them. References to the user are scrubbed
+// from a guild as a whole, so a hold on a single ticket keeps the guild's references in place.
+func (p *Processor) unheldGuilds(guildIds []uint64) []uint64 {
+	return slices.DeleteFunc(slices.Clone(guildIds), p.holds.affects)
+}
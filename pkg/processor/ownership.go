@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// verifiedOwner is the owner of a guild as seen when the requester was last verified against it
+type verifiedOwner struct {
+	ownerId    uint64
+	verifiedAt time.Time
+}
+
+// verifiedOwners tracks the guild owners observed while a request is processed, for the audit trail
+// and to tell when a guild must be verified again
+type verifiedOwners struct {
+	mu     sync.Mutex
+	owners map[uint64]verifiedOwner
+}
+
+func newVerifiedOwners() *verifiedOwners {
+	return &verifiedOwners{
+		owners: make(map[uint64]verifiedOwner),
+	}
+}
+
+// record stores the owner seen by a successful verification. Safe to call on a nil tracker.
+func (v *verifiedOwners) record(guildId, ownerId uint64) {
+	if v == nil {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.owners[guildId] = verifiedOwner{
+		ownerId:    ownerId,
+		verifiedAt: time.Now(),
+	}
+}
+
+// get returns the owner recorded for a guild. Safe to call on a nil tracker.
+func (v *verifiedOwners) get(guildId uint64) (verifiedOwner, bool) {
+	if v == nil {
+		return verifiedOwner{}, false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	owner, ok := v.owners[guildId]
+	return owner, ok
+}
+
+// snapshot returns the owner recorded for each guild. Safe to call on a nil tracker.
+func (v *verifiedOwners) snapshot() map[uint64]uint64 {
+	if v == nil {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.owners) == 0 {
+		return nil
+	}
+
+	snapshot := make(map[uint64]uint64, len(v.owners))
+	for guildId, owner := range v.owners {
+		snapshot[guildId] = owner.ownerId
+	}
+
+	return snapshot
+}
+
+// recheckOwnership verifies the requester against a guild again immediately before its data is
+// deleted, if the last verification is older than the recheck window. Without it, a server
+// transferred while a long request waits or runs would still be erased on the previous owner's say.
+func (p *Processor) recheckOwnership(ctx context.Context, guildId, userId uint64, mode gdpr.VerificationMode) error {
+	if p.ownerRecheckAfter <= 0 || mode == gdpr.VerificationModeOpener {
+		return nil
+	}
+
+	previous, ok := p.owners.get(guildId)
+	if !ok || time.Since(previous.verifiedAt) < p.ownerRecheckAfter {
+		return nil
+	}
+
+	// Cached lookups could predate the transfer
+	if p.permissions != nil {
+		p.permissions.Forget(guildId, userId)
+	}
+
+	if err := p.verifyGuildOwnership(ctx, guildId, userId, mode); err != nil {
+		return fmt.Errorf("server ownership changed while the request was being processed: %w", err)
+	}
+
+	if current, _ := p.owners.get(guildId); current.ownerId != previous.ownerId {
+		p.logger.Warn("Guild owner changed during request, requester is still entitled to it",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+			zap.Uint64("guild_id", guildId),
+			zap.String("scrambled_previous_owner_id", utils.ScrambleUserId(previous.ownerId)),
+			zap.String("scrambled_owner_id", utils.ScrambleUserId(current.ownerId)),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer caps how many tickets are deleted per second, spreading deletions out evenly. Shared between
+// scoped copies, so the cap covers every request the processor handles.
+type pacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // When the next ticket may be deleted
+}
+
+// newPacer returns nil if perSecond is zero or less, which doesn't limit anything
+func newPacer(perSecond float64) *pacer {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &pacer{
+		interval: time.Duration(float64(time.Second) / perSecond),
+	}
+}
+
+// wait blocks until tickets more may be deleted, or ctx is done. Safe to call on a nil pacer.
+func (p *pacer) wait(ctx context.Context, tickets int) error {
+	if p == nil || tickets <= 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(p.interval * time.Duration(tickets))
+	p.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return interrupted(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
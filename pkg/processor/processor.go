@@ -0,0 +1,1777 @@
+package processor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/TicketsBot-cloud/archiverclient"
+	"github.com/TicketsBot-cloud/database"
+	"github.com/TicketsBot-cloud/gdl/objects/channel"
+	"github.com/TicketsBot-cloud/gdl/rest"
+	"github.com/TicketsBot-cloud/gdl/rest/ratelimit"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/permissions"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	v2 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Processor handles the execution of GDPR data deletion requests
+type Processor struct {
+	logger       *zap.Logger
+	db           *database.Database
+	archiver     *archiverclient.ArchiverClient
+	retriever    archiverclient.Retriever
+	purger       GuildPurger
+	storage      GuildObjectLister
+	purgeTimeout time.Duration
+	discordToken string
+	botId        uint64 // The bot's own user ID, taken from the token, 0 without one
+	rateLimiter  *ratelimit.Ratelimiter
+	permissions  *permissions.Resolver // Nil without a Discord token
+	exportStore  ExportStore
+	attachments  AttachmentDeleter
+	placeholder  v2.User
+	dryRun       bool
+	verifyGuild  bool
+	pacer        *pacer // Nil if deletions aren't rate limited
+
+	platformErasures bool
+
+	transcriptKey   []byte
+	streamThreshold int
+
+	verificationMode  gdpr.VerificationMode
+	ownerRecheckAfter time.Duration
+	owners            *verifiedOwners // Owners seen by verification of the request being processed, only set on scoped copies
+
+	batchDeleter     BatchDeleter
+	batchSize        int
+	batchUnsupported *atomic.Bool // Shared between scoped copies, set once the archiver turns out not to support batches
+
+	progressReporter ProgressReporter
+	progressEvery    int
+	progressInterval time.Duration
+	progress         *progressTracker // Progress of the request being processed, only set on scoped copies
+	states           StateStore
+
+	checkpoints     CheckpointStore
+	checkpointEvery int
+	checkpoint      *checkpointer // Checkpoint of the request being processed, only set on scoped copies
+
+	results *guildResults // Per-guild outcomes of the request being processed, only set on scoped copies
+
+	transcriptLocks TranscriptLocks
+	conflictWait    time.Duration
+
+	legalHolds LegalHoldStore
+	holds      LegalHolds // Holds active when the request being processed started, only set on scoped copies
+
+	quarantine       QuarantineStore
+	quarantinePeriod time.Duration
+	requestId        int       // ID of the request being processed, only set on scoped copies
+	purgeAt          time.Time // When transcripts quarantined by the request being processed are purged, only set on scoped copies
+}
+
+// Options contains the dependencies a Processor operates on
+type Options struct {
+	Database     *database.Database             // Database holding ticket metadata
+	Archiver     *archiverclient.ArchiverClient // Client used to fetch and store transcripts
+	Retriever    archiverclient.Retriever       // Retriever used to delete transcripts
+	Purger       GuildPurger                    // Removes a guild's transcripts in one operation, transcripts are deleted one by one if nil
+	PurgeTimeout time.Duration                  // How long to wait for a guild purge to finish, DefaultPurgeTimeout if zero
+	BatchDeleter BatchDeleter                   // Deletes transcripts in batches, transcripts are deleted one by one if nil
+	BatchSize    int                            // Tickets per batch deletion call, DefaultBatchSize if zero
+	Storage      GuildObjectLister              // Transcript storage checked for leftovers after a purge, skipped if nil
+	DiscordToken string                         // Bot token used for ownership verification, skipped if empty
+	RateLimiter  *ratelimit.Ratelimiter         // Discord REST rate limiter, an in-memory one is created if nil
+	ExportStore  ExportStore                    // Storage for data export bundles, exports are disabled if nil
+	Attachments  AttachmentDeleter              // Removes the files behind redacted attachments, which are left in place if nil
+	Placeholder  *v2.User                       // Identity redacted messages are attributed to, DefaultPlaceholder if nil
+	DryRun       bool                           // Process every request as a dry run, regardless of the request's own flag
+	VerifyGuild  bool                           // Report ticket IDs that don't belong to the requested guild
+
+	TicketsPerSecond float64 // Caps how fast tickets are deleted, unlimited if zero
+	PlatformErasures bool    // Accept requests with gdpr.VerificationModePlatform, only for the processor platform erasures run on
+
+	TranscriptKey   []byte // Key transcripts are encrypted with, required to stream large transcripts
+	StreamThreshold int    // Transcripts of at least this many bytes are rewritten while streaming, disabled if zero
+
+	VerificationMode  gdpr.VerificationMode // Who may make requests covering a server if the request doesn't say, VerificationModeOwner if empty
+	OwnerRecheckAfter time.Duration         // Verify a server again before deleting from it if verified longer ago than this, disabled if zero
+
+	Progress         ProgressReporter // Notified of progress on long-running requests, disabled if nil
+	ProgressEvery    int              // Report progress after this many tickets, 0 to only report by time
+	ProgressInterval time.Duration    // Report progress at least this often, 0 to only report by tickets
+	States           StateStore       // Keeps the stage and progress of each request for status queries, disabled if nil
+
+	Checkpoints     CheckpointStore // Persists progress so retries resume where they left off, disabled if nil
+	CheckpointEvery int             // Save the checkpoint after this many tickets, DefaultCheckpointEvery if zero
+
+	LegalHolds LegalHoldStore // Guilds and tickets that must be kept for litigation or regulators, nothing is held if nil
+
+	TranscriptLocks TranscriptLocks // Orders transcript deletions before concurrent message cleaning in the same guild, unordered if nil
+	ConflictWait    time.Duration   // How long to wait for a conflicting request before retrying later, DefaultConflictWait if zero
+
+	Quarantine       QuarantineStore // Keeps deleted transcripts for a grace period in which requests can be reversed, they are deleted immediately if nil
+	QuarantinePeriod time.Duration   // How long quarantined transcripts are kept, DefaultQuarantinePeriod if zero
+}
+
+// GuildPurger removes every transcript stored under a guild server-side. Purges run asynchronously,
+// their progress is polled with PurgeStatus.
+type GuildPurger interface {
+	PurgeGuild(ctx context.Context, guildId uint64) error
+	PurgeStatus(ctx context.Context, guildId uint64) (archiverclient.PurgeStatus, error)
+}
+
+// GuildObjectLister lists the keys of the transcript objects stored under a guild's prefix
+type GuildObjectLister interface {
+	GetAllKeysForGuild(ctx context.Context, guildId uint64) ([]string, error)
+}
+
+const (
+	DefaultPurgeTimeout    = 10 * time.Minute
+	DefaultCheckpointEvery = 25
+
+	DefaultQuarantinePeriod = 7 * 24 * time.Hour
+)
+
+// DefaultPlaceholder is the identity redacted messages are attributed to unless configured otherwise
+var DefaultPlaceholder = v2.User{
+	Id:       0,
+	Username: "Removed for privacy",
+}
+
+func New(logger *zap.Logger, options Options) *Processor {
+	rateLimiter := options.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = ratelimit.NewRateLimiter(ratelimit.NewMemoryStore(), 0)
+	}
+
+	placeholder := DefaultPlaceholder
+	if options.Placeholder != nil {
+		placeholder = *options.Placeholder
+		placeholder.Bot = false
+	}
+
+	// The transcript viewer builds avatar URLs from the user ID and avatar hash, so a custom avatar
+	// can only render when it belongs to a real user ID
+	if placeholder.Id == 0 && placeholder.Avatar != "" {
+		logger.Warn("Placeholder avatar requires a non-zero placeholder user ID, ignoring avatar")
+		placeholder.Avatar = ""
+	}
+
+	purgeTimeout := options.PurgeTimeout
+	if purgeTimeout == 0 {
+		purgeTimeout = DefaultPurgeTimeout
+	}
+
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	checkpointEvery := options.CheckpointEvery
+	if checkpointEvery <= 0 {
+		checkpointEvery = DefaultCheckpointEvery
+	}
+
+	quarantinePeriod := options.QuarantinePeriod
+	if quarantinePeriod <= 0 {
+		quarantinePeriod = DefaultQuarantinePeriod
+	}
+
+	conflictWait := options.ConflictWait
+	if conflictWait <= 0 {
+		conflictWait = DefaultConflictWait
+	}
+
+	verificationMode := options.VerificationMode
+	if verificationMode == "" {
+		verificationMode = gdpr.VerificationModeOwner
+	}
+
+	var resolver *permissions.Resolver
+	if options.DiscordToken != "" {
+		resolver = permissions.NewResolver(options.DiscordToken, rateLimiter, permissions.DefaultCacheTTL)
+	}
+
+	return &Processor{
+		logger:       logger,
+		db:           options.Database,
+		archiver:     options.Archiver,
+		retriever:    options.Retriever,
+		purger:       options.Purger,
+		storage:      options.Storage,
+		purgeTimeout: purgeTimeout,
+		discordToken: options.DiscordToken,
+		botId:        botIdFromToken(options.DiscordToken),
+		rateLimiter:  rateLimiter,
+		permissions:  resolver,
+		exportStore:  options.ExportStore,
+		attachments:  options.Attachments,
+		placeholder:  placeholder,
+		dryRun:       options.DryRun,
+		verifyGuild:  options.VerifyGuild,
+		pacer:        newPacer(options.TicketsPerSecond),
+
+		platformErasures: options.PlatformErasures,
+
+		transcriptKey:   options.TranscriptKey,
+		streamThreshold: options.StreamThreshold,
+
+		verificationMode:  verificationMode,
+		ownerRecheckAfter: options.OwnerRecheckAfter,
+
+		batchDeleter:     options.BatchDeleter,
+		batchSize:        batchSize,
+		batchUnsupported: &atomic.Bool{},
+
+		progressReporter: options.Progress,
+		progressEvery:    options.ProgressEvery,
+		progressInterval: options.ProgressInterval,
+		states:           options.States,
+
+		checkpoints:     options.Checkpoints,
+		checkpointEvery: checkpointEvery,
+
+		legalHolds: options.LegalHolds,
+
+		transcriptLocks: options.TranscriptLocks,
+		conflictWait:    conflictWait,
+
+		quarantine:       options.Quarantine,
+		quarantinePeriod: quarantinePeriod,
+	}
+}
+
+func (p *Processor) withLogger(logger *zap.Logger) *Processor {
+	scoped := *p
+	scoped.logger = logger
+	return &scoped
+}
+
+// ProcessResult contains the outcome of processing a GDPR request
+type ProcessResult struct {
+	TranscriptsDeleted  int       // Number of transcript archives deleted from archiver
+	MessagesDeleted     int       // Number of ticket messages deleted from database
+	TranscriptsExported int       // Number of transcripts included in a data export
+	ExportUrl           string    // Time-limited download link for a data export
+	ExportExpiresAt     time.Time // When the export download link stops working
+	ExportPassword      string    // Password the data export is encrypted under, sent to the user apart from the link
+	DryRun              bool      // Whether counts are a preview of what would be deleted, nothing was modified
+	UnmatchedTicketIds  []int     // Requested ticket IDs that don't exist in the requested guild
+	ReferencesScrubbed  int       // Number of database rows the user's ID was removed from
+	FeedbackDeleted     int       // Number of ratings, survey responses and close reasons deleted
+	LeftoverObjects     int       // Number of transcript objects still in storage after a guild purge
+	TicketsWithheld     int       // Number of tickets left untouched as they are under a legal hold
+	TicketsNoTranscript int       // Number of tickets in scope that had no stored transcript to delete or clean
+	PurgeAt             time.Time // When deleted transcripts are permanently removed, zero if they were removed immediately
+	Error               error     // Error if the processing failed, nil on success
+
+	GuildResults   map[uint64]GuildResult // Outcome within each guild, for requests that work through tickets
+	VerifiedOwners map[uint64]uint64      // Owner of each server when the requester was last verified against it
+	LateTickets    map[uint64][]int       // Tickets of a message erasure whose transcript may yet be stored, by guild
+	ArchiverBytes  ArchiverBytes          // Transcript data moved to and from the archiver by this attempt
+}
+
+func (p *Processor) Process(ctx context.Context, queued gdpr.QueuedRequest) ProcessResult {
+	ctx, span := tracing.Start(tracing.WithRequestId(ctx, queued.RequestID), "gdpr.process",
+		tracing.AttributeRequestType.String(utils.GetRequestTypeName(int(queued.Request.Type))),
+	)
+
+	// Scope log entries to this request so they can be correlated with the queue and callback logs
+	p = p.withLogger(p.logger.With(
+		zap.Int("request_id", queued.RequestID),
+		zap.Int("retry_count", queued.RetryCount),
+	))
+
+	request := queued.Request
+	if request.DryRun {
+		p.dryRun = true
+	}
+
+	p.requestId = queued.RequestID
+	p.purgeAt = time.Now().Add(p.quarantinePeriod)
+
+	var reporter ProgressReporter
+	if p.progressReporter != nil && (p.progressEvery > 0 || p.progressInterval > 0) {
+		reporter = p.progressReporter
+	}
+
+	if reporter != nil || p.states != nil {
+		p.progress = newProgressTracker(reporter, p.states, queued, p.progressEvery, p.progressInterval, p.logger)
+	}
+
+	if p.dryRun {
+		p.logger.Info("Processing GDPR request as a dry run, nothing will be deleted")
+	} else if p.checkpoints != nil && processesTickets(request.Type) {
+		p.checkpoint = newCheckpointer(ctx, p.checkpoints, queued.RequestID, p.checkpointEvery, p.logger)
+	}
+
+	if processesTickets(request.Type) {
+		p.results = newGuildResults(request.GuildIds)
+	}
+
+	p.owners = newVerifiedOwners()
+	p.progress.setStage(ctx, initialStage(request.Type))
+
+	volume := &byteVolume{}
+	ctx = withByteVolume(ctx, volume)
+
+	var result ProcessResult
+	if err := p.loadLegalHolds(ctx, request); err != nil {
+		result = ProcessResult{Error: err}
+	} else {
+		switch request.Type {
+		case gdpr.RequestTypeAllTranscripts:
+			result = p.processAllTranscripts(ctx, request)
+		case gdpr.RequestTypeSpecificTranscripts:
+			result = p.processSpecificTranscripts(ctx, request)
+		case gdpr.RequestTypeAllMessages:
+			result = p.processAllMessages(ctx, request)
+		case gdpr.RequestTypeSpecificMessages:
+			result = p.processSpecificMessages(ctx, request)
+		case gdpr.RequestTypeDataExport:
+			result = p.processDataExport(ctx, request)
+		case gdpr.RequestTypeFeedback:
+			result = p.processFeedback(ctx, request)
+		default:
+			result = ProcessResult{Error: &invalidRequestError{fmt.Sprintf("unknown GDPR request type: %d", request.Type)}}
+		}
+	}
+
+	if p.checkpoint != nil {
+		// Work done by earlier attempts is skipped this time round, so it's added back from the checkpoint
+		result.TranscriptsDeleted += p.checkpoint.restored.TranscriptsDeleted
+		result.MessagesDeleted += p.checkpoint.restored.MessagesDeleted
+
+		if result.Error == nil {
+			p.checkpoint.clear(ctx)
+		} else {
+			p.checkpoint.save(ctx)
+		}
+	}
+
+	result.ArchiverBytes = volume.snapshot()
+	result.GuildResults = p.results.snapshot()
+	for guildId, guildResult := range result.GuildResults {
+		result.TicketsWithheld += guildResult.Withheld
+		result.TicketsNoTranscript += guildResult.NoTranscript
+		if guildResult.Error != nil {
+			p.logger.Warn("GDPR request failed in guild",
+				zap.Uint64("guild_id", guildId),
+				zap.Int("tickets_failed", guildResult.Failed),
+				zap.Error(guildResult.Error),
+			)
+		}
+	}
+
+	result.VerifiedOwners = p.owners.snapshot()
+	result.DryRun = p.dryRun
+	if p.quarantine != nil && !p.dryRun && result.TranscriptsDeleted > 0 {
+		result.PurgeAt = p.purgeAt
+	}
+	tracing.End(span, result.Error)
+
+	return result
+}
+
+// initialStage is the stage a request of the type starts in. Requests for transcripts are verified
+// against their servers first, the rest only cover the user's own data.
+func initialStage(requestType gdpr.RequestType) gdpr.Stage {
+	switch requestType {
+	case gdpr.RequestTypeAllTranscripts, gdpr.RequestTypeSpecificTranscripts:
+		return gdpr.StageVerifying
+	case gdpr.RequestTypeDataExport:
+		return gdpr.StageExporting
+	default:
+		return gdpr.StageDeleting
+	}
+}
+
+// loadLegalHolds fetches the holds that apply to the request. Nothing is deleted without knowing
+// them, so failing to load them fails the request. Exports only read data, so aren't affected.
+func (p *Processor) loadLegalHolds(ctx context.Context, request gdpr.Request) error {
+	if p.legalHolds == nil || request.Type == gdpr.RequestTypeDataExport {
+		return nil
+	}
+
+	// Feedback is deleted across every guild the user left it in
+	guildIds := request.GuildIds
+	if request.Type == gdpr.RequestTypeFeedback {
+		guildIds = nil
+	}
+
+	holds, err := p.legalHolds.ActiveLegalHolds(ctx, guildIds)
+	if err != nil {
+		return fmt.Errorf("failed to load legal holds: %w", err)
+	}
+
+	if affected := holds.affectedGuilds(); len(affected) > 0 {
+		p.logger.Info("Legal holds apply to request, held tickets will be withheld", zap.Int("guilds", len(affected)))
+	}
+
+	p.holds = holds
+	return nil
+}
+
+// processesTickets reports whether the request type works through tickets one by one, and so can
+// resume from a checkpoint and report per-guild results
+func processesTickets(requestType gdpr.RequestType) bool {
+	switch requestType {
+	case gdpr.RequestTypeAllTranscripts, gdpr.RequestTypeSpecificTranscripts,
+		gdpr.RequestTypeAllMessages, gdpr.RequestTypeSpecificMessages:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupted returns an error once the request has been cancelled or the worker is shutting down.
+// It's checked between tickets, so either takes effect without waiting for the rest of the guild.
+func interrupted(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("processing interrupted: %w", err)
+	}
+	return nil
+}
+
+// verificationModeFor returns who may make the request, falling back to the configured mode
+func (p *Processor) verificationModeFor(request gdpr.Request) gdpr.VerificationMode {
+	if request.VerificationMode != "" {
+		return request.VerificationMode
+	}
+	return p.verificationMode
+}
+
+func (p *Processor) verifyGuildOwnership(ctx context.Context, guildId, userId uint64, mode gdpr.VerificationMode) (err error) {
+	ctx, span := tracing.Start(ctx, "discord.verify_guild_ownership", tracing.GuildId(guildId),
+		attribute.String("gdpr.verification_mode", string(mode)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Ordered by the bot operator, whose authority doesn't depend on anything Discord says. Queued
+	// requests are never trusted with it, only those handed over by the platform erasure runner.
+	if mode == gdpr.VerificationModePlatform {
+		if !p.platformErasures {
+			return &notAuthorizedError{message: "platform erasures may only be ordered through the admin API"}
+		}
+		return nil
+	}
+
+	// Checked before the token, as a server the bot is still in must never be erased this way
+	if mode == gdpr.VerificationModeGuildRemoved {
+		return p.verifyBotRemoved(ctx, guildId)
+	}
+
+	scrambledUserId := utils.ScrambleUserId(userId)
+
+	if p.discordToken == "" {
+		p.logger.Warn("Discord token not configured, skipping ownership verification",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Uint64("guild_id", guildId),
+		)
+		return nil
+	}
+
+	switch mode {
+	case gdpr.VerificationModeOwner:
+	case gdpr.VerificationModeAdmin:
+		return p.verifyGuildAdmin(ctx, guildId, userId)
+	case gdpr.VerificationModeOpener:
+		return &invalidRequestError{fmt.Sprintf("verification mode %q only applies to specific transcript requests", mode)}
+	default:
+		return &invalidRequestError{fmt.Sprintf("unknown verification mode %q", mode)}
+	}
+
+	guild, err := rest.GetGuild(ctx, p.discordToken, p.rateLimiter, guildId)
+	if err != nil {
+		p.logger.Error("Failed to fetch guild for ownership verification",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to verify guild ownership: unable to fetch guild information")
+	}
+
+	if guild.OwnerId != userId {
+		p.logger.Warn("Ownership verification failed",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Uint64("guild_id", guildId),
+			zap.String("scrambled_actual_owner_id", utils.ScrambleUserId(guild.OwnerId)),
+		)
+		return &notAuthorizedError{fmt.Sprintf("you are not the owner of this server (ID: %d)", guildId)}
+	}
+
+	p.owners.record(guildId, guild.OwnerId)
+
+	p.logger.Debug("Guild ownership verified",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.Uint64("guild_id", guildId),
+	)
+
+	return nil
+}
+
+// verifyBotRemoved accepts purges of servers the bot is no longer in, scheduled when it was removed
+func (p *Processor) verifyBotRemoved(ctx context.Context, guildId uint64) error {
+	if p.discordToken == "" {
+		return fmt.Errorf("a Discord token is required to confirm the bot was removed from server %d", guildId)
+	}
+
+	inGuild, err := permissions.BotInGuild(ctx, p.discordToken, p.rateLimiter, guildId)
+	if err != nil {
+		p.logger.Error("Failed to check whether the bot is still in guild",
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to verify the bot was removed: unable to fetch guild information")
+	}
+
+	if inGuild {
+		p.logger.Warn("Bot is back in guild, refusing to purge it", zap.Uint64("guild_id", guildId))
+		return &notAuthorizedError{fmt.Sprintf("the bot is still in this server (ID: %d)", guildId)}
+	}
+
+	return nil
+}
+
+// verifyGuildAdmin accepts the guild owner, members with Administrator and users or roles made
+// admins on the dashboard, mirroring how the bot authorizes settings changes
+func (p *Processor) verifyGuildAdmin(ctx context.Context, guildId, userId uint64) error {
+	scrambledUserId := utils.ScrambleUserId(userId)
+
+	admin, err := p.isGuildAdmin(ctx, guildId, userId)
+	if err != nil {
+		p.logger.Error("Failed to resolve permissions for admin verification",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed to verify server permissions: unable to fetch member information")
+	}
+
+	if !admin {
+		p.logger.Warn("Admin verification failed",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Uint64("guild_id", guildId),
+		)
+		return &notAuthorizedError{fmt.Sprintf("you must be the owner or an administrator of this server (ID: %d)", guildId)}
+	}
+
+	// The guild is already cached from the permission check, so this doesn't cost a request
+	if ownerId, err := p.permissions.GuildOwner(ctx, guildId); err == nil {
+		p.owners.record(guildId, ownerId)
+	}
+
+	p.logger.Debug("Guild admin verified",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.Uint64("guild_id", guildId),
+	)
+
+	return nil
+}
+
+func (p *Processor) isGuildAdmin(ctx context.Context, guildId, userId uint64) (bool, error) {
+	// Covers the owner too, who holds every permission
+	administrator, err := p.permissions.HasPermissions(ctx, guildId, userId, permissions.Administrator)
+	if err != nil {
+		return false, err
+	}
+
+	if administrator {
+		return true, nil
+	}
+
+	dashboardAdmin, err := p.db.Permissions.IsAdmin(ctx, guildId, userId)
+	if err != nil {
+		return false, fmt.Errorf("failed to check dashboard admins: %w", err)
+	}
+
+	if dashboardAdmin {
+		return true, nil
+	}
+
+	adminRoles, err := p.db.RolePermissions.GetAdminRoles(ctx, guildId)
+	if err != nil {
+		return false, fmt.Errorf("failed to get dashboard admin roles: %w", err)
+	}
+
+	if len(adminRoles) == 0 {
+		return false, nil
+	}
+
+	memberRoles, err := p.permissions.MemberRoles(ctx, guildId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, roleId := range memberRoles {
+		if slices.Contains(adminRoles, roleId) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// verifyTicketOpener checks the user opened every requested ticket that exists in the guild. Tickets
+// that don't exist are left to the unmatched ticket check.
+func (p *Processor) verifyTicketOpener(ctx context.Context, guildId uint64, ticketIds []int, userId uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "gdpr.verify_ticket_opener", tracing.GuildId(guildId), tracing.AttributeTicketCount.Int(len(ticketIds)))
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := p.db.Tickets.Query(ctx,
+		`SELECT id FROM tickets WHERE guild_id = $1 AND id = ANY($2) AND user_id != $3 ORDER BY id`,
+		guildId, ticketIds, userId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify ticket opener: %w", err)
+	}
+	defer rows.Close()
+
+	var notOpened []int
+	for rows.Next() {
+		var ticketId int
+		if err := rows.Scan(&ticketId); err != nil {
+			return fmt.Errorf("failed to verify ticket opener: %w", err)
+		}
+		notOpened = append(notOpened, ticketId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to verify ticket opener: %w", err)
+	}
+
+	if len(notOpened) > 0 {
+		p.logger.Warn("Ticket opener verification failed",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+			zap.Uint64("guild_id", guildId),
+			zap.Ints("ticket_ids", notOpened),
+		)
+		formatted := make([]string, len(notOpened))
+		for i, ticketId := range notOpened {
+			formatted[i] = fmt.Sprintf("#%d", ticketId)
+		}
+
+		return &notAuthorizedError{fmt.Sprintf("you did not open these tickets: %s", strings.Join(formatted, ", "))}
+	}
+
+	return nil
+}
+
+// notAuthorizedError is returned when verification shows the user may not make requests for a
+// guild, as opposed to verification that couldn't be carried out
+type notAuthorizedError struct {
+	message string
+}
+
+func (e *notAuthorizedError) Error() string {
+	return e.message
+}
+
+// IsNotAuthorized reports whether err is because the user may not make requests for the servers of
+// the request, rather than because processing failed
+func IsNotAuthorized(err error) bool {
+	var notAuthorized *notAuthorizedError
+	return errors.As(err, &notAuthorized)
+}
+
+// invalidRequestError is returned for requests that can never be processed as made, such as ones
+// missing the servers or tickets they apply to
+type invalidRequestError struct {
+	message string
+}
+
+func (e *invalidRequestError) Error() string {
+	return e.message
+}
+
+// IsPermanent reports whether err can't be resolved by trying the request again, as the user may
+// not make it or it is invalid. Any other error is taken to be transient, such as Discord, the
+// database or the archiver being unreachable, and is worth retrying.
+func IsPermanent(err error) bool {
+	var invalidRequest *invalidRequestError
+	return IsNotAuthorized(err) || errors.As(err, &invalidRequest)
+}
+
+// verifyAllGuildsOwnership returns the guilds the user may make requests for, recording the rest as
+// rejected. Fails if none of the guilds can be processed, or verification couldn't be carried out
+// for one of them, in which case the request is retried rather than skipping the guild.
+func (p *Processor) verifyAllGuildsOwnership(ctx context.Context, guildIds []uint64, userId uint64, mode gdpr.VerificationMode) ([]uint64, error) {
+	verified := make([]uint64, 0, len(guildIds))
+	var rejection error
+
+	for _, guildId := range guildIds {
+		err := p.verifyGuildOwnership(ctx, guildId, userId, mode)
+
+		var notAuthorized *notAuthorizedError
+		if errors.As(err, &notAuthorized) {
+			rejection = err
+			p.results.rejected(guildId, err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		verified = append(verified, guildId)
+	}
+
+	if len(verified) == 0 {
+		return nil, rejection
+	}
+
+	return verified, nil
+}
+
+func (p *Processor) processAllTranscripts(ctx context.Context, request gdpr.Request) ProcessResult {
+	if len(request.GuildIds) == 0 {
+		return ProcessResult{Error: &invalidRequestError{"invalid server ID provided"}}
+	}
+
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	mode := p.verificationModeFor(request)
+	guildIds, err := p.verifyAllGuildsOwnership(ctx, request.GuildIds, request.UserId, mode)
+	if err != nil {
+		p.logger.Error("Guild ownership verification failed",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Error(err),
+		)
+		return ProcessResult{Error: err}
+	}
+
+	if rejected := len(request.GuildIds) - len(guildIds); rejected > 0 {
+		p.logger.Warn("Skipping servers the user may not make requests for",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.Int("rejected", rejected),
+			zap.Int("verified", len(guildIds)),
+		)
+	}
+
+	// Locked before anything is deleted, so message cleaning requests for the same servers wait
+	// for the deletion rather than storing cleaned copies of transcripts back afterwards
+	unlock, err := p.lockDeletion(ctx, guildIds)
+	if err != nil {
+		return ProcessResult{Error: err}
+	}
+	defer unlock()
+
+	p.progress.setStage(ctx, gdpr.StageDeleting)
+
+	transcriptsDeleted := 0
+	leftovers := 0
+	var lastError error
+
+	for i, guildId := range guildIds {
+		if err := interrupted(ctx); err != nil {
+			return ProcessResult{TranscriptsDeleted: transcriptsDeleted, Error: err}
+		}
+
+		if err := p.awaitRewrites(ctx, guildIds[i:]); err != nil {
+			if ctx.Err() != nil {
+				return ProcessResult{TranscriptsDeleted: transcriptsDeleted, Error: err}
+			}
+
+			lastError = err
+			p.results.guildFailed(guildId, err)
+			p.logger.Error("Transcripts still being rewritten, skipping guild",
+				zap.String("scrambled_user_id", scrambledUserId),
+				zap.Uint64("guild_id", guildId),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if err := p.recheckOwnership(ctx, guildId, request.UserId, mode); err != nil {
+			lastError = err
+			var notAuthorized *notAuthorizedError
+			if errors.As(err, &notAuthorized) {
+				p.results.rejected(guildId, err)
+			} else {
+				p.results.guildFailed(guildId, err)
+			}
+			p.logger.Error("Guild ownership recheck failed, skipping guild",
+				zap.String("scrambled_user_id", scrambledUserId),
+				zap.Uint64("guild_id", guildId),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		deleted, remaining, err := p.deleteAllTranscripts(ctx, guildId)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ProcessResult{TranscriptsDeleted: transcriptsDeleted + deleted, Error: err}
+			}
+
+			lastError = err
+			p.results.guildFailed(guildId, err)
+			p.logger.Error("Failed to delete transcripts",
+				zap.String("scrambled_user_id", scrambledUserId),
+				zap.String("request_type", requestTypeName),
+				zap.Error(err),
+			)
+			continue
+		}
+		transcriptsDeleted += deleted
+		leftovers += remaining
+	}
+
+	if transcriptsDeleted == 0 && lastError != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to delete any transcripts: %w", lastError)}
+	}
+
+	// Purges of servers the bot was removed from have no user to scrub
+	var scrubbed int
+	if request.UserId != 0 {
+		scrubbed, err = p.scrubUserReferences(ctx, request.UserId, guildIds)
+		if err != nil {
+			return ProcessResult{
+				TranscriptsDeleted: transcriptsDeleted,
+				Error:              fmt.Errorf("failed to scrub user references: %w", err),
+			}
+		}
+	}
+
+	if transcriptsDeleted > 0 || scrubbed > 0 {
+		p.logger.Info("GDPR request completed",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.String("request_type", requestTypeName),
+			zap.Int("transcripts_deleted", transcriptsDeleted),
+			zap.Int("references_scrubbed", scrubbed),
+		)
+	}
+
+	return ProcessResult{
+		TranscriptsDeleted: transcriptsDeleted,
+		ReferencesScrubbed: scrubbed,
+		LeftoverObjects:    leftovers,
+	}
+}
+
+func (p *Processor) processSpecificTranscripts(ctx context.Context, request gdpr.Request) ProcessResult {
+	if len(request.GuildIds) == 0 {
+		return ProcessResult{Error: &invalidRequestError{"no server ID provided"}}
+	}
+	if len(request.TicketIds) == 0 {
+		return ProcessResult{Error: &invalidRequestError{"no ticket IDs provided"}}
+	}
+
+	guildId := request.GuildIds[0]
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	var verifyErr error
+	mode := p.verificationModeFor(request)
+	if mode == gdpr.VerificationModeOpener {
+		verifyErr = p.verifyTicketOpener(ctx, guildId, request.TicketIds, request.UserId)
+	} else {
+		verifyErr = p.verifyGuildOwnership(ctx, guildId, request.UserId, mode)
+	}
+
+	if err := verifyErr; err != nil {
+		p.logger.Error("Guild ownership verification failed",
+			zap.String("scrambled_user_id", scrambledUserId),
+			zap.String("request_type", requestTypeName),
+			zap.Error(err),
+		)
+		return ProcessResult{Error: err}
+	}
+
+	p.progress.setStage(ctx, gdpr.StageDeleting)
+
+	var unmatched []int
+	if p.verifyGuild {
+		var err error
+		unmatched, err = p.getUnmatchedTicketIds(ctx, guildId, request.TicketIds)
+		if err != nil {
+			return ProcessResult{Error: err}
+		}
+
+		if len(unmatched) > 0 {
+			p.logger.Warn("Requested tickets do not belong to guild",
+				zap.String("scrambled_user_id", scrambledUserId),
+				zap.Uint64("guild_id", guildId),
+				zap.Ints("ticket_ids", unmatched),
+			)
+		}
+	}
+
+	if err := p.recheckOwnership(ctx, guildId, request.UserId, mode); err != nil {
+		return ProcessResult{Error: err}
+	}
+
+	transcriptsDeleted, err := p.deleteSpecificTranscripts(ctx, guildId, request.TicketIds)
+	if err != nil {
+		return ProcessResult{
+			TranscriptsDeleted: transcriptsDeleted,
+			Error:              fmt.Errorf("failed to delete specific transcripts: %w", err),
+		}
+	}
+
+	p.logger.Info("GDPR request completed",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("transcripts_deleted", transcriptsDeleted),
+	)
+
+	return ProcessResult{
+		TranscriptsDeleted: transcriptsDeleted,
+		UnmatchedTicketIds: unmatched,
+	}
+}
+
+func (p *Processor) processAllMessages(ctx context.Context, request gdpr.Request) ProcessResult {
+	var messagesDeleted int
+	var err error
+
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	// Looked up before cleaning, so tickets archived while the request runs aren't missed, and
+	// before the user's memberships are scrubbed
+	lateTickets := p.findUnarchivedTickets(ctx, request.UserId, request.GuildIds, nil, time.Now())
+
+	messagesDeleted, err = p.deleteUserMessagesFromGuilds(ctx, request.GuildIds, request.UserId)
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to delete all user messages: %w", err)}
+	}
+
+	// Transcripts are looked up through the user's ticket memberships, so these must only be
+	// scrubbed once the messages have been cleaned
+	scrubbed, err := p.scrubUserReferences(ctx, request.UserId, request.GuildIds)
+	if err != nil {
+		return ProcessResult{
+			MessagesDeleted: messagesDeleted,
+			Error:           fmt.Errorf("failed to scrub user references: %w", err),
+		}
+	}
+
+	p.logger.Info("GDPR request completed",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("messages_deleted", messagesDeleted),
+		zap.Int("references_scrubbed", scrubbed),
+	)
+
+	return ProcessResult{
+		MessagesDeleted:    messagesDeleted,
+		ReferencesScrubbed: scrubbed,
+		LateTickets:        lateTickets,
+	}
+}
+
+func (p *Processor) processSpecificMessages(ctx context.Context, request gdpr.Request) ProcessResult {
+	if len(request.GuildIds) == 0 {
+		return ProcessResult{Error: &invalidRequestError{"no guild ID provided"}}
+	}
+	if len(request.TicketIds) == 0 {
+		return ProcessResult{Error: &invalidRequestError{"no ticket IDs provided"}}
+	}
+
+	guildId := request.GuildIds[0]
+	scrambledUserId := utils.ScrambleUserId(request.UserId)
+	requestTypeName := utils.GetRequestTypeName(int(request.Type))
+
+	lateTickets := p.findUnarchivedTickets(ctx, request.UserId, request.GuildIds[:1], request.TicketIds, time.Now())
+
+	messagesDeleted, err := p.deleteUserMessagesFromTickets(ctx, guildId, request.TicketIds, request.UserId)
+	if err != nil {
+		return ProcessResult{Error: fmt.Errorf("failed to delete specific user messages: %w", err)}
+	}
+
+	p.logger.Info("GDPR request completed",
+		zap.String("scrambled_user_id", scrambledUserId),
+		zap.String("request_type", requestTypeName),
+		zap.Int("messages_deleted", messagesDeleted),
+	)
+
+	return ProcessResult{
+		MessagesDeleted: messagesDeleted,
+		LateTickets:     lateTickets,
+	}
+}
+
+// Transcript deletion helpers
+// deleteAllTranscripts deletes every transcript in the guild, returning the number deleted and, if
+// the guild was purged, the number of objects found left in storage afterwards
+func (p *Processor) deleteAllTranscripts(ctx context.Context, guildId uint64) (int, int, error) {
+	ticketIds, err := p.getTranscriptTicketIds(ctx, guildId, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p.countWithoutTranscript(ctx, guildId, nil)
+	ticketIds = p.withholdTranscripts(guildId, ticketIds)
+
+	// A purge removes everything stored under the guild, so can't be used with anything held or when
+	// transcripts must be kept until their grace period is over
+	if p.purger != nil && p.quarantine == nil && !p.dryRun && len(ticketIds) > 0 && !p.holds.affects(guildId) {
+		deleted, err := p.purgeGuildTranscripts(ctx, guildId, ticketIds)
+		if err == nil {
+			p.results.deleted(guildId, deleted, 0)
+			return deleted, p.countLeftoverObjects(ctx, guildId), nil
+		}
+
+		if ctx.Err() != nil {
+			return 0, 0, interrupted(ctx)
+		}
+
+		// The per-ticket path is authoritative, so anything the purge missed is retried through it
+		p.logger.Warn("Guild transcript purge failed, deleting transcripts individually",
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+	}
+
+	deleted, err := p.deleteTranscripts(ctx, guildId, ticketIds)
+	return deleted, 0, err
+}
+
+func (p *Processor) deleteSpecificTranscripts(ctx context.Context, guildId uint64, ticketIds []int) (int, error) {
+	if len(ticketIds) == 0 {
+		return 0, nil
+	}
+
+	validIds, err := p.getTranscriptTicketIds(ctx, guildId, ticketIds)
+	if err != nil {
+		return 0, err
+	}
+
+	// Tickets without a transcript have nothing left to delete, those that are open or don't exist
+	// aren't in scope
+	withoutTranscript := p.countWithoutTranscript(ctx, guildId, ticketIds)
+	p.results.skipped(guildId, len(ticketIds)-len(validIds)-withoutTranscript)
+
+	return p.deleteTranscripts(ctx, guildId, p.withholdTranscripts(guildId, validIds))
+}
+
+func (p *Processor) getTranscriptTicketIds(ctx context.Context, guildId uint64, filterIds []int) ([]int, error) {
+	ctx, span := tracing.StartQuery(ctx, "get_transcript_ticket_ids", tracing.GuildId(guildId))
+	defer span.End()
+
+	var query string
+	var args []interface{}
+
+	if filterIds == nil {
+		query = `SELECT id FROM tickets WHERE guild_id = $1 AND has_transcript = true AND open = false ORDER BY id`
+		args = []interface{}{guildId}
+	} else {
+		query = `SELECT id FROM tickets WHERE guild_id = $1 AND id = ANY($2) AND has_transcript = true AND open = false ORDER BY id`
+		args = []interface{}{guildId, filterIds}
+	}
+
+	rows, err := p.db.Tickets.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tickets: %w", err)
+	}
+	defer rows.Close()
+
+	var ticketIds []int
+	for rows.Next() {
+		var ticketId int
+		if err := rows.Scan(&ticketId); err == nil {
+			ticketIds = append(ticketIds, ticketId)
+		}
+	}
+
+	return ticketIds, nil
+}
+
+// countWithoutTranscript records the closed tickets of the guild, or those of ticketIds if not nil,
+// that have no stored transcript, and returns how many there were. Tickets an earlier attempt
+// handled are left out, as it deleted their transcript. The count is only informational, so a
+// failed query counts none.
+func (p *Processor) countWithoutTranscript(ctx context.Context, guildId uint64, ticketIds []int) int {
+	ctx, span := tracing.StartQuery(ctx, "get_tickets_without_transcript", tracing.GuildId(guildId))
+	defer span.End()
+
+	query := `SELECT id FROM tickets WHERE guild_id = $1 AND has_transcript = false AND open = false`
+	args := []interface{}{guildId}
+	if ticketIds != nil {
+		query += ` AND id = ANY($2)`
+		args = append(args, ticketIds)
+	}
+
+	rows, err := p.db.Tickets.Query(ctx, query, args...)
+	if err != nil {
+		p.logger.Warn("Failed to count tickets without a transcript",
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+		return 0
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var ticketId int
+		if err := rows.Scan(&ticketId); err == nil && !p.checkpoint.handled(guildId, ticketId) {
+			count++
+		}
+	}
+
+	p.results.noTranscript(guildId, count)
+	return count
+}
+
+// getUnmatchedTicketIds returns the IDs in ticketIds that don't exist in the guild, regardless of
+// whether they are open or have a transcript
+func (p *Processor) getUnmatchedTicketIds(ctx context.Context, guildId uint64, ticketIds []int) ([]int, error) {
+	ctx, span := tracing.StartQuery(ctx, "get_unmatched_ticket_ids", tracing.GuildId(guildId))
+	defer span.End()
+
+	rows, err := p.db.Tickets.Query(ctx, `SELECT id FROM tickets WHERE guild_id = $1 AND id = ANY($2)`, guildId, ticketIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tickets: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[int]bool)
+	for rows.Next() {
+		var ticketId int
+		if err := rows.Scan(&ticketId); err == nil {
+			found[ticketId] = true
+		}
+	}
+
+	var unmatched []int
+	for _, ticketId := range ticketIds {
+		if !found[ticketId] {
+			unmatched = append(unmatched, ticketId)
+		}
+	}
+
+	return unmatched, nil
+}
+
+func (p *Processor) deleteTranscripts(ctx context.Context, guildId uint64, ticketIds []int) (int, error) {
+	if p.dryRun {
+		p.results.deleted(guildId, len(ticketIds), 0)
+		return len(ticketIds), nil
+	}
+
+	// Tickets must be visited in ID order for the checkpoint cursor to be meaningful
+	remaining := ticketIds[:0:0]
+	for _, ticketId := range ticketIds {
+		if !p.checkpoint.handled(guildId, ticketId) {
+			remaining = append(remaining, ticketId)
+		}
+	}
+
+	p.results.skipped(guildId, len(ticketIds)-len(remaining))
+	p.progress.addTotal(len(remaining))
+
+	if p.quarantine != nil {
+		return p.quarantineTranscripts(ctx, guildId, remaining)
+	}
+
+	deleted := 0
+	if p.batchDeleter != nil && !p.batchUnsupported.Load() {
+		deleted, remaining = p.deleteTranscriptsBatched(ctx, guildId, remaining)
+	}
+
+	for _, ticketId := range remaining {
+		if err := interrupted(ctx); err != nil {
+			return deleted, err
+		}
+
+		if err := p.pacer.wait(ctx, 1); err != nil {
+			return deleted, err
+		}
+
+		if err := p.deleteTranscript(ctx, guildId, ticketId); err == nil {
+			deleted++
+			if changed, err := p.setHasTranscript(ctx, guildId, ticketId, false); err != nil {
+				p.logger.Error("Failed to update has_transcript flag after deletion",
+					zap.Uint64("guild_id", guildId),
+					zap.Int("ticket_id", ticketId),
+					zap.Error(err),
+				)
+			} else if !changed {
+				p.reportFlagMismatch(guildId, []int{ticketId}, flagAlreadyCleared)
+			}
+			p.progress.ticketDone(ctx, 1, 0)
+			p.checkpoint.record(ctx, guildId, ticketId, true, 1, 0)
+			p.results.deleted(guildId, 1, 0)
+		} else if ctx.Err() != nil {
+			// The call was aborted rather than failing, so the ticket is left for the next attempt
+			return deleted, interrupted(ctx)
+		} else {
+			p.progress.ticketDone(ctx, 0, 0)
+			p.checkpoint.record(ctx, guildId, ticketId, false, 0, 0)
+			p.results.failed(guildId, fmt.Errorf("failed to delete transcript for ticket %d: %w", ticketId, err))
+		}
+	}
+	return deleted, nil
+}
+
+func (p *Processor) deleteTranscript(ctx context.Context, guildId uint64, ticketId int) (err error) {
+	ctx, span := tracing.Start(ctx, "archiver.delete_ticket", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	if p.retriever == nil {
+		return fmt.Errorf("archiver retriever not configured")
+	}
+	return p.retriever.DeleteTicket(ctx, guildId, ticketId)
+}
+
+// setHasTranscript updates the has_transcript flag of a ticket only if it holds the other value,
+// reporting whether it changed. Replays and concurrent requests setting the same value then show up
+// as no change, rather than silently overwriting whatever state the flag was in.
+func (p *Processor) setHasTranscript(ctx context.Context, guildId uint64, ticketId int, hasTranscript bool) (changed bool, err error) {
+	ctx, span := tracing.StartQuery(ctx, "set_has_transcript", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	tag, err := p.db.Tickets.Exec(ctx,
+		`UPDATE tickets SET has_transcript = $3 WHERE guild_id = $1 AND id = $2 AND has_transcript IS DISTINCT FROM $3`,
+		guildId, ticketId, hasTranscript,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// Message deletion helpers
+func (p *Processor) deleteUserMessagesFromGuilds(ctx context.Context, guildIds []uint64, userId uint64) (messagesDeleted int, err error) {
+	tickets, err := p.getUserTicketsInGuilds(ctx, userId, guildIds)
+	if err != nil {
+		return 0, err
+	}
+
+	p.countUserTicketsWithoutTranscript(ctx, userId, guildIds)
+	return p.cleanUserMessagesInTickets(ctx, p.withholdTickets(tickets), userId)
+}
+
+// countUserTicketsWithoutTranscript records the closed tickets of the user in the guilds that have
+// no stored transcript to clean. Like countWithoutTranscript, it's only informational.
+func (p *Processor) countUserTicketsWithoutTranscript(ctx context.Context, userId uint64, guildIds []uint64) {
+	ctx, span := tracing.StartQuery(ctx, "get_user_tickets_without_transcript")
+	defer span.End()
+
+	query := `
+	SELECT DISTINCT t.id, t.guild_id
+	FROM tickets t
+	LEFT JOIN ticket_members tm ON t.guild_id = tm.guild_id AND t.id = tm.ticket_id
+	WHERE (tm.user_id = $1 OR t.user_id = $1)
+	AND t.guild_id = ANY($2)
+	AND t.open = false
+	AND t.has_transcript = false
+	`
+
+	rows, err := p.db.Tickets.Query(ctx, query, userId, guildIds)
+	if err != nil {
+		p.logger.Warn("Failed to count user tickets without a transcript",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+			zap.Error(err),
+		)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ticket ticketInfo
+		if err := rows.Scan(&ticket.ID, &ticket.GuildID); err == nil && !p.checkpoint.handled(ticket.GuildID, ticket.ID) {
+			p.results.noTranscript(ticket.GuildID, 1)
+		}
+	}
+}
+
+type ticketInfo struct {
+	ID      int
+	GuildID uint64
+}
+
+func (p *Processor) deleteUserMessagesFromTickets(ctx context.Context, guildId uint64, ticketIds []int, userId uint64) (messagesDeleted int, err error) {
+	if len(ticketIds) == 0 {
+		return 0, nil
+	}
+
+	tickets := make([]ticketInfo, 0, len(ticketIds))
+	for _, id := range ticketIds {
+		tickets = append(tickets, ticketInfo{ID: id, GuildID: guildId})
+	}
+
+	validTickets := p.validateTicketsForMessageCleaning(ctx, tickets)
+	withoutTranscript := p.countWithoutTranscript(ctx, guildId, ticketIds)
+	p.results.skipped(guildId, len(tickets)-len(validTickets)-withoutTranscript)
+
+	return p.cleanUserMessagesInTickets(ctx, p.withholdTickets(validTickets), userId)
+}
+
+func (p *Processor) getUserTickets(ctx context.Context, userId uint64) ([]ticketInfo, error) {
+	ctx, span := tracing.StartQuery(ctx, "get_user_tickets")
+	defer span.End()
+
+	query := `
+	SELECT DISTINCT t.id, t.guild_id
+	FROM tickets t
+	LEFT JOIN ticket_members tm ON t.guild_id = tm.guild_id AND t.id = tm.ticket_id
+	WHERE (tm.user_id = $1 OR t.user_id = $1)
+	AND t.open = false
+	AND t.has_transcript = true
+	ORDER BY t.id
+	`
+
+	rows, err := p.db.Tickets.Query(ctx, query, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user tickets: %w", err)
+	}
+	defer rows.Close()
+
+	var tickets []ticketInfo
+	for rows.Next() {
+		var ticket ticketInfo
+		if err := rows.Scan(&ticket.ID, &ticket.GuildID); err == nil {
+			tickets = append(tickets, ticket)
+		}
+	}
+
+	return tickets, nil
+}
+
+func (p *Processor) getUserTicketsInGuilds(ctx context.Context, userId uint64, guildIds []uint64) ([]ticketInfo, error) {
+	ctx, span := tracing.StartQuery(ctx, "get_user_tickets_in_guilds")
+	defer span.End()
+
+	query := `
+	SELECT DISTINCT t.id, t.guild_id
+	FROM tickets t
+	LEFT JOIN ticket_members tm ON t.guild_id = tm.guild_id AND t.id = tm.ticket_id
+	WHERE (tm.user_id = $1 OR t.user_id = $1)
+	AND t.guild_id = ANY($2)
+	AND t.open = false
+	AND t.has_transcript = true
+	ORDER BY t.id
+	`
+
+	rows, err := p.db.Tickets.Query(ctx, query, userId, guildIds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user tickets in guilds: %w", err)
+	}
+	defer rows.Close()
+
+	var tickets []ticketInfo
+	for rows.Next() {
+		var ticket ticketInfo
+		if err := rows.Scan(&ticket.ID, &ticket.GuildID); err == nil {
+			tickets = append(tickets, ticket)
+		}
+	}
+
+	return tickets, nil
+}
+
+func (p *Processor) validateTicketsForMessageCleaning(ctx context.Context, tickets []ticketInfo) []ticketInfo {
+	if len(tickets) == 0 {
+		return nil
+	}
+
+	// Group tickets by guild_id for efficient querying
+	ticketsByGuild := make(map[uint64][]int)
+	ticketMap := make(map[uint64]map[int]ticketInfo)
+
+	for _, ticket := range tickets {
+		ticketsByGuild[ticket.GuildID] = append(ticketsByGuild[ticket.GuildID], ticket.ID)
+		if ticketMap[ticket.GuildID] == nil {
+			ticketMap[ticket.GuildID] = make(map[int]ticketInfo)
+		}
+		ticketMap[ticket.GuildID][ticket.ID] = ticket
+	}
+
+	var validTickets []ticketInfo
+
+	// Query each guild's tickets in a single query
+	for guildId, ticketIds := range ticketsByGuild {
+		queryCtx, span := tracing.StartQuery(ctx, "validate_tickets", tracing.GuildId(guildId), tracing.AttributeTicketCount.Int(len(ticketIds)))
+
+		query := `SELECT id FROM tickets WHERE guild_id = $1 AND id = ANY($2) AND open = false AND has_transcript = true ORDER BY id`
+		rows, err := p.db.Tickets.Query(queryCtx, query, guildId, ticketIds)
+		if err != nil {
+			tracing.End(span, err)
+			p.logger.Error("Failed to validate tickets for message cleaning",
+				zap.Uint64("guild_id", guildId),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		for rows.Next() {
+			var ticketId int
+			if err := rows.Scan(&ticketId); err == nil {
+				if ticket, exists := ticketMap[guildId][ticketId]; exists {
+					validTickets = append(validTickets, ticket)
+				}
+			}
+		}
+		rows.Close()
+		span.End()
+	}
+
+	return validTickets
+}
+
+func (p *Processor) cleanUserMessagesInTickets(ctx context.Context, tickets []ticketInfo, userId uint64) (messagesDeleted int, err error) {
+	remaining := tickets[:0:0]
+	for _, ticket := range tickets {
+		if !p.checkpoint.handled(ticket.GuildID, ticket.ID) {
+			remaining = append(remaining, ticket)
+		} else {
+			p.results.skipped(ticket.GuildID, 1)
+		}
+	}
+
+	p.progress.addTotal(len(remaining))
+
+	var lastErr error
+	for _, ticket := range remaining {
+		if err := interrupted(ctx); err != nil {
+			return messagesDeleted, err
+		}
+
+		count, err := p.cleanUserMessages(ctx, ticket.GuildID, ticket.ID, userId)
+		if errors.Is(err, errDeletionStarted) {
+			// Cleaning again waits for the deletion, then finds out whether it took the transcript
+			count, err = p.cleanUserMessages(ctx, ticket.GuildID, ticket.ID, userId)
+		}
+
+		if err != nil && ctx.Err() != nil {
+			return messagesDeleted, interrupted(ctx)
+		}
+
+		if errors.Is(err, errTranscriptGone) {
+			p.progress.ticketDone(ctx, 0, 0)
+			p.checkpoint.record(ctx, ticket.GuildID, ticket.ID, true, 0, 0)
+			p.results.alreadyDeleted(ticket.GuildID, ticket.ID)
+			continue
+		}
+
+		if errors.Is(err, errTranscriptNotFound) {
+			p.reportFlagMismatch(ticket.GuildID, []int{ticket.ID}, flagNoObject)
+			p.progress.ticketDone(ctx, 0, 0)
+			p.checkpoint.record(ctx, ticket.GuildID, ticket.ID, true, 0, 0)
+			p.results.noTranscript(ticket.GuildID, 1)
+			continue
+		}
+
+		p.progress.ticketDone(ctx, 0, count)
+		p.checkpoint.record(ctx, ticket.GuildID, ticket.ID, err == nil, 0, count)
+		if err != nil {
+			lastErr = err
+			p.results.failed(ticket.GuildID, fmt.Errorf("failed to clean messages in ticket %d: %w", ticket.ID, err))
+			continue
+		}
+
+		if count > 0 {
+			messagesDeleted += count
+			p.results.deleted(ticket.GuildID, 0, count)
+		} else {
+			p.results.skipped(ticket.GuildID, 1)
+		}
+	}
+
+	if messagesDeleted == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+
+	return messagesDeleted, nil
+}
+
+func (p *Processor) cleanUserMessages(ctx context.Context, guildId uint64, ticketId int, userId uint64) (count int, err error) {
+	ctx, span := tracing.Start(ctx, "gdpr.clean_ticket_messages", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() {
+		span.SetAttributes(attribute.Int("gdpr.messages_cleaned", count))
+		tracing.End(span, err)
+	}()
+
+	if p.archiver == nil {
+		return 0, fmt.Errorf("archiver client not configured")
+	}
+
+	if err := p.awaitDeletion(ctx, guildId); err != nil {
+		return 0, err
+	}
+
+	ticket, err := p.getTicket(ctx, guildId, ticketId)
+	if err != nil {
+		return 0, fmt.Errorf("ticket %d not found in guild %d", ticketId, guildId)
+	}
+	if !ticket.HasTranscript {
+		return 0, errTranscriptGone
+	}
+
+	transcript, encoded, err := p.fetchTranscript(ctx, guildId, ticketId)
+	if err != nil {
+		return 0, err
+	}
+
+	bots, err := p.guildBots(ctx, guildId)
+	if err != nil {
+		return 0, err
+	}
+
+	var cleaned []byte
+	var unattributed int
+	var attachments []channel.Attachment
+	if encoded != nil {
+		cleaned, count, unattributed, attachments, err = p.cleanTranscriptStream(encoded, userId, bots)
+		if errors.Is(err, errEntitiesAfterMessages) {
+			if transcript, err = decodeTranscriptJson(encoded); err != nil {
+				return 0, err
+			}
+			encoded = nil
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to rewrite transcript: %w", err)
+		}
+	}
+
+	if encoded == nil {
+		count, unattributed, attachments = p.cleanMessagesInTranscript(&transcript, userId, bots)
+	}
+
+	if count == 0 || p.dryRun {
+		p.reportUnattributed(guildId, ticketId, unattributed)
+		return count, nil
+	}
+
+	// Held until the cleaned transcript is stored, so a deletion starting meanwhile waits for it
+	unlock, err := p.lockRewrite(ctx, guildId)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// A deletion may have come and gone since the ticket was read, storing now would bring it back
+	if ticket, err = p.getTicket(ctx, guildId, ticketId); err != nil {
+		return 0, fmt.Errorf("ticket %d not found in guild %d", ticketId, guildId)
+	}
+	if !ticket.HasTranscript {
+		return 0, errTranscriptGone
+	}
+
+	// Removed before the transcript is stored, so a failure leaves the references in place for the
+	// retry to find again
+	if err := p.deleteAttachments(ctx, guildId, ticketId, attachments); err != nil {
+		return 0, err
+	}
+
+	if cleaned == nil {
+		if cleaned, err = json.Marshal(transcript); err != nil {
+			return 0, fmt.Errorf("failed to serialize transcript: %w", err)
+		}
+	}
+
+	if err := p.storeTranscript(ctx, guildId, ticketId, cleaned); err != nil {
+		return 0, fmt.Errorf("failed to store cleaned transcript: %w", err)
+	}
+
+	// The transcript was just read from storage, so the flag should already be set
+	if changed, err := p.setHasTranscript(ctx, guildId, ticketId, true); err != nil {
+		p.logger.Error("Failed to update has_transcript flag after message cleaning",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("ticket_id", ticketId),
+			zap.Error(err),
+		)
+	} else if changed {
+		p.reportFlagMismatch(guildId, []int{ticketId}, flagWasUnset)
+	}
+
+	p.reportUnattributed(guildId, ticketId, unattributed)
+	return count, nil
+}
+
+func (p *Processor) getTicket(ctx context.Context, guildId uint64, ticketId int) (ticket database.Ticket, err error) {
+	ctx, span := tracing.StartQuery(ctx, "get_ticket", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	return p.db.Tickets.Get(ctx, ticketId, guildId)
+}
+
+// errTranscriptNotFound is returned when the archiver has no transcript for a ticket
+var errTranscriptNotFound = errors.New("transcript not found")
+
+func (p *Processor) getTranscript(ctx context.Context, guildId uint64, ticketId int) (v2.Transcript, error) {
+	ctx, span := tracing.Start(ctx, "archiver.get_transcript", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	transcript, err := p.archiver.Get(ctx, guildId, ticketId)
+	tracing.End(span, err)
+	if err != nil {
+		if err == archiverclient.ErrNotFound {
+			return v2.Transcript{}, errTranscriptNotFound
+		}
+		if strings.Contains(err.Error(), "magic number") || strings.Contains(err.Error(), "invalid input") {
+			legacy, legacyErr := p.getLegacyTranscript(ctx, guildId, ticketId)
+			if legacyErr != nil {
+				return v2.Transcript{}, fmt.Errorf("transcript format incompatible with cleaning: %w", legacyErr)
+			}
+
+			p.logger.Debug("Decoded legacy transcript format",
+				zap.Uint64("guild_id", guildId),
+				zap.Int("ticket_id", ticketId),
+			)
+			return legacy, nil
+		}
+		return v2.Transcript{}, fmt.Errorf("failed to retrieve transcript: %w", err)
+	}
+	return transcript, nil
+}
+
+// cleanMessagesInTranscript redacts the user's messages, returning how many were redacted, how many
+// were left in place as they may have been relayed for the user by a webhook, and the attachments
+// that were removed from the redacted messages
+func (p *Processor) cleanMessagesInTranscript(transcript *v2.Transcript, userId uint64, bots map[uint64]bool) (int, int, []channel.Attachment) {
+	relays, ok := p.redactEntities(&transcript.Entities, userId, bots)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	count, unattributed := 0, 0
+	var attachments []channel.Attachment
+	for i := range transcript.Messages {
+		if removed, matched := p.redactMessage(&transcript.Messages[i], userId); matched {
+			count++
+			attachments = append(attachments, removed...)
+		} else if relays[transcript.Messages[i].AuthorId] {
+			unattributed++
+		}
+	}
+
+	return count, unattributed, attachments
+}
+
+// redactEntities points the user's entity at the placeholder. It returns the bot entities that may
+// be webhooks relaying the user's messages, or false if their messages must not be cleaned.
+func (p *Processor) redactEntities(entities *v2.Entities, userId uint64, bots map[uint64]bool) (map[uint64]bool, bool) {
+	// System messages and messages with missing author metadata have a zero author ID, they must
+	// never be matched
+	if userId == 0 {
+		return nil, false
+	}
+
+	if entities.Users == nil {
+		entities.Users = make(map[uint64]v2.User)
+	}
+	if entities.Channels == nil {
+		entities.Channels = make(map[uint64]v2.Channel)
+	}
+	if entities.Roles == nil {
+		entities.Roles = make(map[uint64]v2.Role)
+	}
+
+	original, known := entities.Users[userId]
+	if known && original.Bot {
+		// A GDPR request can only be raised by a real user, so a bot entity under their ID means the
+		// author metadata is confused, e.g. by an interaction or webhook sharing the ID
+		p.logger.Warn("Requesting user is recorded as a bot in transcript, skipping message cleaning",
+			zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+		)
+		return nil, false
+	}
+
+	// Ticket messages relayed through webhooks are authored by the webhook, with the user's name
+	// set as the webhook username. Transcripts record webhooks as bots and keep a single name per
+	// author, so a match can't be told apart from a bot or a webhook relaying other users too. Their
+	// messages are left in place and reported instead of redacted. The guild's own bots are never
+	// webhooks.
+	relays := make(map[uint64]bool)
+	if known && original.Username != "" {
+		for id, user := range entities.Users {
+			if id != userId && user.Bot && !bots[id] && strings.EqualFold(user.Username, original.Username) {
+				relays[id] = true
+			}
+		}
+	}
+
+	// Mentions of the user elsewhere in the transcript resolve through their ID, so point that
+	// entry at the placeholder too
+	entities.Users[userId] = p.placeholder
+	entities.Users[p.placeholder.Id] = p.placeholder
+
+	return relays, true
+}
+
+// redactMessage replaces the message if it was written by the user, returning the attachments it
+// referenced and whether it matched
+func (p *Processor) redactMessage(msg *v2.Message, userId uint64) ([]channel.Attachment, bool) {
+	if msg.AuthorId == 0 || msg.AuthorId != userId {
+		return nil, false
+	}
+
+	attachments := msg.Attachments
+
+	msg.AuthorId = p.placeholder.Id
+	msg.Content = "[This message was removed in accordance with data protection regulations]"
+	msg.Embeds = nil
+	msg.Attachments = nil
+
+	return attachments, true
+}
+
+// guildBots returns the IDs of the bots that post in the guild's tickets: the bot itself and the
+// guild's whitelabel bot, if it has one
+func (p *Processor) guildBots(ctx context.Context, guildId uint64) (map[uint64]bool, error) {
+	bots := make(map[uint64]bool)
+	if p.botId != 0 {
+		bots[p.botId] = true
+	}
+
+	botId, found, err := p.db.WhitelabelGuilds.GetBotByGuild(ctx, guildId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get whitelabel bot of guild: %w", err)
+	}
+
+	if found {
+		bots[botId] = true
+	}
+
+	return bots, nil
+}
+
+// reportUnattributed records messages in a ticket that may have been relayed for the user by a
+// webhook, which were left in place rather than redacted
+func (p *Processor) reportUnattributed(guildId uint64, ticketId, count int) {
+	if count == 0 {
+		return
+	}
+
+	p.logger.Warn("Left messages by a bot sharing the user's name in place, as they can't be attributed to the user",
+		zap.Uint64("guild_id", guildId),
+		zap.Int("ticket_id", ticketId),
+		zap.Int("messages", count),
+	)
+	p.results.unattributed(guildId, count)
+}
+
+// botIdFromToken decodes the bot's user ID from the first segment of its token
+func botIdFromToken(token string) uint64 {
+	segment, _, _ := strings.Cut(token, ".")
+
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return 0
+	}
+
+	botId, _ := strconv.ParseUint(string(decoded), 10, 64)
+	return botId
+}
+
+func (p *Processor) storeTranscript(ctx context.Context, guildId uint64, ticketId int, data []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "archiver.import_transcript", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	return p.archiver.ImportTranscript(ctx, guildId, ticketId, data)
+}
@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+	"go.uber.org/zap"
+)
+
+// ProgressReporter is notified periodically while a request works through its tickets
+type ProgressReporter interface {
+	SendProgress(ctx context.Context, queued gdpr.QueuedRequest, progress Progress) error
+}
+
+// StateStore records the live state of requests, for the bot to answer status queries with
+type StateStore interface {
+	SetState(ctx context.Context, state gdpr.RequestState) error
+}
+
+// Progress is a snapshot of how far through its tickets a request is
+type Progress struct {
+	TicketsProcessed   int // Number of tickets handled so far
+	TicketsTotal       int // Number of tickets discovered so far, grows as each guild is enumerated
+	TranscriptsDeleted int
+	MessagesDeleted    int
+}
+
+const (
+	// minProgressGap stops fast runs of tickets from hammering the interaction endpoint
+	minProgressGap      = 2 * time.Second
+	progressSendTimeout = 5 * time.Second
+
+	// minStateGap stops the state from being rewritten for every ticket, status queries don't need
+	// to be more current than this
+	minStateGap = time.Second
+)
+
+// progressTracker accumulates progress for a single request and reports it to the ProgressReporter
+// every `every` tickets or `interval`, whichever comes first. The request's state is saved to the
+// StateStore as its stage changes, and at most every minStateGap while working through tickets.
+type progressTracker struct {
+	reporter ProgressReporter // Nil if only the state is saved
+	states   StateStore       // Nil if only progress is reported
+	queued   gdpr.QueuedRequest
+	logger   *zap.Logger
+	every    int
+	interval time.Duration
+
+	mu            sync.Mutex
+	progress      Progress
+	stage         gdpr.Stage
+	lastSent      time.Time
+	lastProcessed int
+	lastSaved     time.Time
+}
+
+func newProgressTracker(reporter ProgressReporter, states StateStore, queued gdpr.QueuedRequest, every int, interval time.Duration, logger *zap.Logger) *progressTracker {
+	return &progressTracker{
+		reporter: reporter,
+		states:   states,
+		queued:   queued,
+		logger:   logger,
+		every:    every,
+		interval: interval,
+		lastSent: time.Now(),
+	}
+}
+
+// setStage records that the request moved on to the stage, saving its state. Safe to call on a nil
+// tracker.
+func (t *progressTracker) setStage(ctx context.Context, stage gdpr.Stage) {
+	if t == nil || t.states == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.stage = stage
+	t.lastSaved = time.Now()
+	progress := t.progress
+	t.mu.Unlock()
+
+	t.saveState(ctx, stage, progress)
+}
+
+// addTotal records newly discovered tickets. Safe to call on a nil tracker.
+func (t *progressTracker) addTotal(tickets int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.progress.TicketsTotal += tickets
+	t.mu.Unlock()
+}
+
+// ticketDone records a handled ticket and sends a progress update if one is due. Safe to call on a
+// nil tracker.
+func (t *progressTracker) ticketDone(ctx context.Context, transcriptsDeleted, messagesDeleted int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.progress.TicketsProcessed++
+	t.progress.TranscriptsDeleted += transcriptsDeleted
+	t.progress.MessagesDeleted += messagesDeleted
+	progress := t.progress
+	stage := t.stage
+
+	save := t.states != nil && time.Since(t.lastSaved) >= minStateGap
+	if save {
+		t.lastSaved = time.Now()
+	}
+
+	since := time.Since(t.lastSent)
+	due := (t.every > 0 && t.progress.TicketsProcessed-t.lastProcessed >= t.every) ||
+		(t.interval > 0 && since >= t.interval)
+	send := t.reporter != nil && due && since >= minProgressGap && t.progress.TicketsProcessed < t.progress.TicketsTotal
+	if send {
+		t.lastSent = time.Now()
+		t.lastProcessed = t.progress.TicketsProcessed
+	}
+	t.mu.Unlock()
+
+	if save {
+		t.saveState(ctx, stage, progress)
+	}
+
+	if !send {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, progressSendTimeout)
+	defer cancel()
+
+	// Progress is best effort, the completion message will replace it regardless
+	if err := t.reporter.SendProgress(ctx, t.queued, progress); err != nil {
+		t.logger.Debug("Failed to send progress update", zap.Error(err))
+	}
+}
+
+// saveState is best effort like progress reports, status queries show the last state saved
+func (t *progressTracker) saveState(ctx context.Context, stage gdpr.Stage, progress Progress) {
+	ctx, cancel := context.WithTimeout(ctx, progressSendTimeout)
+	defer cancel()
+
+	state := gdpr.RequestState{
+		RequestId:          t.queued.RequestID,
+		Stage:              stage,
+		Attempt:            t.queued.RetryCount + 1,
+		TicketsProcessed:   progress.TicketsProcessed,
+		TicketsTotal:       progress.TicketsTotal,
+		TranscriptsDeleted: progress.TranscriptsDeleted,
+		MessagesDeleted:    progress.MessagesDeleted,
+		UpdatedAt:          time.Now(),
+	}
+
+	if err := t.states.SetState(ctx, state); err != nil {
+		t.logger.Debug("Failed to save request state", zap.Error(err))
+	}
+}
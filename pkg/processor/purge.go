@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TicketsBot-cloud/archiverclient"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"go.uber.org/zap"
+)
+
+const purgePollInterval = 2 * time.Second
+
+// purgeGuildTranscripts removes every transcript in the guild with a single archiver operation,
+// waits for it to finish and then clears the has_transcript flag of the given tickets
+func (p *Processor) purgeGuildTranscripts(ctx context.Context, guildId uint64, ticketIds []int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.purge_guild", tracing.GuildId(guildId), tracing.AttributeTicketCount.Int(len(ticketIds)))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, p.purgeTimeout)
+	defer cancel()
+
+	if err := p.purger.PurgeGuild(ctx, guildId); err != nil {
+		return 0, fmt.Errorf("failed to start guild purge: %w", err)
+	}
+
+	ticker := time.NewTicker(purgePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("guild purge did not finish: %w", ctx.Err())
+		case <-ticker.C:
+		}
+
+		status, err := p.purger.PurgeStatus(ctx, guildId)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get guild purge status: %w", err)
+		}
+
+		switch status.Status {
+		case archiverclient.StatusInProgress:
+			continue
+		case archiverclient.StatusFailed:
+			return 0, fmt.Errorf("guild purge failed")
+		case archiverclient.StatusComplete:
+			if len(status.Failed) > 0 {
+				return 0, fmt.Errorf("guild purge failed to remove %d objects", len(status.Failed))
+			}
+		default:
+			return 0, fmt.Errorf("unknown guild purge status %q", status.Status)
+		}
+
+		p.logger.Info("Guild transcript purge complete",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("objects_removed", len(status.Removed)),
+		)
+
+		p.clearHasTranscript(ctx, guildId, ticketIds)
+
+		return len(ticketIds), nil
+	}
+}
+
+// countLeftoverObjects lists what is still stored under the guild's prefix after a purge, catching
+// objects that were never referenced by has_transcript. Returns 0 if storage can't be checked.
+func (p *Processor) countLeftoverObjects(ctx context.Context, guildId uint64) int {
+	if p.storage == nil {
+		return 0
+	}
+
+	keys, err := p.storage.GetAllKeysForGuild(ctx, guildId)
+	if err != nil {
+		p.logger.Error("Failed to list transcript storage after guild purge",
+			zap.Uint64("guild_id", guildId),
+			zap.Error(err),
+		)
+		return 0
+	}
+
+	if len(keys) > 0 {
+		p.logger.Warn("Transcript objects left in storage after guild purge",
+			zap.Uint64("guild_id", guildId),
+			zap.Int("count", len(keys)),
+		)
+	}
+
+	return len(keys)
+}
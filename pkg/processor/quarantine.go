@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLegalHold is returned when purging a quarantined transcript that came under a legal hold
+// during its grace period
+var ErrLegalHold = errors.New("transcript is under a legal hold")
+
+// QuarantineStore keeps transcripts deleted by a request until their grace period is over. The
+// worker permanently deletes them afterwards, unless support reverses the request first.
+type QuarantineStore interface {
+	// Quarantine records transcripts to be permanently deleted at purgeAt. A ticket that is already
+	// quarantined has its purge pushed back to purgeAt if that is later, so a retry never purges
+	// anything sooner than the time its user was last told.
+	Quarantine(ctx context.Context, requestId int, guildId uint64, ticketIds []int, purgeAt time.Time) error
+}
+
+// quarantineTranscripts deletes transcripts softly: each is recorded as pending purge and then
+// hidden by clearing its has_transcript flag, while the object itself stays in storage until the
+// grace period is over. Recording comes first, so a crash between the two can't leave an object
+// nothing will ever remove.
+func (p *Processor) quarantineTranscripts(ctx context.Context, guildId uint64, ticketIds []int) (int, error) {
+	quarantined := 0
+	for start := 0; start < len(ticketIds); start += p.batchSize {
+		if err := interrupted(ctx); err != nil {
+			return quarantined, err
+		}
+
+		chunk := ticketIds[start:min(start+p.batchSize, len(ticketIds))]
+		if err := p.pacer.wait(ctx, len(chunk)); err != nil {
+			return quarantined, err
+		}
+
+		if err := p.quarantine.Quarantine(ctx, p.requestId, guildId, chunk, p.purgeAt); err != nil {
+			return quarantined, fmt.Errorf("failed to quarantine transcripts: %w", err)
+		}
+
+		p.clearHasTranscript(ctx, guildId, chunk)
+
+		for _, ticketId := range chunk {
+			p.progress.ticketDone(ctx, 1, 0)
+			p.checkpoint.record(ctx, guildId, ticketId, true, 1, 0)
+		}
+
+		quarantined += len(chunk)
+		p.results.deleted(guildId, len(chunk), 0)
+	}
+
+	return quarantined, nil
+}
+
+// PurgeQuarantined permanently deletes a transcript whose grace period is over. Holds placed since
+// it was quarantined are checked first, returning ErrLegalHold if one applies.
+func (p *Processor) PurgeQuarantined(ctx context.Context, guildId uint64, ticketId int) error {
+	if p.legalHolds != nil {
+		holds, err := p.legalHolds.ActiveLegalHolds(ctx, []uint64{guildId})
+		if err != nil {
+			return fmt.Errorf("failed to load legal holds: %w", err)
+		}
+
+		if holds.ticketHeld(guildId, ticketId) {
+			return ErrLegalHold
+		}
+	}
+
+	return p.deleteTranscript(ctx, guildId, ticketId)
+}
@@ -0,0 +1,198 @@
+package processor
+
+import "sync"
+
+// GuildResult is the outcome of a request within a single guild
+type GuildResult struct {
+	TranscriptsDeleted int   // Number of transcript archives deleted from the guild
+	MessagesDeleted    int   // Number of the user's messages deleted from the guild's transcripts
+	AttachmentsDeleted int   // Number of attachment files removed from storage along with the user's messages
+	Skipped            int   // Tickets left untouched, as there was nothing to delete or a previous attempt handled them
+	NoTranscript       int   // Tickets in scope with no stored transcript, as their flag was unset or the archiver had none
+	Withheld           int   // Tickets left untouched as they are under a legal hold
+	Unattributed       int   // Messages by a bot sharing the user's name, possibly a webhook relaying them, left in place
+	Failed             int   // Tickets that could not be processed
+	AlreadyDeleted     []int // Tickets whose transcript was deleted, by a request for the guild's transcripts, before messages could be cleaned from it
+	Error              error // Last error encountered in the guild, nil if none
+
+	Rejected bool // Whether the guild was skipped as the user may not make requests for it, Error says why
+}
+
+// guildResults accumulates per-guild outcomes while a request is processed
+type guildResults struct {
+	mu      sync.Mutex
+	results map[uint64]*GuildResult
+}
+
+// newGuildResults creates a tracker with an empty result for each guild, so guilds where nothing
+// was found are still reported
+func newGuildResults(guildIds []uint64) *guildResults {
+	r := &guildResults{
+		results: make(map[uint64]*GuildResult, len(guildIds)),
+	}
+
+	for _, guildId := range guildIds {
+		r.results[guildId] = &GuildResult{}
+	}
+
+	return r
+}
+
+func (r *guildResults) get(guildId uint64) *GuildResult {
+	result, ok := r.results[guildId]
+	if !ok {
+		result = &GuildResult{}
+		r.results[guildId] = result
+	}
+
+	return result
+}
+
+// deleted records deletions in a guild. Safe to call on a nil tracker.
+func (r *guildResults) deleted(guildId uint64, transcripts, messages int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.get(guildId)
+	result.TranscriptsDeleted += transcripts
+	result.MessagesDeleted += messages
+}
+
+// attachmentsDeleted records attachment files removed in a guild. Safe to call on a nil tracker.
+func (r *guildResults) attachmentsDeleted(guildId uint64, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).AttachmentsDeleted += count
+}
+
+// skipped records tickets in a guild that needed no changes. Safe to call on a nil tracker.
+func (r *guildResults) skipped(guildId uint64, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).Skipped += count
+}
+
+// noTranscript records tickets in a guild found to have no stored transcript. Safe to call on a nil
+// tracker.
+func (r *guildResults) noTranscript(guildId uint64, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).NoTranscript += count
+}
+
+// withheld records tickets in a guild left untouched due to a legal hold. Safe to call on a nil
+// tracker.
+func (r *guildResults) withheld(guildId uint64, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).Withheld += count
+}
+
+// unattributed records messages in a guild that may have been the user's but couldn't be attributed
+// to them. Safe to call on a nil tracker.
+func (r *guildResults) unattributed(guildId uint64, count int) {
+	if r == nil || count == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).Unattributed += count
+}
+
+// alreadyDeleted records a ticket whose transcript was deleted before messages could be cleaned
+// from it. Safe to call on a nil tracker.
+func (r *guildResults) alreadyDeleted(guildId uint64, ticketId int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.get(guildId)
+	result.AlreadyDeleted = append(result.AlreadyDeleted, ticketId)
+}
+
+// failed records a ticket in a guild that could not be processed. Safe to call on a nil tracker.
+func (r *guildResults) failed(guildId uint64, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.get(guildId)
+	result.Failed++
+	result.Error = err
+}
+
+// rejected records a guild skipped as the user may not make requests for it. Safe to call on a
+// nil tracker.
+func (r *guildResults) rejected(guildId uint64, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.get(guildId)
+	result.Rejected = true
+	result.Error = err
+}
+
+// guildFailed records an error affecting a whole guild. Safe to call on a nil tracker.
+func (r *guildResults) guildFailed(guildId uint64, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(guildId).Error = err
+}
+
+// snapshot returns a copy of the results gathered so far. Safe to call on a nil tracker.
+func (r *guildResults) snapshot() map[uint64]GuildResult {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := make(map[uint64]GuildResult, len(r.results))
+	for guildId, result := range r.results {
+		snapshot[guildId] = *result
+	}
+
+	return snapshot
+}
@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/utils"
+	"go.uber.org/zap"
+)
+
+// erasureStatement removes or replaces references to the user in a single table. $1 is the user ID,
+// followed by any statement specific arguments and, for statements that replace the reference, the
+// placeholder user ID.
+type erasureStatement struct {
+	table   string
+	query   string
+	replace bool
+}
+
+// Exit survey responses must be removed while tickets.user_id still identifies the user's tickets,
+// so the order of these statements matters. $2 is the guild IDs.
+var scrubStatements = []erasureStatement{
+	{"exit_survey_responses", `
+DELETE FROM exit_survey_responses r
+USING tickets t
+WHERE r.guild_id = t.guild_id AND r.ticket_id = t.id
+AND t.user_id = $1 AND t.guild_id = ANY($2)`, false},
+	{"ticket_members", `DELETE FROM ticket_members WHERE user_id = $1 AND guild_id = ANY($2)`, false},
+	{"participant", `DELETE FROM participant WHERE user_id = $1 AND guild_id = ANY($2)`, false},
+	{"close_reason", `UPDATE close_reason SET closed_by = NULL WHERE closed_by = $1 AND guild_id = ANY($2)`, false},
+	{"close_request", `UPDATE close_request SET user_id = $3 WHERE user_id = $1 AND guild_id = ANY($2)`, true},
+	{"ticket_claims", `UPDATE ticket_claims SET user_id = $3 WHERE user_id = $1 AND guild_id = ANY($2)`, true},
+	{"first_response_time", `UPDATE first_response_time SET user_id = $3 WHERE user_id = $1 AND guild_id = ANY($2)`, true},
+	{"tickets", `UPDATE tickets SET user_id = $3 WHERE user_id = $1 AND open = false AND guild_id = ANY($2)`, true},
+}
+
+// scrubUserReferences erases the user's ID from the relational ticket data in the given guilds,
+// returning the number of rows changed. Open tickets keep their opener, as the bot still needs to
+// know who opened them, and guilds under a legal hold are left as they are. In dry run mode the changes are counted and rolled back.
+func (p *Processor) scrubUserReferences(ctx context.Context, userId uint64, guildIds []uint64) (int, error) {
+	guildIds = p.unheldGuilds(guildIds)
+	if len(guildIds) == 0 {
+		return 0, nil
+	}
+
+	return p.execErasure(ctx, userId, scrubStatements, guildIds)
+}
+
+// execErasure runs the statements in a single transaction, returning the number of rows changed.
+// In dry run mode the changes are counted and rolled back.
+func (p *Processor) execErasure(ctx context.Context, userId uint64, statements []erasureStatement, args ...interface{}) (int, error) {
+	tx, err := p.db.Tickets.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	total := 0
+	for _, statement := range statements {
+		statementArgs := append([]interface{}{userId}, args...)
+		if statement.replace {
+			statementArgs = append(statementArgs, p.placeholder.Id)
+		}
+
+		tag, err := tx.Exec(ctx, statement.query, statementArgs...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to erase from %s: %w", statement.table, err)
+		}
+
+		if tag.RowsAffected() > 0 {
+			p.logger.Debug("Erased user data",
+				zap.String("scrambled_user_id", utils.ScrambleUserId(userId)),
+				zap.String("table", statement.table),
+				zap.Int64("rows", tag.RowsAffected()),
+			)
+		}
+
+		total += int(tag.RowsAffected())
+	}
+
+	if p.dryRun {
+		return total, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,205 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/TicketsBot-cloud/gdl/objects/channel"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/tracing"
+	"github.com/TicketsBot-cloud/logarchiver/pkg/model"
+	v2 "github.com/TicketsBot-cloud/logarchiver/pkg/model/v2"
+	"github.com/TicketsBot/common/encryption"
+)
+
+// encryptionNonceSize is the length of the nonce transcripts are prefixed with, decryption panics on
+// shorter input
+const encryptionNonceSize = 12
+
+// errEntitiesAfterMessages is returned when a transcript lists its messages before the entities
+// needed to match them, so it can't be rewritten in one pass
+var errEntitiesAfterMessages = errors.New("transcript entities follow its messages")
+
+// fetchTranscript returns a ticket's transcript decoded, or, if it's a v2 transcript at least the
+// streaming threshold in size, still encoded for cleanTranscriptStream
+func (p *Processor) fetchTranscript(ctx context.Context, guildId uint64, ticketId int) (v2.Transcript, []byte, error) {
+	if p.streamThreshold <= 0 || p.retriever == nil || len(p.transcriptKey) == 0 {
+		transcript, err := p.getTranscript(ctx, guildId, ticketId)
+		return transcript, nil, err
+	}
+
+	data, err := p.readTranscript(ctx, guildId, ticketId)
+	if err != nil {
+		// The archiver client path reports missing transcripts and falls back to legacy formats
+		transcript, err := p.getTranscript(ctx, guildId, ticketId)
+		return transcript, nil, err
+	}
+
+	if len(data) >= p.streamThreshold && model.GetVersion(data) == model.V2 {
+		return v2.Transcript{}, data, nil
+	}
+
+	transcript, err := decodeTranscriptJson(data)
+	return transcript, nil, err
+}
+
+// readTranscript downloads and decrypts a transcript without decoding it, as the archiver client does
+func (p *Processor) readTranscript(ctx context.Context, guildId uint64, ticketId int) (data []byte, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.read_transcript", tracing.GuildId(guildId), tracing.AttributeTicketId.Int(ticketId))
+	defer func() { tracing.End(span, err) }()
+
+	body, err := p.retriever.GetTicket(ctx, guildId, ticketId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transcripts are compressed after encryption, apart from older ones which are only encrypted
+	if len(body) > encryptionNonceSize {
+		if decrypted, err := encryption.Decrypt(p.transcriptKey, body); err == nil {
+			return decrypted, nil
+		}
+	}
+
+	decompressed, err := encryption.Decompress(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress transcript: %w", err)
+	}
+
+	if len(decompressed) <= encryptionNonceSize {
+		return nil, fmt.Errorf("transcript too short to decrypt")
+	}
+
+	return encryption.Decrypt(p.transcriptKey, decompressed)
+}
+
+// cleanTranscriptStream redacts the user's messages from an encoded v2 transcript, decoding one
+// message at a time rather than the whole transcript. The encoded input and output are still held
+// in memory, as transcripts are encrypted as a whole, but the decoded messages, which take several
+// times as much space, never are.
+func (p *Processor) cleanTranscriptStream(data []byte, userId uint64, bots map[uint64]bool) ([]byte, int, int, []channel.Attachment, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	out.WriteByte('{')
+
+	var relays map[uint64]bool
+	entitiesSeen := false
+	count, unattributed := 0, 0
+	var attachments []channel.Attachment
+
+	for first := true; decoder.More(); first = false {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+
+		key, ok := token.(string)
+		if !ok {
+			return nil, 0, 0, nil, fmt.Errorf("unexpected transcript token %v", token)
+		}
+
+		if !first {
+			out.WriteByte(',')
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		out.Write(encodedKey)
+		out.WriteByte(':')
+
+		switch key {
+		case "entities":
+			var entities v2.Entities
+			if err := decoder.Decode(&entities); err != nil {
+				return nil, 0, 0, nil, fmt.Errorf("failed to decode transcript entities: %w", err)
+			}
+
+			if relays, ok = p.redactEntities(&entities, userId, bots); !ok {
+				return nil, 0, 0, nil, nil
+			}
+			entitiesSeen = true
+
+			if err := writeJson(&out, entities); err != nil {
+				return nil, 0, 0, nil, err
+			}
+		case "messages":
+			if !entitiesSeen {
+				return nil, 0, 0, nil, errEntitiesAfterMessages
+			}
+
+			if err := expectDelim(decoder, '['); err != nil {
+				return nil, 0, 0, nil, err
+			}
+			out.WriteByte('[')
+
+			for i := 0; decoder.More(); i++ {
+				var msg v2.Message
+				if err := decoder.Decode(&msg); err != nil {
+					return nil, 0, 0, nil, fmt.Errorf("failed to decode transcript message: %w", err)
+				}
+
+				if removed, matched := p.redactMessage(&msg, userId); matched {
+					count++
+					attachments = append(attachments, removed...)
+				} else if relays[msg.AuthorId] {
+					unattributed++
+				}
+
+				if i > 0 {
+					out.WriteByte(',')
+				}
+
+				if err := writeJson(&out, msg); err != nil {
+					return nil, 0, 0, nil, err
+				}
+			}
+
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, 0, 0, nil, err
+			}
+			out.WriteByte(']')
+		default:
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return nil, 0, 0, nil, fmt.Errorf("failed to decode transcript field %q: %w", key, err)
+			}
+			out.Write(raw)
+		}
+	}
+
+	if err := expectDelim(decoder, '}'); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	out.WriteByte('}')
+
+	return out.Bytes(), count, unattributed, attachments, nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read transcript: %w", err)
+	}
+
+	if token != delim {
+		return fmt.Errorf("unexpected transcript token %v, expected %v", token, delim)
+	}
+
+	return nil
+}
+
+func writeJson(out *bytes.Buffer, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to serialize transcript: %w", err)
+	}
+
+	out.Write(encoded)
+	return nil
+}
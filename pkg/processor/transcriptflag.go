@@ -0,0 +1,26 @@
+package processor
+
+import (
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// States a has_transcript flag can unexpectedly be found in when it is updated
+const (
+	flagAlreadyCleared = "already_cleared" // Clear after its transcript was deleted, by a concurrent request, a replay or a missing ticket row
+	flagWasUnset       = "was_unset"       // Clear even though storage held a transcript for the ticket
+	flagNoObject       = "no_object"       // Set even though storage held no transcript for the ticket
+)
+
+// reportFlagMismatch records tickets whose has_transcript flag wasn't in the state expected before
+// it was updated. An earlier delivery of the same request clearing it is harmless, but the same
+// outcome also hides the database disagreeing with storage, so both are counted for investigation.
+func (p *Processor) reportFlagMismatch(guildId uint64, ticketIds []int, state string) {
+	metrics.TranscriptFlagMismatches.WithLabelValues(state).Add(float64(len(ticketIds)))
+
+	p.logger.Warn("has_transcript flag was not in the expected state",
+		zap.Uint64("guild_id", guildId),
+		zap.Ints("ticket_ids", ticketIds),
+		zap.String("state", state),
+	)
+}
@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/TicketsBot-cloud/archiverclient"
+	"github.com/TicketsBot-cloud/gdpr-worker/internal/metrics"
+)
+
+// ArchiverBytes is how much transcript data a request moved to and from the archiver, as stored,
+// i.e. compressed and encrypted
+type ArchiverBytes struct {
+	Fetched   int64 // Transcripts downloaded to be cleaned or exported
+	Rewritten int64 // Cleaned transcripts uploaded in place of the originals
+	Deleted   int64 // Transcripts deleted, only known when the archiver's batch endpoint reports it
+}
+
+const (
+	archiverFetch   = "fetch"
+	archiverRewrite = "rewrite"
+	archiverDelete  = "delete"
+)
+
+// byteVolume accumulates the archiver traffic of a single request. The archiver client makes its own
+// retriever calls, so it's carried in the request's context rather than on the scoped copy.
+type byteVolume struct {
+	fetched   atomic.Int64
+	rewritten atomic.Int64
+	deleted   atomic.Int64
+}
+
+type byteVolumeKey struct{}
+
+func withByteVolume(ctx context.Context, volume *byteVolume) context.Context {
+	return context.WithValue(ctx, byteVolumeKey{}, volume)
+}
+
+// countArchiverBytes records bytes moved by an archiver operation, against the request being
+// processed if there is one
+func countArchiverBytes(ctx context.Context, operation string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+
+	metrics.ArchiverBytes.WithLabelValues(operation).Add(float64(bytes))
+
+	volume, ok := ctx.Value(byteVolumeKey{}).(*byteVolume)
+	if !ok {
+		return
+	}
+
+	switch operation {
+	case archiverFetch:
+		volume.fetched.Add(bytes)
+	case archiverRewrite:
+		volume.rewritten.Add(bytes)
+	case archiverDelete:
+		volume.deleted.Add(bytes)
+	}
+}
+
+func (v *byteVolume) snapshot() ArchiverBytes {
+	return ArchiverBytes{
+		Fetched:   v.fetched.Load(),
+		Rewritten: v.rewritten.Load(),
+		Deleted:   v.deleted.Load(),
+	}
+}
+
+// MeteredRetriever counts the transcript data passing through a retriever. Both the processor and
+// the archiver client it's given to should use it, so every download and upload is counted.
+type MeteredRetriever struct {
+	archiverclient.Retriever
+}
+
+var _ archiverclient.Retriever = (*MeteredRetriever)(nil)
+
+func NewMeteredRetriever(retriever archiverclient.Retriever) *MeteredRetriever {
+	return &MeteredRetriever{
+		Retriever: retriever,
+	}
+}
+
+func (r *MeteredRetriever) GetTicket(ctx context.Context, guildId uint64, ticketId int) ([]byte, error) {
+	data, err := r.Retriever.GetTicket(ctx, guildId, ticketId)
+	if err == nil {
+		countArchiverBytes(ctx, archiverFetch, int64(len(data)))
+	}
+
+	return data, err
+}
+
+func (r *MeteredRetriever) StoreTicket(ctx context.Context, guildId uint64, ticketId int, data []byte) error {
+	err := r.Retriever.StoreTicket(ctx, guildId, ticketId, data)
+	if err == nil {
+		countArchiverBytes(ctx, archiverRewrite, int64(len(data)))
+	}
+
+	return err
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/TicketsBot-cloud/gdpr-worker/pkg/gdpr"
+)
+
+// HeaderDelivery carries the ID of a delivery, the same across every attempt at it, so receivers
+// can ignore deliveries they have already processed
+const HeaderDelivery = "X-GDPR-Delivery"
+
+// Status is the final outcome of a request
+type Status string
+
+const (
+	StatusCompleted   Status = "completed"
+	StatusFailed      Status = "failed" // Every attempt failed, the request won't be tried again
+	StatusCancelled   Status = "cancelled"
+	StatusRateLimited Status = "rate_limited" // Rejected as the user made too many requests recently, nothing was deleted
+	StatusCoalesced   Status = "coalesced"    // An identical request already being processed covers it, nothing was deleted by this one
+)
+
+// Payload is the body of a delivery, sent once a request reaches its final outcome. It identifies
+// the user only by the hex SHA-256 of their decimal ID, the same scrambled ID the worker logs.
+type Payload struct {
+	RequestId           int       `json:"request_id"`
+	RequestType         string    `json:"request_type"`
+	ScrambledUserId     string    `json:"scrambled_user_id"`
+	Status              Status    `json:"status"`
+	DryRun              bool      `json:"dry_run,omitempty"`
+	GuildCount          int       `json:"guild_count"`
+	TicketCount         int       `json:"ticket_count"`
+	TranscriptsDeleted  int       `json:"transcripts_deleted"`
+	MessagesDeleted     int       `json:"messages_deleted"`
+	TranscriptsExported int       `json:"transcripts_exported"`
+	ReferencesScrubbed  int       `json:"references_scrubbed"`
+	FeedbackDeleted     int       `json:"feedback_deleted"`
+	TicketsWithheld     int       `json:"tickets_withheld,omitempty"`      // Tickets left untouched as they are under a legal hold
+	TicketsNoTranscript int       `json:"tickets_no_transcript,omitempty"` // Tickets in scope with no stored transcript
+	Attempts            int       `json:"attempts"`
+	QueuedAt            time.Time `json:"queued_at"`
+	FinishedAt          time.Time `json:"finished_at"`
+
+	ResultCode gdpr.ResultCode `json:"result_code"` // Stable classification of the outcome, to branch on rather than Status
+}
@@ -0,0 +1,27 @@
+-- The archiver keeps its object index in a database of its own, pointing at the MinIO bucket the self
+-- test creates before storing transcripts
+CREATE DATABASE archiver;
+
+\connect archiver
+
+CREATE TABLE buckets
+(
+    "id"           uuid PRIMARY KEY      DEFAULT gen_random_uuid(),
+    "endpoint_url" VARCHAR(255) NOT NULL,
+    "name"         VARCHAR(255) NOT NULL,
+    "active"       BOOLEAN      NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE objects
+(
+    "guild_id"  int8 NOT NULL,
+    "ticket_id" int4 NOT NULL,
+    "bucket_id" uuid NOT NULL,
+    PRIMARY KEY ("guild_id", "ticket_id"),
+    FOREIGN KEY ("bucket_id") REFERENCES "buckets" ("id")
+);
+
+CREATE INDEX objects_guid_id_idx ON objects ("guild_id");
+
+INSERT INTO buckets ("id", "endpoint_url", "name", "active")
+VALUES ('00000000-0000-0000-0000-000000000001', 'minio:9000', 'transcripts', true);